@@ -0,0 +1,245 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Array wraps a slice written to the database as a PostgreSQL array literal, like {"go","sql"},
+// nil slices being written as NULL. When value is a pointer to a slice, the array read from
+// the database is decoded into it. Slices of strings, numbers, booleans, pointers to them and
+// nested slices for multidimensional arrays are supported. Struct fields tagged array are
+// wrapped automatically.
+//
+//	connection.Exec("INSERT INTO articles(tags) VALUES(?)", db.Array([]string{"go", "sql"}))
+//	connection.QueryRow("SELECT tags FROM articles").GetSingleResult(db.Array(&tags))
+func Array(value interface{}) ValueScanner {
+	return &arrayValue{value}
+}
+
+type arrayValue struct {
+	value interface{}
+}
+
+func (a arrayValue) Value() (driver.Value, error) {
+	Value := reflect.ValueOf(a.value)
+	for Value.Kind() == reflect.Ptr || Value.Kind() == reflect.Interface {
+		if Value.IsNil() {
+			return nil, nil
+		}
+		Value = Value.Elem()
+	}
+	if Value.Kind() != reflect.Slice && Value.Kind() != reflect.Array {
+		return nil, ErrUnsupportedArrayType
+	}
+	if Value.Kind() == reflect.Slice && Value.IsNil() {
+		return nil, nil
+	}
+	return encodeArray(Value)
+}
+
+func (a arrayValue) Scan(src interface{}) error {
+	destination := reflect.ValueOf(a.value)
+	if destination.Kind() != reflect.Ptr || destination.IsNil() {
+		return ErrNotAPointer
+	}
+	var literal string
+	switch src := src.(type) {
+	case nil:
+		destination.Elem().Set(reflect.Zero(destination.Elem().Type()))
+		return nil
+	case []byte:
+		literal = string(src)
+	case string:
+		literal = src
+	default:
+		return ErrUnsupportedScanSource
+	}
+	elements, rest, err := parseArray(strings.TrimSpace(literal))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return ErrInvalidArrayLiteral
+	}
+	return decodeArray(destination.Elem(), elements)
+}
+
+var arrayElementEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// encodeArray returns the array literal of a slice
+func encodeArray(Value reflect.Value) (string, error) {
+	if Value.Type().Elem().Kind() == reflect.Uint8 {
+		// []byte is a single value, not an array
+		return "", ErrUnsupportedArrayType
+	}
+	elements := make([]string, Value.Len())
+	for i := range elements {
+		element := Value.Index(i)
+		for element.Kind() == reflect.Ptr || element.Kind() == reflect.Interface {
+			if element.IsNil() {
+				break
+			}
+			element = element.Elem()
+		}
+		switch element.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			elements[i] = "NULL"
+		case reflect.String:
+			elements[i] = `"` + arrayElementEscaper.Replace(element.String()) + `"`
+		case reflect.Bool:
+			elements[i] = strconv.FormatBool(element.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			elements[i] = strconv.FormatInt(element.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			elements[i] = strconv.FormatUint(element.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			elements[i] = strconv.FormatFloat(element.Float(), 'g', -1, element.Type().Bits())
+		case reflect.Slice, reflect.Array:
+			nested, err := encodeArray(element)
+			if err != nil {
+				return "", err
+			}
+			elements[i] = nested
+		default:
+			return "", ErrUnsupportedArrayType
+		}
+	}
+	return "{" + strings.Join(elements, ",") + "}", nil
+}
+
+// parseArray parses the array literal at the start of literal and returns the rest of literal.
+// The elements are strings, nil for NULL elements, or []interface{} for nested arrays.
+func parseArray(literal string) (elements []interface{}, rest string, err error) {
+	if !strings.HasPrefix(literal, "{") {
+		return nil, "", ErrInvalidArrayLiteral
+	}
+	rest = strings.TrimLeft(literal[1:], " ")
+	if strings.HasPrefix(rest, "}") {
+		return []interface{}{}, rest[1:], nil
+	}
+	for {
+		var element interface{}
+		switch {
+		case strings.HasPrefix(rest, "{"):
+			if element, rest, err = parseArray(rest); err != nil {
+				return nil, "", err
+			}
+		case strings.HasPrefix(rest, `"`):
+			value := []byte{}
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value = append(value, rest[i])
+			}
+			if i == len(rest) {
+				return nil, "", ErrInvalidArrayLiteral
+			}
+			element, rest = string(value), rest[i+1:]
+		default:
+			end := strings.IndexAny(rest, ",}")
+			if end == -1 {
+				return nil, "", ErrInvalidArrayLiteral
+			}
+			if value := strings.TrimSpace(rest[:end]); !strings.EqualFold(value, "NULL") {
+				element = value
+			}
+			rest = rest[end:]
+		}
+		elements = append(elements, element)
+		rest = strings.TrimLeft(rest, " ")
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = strings.TrimLeft(rest[1:], " ")
+		case strings.HasPrefix(rest, "}"):
+			return elements, rest[1:], nil
+		default:
+			return nil, "", ErrInvalidArrayLiteral
+		}
+	}
+}
+
+// decodeArray sets destination, a slice, to the parsed elements of an array
+func decodeArray(destination reflect.Value, elements []interface{}) error {
+	if destination.Kind() != reflect.Slice {
+		return ErrUnsupportedArrayType
+	}
+	slice := reflect.MakeSlice(destination.Type(), len(elements), len(elements))
+	for i, element := range elements {
+		if err := decodeArrayElement(slice.Index(i), element); err != nil {
+			return err
+		}
+	}
+	destination.Set(slice)
+	return nil
+}
+
+// decodeArrayElement sets destination to a parsed element, NULL elements leaving destination unset
+func decodeArrayElement(destination reflect.Value, element interface{}) error {
+	if element == nil {
+		return nil
+	}
+	if destination.Kind() == reflect.Ptr {
+		destination.Set(reflect.New(destination.Type().Elem()))
+		destination = destination.Elem()
+	}
+	if nested, ok := element.([]interface{}); ok {
+		return decodeArray(destination, nested)
+	}
+	value := element.(string)
+	var err error
+	switch destination.Kind() {
+	case reflect.String:
+		destination.SetString(value)
+	case reflect.Bool:
+		var b bool
+		// PostgreSQL writes booleans as t and f
+		switch strings.ToLower(value) {
+		case "t", "true", "1":
+			b = true
+		case "f", "false", "0":
+		default:
+			return ErrInvalidArrayLiteral
+		}
+		destination.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(value, 10, destination.Type().Bits()); err == nil {
+			destination.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(value, 10, destination.Type().Bits()); err == nil {
+			destination.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(value, destination.Type().Bits()); err == nil {
+			destination.SetFloat(f)
+		}
+	default:
+		return ErrUnsupportedArrayType
+	}
+	if err != nil {
+		return ErrInvalidArrayLiteral
+	}
+	return nil
+}
@@ -15,16 +15,34 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"database/sql"
 
 	"github.com/Mparaiso/go-tiger/container"
 	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/db/platform"
 )
 
-// QueryBuilder is a query builder
+// QueryBuilder is a query builder.
+//
+// Values are bound to queries as parameters, either with expression.Param
+// or with named placeholders whose values are set with SetParameter :
+//
+//	qb.Select("*").From("users").Where(expression.Eq("id", expression.Param(42)))
+//	qb.Select("*").From("users").Where("name = :name").SetParameter("name", "john")
+//
+// Placeholders are replaced with the placeholders of the database platform,
+// and Exec, Query and QueryRow pass the parameters to the database.
+//
+// A query builder nested in another query builder is rendered as a subquery,
+// its parameters being bound by the outer query :
+//
+//	authors := connection.CreateQueryBuilder().Select("author_id").From("articles").Where(expression.Eq("published", expression.Param(true)))
+//	qb.Select("*").From("users").Where(expression.In("id", authors))
 type QueryBuilder struct {
 	connection   Connection
 	builderType  Type
@@ -34,11 +52,121 @@ type QueryBuilder struct {
 	maxResults   int
 	firstResult  int
 	isLimitquery bool
+	parameters   map[string]interface{}
+	// placeholders are the names of the parameters of sql in order
+	placeholders []string
+	// queryer executes the queries, either the connection or a transaction
+	queryer    queryer
+	distinct   bool
+	lockMode   platform.LockMode
+	skipLocked bool
+	// isSubquery is true while the query is rendered in another query
+	isSubquery bool
+	// cache are the options of the result cache set with UseCache
+	cache *cacheOptions
+	// filters are the filters of the connection restricting the query while it is executed
+	filters *filterScope
+}
+
+// queryer executes queries, either on a connection or in a transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, arguments ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, arguments ...interface{}) *Rows
+	QueryRowContext(ctx context.Context, query string, arguments ...interface{}) *Row
 }
 
 // NewQueryBuilder returns a new query builder
 func NewQueryBuilder(connection Connection) *QueryBuilder {
-	return &QueryBuilder{connection: connection, builderType: Select, sqlParts: map[string][]interface{}{}}
+	return &QueryBuilder{connection: connection, builderType: Select, sqlParts: map[string][]interface{}{}, parameters: map[string]interface{}{}, queryer: connection}
+}
+
+// Clone returns a copy of the query builder, the copy can be modified
+// without modifying the query builder
+func (b *QueryBuilder) Clone() *QueryBuilder {
+	clone := *b
+	clone.sql, clone.placeholders, clone.state = "", nil, Dirty
+	clone.sqlParts = map[string][]interface{}{}
+	for name, part := range b.sqlParts {
+		clonedPart := make([]interface{}, len(part))
+		for i, content := range part {
+			// AndWhere and OrWhere add their parts to AND and OR expressions
+			if exp, ok := content.(*expression.Expression); ok {
+				clonedExpression := *exp
+				clonedExpression.Parts = append([]interface{}{}, exp.Parts...)
+				content = &clonedExpression
+			}
+			clonedPart[i] = content
+		}
+		clone.sqlParts[name] = clonedPart
+	}
+	clone.parameters = map[string]interface{}{}
+	for name, value := range b.parameters {
+		clone.parameters[name] = value
+	}
+	return &clone
+}
+
+// SetParameter sets the value of the named parameter :name
+func (b *QueryBuilder) SetParameter(name string, value interface{}) *QueryBuilder {
+	if b.parameters == nil {
+		b.parameters = map[string]interface{}{}
+	}
+	b.parameters[name] = value
+	return b
+}
+
+// SetParameters sets the values of named parameters
+func (b *QueryBuilder) SetParameters(parameters map[string]interface{}) *QueryBuilder {
+	for name, value := range parameters {
+		b.SetParameter(name, value)
+	}
+	return b
+}
+
+// GetParameters returns the values of the parameters of the query by name,
+// including the values bound with expression.Param
+func (b *QueryBuilder) GetParameters() map[string]interface{} {
+	parameters := map[string]interface{}{}
+	for _, part := range b.sqlParts {
+		collectParameters(parameters, part...)
+	}
+	for name, value := range b.parameters {
+		parameters[name] = value
+	}
+	return parameters
+}
+
+// GetArguments returns the arguments of the query in the order of its placeholders.
+// Positional placeholders written as ? take their values from arguments.
+func (b *QueryBuilder) GetArguments(arguments ...interface{}) ([]interface{}, error) {
+	if _, ok := b.sqlParts[returning]; ok && !b.connection.GetDatabasePlatform().SupportsReturning() {
+		return nil, ErrReturningNotSupported
+	}
+	if b.lockMode != platform.NoLock && !b.connection.GetDatabasePlatform().SupportsLockMode(b.lockMode) {
+		return nil, ErrLockNotSupported
+	}
+	if b.builderType == Insert && len(b.getInsertColumns()) == 0 && b.connection.GetDatabasePlatform().GetDefaultValuesSQL() == "" {
+		return nil, ErrDefaultValuesNotSupported
+	}
+	// placeholders are parsed along with the query
+	_ = b.String()
+	parameters := b.GetParameters()
+	result := []interface{}{}
+	for _, name := range b.placeholders {
+		if name == "" {
+			if len(arguments) == 0 {
+				return nil, ErrMissingParameter
+			}
+			result, arguments = append(result, arguments[0]), arguments[1:]
+			continue
+		}
+		value, ok := parameters[name]
+		if !ok {
+			return nil, ErrMissingParameter
+		}
+		result = append(result, value)
+	}
+	return append(result, arguments...), nil
 }
 
 // GetType returns the Type
@@ -59,11 +187,13 @@ func (b QueryBuilder) GetFirstResult() int {
 // SetfirstResult sets b.firstResult
 func (b *QueryBuilder) SetFirstResult(firstResult int) *QueryBuilder {
 	b.state = Dirty
+	b.isLimitquery = true
 	b.firstResult = firstResult
 	return b
 }
 func (b *QueryBuilder) SetMaxResults(maxResults int) *QueryBuilder {
 	b.state = Dirty
+	b.isLimitquery = true
 	b.maxResults = maxResults
 	return b
 }
@@ -102,6 +232,92 @@ func (b *QueryBuilder) From(table string, alias ...string) *QueryBuilder {
 	}
 	return b.add(from, []interface{}{fromMap}, true)
 }
+
+// FromSubquery adds a subquery to the FROM clause, alias being the name of its result
+func (b *QueryBuilder) FromSubquery(subquery *QueryBuilder, alias string) *QueryBuilder {
+	return b.add(from, []interface{}{From{Subquery: subquery, Alias: alias}}, true)
+}
+
+// Distinct removes the duplicate rows of the query result
+func (b *QueryBuilder) Distinct() *QueryBuilder {
+	b.distinct = true
+	b.state = Dirty
+	return b
+}
+
+// Union combines the rows of the query with the rows of queries, removing duplicate rows.
+// ORDER BY and LIMIT clauses apply to the combined rows.
+func (b *QueryBuilder) Union(queries ...*QueryBuilder) *QueryBuilder {
+	return b.addCompound("UNION", queries...)
+}
+
+// UnionAll combines the rows of the query with the rows of queries
+func (b *QueryBuilder) UnionAll(queries ...*QueryBuilder) *QueryBuilder {
+	return b.addCompound("UNION ALL", queries...)
+}
+
+// Intersect keeps the rows of the query that are returned by queries
+func (b *QueryBuilder) Intersect(queries ...*QueryBuilder) *QueryBuilder {
+	return b.addCompound("INTERSECT", queries...)
+}
+
+// Except removes the rows of the query that are returned by queries
+func (b *QueryBuilder) Except(queries ...*QueryBuilder) *QueryBuilder {
+	return b.addCompound("EXCEPT", queries...)
+}
+
+func (b *QueryBuilder) addCompound(operator string, queries ...*QueryBuilder) *QueryBuilder {
+	contents := []interface{}{}
+	for _, query := range queries {
+		contents = append(contents, compound{Operator: operator, Query: query})
+	}
+	return b.add(union, contents, true)
+}
+
+// With adds a common table expression named name to the query, columns
+// being the names of the columns of its result
+//
+//	qb.With("authors", authors).Select("*").From("authors")
+func (b *QueryBuilder) With(name string, query *QueryBuilder, columns ...string) *QueryBuilder {
+	return b.add(with, []interface{}{commonTableExpression{Name: name, Columns: columns, Query: query}}, true)
+}
+
+// WithRecursive adds a recursive common table expression, a query referencing itself
+// usually written as the union of an initial query and a recursive query :
+//
+//	qb.WithRecursive("parents", initial.UnionAll(recursive)).Select("*").From("parents")
+func (b *QueryBuilder) WithRecursive(name string, query *QueryBuilder, columns ...string) *QueryBuilder {
+	return b.add(with, []interface{}{commonTableExpression{Name: name, Columns: columns, Query: query, Recursive: true}}, true)
+}
+
+// ForUpdate locks the selected rows until the end of the transaction,
+// preventing other transactions from locking or updating them
+func (b *QueryBuilder) ForUpdate() *QueryBuilder {
+	return b.Lock(platform.ForUpdate)
+}
+
+// ForShare locks the selected rows until the end of the transaction,
+// preventing other transactions from updating them
+func (b *QueryBuilder) ForShare() *QueryBuilder {
+	return b.Lock(platform.ForShare)
+}
+
+// Lock locks the selected rows with the clause or the table hints of the platform for mode,
+// platform.NoLock removing the lock. Executing the query returns ErrLockNotSupported if the
+// platform can't lock rows with mode. sqlite ignores locks, it locks the whole database.
+func (b *QueryBuilder) Lock(mode platform.LockMode) *QueryBuilder {
+	b.lockMode = mode
+	b.state = Dirty
+	return b
+}
+
+// SkipLocked skips the rows locked by other transactions instead of waiting for them,
+// see ForUpdate and ForShare
+func (b *QueryBuilder) SkipLocked() *QueryBuilder {
+	b.skipLocked = true
+	b.state = Dirty
+	return b
+}
 func (b *QueryBuilder) Where(parts ...interface{}) *QueryBuilder {
 	// Note : in theory there should be only one where part
 	if len(parts) > 1 {
@@ -282,7 +498,7 @@ func (b *QueryBuilder) Update(table string, alias ...string) *QueryBuilder {
 
 // Set sets a new value for a column in a bulk update query.
 func (b *QueryBuilder) Set(field string, value interface{}) *QueryBuilder {
-	return b.add(set, []interface{}{field + " = " + fmt.Sprintf("%v", value)}, true)
+	return b.add(set, []interface{}{assignment{Column: field, Value: value}}, true)
 }
 
 // Delete creates a delete query
@@ -311,19 +527,104 @@ func (b *QueryBuilder) Insert(table string) *QueryBuilder {
 // SetValue sets a value for a column in an insert query
 func (b *QueryBuilder) SetValue(field string, value interface{}) *QueryBuilder {
 	if valuePart, ok := b.sqlParts[values]; ok && len(valuePart) > 0 {
-		valuePart[0].(Values).Set(field, value)
-		return b
+		if valuesOfRow, ok := valuePart[0].(Values); ok {
+			valuesOfRow.Set(field, value)
+			b.state = Dirty
+			return b
+		}
 	}
 	Map := container.NewOrderedMap()
 	Map.Set(field, value)
 	return b.add(values, []interface{}{Values{Map}}, false)
 }
 
-// convert converts an []interface{} to []string
+// Columns sets the columns of an insert query inserting several rows,
+// the values of each row are added with AddValues :
+//
+//	qb.Insert("users").Columns("name", "email").
+//		AddValues(expression.Params("john", "john@acme.com")...).
+//		AddValues(expression.Params("jane", "jane@acme.com")...)
+func (b *QueryBuilder) Columns(columns ...string) *QueryBuilder {
+	return b.add(values, []interface{}{BulkValues{Columns: columns}}, false)
+}
+
+// AddValues adds a row to an insert query, in the order of the columns set with Columns
+func (b *QueryBuilder) AddValues(valuesOfRow ...interface{}) *QueryBuilder {
+	bulkValues := BulkValues{}
+	if valuePart, ok := b.sqlParts[values]; ok && len(valuePart) > 0 {
+		if part, ok := valuePart[0].(BulkValues); ok {
+			bulkValues = part
+		}
+	}
+	bulkValues.Rows = append(bulkValues.Rows, valuesOfRow)
+	return b.add(values, []interface{}{bulkValues}, false)
+}
+
+// OnConflict turns an insert query into an upsert, conflictColumns being the columns
+// of the unique index the inserted rows may conflict with. By default the conflicting rows
+// are updated with the inserted values, see DoUpdate and DoNothing.
+// The clause is generated by the database platform, conflictColumns are ignored by mysql.
+func (b *QueryBuilder) OnConflict(conflictColumns ...string) *QueryBuilder {
+	return b.add(onConflict, []interface{}{upsert{ConflictColumns: conflictColumns}}, false)
+}
+
+// DoUpdate sets the columns of the conflicting rows updated with the inserted values,
+// all the inserted columns except the conflict columns if no column is given
+func (b *QueryBuilder) DoUpdate(updateColumns ...string) *QueryBuilder {
+	onConflictPart := b.getUpsert()
+	onConflictPart.UpdateColumns, onConflictPart.DoNothing = updateColumns, false
+	return b.add(onConflict, []interface{}{onConflictPart}, false)
+}
+
+// DoNothing leaves the conflicting rows untouched
+func (b *QueryBuilder) DoNothing() *QueryBuilder {
+	onConflictPart := b.getUpsert()
+	onConflictPart.UpdateColumns, onConflictPart.DoNothing = nil, true
+	return b.add(onConflict, []interface{}{onConflictPart}, false)
+}
+
+// Returning sets the columns of the inserted rows returned by an insert query,
+// use Query to read them. Executing the query returns ErrReturningNotSupported if
+// the database platform doesn't support RETURNING.
+func (b *QueryBuilder) Returning(columns ...string) *QueryBuilder {
+	contents := []interface{}{}
+	for _, column := range columns {
+		contents = append(contents, column)
+	}
+	return b.add(returning, contents, true)
+}
+
+func (b *QueryBuilder) getUpsert() upsert {
+	if onConflictPart, ok := b.sqlParts[onConflict]; ok && len(onConflictPart) > 0 {
+		return onConflictPart[0].(upsert)
+	}
+	return upsert{}
+}
+
+// getInsertColumns returns the columns of an insert query
+func (b *QueryBuilder) getInsertColumns() []string {
+	valuePart, ok := b.sqlParts[values]
+	if !ok || len(valuePart) == 0 {
+		return nil
+	}
+	switch part := valuePart[0].(type) {
+	case BulkValues:
+		return part.Columns
+	case Values:
+		return b.convert(part.Keys()...)
+	}
+	return nil
+}
+
+// convert converts an []interface{} to []string, rendering the parts for the database platform
 func (b *QueryBuilder) convert(values ...interface{}) []string {
+	var databasePlatform platform.DatabasePlatform
+	if b.connection != nil {
+		databasePlatform = b.connection.GetDatabasePlatform()
+	}
 	result := []string{}
 	for _, value := range values {
-		result = append(result, fmt.Sprint(value))
+		result = append(result, expression.ToSQL(value, databasePlatform))
 	}
 	return result
 }
@@ -341,7 +642,7 @@ func (b *QueryBuilder) add(part string, contents []interface{}, doAppend bool) *
 func (b *QueryBuilder) getSQLForUpdate() string {
 	query := update + " " + strings.Join(b.convert(b.sqlParts[from]...), "")
 	query += " " + set + " " + strings.Join(b.convert(b.sqlParts[set]...), ", ")
-	if wherePart, ok := b.sqlParts[where]; ok {
+	if wherePart := b.getWherePart(); len(wherePart) > 0 {
 		query += " " + where + " " + strings.Join(b.convert(wherePart...), "")
 	}
 	return query
@@ -368,6 +669,9 @@ func (b *QueryBuilder) getSQLForFrom() string {
 
 			// had to query
 			query += " " + f.String()
+			if hint := b.getLockHintSQL(); hint != "" && f.Subquery == nil {
+				query += " " + hint
+			}
 			// if there are some join parts
 			if joinPartok {
 				indexes := []int{}
@@ -377,7 +681,7 @@ func (b *QueryBuilder) getSQLForFrom() string {
 					j := join.(Join)
 					if f.Alias == j.FromAlias || f.Table == j.FromAlias {
 						// add to query right after the from part
-						query += " " + j.String()
+						query += " " + b.lockJoin(b.filterJoin(j)).String()
 						indexes = append(indexes, i)
 					}
 				}
@@ -404,15 +708,38 @@ func (b *QueryBuilder) getSQLForFrom() string {
 	}
 	// if there are some join parts left, add them at the end of the from statement
 	if len(joinPartRest) > 0 {
+		for i, join := range joinPartRest {
+			joinPartRest[i] = b.lockJoin(b.filterJoin(join.(Join)))
+		}
 		query += " " + strings.Join(b.convert(joinPartRest...), " ")
 	}
 	return query
 }
+// getLockHintSQL returns the table hint locking the rows read from the tables of the query
+func (b *QueryBuilder) getLockHintSQL() string {
+	if b.lockMode == platform.NoLock {
+		return ""
+	}
+	return b.connection.GetDatabasePlatform().GetLockHintSQL(b.lockMode, b.skipLocked)
+}
+
+// lockJoin adds the lock hint of the query to a joined table
+func (b *QueryBuilder) lockJoin(join Join) Join {
+	if hint := b.getLockHintSQL(); hint != "" {
+		join.Alias = strings.TrimLeft(join.Alias+" "+hint, " ")
+	}
+	return join
+}
+
 func (b *QueryBuilder) getSQLForSelect() string {
-	query := "SELECT " + strings.Join(b.convert(b.sqlParts[select_]...), ", ")
+	query := "SELECT "
+	if b.distinct {
+		query += "DISTINCT "
+	}
+	query += strings.Join(b.convert(b.sqlParts[select_]...), ", ")
 
 	query += b.getSQLForFrom()
-	if wherePart, ok := b.sqlParts[where]; ok {
+	if wherePart := b.getWherePart(); len(wherePart) > 0 {
 		query += " WHERE " + strings.Join(b.convert(wherePart...), "")
 	}
 	if groupByPart, ok := b.sqlParts[groupBy]; ok {
@@ -421,22 +748,30 @@ func (b *QueryBuilder) getSQLForSelect() string {
 	if b.sqlParts[having] != nil {
 		query += " HAVING " + strings.Join(b.convert(b.sqlParts[having]...), "")
 	}
+	if unionPart, ok := b.sqlParts[union]; ok {
+		query += " " + strings.Join(b.convert(unionPart...), " ")
+	}
 	if b.sqlParts[orderBy] != nil {
 		query += " ORDER BY " + strings.Join(b.convert(b.sqlParts[orderBy]...), ", ")
 	}
 	if b.isLimitquery {
-		return b.connection.GetDatabasePlatform().ModifyLimitQuery(
+		query = b.connection.GetDatabasePlatform().ModifyLimitQuery(
 			query,
 			b.maxResults,
 			b.firstResult,
 		)
 	}
+	if b.lockMode != platform.NoLock {
+		if lock := b.connection.GetDatabasePlatform().GetLockSQL(b.lockMode, b.skipLocked); lock != "" {
+			query += " " + lock
+		}
+	}
 	return query
 }
 
 func (b *QueryBuilder) getSQLDelete() string {
 	query := "DELETE FROM " + strings.Join(b.convert(b.sqlParts[from]...), "")
-	if wherePart, ok := b.sqlParts[where]; ok {
+	if wherePart := b.getWherePart(); len(wherePart) > 0 {
 		query += " WHERE " + strings.Join(b.convert(wherePart...), "")
 	}
 	if b.isLimitquery {
@@ -452,39 +787,204 @@ func (b *QueryBuilder) getSQLDelete() string {
 
 func (b *QueryBuilder) getSQLForInsert() string {
 	query := "INSERT INTO " + strings.Join(b.convert(b.sqlParts[insert]...), "")
-	query += " " + strings.Join(b.convert(b.sqlParts[values]...), "")
+	databasePlatform := b.connection.GetDatabasePlatform()
+	if len(b.sqlParts[values]) == 0 {
+		query += " " + databasePlatform.GetDefaultValuesSQL()
+	} else {
+		query += " " + strings.Join(b.convert(b.sqlParts[values]...), "")
+	}
+	if _, ok := b.sqlParts[onConflict]; ok {
+		onConflictPart, columns := b.getUpsert(), b.getInsertColumns()
+		updateColumns := onConflictPart.UpdateColumns
+		if len(updateColumns) == 0 && !onConflictPart.DoNothing {
+			for _, column := range columns {
+				if indexOfString(onConflictPart.ConflictColumns, column) == -1 {
+					updateColumns = append(updateColumns, column)
+				}
+			}
+		}
+		query += " " + databasePlatform.GetUpsertSQL(columns, onConflictPart.ConflictColumns, updateColumns)
+	}
+	if returningPart, ok := b.sqlParts[returning]; ok && databasePlatform.SupportsReturning() {
+		query += " " + databasePlatform.GetReturningSQL(b.convert(returningPart...))
+	}
 	return query
 }
 
+// String returns the query with the placeholders of the database platform,
+// or the query between parentheses with its named placeholders when it is
+// rendered as a subquery.
 func (b *QueryBuilder) String() string {
-	if b.sql != "" && b.state == Clean {
+	if b.isSubquery {
+		return "(" + b.getSQL() + ")"
+	}
+	if b.sql != "" && b.state == Clean && len(b.getSubqueries()) == 0 {
 		return b.sql
 	}
+	b.sql, b.placeholders = parseParameters(b.getSQL(), b.connection.GetDatabasePlatform())
+	b.state = Clean
+	return b.sql
+}
+
+// getSQL returns the query with its named placeholders
+func (b *QueryBuilder) getSQL() string {
+	subqueries := b.getSubqueries()
+	for _, subquery := range subqueries {
+		subquery.isSubquery, subquery.filters = true, b.filters
+	}
+	defer func() {
+		for _, subquery := range subqueries {
+			subquery.isSubquery, subquery.filters = false, nil
+		}
+	}()
+	query := ""
+	if withPart, ok := b.sqlParts[with]; ok {
+		query = "WITH "
+		for _, part := range withPart {
+			if part.(commonTableExpression).Recursive {
+				query += "RECURSIVE "
+				break
+			}
+		}
+		query += strings.Join(b.convert(withPart...), ", ") + " "
+	}
 	switch b.builderType {
 	case Insert:
-		b.sql = b.getSQLForInsert()
+		query += b.getSQLForInsert()
 	case Delete:
-		b.sql = b.getSQLDelete()
+		query += b.getSQLDelete()
 	case Update:
-		b.sql = b.getSQLForUpdate()
+		query += b.getSQLForUpdate()
 	default:
-		b.sql = b.getSQLForSelect()
+		query += b.getSQLForSelect()
 	}
-	b.state = Clean
-	return b.sql
+	return query
+}
+
+// getSubqueries returns the query builders nested in the query
+func (b *QueryBuilder) getSubqueries() []*QueryBuilder {
+	subqueries := []*QueryBuilder{}
+	for _, part := range b.sqlParts {
+		walkParts(func(part interface{}) {
+			if subquery, ok := part.(*QueryBuilder); ok {
+				subqueries = append(subqueries, subquery)
+			}
+		}, part...)
+	}
+	return subqueries
 }
 
 func (b *QueryBuilder) Prepare() *Statement {
-	return b.GetConnection().Prepare(b.String())
+	return b.PrepareContext(context.Background())
+}
+func (b *QueryBuilder) PrepareContext(ctx context.Context) *Statement {
+	if filtered, err := b.applyFilters(ctx); err != nil {
+		return &Statement{query: b.String(), err: err}
+	} else if filtered != b {
+		// the values of the filters are not arguments of the statement
+		return &Statement{query: b.String(), err: ErrFilteredStatement}
+	}
+	if transaction, ok := b.queryer.(*Transaction); ok {
+		statement, err := transaction.PrepareContext(ctx, b.String())
+		if err != nil {
+			return &Statement{query: b.String(), err: err}
+		}
+		return statement
+	}
+	return b.GetConnection().PrepareContext(ctx, b.String())
 }
+
+// Exec executes the query with its parameters, arguments being
+// the values of positional placeholders
 func (b *QueryBuilder) Exec(arguments ...interface{}) (sql.Result, error) {
-	return b.GetConnection().Exec(b.String(), arguments...)
+	return b.ExecContext(context.Background(), arguments...)
+}
+
+// ExecContext executes the query with its parameters, see Exec
+func (b *QueryBuilder) ExecContext(ctx context.Context, arguments ...interface{}) (sql.Result, error) {
+	b, err := b.applyFilters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	arguments, err = b.GetArguments(arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return b.queryer.ExecContext(ctx, b.String(), arguments...)
 }
 func (b *QueryBuilder) Query(arguments ...interface{}) *Rows {
-	return b.GetConnection().Query(b.String(), arguments...)
+	return b.QueryContext(context.Background(), arguments...)
+}
+func (b *QueryBuilder) QueryContext(ctx context.Context, arguments ...interface{}) *Rows {
+	b, err := b.applyFilters(ctx)
+	if err != nil {
+		return &Rows{err: err}
+	}
+	arguments, err = b.GetArguments(arguments...)
+	if err != nil {
+		return &Rows{err: err}
+	}
+	if cached, ok := b.queryer.(cachingQueryer); ok && b.cache != nil {
+		return cached.queryCached(ctx, b.getCacheOptions(), b.String(), arguments, func() *Rows {
+			return b.queryer.QueryContext(ctx, b.String(), arguments...)
+		})
+	}
+	return b.queryer.QueryContext(ctx, b.String(), arguments...)
 }
 func (b *QueryBuilder) QueryRow(arguments ...interface{}) *Row {
-	return b.GetConnection().QueryRow(b.String(), arguments...)
+	return b.QueryRowContext(context.Background(), arguments...)
+}
+func (b *QueryBuilder) QueryRowContext(ctx context.Context, arguments ...interface{}) *Row {
+	b, err := b.applyFilters(ctx)
+	if err != nil {
+		return &Row{err: err}
+	}
+	if b.cache != nil {
+		if _, ok := b.queryer.(cachingQueryer); ok {
+			rows := b.QueryContext(ctx, arguments...)
+			return &Row{rows: rows.rows, err: rows.err}
+		}
+	}
+	arguments, err = b.GetArguments(arguments...)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return b.queryer.QueryRowContext(ctx, b.String(), arguments...)
+}
+
+// UseCache caches the results of the query for ttl in the result cache of the connection,
+// see DefaultConnection.SetResultCache, a ttl of 0 never expiring. The cache key is derived
+// from the SQL and the arguments of the query if key is "". Results are tagged with the tables
+// of the FROM and JOIN clauses and with tags, writes to a table through the connection
+// invalidating the results tagged with the table. Queries run in a transaction are not cached.
+//
+//	err := connection.CreateQueryBuilder().Select("*").From("countries").
+//		UseCache("countries", time.Hour).
+//		Query().GetResults(&countries)
+func (b *QueryBuilder) UseCache(key string, ttl time.Duration, tags ...string) *QueryBuilder {
+	b.cache = &cacheOptions{key: key, ttl: ttl, tags: tags}
+	return b
+}
+
+// getCacheOptions returns the options of the result cache, tagged with the tables of the query
+func (b *QueryBuilder) getCacheOptions() *cacheOptions {
+	// the tables of subqueries and common table expressions are tags of the query as well
+	tags := append(append([]string{}, b.cache.tags...), b.getTables()...)
+	options := &cacheOptions{key: b.cache.key, ttl: b.cache.ttl}
+	if options.key != "" && b.filters != nil {
+		// the results of a filtered query depend on the values of the filters
+		for _, name := range b.filters.names {
+			options.key += fmt.Sprintf(" %s=%v", name, b.filters.values[name])
+		}
+	}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if tag = normalizeCacheTag(tag); !seen[tag] {
+			seen[tag] = true
+			options.tags = append(options.tags, tag)
+		}
+	}
+	return options
 }
 
 type Type int
@@ -492,6 +992,17 @@ type Type int
 var (
 	ErrNotASelectStatement = fmt.Errorf("Error the query is not a select statement")
 	ErrSelectStatement     = fmt.Errorf("Error the query is a select statement ")
+	// ErrMissingParameter is yield when no value was given for a parameter of a query
+	ErrMissingParameter = fmt.Errorf("Error a parameter of the query has no value")
+	// ErrReturningNotSupported is yield when an insert query with a RETURNING clause
+	// is executed on a platform that doesn't support RETURNING
+	ErrReturningNotSupported = fmt.Errorf("Error the database platform doesn't support RETURNING")
+	// ErrLockNotSupported is yield when a query locking rows is executed on a platform
+	// that can't lock rows with the lock mode of the query
+	ErrLockNotSupported = fmt.Errorf("Error the database platform doesn't support the lock mode of the query")
+	// ErrDefaultValuesNotSupported is yield when an insert query without values is executed
+	// on a platform that can't insert a row of default values
+	ErrDefaultValuesNotSupported = fmt.Errorf("Error the database platform can't insert a row without values")
 )
 
 const (
@@ -513,6 +1024,10 @@ const (
 	select_                = "SELECT"
 	insert                 = "INSERT"
 	values                 = "VALUES"
+	onConflict             = "ON CONFLICT"
+	union                  = "UNION"
+	with                   = "WITH"
+	returning              = "RETURNING"
 	update                 = "UPDATE"
 	from                   = "FROM"
 	where                  = "WHERE"
@@ -534,24 +1049,119 @@ type Values struct {
 }
 
 func (v Values) String() string {
+	return v.ToSQL(nil)
+}
+
+func (v Values) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	if v.OrderedMap.Length() == 0 {
+		if databasePlatform == nil {
+			return "DEFAULT VALUES"
+		}
+		return databasePlatform.GetDefaultValuesSQL()
+	}
 	keys := []string{}
 	values := []string{}
 	for i := 0; i < v.OrderedMap.Length(); i++ {
-		keys = append(keys, fmt.Sprint(v.OrderedMap.KeyAt(i)))
-		values = append(values, fmt.Sprint(v.OrderedMap.ValueAt(i)))
+		keys = append(keys, getColumnSQL(databasePlatform, fmt.Sprint(v.OrderedMap.KeyAt(i))))
+		values = append(values, expression.ToSQL(v.OrderedMap.ValueAt(i), databasePlatform))
 	}
 	return fmt.Sprintf("(%s) VALUES(%s)", strings.Join(keys, ", "), strings.Join(values, ", "))
 }
 
-// FROM represents a part of a FROM statement
+// BulkValues are the values of the rows inserted by an insert query
+type BulkValues struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func (v BulkValues) String() string {
+	return v.ToSQL(nil)
+}
+
+func (v BulkValues) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	rows := []string{}
+	for _, row := range v.Rows {
+		values := []string{}
+		for _, value := range row {
+			values = append(values, expression.ToSQL(value, databasePlatform))
+		}
+		rows = append(rows, "("+strings.Join(values, ", ")+")")
+	}
+	columns := []string{}
+	for _, column := range v.Columns {
+		columns = append(columns, getColumnSQL(databasePlatform, column))
+	}
+	return fmt.Sprintf("(%s) VALUES%s", strings.Join(columns, ", "), strings.Join(rows, ", "))
+}
+
+// getColumnSQL returns the name of a column written by a query, quoted if it is a reserved keyword
+func getColumnSQL(databasePlatform platform.DatabasePlatform, column string) string {
+	if databasePlatform == nil {
+		return column
+	}
+	return platform.GetIdentifierSQL(databasePlatform, column)
+}
+
+// upsert is the ON CONFLICT part of an insert query
+type upsert struct {
+	ConflictColumns []string
+	UpdateColumns   []string
+	DoNothing       bool
+}
+
+// assignment is a column = value part of an UPDATE statement
+type assignment struct {
+	Column string
+	Value  interface{}
+}
+
+func (a assignment) String() string {
+	return a.ToSQL(nil)
+}
+
+func (a assignment) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	return getColumnSQL(databasePlatform, a.Column) + " = " + expression.ToSQL(a.Value, databasePlatform)
+}
+
+// FROM represents a part of a FROM statement, either a table or a subquery
 type From struct {
 	Table, Alias string
+	Subquery     *QueryBuilder
 }
 
 func (f From) String() string {
+	if f.Subquery != nil {
+		return strings.Trim(f.Subquery.String()+" "+f.Alias, " ")
+	}
 	return strings.Trim(f.Table+" "+f.Alias, " ")
 }
 
+// compound is a query combined with the query of a select statement by UNION, INTERSECT or EXCEPT
+type compound struct {
+	Operator string
+	Query    *QueryBuilder
+}
+
+func (c compound) String() string {
+	return c.Operator + " " + c.Query.getSQL()
+}
+
+// commonTableExpression is a query named in the WITH clause of a statement
+type commonTableExpression struct {
+	Name      string
+	Columns   []string
+	Query     *QueryBuilder
+	Recursive bool
+}
+
+func (cte commonTableExpression) String() string {
+	name := cte.Name
+	if len(cte.Columns) > 0 {
+		name += " (" + strings.Join(cte.Columns, ", ") + ")"
+	}
+	return name + " AS (" + cte.Query.getSQL() + ")"
+}
+
 // Join represents a JOIN statement
 type Join struct {
 	FromAlias, Table, Alias string
@@ -560,5 +1170,13 @@ type Join struct {
 }
 
 func (join Join) String() string {
-	return fmt.Sprint(append([]interface{}{join.Type, " ", join.Table, " ", join.Alias, " ON "}, join.Conditions...)...)
+	return join.ToSQL(nil)
+}
+
+func (join Join) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	result := fmt.Sprint(join.Type, " ", join.Table, " ", join.Alias, " ON ")
+	for _, condition := range join.Conditions {
+		result += expression.ToSQL(condition, databasePlatform)
+	}
+	return result
 }
@@ -15,6 +15,8 @@
 package db_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/Mparaiso/go-tiger/db"
@@ -669,6 +671,203 @@ func TestSelectAllWithoutTableAlias(t *testing.T) {
 	test.Fatal(t, qb.String(), "SELECT * FROM users")
 }
 
+func TestBuilderParameters(t *testing.T) {
+	connection := NewTestConnection(t)
+	qb := db.NewQueryBuilder(connection)
+	id := Param(42)
+	qb.Select("*").From("users").
+		Where(Eq("id", id), "name = :name", "created > ?", In("status", Params("new", "active")...), "nick = ':nick'", "age::text = :age").
+		SetParameter("name", "john").
+		SetParameters(map[string]interface{}{"age": 30})
+	test.Fatal(t, qb.String(), "SELECT * FROM users WHERE (id = ?) AND (name = ?) AND (created > ?) AND (status IN ( ? , ? )) AND (nick = ':nick') AND (age::text = ?)")
+	test.Fatal(t, qb.GetParameters()[id.Name], 42)
+	arguments, err := qb.GetArguments("2016-01-01")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint(42, "john", "2016-01-01", "new", "active", 30))
+	_, err = db.NewQueryBuilder(connection).Select("*").From("users").Where(Eq("name", Named("name"))).GetArguments()
+	test.Fatal(t, err, db.ErrMissingParameter)
+	_, err = db.NewQueryBuilder(connection).Select("*").From("users").Where("id = ?").GetArguments()
+	test.Fatal(t, err, db.ErrMissingParameter)
+}
+
+func TestBuilderParametersPostgreSql(t *testing.T) {
+	connection := &TestConnection{DatabasePlatform: platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())}
+	qb := db.NewQueryBuilder(connection).Update("users").
+		Set("name", Param("john")).
+		Set("email", Named("email")).
+		Where(Eq("id", "?"))
+	test.Fatal(t, qb.String(), "UPDATE users SET name = $1, email = $2 WHERE id = $3")
+	qb.SetParameter("email", "john@example.com")
+	arguments, err := qb.GetArguments(1)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint("john", "john@example.com", 1))
+}
+
+func TestBuilderExecWithParameters(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	_, err := connection.CreateQueryBuilder().Update("users").
+		Set("email", Param("johnny@acme.com")).
+		Where(Eq("name", Named("name"))).
+		SetParameter("name", "John Doe").
+		Exec()
+	test.Fatal(t, err, nil)
+	var email string
+	err = connection.CreateQueryBuilder().Select("email").From("users").
+		Where(Eq("name", Param("John Doe"))).
+		QueryRow().GetSingleResult(&email)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, email, "johnny@acme.com")
+}
+
+func TestBuilderSubqueries(t *testing.T) {
+	connection := &TestConnection{DatabasePlatform: platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())}
+	authors := db.NewQueryBuilder(connection).Select("author_id").From("articles").Where(Eq("published", Param(true)))
+	qb := db.NewQueryBuilder(connection).Select("*").From("users").
+		Where(Eq("name", Param("john")), In("id", authors), Exists(db.NewQueryBuilder(connection).Select("1").From("comments").Where("comments.user_id = users.id", Eq("status", Named("status"))))).
+		SetParameter("status", "approved")
+	test.Fatal(t, qb.String(), "SELECT * FROM users WHERE (name = $1) AND (id IN (SELECT author_id FROM articles WHERE published = $2)) AND (EXISTS (SELECT 1 FROM comments WHERE (comments.user_id = users.id) AND (status = $3)))")
+	arguments, err := qb.GetArguments()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint("john", true, "approved"))
+	// the subquery is rendered alone once the outer query is rendered
+	test.Fatal(t, authors.String(), "SELECT author_id FROM articles WHERE published = $1")
+
+	qb = db.NewQueryBuilder(connection).
+		With("authors", authors).
+		Select("name").Distinct().FromSubquery(db.NewQueryBuilder(connection).Select("*").From("users").Where(Gt("id", Param(10))), "u").
+		Where("u.id IN (SELECT author_id FROM authors)").
+		Union(db.NewQueryBuilder(connection).Select("name").From("admins")).
+		OrderBy("name").
+		ForUpdate().SkipLocked()
+	test.Fatal(t, qb.String(), "WITH authors AS (SELECT author_id FROM articles WHERE published = $1) SELECT DISTINCT name FROM (SELECT * FROM users WHERE id > $2) u WHERE u.id IN (SELECT author_id FROM authors) UNION SELECT name FROM admins ORDER BY name ASC FOR UPDATE SKIP LOCKED")
+	arguments, err = qb.GetArguments()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint(true, 10))
+
+	qb = db.NewQueryBuilder(connection).Select("*").From("users").Lock(platform.ForShare)
+	test.Fatal(t, qb.String(), "SELECT * FROM users FOR SHARE")
+	test.Fatal(t, qb.Lock(platform.NoLock).String(), "SELECT * FROM users")
+}
+
+func TestBuilderLimitedUnionOnMSSql(t *testing.T) {
+	connection := &TestConnection{DatabasePlatform: platform.NewMSSqlPlatform(platform.NewDefaultPlatform())}
+	qb := db.NewQueryBuilder(connection).Select("name").From("users").
+		Union(db.NewQueryBuilder(connection).Select("name").From("admins")).
+		SetMaxResults(10)
+	test.Fatal(t, qb.String(), "SELECT TOP (10) * FROM (SELECT name FROM users UNION SELECT name FROM admins) tiger_query")
+	test.Fatal(t, qb.OrderBy("name").String(), "SELECT name FROM users UNION SELECT name FROM admins ORDER BY name ASC OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY")
+}
+
+func TestBuilderLockHints(t *testing.T) {
+	mssql := &TestConnection{DatabasePlatform: platform.NewMSSqlPlatform(platform.NewDefaultPlatform())}
+	qb := db.NewQueryBuilder(mssql).Select("*").From("users", "u").Join("u", "articles", "a", "a.author_id = u.id").
+		Where(Eq("u.id", Param(1))).ForUpdate()
+	test.Fatal(t, qb.String(), "SELECT * FROM users u WITH (UPDLOCK, ROWLOCK) JOIN articles a WITH (UPDLOCK, ROWLOCK) ON a.author_id = u.id WHERE u.id = @p1")
+	test.Fatal(t, db.NewQueryBuilder(mssql).Select("*").From("users").ForShare().SkipLocked().String(),
+		"SELECT * FROM users WITH (REPEATABLEREAD, ROWLOCK, READPAST)")
+
+	oracle := &TestConnection{DatabasePlatform: platform.NewOraclePlatform(platform.NewDefaultPlatform())}
+	_, err := db.NewQueryBuilder(oracle).Select("*").From("users").ForShare().GetArguments()
+	test.Fatal(t, err, db.ErrLockNotSupported)
+	_, err = db.NewQueryBuilder(oracle).Select("*").From("users").ForUpdate().GetArguments()
+	test.Fatal(t, err, nil)
+}
+
+func TestBuilderSubqueriesQuery(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	var names []string
+	rows, err := connection.CreateQueryBuilder().Select("name").From("users").
+		Where(In("id", connection.CreateQueryBuilder().Select("id").From("users").Where(Neq("name", Param("John Doe"))))).
+		Union(connection.CreateQueryBuilder().Select("name").From("users").Where(Eq("name", Param("John Doe")))).
+		OrderBy("name").
+		ForUpdate().
+		Query().GetRows()
+	test.Fatal(t, err, nil)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		test.Fatal(t, rows.Scan(&name), nil)
+		names = append(names, name)
+	}
+	test.Fatal(t, strings.Join(names, ", "), "Jack Doe, Jane Doe, John Doe")
+	rows.Close()
+
+	var count int
+	err = connection.CreateQueryBuilder().
+		WithRecursive("counter", connection.CreateQueryBuilder().Select("1").
+			UnionAll(connection.CreateQueryBuilder().Select("n + 1").From("counter").Where(Lt("n", Param(5)))), "n").
+		Select("SUM(n)").From("counter").
+		QueryRow().GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 15)
+
+	var rank int
+	err = connection.CreateQueryBuilder().Select("rank").
+		FromSubquery(connection.CreateQueryBuilder().Select("name", Over("ROW_NUMBER()").OrderBy("name").As("rank").String()).From("users"), "ranked").
+		Where(Eq("name", Param("John Doe"))).
+		QueryRow().GetSingleResult(&rank)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, rank, 3)
+}
+
+func TestBuilderExpressions(t *testing.T) {
+	connection := &TestConnection{DatabasePlatform: platform.NewMySqlPlatform(platform.NewDefaultPlatform())}
+	qb := db.NewQueryBuilder(connection).Update("articles").
+		Set("position", Plus("position", Param(1))).
+		Where(Eq("order", Raw("? * 2", 5)), ILike("title", Param("%go%")), Lt(Coalesce("updated", "created"), Named("date")))
+	test.Fatal(t, qb.String(), "UPDATE articles SET position = (position + ?) WHERE (`order` = ? * 2) AND (LOWER(title) LIKE LOWER(?)) AND (COALESCE(updated, created) < ?)")
+	arguments, err := qb.SetParameter("date", "2016-01-01").GetArguments()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint(1, 5, "%go%", "2016-01-01"))
+
+	// placeholders in string literals are not parameters of raw fragments
+	qb = db.NewQueryBuilder(connection).Select("*").From("users").Where(Raw("name = '?' OR id = ?", 1))
+	test.Fatal(t, qb.String(), "SELECT * FROM users WHERE name = '?' OR id = ?")
+	arguments, err = qb.GetArguments()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint(1))
+
+	sqlite := GetConnection(t)
+	test.Fatal(t, LoadFixtures(sqlite), nil)
+	var count int
+	err = sqlite.CreateQueryBuilder().Select("COUNT(*)").From("users").
+		Where(Or(ILike("name", Param("JOHN%")), And(Between("id", Param(2), Param(3)), Not(Eq(Lower("name"), Param("jack doe")))))).
+		QueryRow().GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 2)
+	err = sqlite.CreateQueryBuilder().Select("COUNT(*)").From("users").
+		Where(Eq(Case().When(Eq("name", Param("John Doe")), Concat(Param("Mr "), "name")).Else("name"), Param("Mr John Doe"))).
+		QueryRow().GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+}
+
+func TestBuilderInsertMany(t *testing.T) {
+	connection := &TestConnection{DatabasePlatform: platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())}
+	qb := db.NewQueryBuilder(connection).Insert("users").Columns("name", "email").
+		AddValues(Params("john", "john@acme.com")...).
+		AddValues(Params("jane", "jane@acme.com")...).
+		OnConflict("email").
+		Returning("id")
+	test.Fatal(t, qb.String(), "INSERT INTO users (name, email) VALUES($1, $2), ($3, $4) ON CONFLICT (email) DO UPDATE SET name = excluded.name RETURNING id")
+	arguments, err := qb.GetArguments()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fmt.Sprint(arguments...), fmt.Sprint("john", "john@acme.com", "jane", "jane@acme.com"))
+	test.Fatal(t, qb.DoNothing().String(), "INSERT INTO users (name, email) VALUES($1, $2), ($3, $4) ON CONFLICT (email) DO NOTHING RETURNING id")
+
+	connection = &TestConnection{DatabasePlatform: platform.NewMySqlPlatform(platform.NewDefaultPlatform())}
+	qb = db.NewQueryBuilder(connection).Insert("users").
+		SetValue("name", Param("john")).
+		SetValue("email", Param("john@acme.com")).
+		OnConflict("email").DoUpdate("name").
+		Returning("id")
+	test.Fatal(t, qb.String(), "INSERT INTO users (name, email) VALUES(?, ?) ON DUPLICATE KEY UPDATE name = VALUES(name)")
+	_, err = qb.GetArguments()
+	test.Fatal(t, err, db.ErrReturningNotSupported)
+}
+
 //     /**
 //      * @group DBAL-959
 //      */
@@ -15,14 +15,19 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"reflect"
 
 	"regexp"
+	"sort"
 
 	"strings"
+	"sync"
 
+	"github.com/Mparaiso/go-tiger/container"
 	"github.com/Mparaiso/go-tiger/db/expression"
 	"github.com/Mparaiso/go-tiger/db/platform"
 	"github.com/Mparaiso/go-tiger/logger"
@@ -35,27 +40,41 @@ var (
 	ErrNotAStruct = fmt.Errorf("Error this value is not a struct")
 )
 
-// Connection is the db connection
+// Connection is the db connection.
+// Methods with a Context suffix take a context that cancels the query
+// when it is done, the other methods use context.Background.
 type Connection interface {
 	Begin() (*Transaction, error)
+	// BeginTx begins a transaction, options set its isolation level
+	// and whether it is read only, options may be nil.
+	BeginTx(ctx context.Context, options *sql.TxOptions) (*Transaction, error)
 	Close() error
 	DB() *sql.DB
 	Ping() error
 	CreateQueryBuilder() *QueryBuilder
 	Exec(query string, parameters ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, parameters ...interface{}) (sql.Result, error)
 	GetDatabasePlatform() platform.DatabasePlatform
 	GetDriverName() string
 	Prepare(sql string) *Statement
+	PrepareContext(ctx context.Context, sql string) *Statement
 	Query(sql string, arguments ...interface{}) *Rows
+	QueryContext(ctx context.Context, sql string, arguments ...interface{}) *Rows
 	QueryRow(sql string, arguments ...interface{}) *Row
+	QueryRowContext(ctx context.Context, sql string, arguments ...interface{}) *Row
 	SetLogger(logger.Logger)
 	Quote(input string, inputType ...string) string
+	// Transactional runs fn in a transaction committed if fn returns nil
+	// and rolled back otherwise
+	Transactional(ctx context.Context, fn TransactionalFunc) error
 }
 
 // ConnectionOptions gather options related to Connection type.
 type ConnectionOptions struct {
 	Logger              logger.Logger
 	IgnoreMissingFields bool
+	// Observers observe the queries of the connection, its transactions and statements
+	Observers []QueryObserver
 }
 
 // DefaultConnection is a database connection.
@@ -65,6 +84,16 @@ type DefaultConnection struct {
 	DriverName string
 	Options    *ConnectionOptions
 	Platform   platform.DatabasePlatform
+	// statementCache caches the statements prepared by the connection
+	statementCache *StatementCache
+	// resultCache caches the results of the queries built with QueryBuilder.UseCache
+	resultCache        ResultCache
+	resultCacheFlights *singleFlight
+	// resultCacheGeneration is incremented each time cached results are invalidated
+	resultCacheGeneration uint64
+	// filters restrict the queries built with QueryBuilder, see AddFilter
+	filters     map[string]*Filter
+	filterMutex sync.RWMutex
 }
 
 // NewConnection creates an  Connection
@@ -97,6 +126,23 @@ func (connection *DefaultConnection) SetLogger(Logger logger.Logger) {
 	connection.GetOptions().Logger = Logger
 }
 
+// SetStatementCache sets the cache of the statements prepared with the connection,
+// cached statements are closed by the cache and not by Statement.Close.
+func (connection *DefaultConnection) SetStatementCache(cache *StatementCache) {
+	connection.statementCache = cache
+}
+
+// GetStatementCache returns the statement cache of the connection, nil if statements are not cached
+func (connection *DefaultConnection) GetStatementCache() *StatementCache {
+	return connection.statementCache
+}
+
+// AddQueryObserver adds an observer of the queries of the connection.
+// Observers must be added before the connection is used concurrently.
+func (connection *DefaultConnection) AddQueryObserver(observer QueryObserver) {
+	connection.GetOptions().Observers = append(connection.GetOptions().Observers, observer)
+}
+
 // GetDriverName returns the DriverName
 func (connection *DefaultConnection) GetDriverName() string {
 	return connection.DriverName
@@ -114,18 +160,11 @@ func (connection *DefaultConnection) GetDatabasePlatform() platform.DatabasePlat
 	}
 	return connection.Platform
 }
+
+// detectDatabasePlatform sets the platform registered for the driver of the connection,
+// see platform.Register
 func (connection *DefaultConnection) detectDatabasePlatform() {
-	databasePlatform := platform.NewDefaultPlatform()
-	switch connection.GetDriverName() {
-	case "sqlite3", "sqlite":
-		connection.Platform = platform.NewSqlitePlatform(databasePlatform)
-	case "mysql":
-		connection.Platform = platform.NewMySqlPlatform(databasePlatform)
-	case "postgresql":
-		connection.Platform = platform.NewPostgreSqlPlatform(databasePlatform)
-	default:
-		connection.Platform = databasePlatform
-	}
+	connection.Platform = platform.GetPlatform(connection.GetDriverName())
 }
 
 // Ping verifies a connection to the database is still alive,
@@ -141,9 +180,18 @@ func (connection *DefaultConnection) DB() *sql.DB {
 
 // Delete all rows of a table matching the given identifier, where keys are column names.
 func (connection *DefaultConnection) Delete(table string, criteria map[string]interface{}) (sql.Result, error) {
-	qb := connection.CreateQueryBuilder().Delete(table)
-	expression, data := mapToExpression(criteria)
-	return qb.Where(expression).Exec(data...)
+	return connection.DeleteContext(context.Background(), table, criteria)
+}
+
+// DeleteContext deletes all rows of a table matching criteria
+func (connection *DefaultConnection) DeleteContext(ctx context.Context, table string, criteria map[string]interface{}) (sql.Result, error) {
+	return deleteRows(ctx, connection.CreateQueryBuilder(), table, criteria)
+}
+
+// deleteRows deletes all rows of a table matching criteria with qb
+func deleteRows(ctx context.Context, qb *QueryBuilder, table string, criteria map[string]interface{}) (sql.Result, error) {
+	qb.Delete(table)
+	return qb.Where(mapToExpression(qb, criteria)).ExecContext(ctx)
 }
 
 func (connection *DefaultConnection) QuoteIdentifier(identifier string) string {
@@ -157,47 +205,99 @@ func (connection *DefaultConnection) Quote(input string, inputType ...string) st
 }
 
 // Update executes an SQL UPDATE statement on a table.
+// When data is a struct with a field tagged version, only the row with the version of the
+// struct is updated and its version is incremented, the version field being set to the new
+// version if data is a pointer. ErrOptimisticLock is returned if no row was updated.
 func (connection *DefaultConnection) Update(table string, criteria map[string]interface{}, data interface{}) (sql.Result, error) {
+	return connection.UpdateContext(context.Background(), table, criteria, data)
+}
+
+// UpdateContext executes an SQL UPDATE statement on a table.
+func (connection *DefaultConnection) UpdateContext(ctx context.Context, table string, criteria map[string]interface{}, data interface{}) (sql.Result, error) {
+	return updateRows(ctx, connection.CreateQueryBuilder(), table, criteria, data, nil)
+}
+
+// UpdateColumns executes an SQL UPDATE statement writing the given columns of data, zero values
+// included, to the rows of a table matching criteria. The version of a versioned struct is
+// checked and incremented, see Update. No statement is executed if no column is given.
+//
+//	_, err := connection.UpdateColumns("users", map[string]interface{}{"id": 1}, user, "name", "active")
+func (connection *DefaultConnection) UpdateColumns(table string, criteria map[string]interface{}, data interface{}, columns ...string) (sql.Result, error) {
+	return connection.UpdateColumnsContext(context.Background(), table, criteria, data, columns...)
+}
+
+// UpdateColumnsContext updates the given columns of the rows of a table, see UpdateColumns
+func (connection *DefaultConnection) UpdateColumnsContext(ctx context.Context, table string, criteria map[string]interface{}, data interface{}, columns ...string) (sql.Result, error) {
+	if len(columns) == 0 {
+		return driver.RowsAffected(0), nil
+	}
+	return updateRows(ctx, connection.CreateQueryBuilder(), table, criteria, data, columns)
+}
+
+// updateRows updates the rows of a table matching criteria with qb, writing the given columns
+// of data or, if columns is nil, the columns of data that are not omitted, see Insert.
+func updateRows(ctx context.Context, qb *QueryBuilder, table string, criteria map[string]interface{}, data interface{}, columns []string) (sql.Result, error) {
 	switch dataType := data.(type) {
 	case map[string]interface{}:
-		data := []interface{}{}
-		qb := connection.CreateQueryBuilder().
-			Update(table)
+		for _, column := range columns {
+			if _, ok := dataType[column]; !ok {
+				return nil, ErrColumnNotFound
+			}
+		}
+		qb.Update(table)
 		for key, value := range dataType {
-			qb.Set(key, "?")
-			data = append(data, value)
+			if columns == nil || indexOfString(columns, key) != -1 {
+				qb.Set(key, expression.Param(value))
+			}
 		}
-		Expression, expressionData := mapToExpression(criteria)
-		qb.Where(Expression)
-		data = append(data, expressionData...)
-
-		return qb.Exec(data...)
+		return qb.Where(mapToExpression(qb, criteria)).ExecContext(ctx)
 	default:
 		Value := reflect.Indirect(reflect.ValueOf(data))
-		Type := Value.Type()
 		if reflect.Struct != Value.Kind() {
 			return nil, ErrNotAStruct
 		}
+		structColumns := getStructColumns(Value)
+		for _, column := range columns {
+			if indexOfStructColumn(structColumns, column) == -1 {
+				return nil, ErrColumnNotFound
+			}
+		}
 		values := map[string]interface{}{}
-		for i := 0; i < Type.NumField(); i++ {
-			Field := Value.Field(i)
-			Tag, ok := Value.Type().Field(i).Tag.Lookup("sql")
-			if ok {
-				if Tag == "-" {
-					continue
+		// version and nextVersion are the version field of a versioned struct and its next value
+		var version, nextVersion reflect.Value
+		for _, column := range structColumns {
+			if column.tag.Version {
+				next, err := getNextVersion(column.field)
+				if err != nil {
+					return nil, err
 				}
-				sqlTag := SQLStructTagBuilder{}.BuildFromString(Tag)
-				if !sqlTag.PersistZeroValue && Field.Type().Comparable() && reflect.Zero(Field.Type()).Interface() == Field.Interface() {
-					continue
-				}
-				if sqlTag.ColumnName != "" {
-					values[sqlTag.ColumnName] = Field.Interface()
-					continue
+				// the row is updated only if its version did not change
+				versionCriteria := map[string]interface{}{column.name: column.field.Interface()}
+				for key, value := range criteria {
+					versionCriteria[key] = value
 				}
+				criteria, version, nextVersion = versionCriteria, column.field, next
+				values[column.name] = next.Interface()
+				continue
+			}
+			if columns == nil && column.isOmitted() || columns != nil && indexOfString(columns, column.name) == -1 {
+				continue
 			}
-			values[Type.Field(i).Name] = Field.Interface()
+			values[column.name] = column.value
+		}
+		result, err := updateRows(ctx, qb, table, criteria, values, nil)
+		if err != nil || !version.IsValid() {
+			return result, err
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			return result, err
+		} else if rowsAffected == 0 {
+			return result, ErrOptimisticLock
 		}
-		return connection.Update(table, criteria, values)
+		if version.CanSet() {
+			version.Set(nextVersion)
+		}
+		return result, nil
 	}
 }
 
@@ -206,71 +306,229 @@ func (connection *DefaultConnection) Update(table string, criteria map[string]in
 // as data. STruct field names ARE NOT automatically lowecased !
 // use `slq:"column_name"` struct tag to explicitely denominate the db column
 // omit a struct field with `sql:"-"` struct tag.
+// Zero values, nil pointers, invalid sql.Null values and empty slices are omitted
+// unless the field is tagged persistZeroValue, nullable fields being written as NULL.
 func (connection *DefaultConnection) Insert(tableName string, data interface{}) (sql.Result, error) {
+	return connection.InsertContext(context.Background(), tableName, data)
+}
+
+// InsertContext persists a new record into the database, see Insert
+func (connection *DefaultConnection) InsertContext(ctx context.Context, tableName string, data interface{}) (sql.Result, error) {
+	return insertRow(ctx, connection.CreateQueryBuilder(), tableName, data)
+}
+
+// insertRow inserts a row in a table with qb
+func insertRow(ctx context.Context, qb *QueryBuilder, tableName string, data interface{}) (sql.Result, error) {
+	row, err := getInsertData(data)
+	if err != nil {
+		return nil, err
+	}
+	qb.Insert(tableName)
+	for i := 0; i < row.Length(); i++ {
+		qb.SetValue(fmt.Sprint(row.KeyAt(i)), expression.Param(row.ValueAt(i)))
+	}
+	return qb.ExecContext(ctx)
+}
+
+// InsertMany inserts the rows of data, a slice of maps or structs, with multi-row INSERT statements.
+// Consecutive rows with the same columns are inserted by the same statement, as long as the
+// statement has less parameters than the limit of the database platform.
+// The rows are inserted by several statements, use Transaction.InsertMany to insert them atomically.
+func (connection *DefaultConnection) InsertMany(tableName string, data interface{}) (sql.Result, error) {
+	return connection.InsertManyContext(context.Background(), tableName, data)
+}
+
+// InsertManyContext inserts several rows into a table, see InsertMany
+func (connection *DefaultConnection) InsertManyContext(ctx context.Context, tableName string, data interface{}) (sql.Result, error) {
+	return insertRows(ctx, connection.CreateQueryBuilder, tableName, data)
+}
+
+// insertRows inserts the rows of data in a table, newQueryBuilder creating the
+// query builder of each statement
+func insertRows(ctx context.Context, newQueryBuilder func() *QueryBuilder, tableName string, data interface{}) (sql.Result, error) {
+	Value := reflect.Indirect(reflect.ValueOf(data))
+	if Value.Kind() != reflect.Slice && Value.Kind() != reflect.Array {
+		return nil, ErrNotASlice
+	}
+	result := &bulkResult{}
+	var (
+		qb            *QueryBuilder
+		columns       []string
+		parameters    int
+		maxParameters int
+	)
+	flush := func() error {
+		if qb == nil {
+			return nil
+		}
+		statementResult, err := qb.ExecContext(ctx)
+		qb = nil
+		if err != nil {
+			return err
+		}
+		result.add(statementResult)
+		return nil
+	}
+	for i := 0; i < Value.Len(); i++ {
+		row, err := getInsertData(Value.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		rowColumns := []string{}
+		for _, key := range row.Keys() {
+			rowColumns = append(rowColumns, fmt.Sprint(key))
+		}
+		if qb != nil && (strings.Join(columns, ", ") != strings.Join(rowColumns, ", ") || parameters+row.Length() > maxParameters) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		if qb == nil {
+			qb = newQueryBuilder()
+			maxParameters = qb.GetConnection().GetDatabasePlatform().GetMaxParameters()
+			columns, parameters = rowColumns, 0
+			qb.Insert(tableName).Columns(columns...)
+		}
+		qb.AddValues(expression.Params(row.Values()...)...)
+		parameters += row.Length()
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Upsert inserts a row, or updates the existing row conflicting with it on conflictColumns.
+// updateColumns are the columns of the existing row updated with the values of data,
+// all the inserted columns except the conflict columns if no column is given.
+// Use QueryBuilder.OnConflict to leave the existing row untouched.
+func (connection *DefaultConnection) Upsert(tableName string, data interface{}, conflictColumns []string, updateColumns ...string) (sql.Result, error) {
+	return connection.UpsertContext(context.Background(), tableName, data, conflictColumns, updateColumns...)
+}
+
+// UpsertContext inserts or updates a row, see Upsert
+func (connection *DefaultConnection) UpsertContext(ctx context.Context, tableName string, data interface{}, conflictColumns []string, updateColumns ...string) (sql.Result, error) {
+	return upsertRow(ctx, connection.CreateQueryBuilder(), tableName, data, conflictColumns, updateColumns...)
+}
+
+// upsertRow inserts or updates a row with qb
+func upsertRow(ctx context.Context, qb *QueryBuilder, tableName string, data interface{}, conflictColumns []string, updateColumns ...string) (sql.Result, error) {
+	row, err := getInsertData(data)
+	if err != nil {
+		return nil, err
+	}
+	qb.Insert(tableName)
+	for i := 0; i < row.Length(); i++ {
+		qb.SetValue(fmt.Sprint(row.KeyAt(i)), expression.Param(row.ValueAt(i)))
+	}
+	return qb.OnConflict(conflictColumns...).DoUpdate(updateColumns...).ExecContext(ctx)
+}
+
+// getInsertData returns the columns and values of a row inserted from a map or a struct, see Insert.
+// The keys of a map are sorted so that rows with the same keys have the same columns.
+func getInsertData(data interface{}) (*container.OrderedMap, error) {
+	row := container.NewOrderedMap()
 	switch dataType := data.(type) {
 	case map[string]interface{}:
-		data := []interface{}{}
-		qb := connection.CreateQueryBuilder().
-			Insert(tableName)
-		for key, value := range dataType {
-			qb.SetValue(key, "?")
-			data = append(data, value)
+		keys := []string{}
+		for key := range dataType {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			row.Set(key, dataType[key])
 		}
-		return qb.Exec(data...)
+		return row, nil
 	default:
 		Value := reflect.Indirect(reflect.ValueOf(data))
-		Type := Value.Type()
-		if Type.Kind() != reflect.Struct {
+		if Value.Kind() != reflect.Struct {
 			return nil, ErrNotAStruct
 		}
-		data := map[string]interface{}{}
-		for i := 0; i < Type.NumField(); i++ {
-			fieldValue, fieldType := Value.Field(i), Value.Field(i).Type()
-			stringTag, ok := Type.Field(i).Tag.Lookup("sql")
-			if ok {
-				if stringTag == "-" {
-					// omit this field
-					continue
-				}
-				tags := SQLStructTagBuilder{}.BuildFromString(stringTag)
-				// if no "persistZeroValue" tag and value is zero then don't persist
-				if !tags.PersistZeroValue && fieldValue.Interface() == reflect.Zero(fieldType).Interface() {
-					continue
-				}
-				if tags.ColumnName != "" {
-					data[tags.ColumnName] = fieldValue.Interface()
-					continue
-				}
+		for _, column := range getStructColumns(Value) {
+			if !column.isOmitted() {
+				row.Set(column.name, column.value)
 			}
-			// if value is zero and no tag, don't persist field
-			if fieldType.Comparable() && fieldValue.Interface() == reflect.Zero(fieldType).Interface() {
-				continue
-			}
-			// use the field name as the db field name
-			data[Type.Field(i).Name] = fieldValue.Interface()
 		}
-		return connection.Insert(tableName, data)
+		return row, nil
 	}
 }
 
+// bulkResult is the result of several statements, LastInsertId returning
+// the result of the last statement and RowsAffected the sum of the rows affected.
+type bulkResult struct {
+	lastInsertID      int64
+	lastInsertIDError error
+	rowsAffected      int64
+	rowsAffectedError error
+}
+
+func (result *bulkResult) add(statementResult sql.Result) {
+	result.lastInsertID, result.lastInsertIDError = statementResult.LastInsertId()
+	rowsAffected, err := statementResult.RowsAffected()
+	if err != nil {
+		result.rowsAffectedError = err
+	}
+	result.rowsAffected += rowsAffected
+}
+
+func (result *bulkResult) LastInsertId() (int64, error) {
+	return result.lastInsertID, result.lastInsertIDError
+}
+
+func (result *bulkResult) RowsAffected() (int64, error) {
+	return result.rowsAffected, result.rowsAffectedError
+}
+
 // Prepare prepares a statement
 func (connection *DefaultConnection) Prepare(sql string) *Statement {
-	stmt, err := connection.DB().Prepare(sql)
-	return &Statement{query: sql, logger: connection.Options.Logger, statement: stmt, err: err}
+	return connection.PrepareContext(context.Background(), sql)
+}
+
+// PrepareContext prepares a statement, ctx is used for the preparation only.
+// The statement is taken from the statement cache of the connection if any.
+func (connection *DefaultConnection) PrepareContext(ctx context.Context, sql string) *Statement {
+	if cache := connection.statementCache; cache != nil {
+		_, release, err := cache.acquire(ctx, connection.DB(), sql)
+		if err == nil {
+			release()
+		}
+		return &Statement{query: sql, logger: connection.Options.Logger, observers: connection.Options.Observers, cache: cache, db: connection.DB(), err: err,
+			written: connection.invalidateWrittenTable}
+	}
+	stmt, err := connection.DB().PrepareContext(ctx, sql)
+	return &Statement{query: sql, logger: connection.Options.Logger, observers: connection.Options.Observers, statement: stmt, err: err,
+		written: connection.invalidateWrittenTable}
 }
 
 // Exec will execute a query like INSERT,UPDATE,DELETE.
 func (connection *DefaultConnection) Exec(query string, parameters ...interface{}) (sql.Result, error) {
+	return connection.ExecContext(context.Background(), query, parameters...)
+}
+
+// ExecContext executes a query like INSERT,UPDATE,DELETE.
+func (connection *DefaultConnection) ExecContext(ctx context.Context, query string, parameters ...interface{}) (sql.Result, error) {
 	defer connection.log(append([]interface{}{query}, parameters...)...)
-	return connection.DB().Exec(query, parameters...)
+	markPrimaryWrite(ctx)
+	done := observeQuery(ctx, connection.Options.Observers, query, parameters)
+	result, err := connection.DB().ExecContext(ctx, query, parameters...)
+	done(result, err)
+	if err == nil {
+		connection.invalidateWrittenTable(query)
+	}
+	return result, err
 }
 
 // Query queries the database and creates Rows that can then be iterated upon
 func (connection *DefaultConnection) Query(query string, parameters ...interface{}) *Rows {
-	defer connection.log(append([]interface{}{query}, parameters...)...)
-
-	rows, err := connection.Db.Query(query, parameters...)
+	return connection.QueryContext(context.Background(), query, parameters...)
+}
 
+// QueryContext queries the database and creates Rows that can then be iterated upon
+func (connection *DefaultConnection) QueryContext(ctx context.Context, query string, parameters ...interface{}) *Rows {
+	defer connection.log(append([]interface{}{query}, parameters...)...)
+	done := observeQuery(ctx, connection.Options.Observers, query, parameters)
+	rows, err := connection.Db.QueryContext(ctx, query, parameters...)
+	done(nil, err)
 	return &Rows{err: err, rows: rows}
 }
 
@@ -281,11 +539,18 @@ func (connection *DefaultConnection) Query(query string, parameters ...interface
 //    err := connection.get(user,"SELECT * from users WHERE users.id = ?",1)
 //
 func (connection *DefaultConnection) QueryRow(query string, arguments ...interface{}) *Row {
+	return connection.QueryRowContext(context.Background(), query, arguments...)
+}
+
+// QueryRowContext will fetch a single record.
+func (connection *DefaultConnection) QueryRowContext(ctx context.Context, query string, arguments ...interface{}) *Row {
 	// make a slice from the record type
 	// pass a pointer to that slice to connection.Select
 	// if the slice's length == 1 , put back the first value of that
 	// slice in the record value.
-	rows, err := connection.Db.Query(query, arguments...)
+	done := observeQuery(ctx, connection.Options.Observers, query, arguments)
+	rows, err := connection.Db.QueryContext(ctx, query, arguments...)
+	done(nil, err)
 	if err != nil {
 		return &Row{err: err}
 	}
@@ -300,16 +565,43 @@ func (connection *DefaultConnection) log(messages ...interface{}) {
 
 // Begin initiates a transaction
 func (connection *DefaultConnection) Begin() (*Transaction, error) {
+	return connection.BeginTx(context.Background(), nil)
+}
+
+// BeginTx initiates a transaction with options like its isolation level,
+// the transaction is rolled back if ctx is done before it is committed.
+func (connection *DefaultConnection) BeginTx(ctx context.Context, options *sql.TxOptions) (*Transaction, error) {
 	defer connection.log("Begin transaction")
-	transaction, err := connection.DB().Begin()
+	markPrimaryWrite(ctx)
+	transaction, err := connection.DB().BeginTx(ctx, options)
 	if err != nil {
 		return nil, err
 	}
-	return &Transaction{Logger: connection.Options.Logger, Tx: transaction}, nil
+	return &Transaction{Logger: connection.Options.Logger, Tx: transaction, connection: connection, observers: connection.Options.Observers, releases: &[]func(){}}, nil
+}
+
+// Transactional runs fn in a transaction. The transaction is committed if fn returns nil,
+// rolled back if fn returns an error or panics, in which case the panic is propagated.
+// fn must not commit or roll back the transaction itself. Nested units of work run
+// with transaction.Transactional are committed and rolled back with savepoints.
+//
+//	err := connection.Transactional(ctx, func(transaction *db.Transaction) error {
+//		_, err := transaction.Insert("users", user)
+//		return err
+//	})
+func (connection *DefaultConnection) Transactional(ctx context.Context, fn TransactionalFunc) error {
+	transaction, err := connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return runTransactional(transaction, fn)
 }
 
-// Close closes the connection
+// Close closes the connection and the statements of its statement cache
 func (connection *DefaultConnection) Close() error {
+	if connection.statementCache != nil {
+		connection.statementCache.Clear()
+	}
 	return connection.Db.Close()
 }
 
@@ -318,23 +610,70 @@ type Statement struct {
 	statement *sql.Stmt
 	query     string
 	logger    logger.Logger
+	observers []QueryObserver
 	err       error
+	// cache is the cache the statement is taken from when executed, if any
+	cache *StatementCache
+	db    *sql.DB
+	// written is called with the query once the statement is executed successfully, if not nil
+	written func(query string)
 }
 
 // Exec executes a prepared statement with the given arguments and
 // returns a Result summarizing the effect of the statement.
 func (statement *Statement) Exec(arguments ...interface{}) (sql.Result, error) {
+	return statement.ExecContext(context.Background(), arguments...)
+}
+
+// ExecContext executes a prepared statement with the given arguments
+func (statement *Statement) ExecContext(ctx context.Context, arguments ...interface{}) (sql.Result, error) {
 	if statement.err != nil {
 		return nil, statement.err
 	}
 	defer statement.log("Executing statement", statement.query, arguments)
-	return statement.statement.Exec(arguments...)
+	stmt, release, err := statement.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	done := observeQuery(ctx, statement.observers, statement.query, arguments)
+	result, err := stmt.ExecContext(ctx, arguments...)
+	done(result, err)
+	if err == nil && statement.written != nil {
+		statement.written(statement.query)
+	}
+	return result, err
 }
 
-// GetStatement returns the original statement
+// GetStatement returns the original statement. A statement taken from a statement cache
+// may be closed once evicted from the cache.
 func (statement *Statement) GetStatement() *sql.Stmt {
+	if statement.cache != nil {
+		stmt, release, err := statement.acquire(context.Background())
+		if err != nil {
+			return nil
+		}
+		release()
+		return stmt
+	}
 	return statement.statement
 }
+
+// Close closes the statement, unless it is owned by a statement cache
+func (statement *Statement) Close() error {
+	if statement.cache != nil || statement.statement == nil {
+		return nil
+	}
+	return statement.statement.Close()
+}
+
+// acquire returns the *sql.Stmt of the statement and a function to call once it is no longer used
+func (statement *Statement) acquire(ctx context.Context) (*sql.Stmt, func(), error) {
+	if statement.cache == nil {
+		return statement.statement, func() {}, nil
+	}
+	return statement.cache.acquire(ctx, statement.db, statement.query)
+}
 func (statement Statement) log(arguments ...interface{}) {
 	if statement.logger != nil {
 		statement.logger.Log(logger.Debug, arguments...)
@@ -344,21 +683,46 @@ func (statement Statement) log(arguments ...interface{}) {
 // Query executes a prepared query statement with the given arguments
 // and returns the query results as a *Rows.
 func (statement *Statement) Query(arguments ...interface{}) *Rows {
+	return statement.QueryContext(context.Background(), arguments...)
+}
+
+// QueryContext executes a prepared query statement with the given arguments
+func (statement *Statement) QueryContext(ctx context.Context, arguments ...interface{}) *Rows {
 	if statement.err != nil {
 		return &Rows{err: statement.err}
 	}
 	defer statement.log(statement.query, arguments)
-	rows, err := statement.statement.Query(arguments...)
+	stmt, release, err := statement.acquire(ctx)
+	if err != nil {
+		return &Rows{err: err}
+	}
+	// the rows keep the statement open until they are closed
+	defer release()
+	done := observeQuery(ctx, statement.observers, statement.query, arguments)
+	rows, err := stmt.QueryContext(ctx, arguments...)
+	done(nil, err)
 	return &Rows{rows: rows, err: err}
 }
 
 // QueryRow executes a prepared query statement with the given arguments.
 func (statement *Statement) QueryRow(arguments ...interface{}) *Row {
+	return statement.QueryRowContext(context.Background(), arguments...)
+}
+
+// QueryRowContext executes a prepared query statement with the given arguments.
+func (statement *Statement) QueryRowContext(ctx context.Context, arguments ...interface{}) *Row {
 	if statement.err != nil {
 		return &Row{err: statement.err}
 	}
 	defer statement.log(statement.query, arguments)
-	rows, err := statement.statement.Query(arguments)
+	stmt, release, err := statement.acquire(ctx)
+	if err != nil {
+		return &Row{err: err}
+	}
+	defer release()
+	done := observeQuery(ctx, statement.observers, statement.query, arguments)
+	rows, err := stmt.QueryContext(ctx, arguments...)
+	done(nil, err)
 	return &Row{rows: rows, err: err}
 }
 
@@ -375,6 +739,9 @@ func NewRow(rows *sql.Rows, err error) *Row {
 // GetSingleResult returns the first column of the queried row
 // pointer must be a pointer to the type of result
 func (row *Row) GetSingleResult(pointer interface{}) error {
+	if row.err != nil {
+		return row.err
+	}
 	defer row.rows.Close()
 	if reflect.TypeOf(pointer).Kind() != reflect.Ptr {
 		return ErrNotAPointer
@@ -390,6 +757,9 @@ func (row *Row) GetSingleResult(pointer interface{}) error {
 // *[]interface{} or
 // *map[string]interface{}
 func (row *Row) GetResult(pointer interface{}) error {
+	if row.err != nil {
+		return row.err
+	}
 	defer row.rows.Close()
 	switch value := pointer.(type) {
 	case *[]interface{}:
@@ -436,9 +806,22 @@ func (row *Row) GetResult(pointer interface{}) error {
 
 // Rows is a wrapper around *sql.Rows
 // Allowing to map db rows to structs directly
+// Rows are the rows of a query. They are either read at once with GetResults,
+// or one at a time, like *sql.Rows, with Next and Scan or with Each :
+//
+//	rows := connection.Query("SELECT * FROM users")
+//	defer rows.Close()
+//	for rows.Next() {
+//		user := new(User)
+//		if err := rows.Scan(user); err != nil {
+//			return err
+//		}
+//	}
+//	return rows.Err()
 type Rows struct {
-	err  error
-	rows *sql.Rows
+	err     error
+	rows    *sql.Rows
+	columns []string
 }
 
 func NewRows(rows *sql.Rows, err error) *Rows {
@@ -459,32 +842,138 @@ func (rows *Rows) GetRows() (*sql.Rows, error) {
 // - a pointer to a slice of slice of empty intefaces : *[][]interface{}
 // - a pointer to a slice of map of strings as key and empty interfaces as value : *[]map[string]interface{}
 func (rows *Rows) GetResults(pointer interface{}) error {
+	return rows.GetResultsContext(context.Background(), pointer)
+}
+
+// GetResultsContext assign results to pointer, see GetResults.
+// It returns an error if ctx or the context of the query is done
+// before all rows are read, instead of truncated results.
+func (rows *Rows) GetResultsContext(ctx context.Context, pointer interface{}) (err error) {
 	if rows.err != nil {
 		return rows.err
 	}
 	defer rows.rows.Close()
-
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	switch Type := pointer.(type) {
 	case *[][]interface{}:
-		return MapRowsToSliceOfSlices(rows.rows, Type)
+		err = MapRowsToSliceOfSlices(rows.rows, Type)
 	case *[]map[string]interface{}:
-		return MapRowsToSliceOfMaps(rows.rows, Type)
+		err = MapRowsToSliceOfMaps(rows.rows, Type)
 	default:
-		return MapRowsToSliceOfStruct(rows.rows, Type, true)
+		err = MapRowsToSliceOfStruct(rows.rows, Type, true)
+	}
+	if err == nil {
+		err = rows.rows.Err()
+	}
+	return err
+}
+
+// Next prepares the next row for Scan, it returns false when there is no next row
+// or an error occurred, in which case Err returns the error. The rows are closed
+// after the last row.
+func (rows *Rows) Next() bool {
+	if rows.err != nil {
+		return false
+	}
+	return rows.rows.Next()
+}
+
+// Scan maps the current row to destinations. A single destination may be a pointer to a struct,
+// mapped like GetResults maps rows to structs, a *map[string]interface{} or a *[]interface{}.
+// Otherwise the columns are scanned into destinations like *sql.Rows.Scan.
+func (rows *Rows) Scan(destinations ...interface{}) (err error) {
+	if rows.err != nil {
+		return rows.err
+	}
+	if len(destinations) != 1 {
+		return rows.rows.Scan(destinations...)
+	}
+	if rows.columns == nil {
+		if rows.columns, err = rows.rows.Columns(); err != nil {
+			return err
+		}
+	}
+	switch destination := destinations[0].(type) {
+	case *map[string]interface{}:
+		*destination, err = MapRowToMap(rows.columns, rows.rows)
+		return err
+	case *[]interface{}:
+		*destination, err = MapRowToSlice(rows.columns, rows.rows)
+		return err
+	}
+	if value := reflect.ValueOf(destinations[0]); value.Kind() == reflect.Ptr && value.Elem().Kind() == reflect.Struct &&
+		!isScannedAsAValue(value.Type()) {
+		return MapRowToStruct(rows.columns, rows.rows, destinations[0], true)
+	}
+	return rows.rows.Scan(destinations...)
+}
+
+// Err returns the error of the query, or the error that occurred while reading the rows
+func (rows *Rows) Err() error {
+	if rows.err != nil {
+		return rows.err
+	}
+	return rows.rows.Err()
+}
+
+// Close closes the rows, it may be called several times
+func (rows *Rows) Close() error {
+	if rows.err != nil {
+		return nil
+	}
+	return rows.rows.Close()
+}
+
+// Each calls fn for each row until fn returns an error, the current row being read with rows.Scan.
+// fn may return ErrStopIteration to stop the iteration without error.
+// The rows are closed when Each returns.
+//
+//	err := connection.Query("SELECT * FROM users").Each(func(rows *db.Rows) error {
+//		user := new(User)
+//		if err := rows.Scan(user); err != nil {
+//			return err
+//		}
+//		return encoder.Encode(user)
+//	})
+func (rows *Rows) Each(fn func(rows *Rows) error) error {
+	return rows.EachContext(context.Background(), fn)
+}
+
+// EachContext calls fn for each row, see Each. It returns the error of ctx
+// if ctx is done before all the rows are read.
+func (rows *Rows) EachContext(ctx context.Context, fn func(rows *Rows) error) error {
+	if rows.err != nil {
+		return rows.err
+	}
+	defer rows.rows.Close()
+	for rows.rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rows); err == ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
 	}
+	return rows.rows.Err()
 }
 
-func mapToExpression(criteria map[string]interface{}) (Expression *expression.Expression, data []interface{}) {
+// mapToExpression converts criteria to an expression, keys being either column names
+// or conditions like "age >". Values are bound to queryBuilder as parameters.
+func mapToExpression(queryBuilder *QueryBuilder, criteria map[string]interface{}) *expression.Expression {
 	parts := []interface{}{}
 	for key, value := range criteria {
+		parameter := expression.Param(value)
 		switch {
 		case regexp.MustCompile(`^\w+$`).MatchString(strings.TrimSpace(key)):
-			parts = append(parts, expression.Eq(key, "?"))
+			parts = append(parts, expression.Eq(key, parameter))
 		default:
-			parts = append(parts, key+" ? ")
+			queryBuilder.SetParameter(parameter.Name, value)
+			parts = append(parts, key+" "+parameter.String())
 		}
-		data = append(data, value)
 	}
-	Expression = expression.And(parts...)
-	return
+	return expression.And(parts...)
 }
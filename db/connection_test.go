@@ -15,11 +15,13 @@
 package db_test
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -257,6 +259,99 @@ func TestConnectionArray(t *testing.T) {
 	test.Fatal(t, len(users), 2)
 }
 
+func TestConnectionContext(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := connection.ExecContext(ctx, "DELETE FROM users")
+	test.Fatal(t, err, context.Canceled)
+	_, err = connection.DeleteContext(ctx, "users", map[string]interface{}{"name": "John Doe"})
+	test.Fatal(t, err, context.Canceled)
+	users := []*AppUser{}
+	err = connection.CreateQueryBuilder().Select("*").From("users").QueryContext(ctx).GetResults(&users)
+	test.Fatal(t, err, context.Canceled)
+	err = connection.Query("SELECT * FROM users").GetResultsContext(ctx, &users)
+	test.Fatal(t, err, context.Canceled)
+	_, err = connection.BeginTx(ctx, nil)
+	test.Fatal(t, err, context.Canceled)
+
+	transaction, err := connection.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	test.Fatal(t, err, nil)
+	_, err = transaction.ExecContext(context.Background(), "DELETE FROM users WHERE name = ?", "John Doe")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, transaction.Commit(), nil)
+	var count int
+	err = connection.Prepare("SELECT COUNT(*) FROM users WHERE name <> ?").QueryRowContext(context.Background(), "Jane Doe").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+}
+
+func TestConnectionInsertMany(t *testing.T) {
+	connection := GetConnection(t)
+	users := []interface{}{}
+	for i := 0; i < 1200; i++ {
+		users = append(users, &AppUser{Name: fmt.Sprintf("user %d", i), Email: fmt.Sprintf("user%d@acme.com", i)})
+	}
+	// rows with other columns are inserted by another statement
+	users = append(users, map[string]interface{}{"name": "John Doe", "email": "john.doe@acme.com", "created": "2016-01-01 00:00:00"})
+	result, err := connection.InsertMany("users", users)
+	test.Fatal(t, err, nil)
+	rowsAffected, err := result.RowsAffected()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, rowsAffected, int64(1201))
+	var count int
+	err = connection.QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1201)
+	_, err = connection.InsertMany("users", &AppUser{})
+	test.Fatal(t, err, db.ErrNotASlice)
+}
+
+func TestConnectionUpsert(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	_, err := connection.Upsert("users", map[string]interface{}{"name": "Johnny Doe", "email": "john.doe@acme.com"}, []string{"email"})
+	test.Fatal(t, err, nil)
+	_, err = connection.Upsert("users", &AppUser{Name: "Joe Doe", Email: "joe.doe@acme.com"}, []string{"email"}, "name")
+	test.Fatal(t, err, nil)
+	var names []string
+	rows, err := connection.DB().Query("SELECT name FROM users ORDER BY id")
+	test.Fatal(t, err, nil)
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		test.Fatal(t, rows.Scan(&name), nil)
+		names = append(names, name)
+	}
+	test.Fatal(t, strings.Join(names, ", "), "Johnny Doe, Jane Doe, Jack Doe, Joe Doe")
+	rows.Close()
+
+	// the inserted rows are returned
+	users := []*AppUser{}
+	err = connection.CreateQueryBuilder().Insert("users").Columns("name", "email").
+		AddValues(expression.Params("Jim Doe", "jim.doe@acme.com")...).
+		AddValues(expression.Params("Jane Doe", "jane.doe@acme.com")...).
+		OnConflict("email").DoNothing().
+		Returning("id", "name").
+		Query().GetResults(&users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(users), 1)
+	test.Fatal(t, users[0].Name, "Jim Doe")
+	test.Fatal(t, users[0].ID != "", true)
+
+	// reserved keywords used as column names are quoted
+	_, err = connection.Exec(`CREATE TABLE settings("key" VARCHAR(255) PRIMARY KEY, "order" INTEGER)`)
+	test.Fatal(t, err, nil)
+	for _, order := range []int{1, 2} {
+		_, err = connection.Upsert("settings", map[string]interface{}{"key": "theme", "order": order}, []string{"key"})
+		test.Fatal(t, err, nil)
+	}
+	var order int
+	test.Fatal(t, connection.QueryRow(`SELECT "order" FROM settings WHERE "key" = 'theme'`).GetSingleResult(&order), nil)
+	test.Fatal(t, order, 2)
+}
+
 /**
  * HELPERS
  */
@@ -340,3 +435,45 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 
 }
+
+func TestRowsCursor(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	rows := connection.Query("SELECT id, name, email FROM users ORDER BY id")
+	names := []string{}
+	for rows.Next() {
+		user := new(AppUser)
+		test.Fatal(t, rows.Scan(user), nil)
+		names = append(names, user.Name)
+	}
+	test.Fatal(t, rows.Err(), nil)
+	test.Fatal(t, rows.Close(), nil)
+	test.Fatal(t, strings.Join(names, ", "), "John Doe, Jane Doe, Jack Doe")
+
+	// the iteration stops on ErrStopIteration
+	emails := []string{}
+	err := connection.Query("SELECT email FROM users ORDER BY id").Each(func(rows *db.Rows) error {
+		row := map[string]interface{}{}
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		emails = append(emails, row["email"].(string))
+		if len(emails) == 2 {
+			return db.ErrStopIteration
+		}
+		return nil
+	})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Join(emails, ", "), "john.doe@acme.com, jane.doe@acme.com")
+
+	// errors of the callback and of the query are returned
+	stop := fmt.Errorf("stop")
+	err = connection.Query("SELECT id FROM users").Each(func(rows *db.Rows) error {
+		var id int
+		test.Fatal(t, rows.Scan(&id), nil)
+		return stop
+	})
+	test.Fatal(t, err, stop)
+	err = connection.Query("SELECT * FROM unknown_table").Each(func(rows *db.Rows) error { return nil })
+	test.Fatal(t, err != nil, true)
+}
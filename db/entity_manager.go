@@ -0,0 +1,639 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/logger"
+)
+
+// EntityManager is an entity manager strongly influenced by Doctrine ORM.
+// It keeps an identity map of the entities it loads, tracks their changes
+// and writes pending inserts, updates and removals in a single transaction
+// when Flush is called.
+type EntityManager interface {
+
+	// Register a new entity type, table is the name of the table
+	// the entity is persisted in, entity is a pointer to struct.
+	// The identifier field is either tagged with `sql:"primaryKey"`,
+	// mapped to the id column or named ID.
+	// use EntityManager.RegisterMany to register many entities at the same time.
+	Register(table string, entity interface{}) error
+
+	// RegisterMany registers many entities or returns an error on error
+	RegisterMany(entities map[string]interface{}) error
+
+	// Persist makes an entity managed. No row is sent to the db
+	// until Flush is called
+	Persist(entity interface{})
+
+	// Remove schedules an entity for removal. Flush must be called to commit changes
+	// to the database
+	Remove(entity interface{})
+
+	// Flush executes inserts, updates and removals pending in the entity manager
+	// in a single transaction
+	Flush() error
+	// FlushContext flushes the entity manager, the filters and the cancellation
+	// of ctx applying to its queries
+	FlushContext(ctx context.Context) error
+
+	// Find finds an entity by its identifier.
+	// If the entity is already managed, the state of the managed entity is copied into entity.
+	Find(id interface{}, entity interface{}) error
+	FindContext(ctx context.Context, id interface{}, entity interface{}) error
+
+	// FindBy finds entities matching criteria, entities is a pointer to a slice of pointers to struct
+	FindBy(criteria map[string]interface{}, entities interface{}) error
+	FindByContext(ctx context.Context, criteria map[string]interface{}, entities interface{}) error
+
+	// FindOneBy finds a single entity matching criteria
+	FindOneBy(criteria map[string]interface{}, entity interface{}) error
+	FindOneByContext(ctx context.Context, criteria map[string]interface{}, entity interface{}) error
+
+	// Contains returns true if entity is managed by the entity manager
+	Contains(entity interface{}) bool
+
+	// Detach stops tracking changes of an entity
+	Detach(entity interface{})
+
+	// Clear detaches all managed entities and discards pending operations
+	Clear()
+
+	// GetConnection returns the connection
+	GetConnection() Connection
+
+	// ResolveRelations loads the relations of an entity or a collection of entities.
+	// given a struct T, it expects either *T or *[]*T. If no field is given,
+	// every relation is loaded.
+	ResolveRelations(entities interface{}, fields ...string) error
+	ResolveRelationsContext(ctx context.Context, entities interface{}, fields ...string) error
+
+	// SetLogger sets the logger
+	SetLogger(logger.Logger)
+}
+
+type defaultEntityManager struct {
+	connection Connection
+	metadatas  metadatas
+	// tables are the registered tables, in registration order
+	tables []string
+	// identityMap maps tables to identifiers to managed entities
+	identityMap map[string]map[interface{}]interface{}
+	// snapshots are the column values of managed entities
+	// as they were when the entities were loaded or last flushed
+	snapshots map[interface{}]*snapshot
+	// insertions are the entities scheduled for insertion, in persist order
+	insertions []interface{}
+	// removals are the entities scheduled for removal
+	removals []interface{}
+	logger   logger.Logger
+}
+
+// NewEntityManager returns an EntityManager
+func NewEntityManager(connection Connection) EntityManager {
+	manager := &defaultEntityManager{connection: connection, metadatas: metadatas{}}
+	manager.Clear()
+	return manager
+}
+
+func (manager *defaultEntityManager) GetConnection() Connection {
+	return manager.connection
+}
+
+func (manager *defaultEntityManager) SetLogger(Logger logger.Logger) {
+	manager.logger = Logger
+}
+
+func (manager *defaultEntityManager) log(messages ...interface{}) {
+	if manager.logger != nil {
+		manager.logger.Log(logger.Debug, messages...)
+	}
+}
+
+func (manager *defaultEntityManager) Register(table string, entity interface{}) error {
+	meta, err := getTypeMetadata(table, entity)
+	if err != nil {
+		return err
+	}
+	if _, ok := manager.metadatas[meta.structType]; !ok {
+		manager.tables = append(manager.tables, table)
+	}
+	manager.metadatas[meta.structType] = meta
+	manager.log("Type registered :", table, meta)
+	return nil
+}
+
+func (manager *defaultEntityManager) RegisterMany(entities map[string]interface{}) error {
+	for table, entity := range entities {
+		if err := manager.Register(table, entity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (manager *defaultEntityManager) Contains(entity interface{}) bool {
+	_, ok := manager.snapshots[entity]
+	return ok
+}
+
+func (manager *defaultEntityManager) Persist(entity interface{}) {
+	manager.removals = removeEntity(manager.removals, entity)
+	if manager.Contains(entity) || indexOfEntity(manager.insertions, entity) != -1 {
+		return
+	}
+	manager.insertions = append(manager.insertions, entity)
+}
+
+func (manager *defaultEntityManager) Remove(entity interface{}) {
+	if index := indexOfEntity(manager.insertions, entity); index != -1 {
+		// the entity was never flushed, just forget about it
+		manager.insertions = removeEntity(manager.insertions, entity)
+		return
+	}
+	if manager.Contains(entity) && indexOfEntity(manager.removals, entity) == -1 {
+		manager.removals = append(manager.removals, entity)
+	}
+}
+
+func (manager *defaultEntityManager) Detach(entity interface{}) {
+	manager.insertions = removeEntity(manager.insertions, entity)
+	manager.removals = removeEntity(manager.removals, entity)
+	if !manager.Contains(entity) {
+		return
+	}
+	delete(manager.snapshots, entity)
+	if meta, err := manager.metadatas.getMetadata(reflect.TypeOf(entity)); err == nil {
+		delete(manager.identityMap[meta.table], meta.getID(reflect.ValueOf(entity)).Interface())
+	}
+}
+
+func (manager *defaultEntityManager) Clear() {
+	manager.identityMap = map[string]map[interface{}]interface{}{}
+	manager.snapshots = map[interface{}]*snapshot{}
+	manager.insertions = []interface{}{}
+	manager.removals = []interface{}{}
+}
+
+// Flush sends inserts, then updates, then removals to the database.
+// Tables are processed in foreign key dependency order, so that referenced rows
+// are inserted before and removed after the rows referencing them.
+// Related entities are persisted or removed according to the cascade option of
+// their relation, join table rows of manyToMany relations are synchronized.
+// If an error occurs the transaction is rolled back, the insertions and removals
+// scheduled before Flush are restored and identifiers generated by the database are
+// reset. Relations loaded or attached by cascades are kept.
+func (manager *defaultEntityManager) Flush() error {
+	return manager.FlushContext(context.Background())
+}
+
+func (manager *defaultEntityManager) FlushContext(ctx context.Context) (err error) {
+	insertions, removals := append([]interface{}{}, manager.insertions...), append([]interface{}{}, manager.removals...)
+	defer func() {
+		if err != nil {
+			// cascades scheduled entities that are not pending anymore
+			manager.insertions, manager.removals = insertions, removals
+		}
+	}()
+	if err := manager.cascadeRemove(ctx); err != nil {
+		return err
+	}
+	manager.cascadePersist()
+	for _, entity := range append(append([]interface{}{}, manager.insertions...), manager.removals...) {
+		if _, err := manager.metadatas.getMetadata(reflect.TypeOf(entity)); err != nil {
+			return err
+		}
+	}
+	if len(manager.insertions) == 0 && len(manager.removals) == 0 && !manager.hasChanges() {
+		return nil
+	}
+	order := manager.metadatas.getCommitOrder(manager.tables)
+	transaction, err := manager.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// identifiers generated by the database, reset if the transaction fails
+	generatedIDs := []interface{}{}
+	// versions of the updated versioned entities, set once the transaction is committed
+	nextVersions := map[interface{}]reflect.Value{}
+	err = func() error {
+		for _, table := range order {
+			for _, entity := range manager.insertions {
+				if meta := manager.metadatas[reflect.TypeOf(entity)]; meta.table == table {
+					generated, err := manager.executeInsert(ctx, transaction, meta, entity)
+					if err != nil {
+						return err
+					}
+					if generated {
+						generatedIDs = append(generatedIDs, entity)
+					}
+				}
+			}
+		}
+		// change sets are computed once every new entity has an identifier
+		updates := manager.computeChangeSets()
+		for _, table := range order {
+			for _, update := range updates {
+				if update.meta.table == table {
+					nextVersion, err := manager.executeUpdate(ctx, transaction, update)
+					if err != nil {
+						return err
+					}
+					if nextVersion.IsValid() {
+						nextVersions[update.entity] = nextVersion
+					}
+				}
+			}
+		}
+		for _, entity := range append(manager.getManagedEntities(), manager.insertions...) {
+			if err := manager.executeCollectionUpdates(ctx, transaction, manager.metadatas[reflect.TypeOf(entity)], entity); err != nil {
+				return err
+			}
+		}
+		for i := len(order) - 1; i >= 0; i-- {
+			for _, entity := range manager.removals {
+				if meta := manager.metadatas[reflect.TypeOf(entity)]; meta.table == order[i] {
+					if err := manager.executeCollectionDeletes(ctx, transaction, meta, entity); err != nil {
+						return err
+					}
+					if err := manager.executeDelete(ctx, transaction, meta, entity); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}()
+	if err == nil {
+		err = transaction.Commit()
+	} else {
+		transaction.Rollback()
+	}
+	if err != nil {
+		for _, entity := range generatedIDs {
+			meta := manager.metadatas[reflect.TypeOf(entity)]
+			id := meta.getID(reflect.ValueOf(entity))
+			id.Set(reflect.Zero(id.Type()))
+		}
+		return err
+	}
+	for entity, nextVersion := range nextVersions {
+		versionField, _ := manager.metadatas[reflect.TypeOf(entity)].findVersionField()
+		reflect.ValueOf(entity).Elem().FieldByName(versionField.name).Set(nextVersion)
+	}
+	for _, entity := range manager.removals {
+		manager.Detach(entity)
+	}
+	for _, entity := range append(manager.getManagedEntities(), manager.insertions...) {
+		manager.manage(manager.metadatas[reflect.TypeOf(entity)], entity)
+	}
+	manager.insertions = []interface{}{}
+	manager.removals = []interface{}{}
+	return nil
+}
+
+func (manager *defaultEntityManager) Find(id interface{}, entity interface{}) error {
+	return manager.FindContext(context.Background(), id, entity)
+}
+
+func (manager *defaultEntityManager) FindContext(ctx context.Context, id interface{}, entity interface{}) error {
+	meta, err := manager.getMetadataForStruct(entity)
+	if err != nil {
+		return err
+	}
+	id = meta.normalizeID(id)
+	if managed, ok := manager.identityMap[meta.table][id]; ok {
+		reflect.ValueOf(entity).Elem().Set(reflect.ValueOf(managed).Elem())
+		return nil
+	}
+	return manager.FindOneByContext(ctx, map[string]interface{}{meta.idColumn: id}, entity)
+}
+
+func (manager *defaultEntityManager) FindOneBy(criteria map[string]interface{}, entity interface{}) error {
+	return manager.FindOneByContext(context.Background(), criteria, entity)
+}
+
+func (manager *defaultEntityManager) FindOneByContext(ctx context.Context, criteria map[string]interface{}, entity interface{}) error {
+	meta, err := manager.getMetadataForStruct(entity)
+	if err != nil {
+		return err
+	}
+	entities := reflect.New(reflect.SliceOf(meta.structType))
+	loaded, err := manager.findBy(ctx, meta, criteria, entities.Interface(), 1)
+	if err != nil {
+		return err
+	}
+	if entities.Elem().Len() == 0 {
+		return sql.ErrNoRows
+	}
+	found := entities.Elem().Index(0)
+	if len(loaded) == 1 {
+		// the entity was not managed yet, manage entity instead of its copy
+		manager.Detach(found.Interface())
+		reflect.ValueOf(entity).Elem().Set(found.Elem())
+		manager.manage(meta, entity)
+		return manager.resolveRelations(ctx, meta, []reflect.Value{reflect.ValueOf(entity)}, nil, true)
+	}
+	reflect.ValueOf(entity).Elem().Set(found.Elem())
+	return nil
+}
+
+func (manager *defaultEntityManager) FindBy(criteria map[string]interface{}, entities interface{}) error {
+	return manager.FindByContext(context.Background(), criteria, entities)
+}
+
+func (manager *defaultEntityManager) FindByContext(ctx context.Context, criteria map[string]interface{}, entities interface{}) error {
+	Value := reflect.ValueOf(entities)
+	if Value.Kind() != reflect.Ptr {
+		return ErrNotAPointer
+	}
+	if Value.Elem().Kind() != reflect.Slice {
+		return ErrNotASlice
+	}
+	meta, err := manager.metadatas.getMetadata(Value.Elem().Type().Elem())
+	if err != nil {
+		return err
+	}
+	loaded, err := manager.findBy(ctx, meta, criteria, entities, 0)
+	if err != nil {
+		return err
+	}
+	values := []reflect.Value{}
+	for _, entity := range loaded {
+		values = append(values, reflect.ValueOf(entity))
+	}
+	return manager.resolveRelations(ctx, meta, values, nil, true)
+}
+
+// findBy loads the entities matching criteria in entities and returns the entities
+// that were not managed yet, without resolving their relations.
+// Rows matching already managed entities are replaced with the managed entities.
+func (manager *defaultEntityManager) findBy(ctx context.Context, meta metadata, criteria map[string]interface{}, entities interface{}, limit int) (loaded []interface{}, err error) {
+	queryBuilder := manager.connection.CreateQueryBuilder().Select("*").From(meta.table)
+	if len(criteria) > 0 {
+		queryBuilder.Where(mapToExpression(queryBuilder, criteria))
+	}
+	if limit > 0 {
+		queryBuilder.SetMaxResults(limit)
+	}
+	if err := queryBuilder.QueryContext(ctx).GetResults(entities); err != nil {
+		return nil, err
+	}
+	Value := reflect.ValueOf(entities).Elem()
+	for i := 0; i < Value.Len(); i++ {
+		entity := Value.Index(i)
+		if managed, ok := manager.identityMap[meta.table][meta.getID(entity).Interface()]; ok {
+			entity.Set(reflect.ValueOf(managed))
+			continue
+		}
+		manager.manage(meta, entity.Interface())
+		loaded = append(loaded, entity.Interface())
+	}
+	return loaded, nil
+}
+
+func (manager *defaultEntityManager) getMetadataForStruct(entity interface{}) (metadata, error) {
+	Value := reflect.ValueOf(entity)
+	if Value.Kind() != reflect.Ptr {
+		return metadata{}, ErrNotAPointer
+	}
+	if Value.Elem().Kind() != reflect.Struct {
+		return metadata{}, ErrNotAStruct
+	}
+	return manager.metadatas.getMetadata(Value.Type())
+}
+
+// manage adds entity to the identity map and takes a snapshot of its state
+func (manager *defaultEntityManager) manage(meta metadata, entity interface{}) {
+	Value := reflect.ValueOf(entity)
+	if manager.identityMap[meta.table] == nil {
+		manager.identityMap[meta.table] = map[interface{}]interface{}{}
+	}
+	manager.identityMap[meta.table][meta.getID(Value).Interface()] = entity
+	snapshot := &snapshot{columns: map[string]interface{}{}, collections: map[string][]interface{}{}}
+	for _, f := range meta.fields {
+		if f.isColumn() {
+			snapshot.columns[f.column] = manager.getFieldValue(Value, f)
+		}
+		if f.relation.relation == manyToMany && f.relation.isOwningSide() {
+			snapshot.collections[f.name] = manager.getCollectionIDs(Value, f)
+		}
+	}
+	manager.snapshots[entity] = snapshot
+}
+
+// getManagedEntities returns the managed entities
+// that are not scheduled for removal
+func (manager *defaultEntityManager) getManagedEntities() (entities []interface{}) {
+	for _, table := range manager.tables {
+		for _, entity := range manager.identityMap[table] {
+			if indexOfEntity(manager.removals, entity) == -1 {
+				entities = append(entities, entity)
+			}
+		}
+	}
+	return
+}
+
+// getFieldValue returns the value persisted in the column of a field,
+// which is the identifier of the related entity for a manyToOne relation.
+// JSON and array fields are encoded so that snapshots are not altered by
+// changes made in place to maps or slices.
+func (manager *defaultEntityManager) getFieldValue(entity reflect.Value, f field) interface{} {
+	value := reflect.Indirect(entity).FieldByName(f.name)
+	if f.json || f.array {
+		wrapped := wrapColumnValue(value.Interface(), f.json, f.array).(driver.Valuer)
+		if encoded, err := wrapped.Value(); err == nil {
+			return encoded
+		}
+		// the error is returned when the value is written
+		return wrapped
+	}
+	if f.relation.relation != manyToOne {
+		return value.Interface()
+	}
+	targetMeta, ok := manager.metadatas.findMetadataByTable(f.relation.targetEntity)
+	if value.IsNil() || !ok || !targetMeta.hasID(value) {
+		return nil
+	}
+	return targetMeta.getID(value).Interface()
+}
+
+// snapshot is the state of a managed entity
+// when it was loaded or last flushed
+type snapshot struct {
+	// columns are the values of columns
+	columns map[string]interface{}
+	// collections are the identifiers of the entities
+	// related through an owned manyToMany relation
+	collections map[string][]interface{}
+}
+
+// changeSet lists the columns of a managed entity that changed since its snapshot
+type changeSet struct {
+	meta    metadata
+	entity  interface{}
+	columns []string
+	values  []interface{}
+}
+
+// computeChangeSets compares managed entities with their snapshots
+func (manager *defaultEntityManager) computeChangeSets() (changeSets []changeSet) {
+	for _, entity := range manager.getManagedEntities() {
+		meta := manager.metadatas[reflect.TypeOf(entity)]
+		snapshot := manager.snapshots[entity]
+		set := changeSet{meta: meta, entity: entity}
+		Value := reflect.ValueOf(entity)
+		for _, f := range meta.fields {
+			if f.name == meta.idField || !f.isColumn() || f.version {
+				continue
+			}
+			value := manager.getFieldValue(Value, f)
+			if !reflect.DeepEqual(value, snapshot.columns[f.column]) {
+				set.columns = append(set.columns, f.column)
+				set.values = append(set.values, value)
+			}
+		}
+		if len(set.columns) > 0 {
+			changeSets = append(changeSets, set)
+		}
+	}
+	return
+}
+
+// hasChanges returns true if a managed entity changed since its snapshot
+func (manager *defaultEntityManager) hasChanges() bool {
+	if len(manager.computeChangeSets()) > 0 {
+		return true
+	}
+	for _, entity := range manager.getManagedEntities() {
+		meta := manager.metadatas[reflect.TypeOf(entity)]
+		for _, f := range meta.fields {
+			if f.relation.relation == manyToMany && f.relation.isOwningSide() &&
+				!reflect.DeepEqual(manager.getCollectionIDs(reflect.ValueOf(entity), f), manager.snapshots[entity].collections[f.name]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// executeInsert inserts entity and sets its identifier if generated by the database
+func (manager *defaultEntityManager) executeInsert(ctx context.Context, transaction *Transaction, meta metadata, entity interface{}) (generated bool, err error) {
+	Value := reflect.ValueOf(entity)
+	queryBuilder := transaction.CreateQueryBuilder().Insert(meta.table)
+	for _, field := range meta.fields {
+		if !field.isColumn() {
+			continue
+		}
+		fieldValue := manager.getFieldValue(Value, field)
+		if fieldValue == nil || (!field.persistZeroValue && isZero(reflect.ValueOf(fieldValue))) {
+			continue
+		}
+		queryBuilder.SetValue(field.column, expression.Param(fieldValue))
+	}
+	result, err := queryBuilder.ExecContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if meta.hasID(Value) {
+		return false, nil
+	}
+	return true, setGeneratedID(meta, Value, result)
+}
+
+// setGeneratedID sets the identifier of entity to the id generated by the database
+func setGeneratedID(meta metadata, entity reflect.Value, result sql.Result) error {
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	id := meta.getID(entity)
+	if id.Kind() == reflect.String {
+		// converting an integer to a string would yield a rune
+		id.SetString(fmt.Sprint(lastInsertID))
+		return nil
+	}
+	if !reflect.ValueOf(lastInsertID).Type().ConvertibleTo(id.Type()) {
+		return fmt.Errorf("Error cannot assign generated id %d to field %s of type %s", lastInsertID, meta.idField, id.Type())
+	}
+	id.Set(reflect.ValueOf(lastInsertID).Convert(id.Type()))
+	return nil
+}
+
+// executeUpdate updates the changed columns of an entity. The version of a versioned entity
+// is incremented, ErrOptimisticLock being returned if the version of its row changed.
+func (manager *defaultEntityManager) executeUpdate(ctx context.Context, transaction *Transaction, update changeSet) (nextVersion reflect.Value, err error) {
+	queryBuilder := transaction.CreateQueryBuilder().Update(update.meta.table)
+	for i, column := range update.columns {
+		queryBuilder.Set(column, expression.Param(update.values[i]))
+	}
+	versionField, versioned := update.meta.findVersionField()
+	var version reflect.Value
+	if versioned {
+		// the version of the snapshot is the version of the row when the entity was loaded
+		version = reflect.ValueOf(manager.snapshots[update.entity].columns[versionField.column])
+		if nextVersion, err = getNextVersion(version); err != nil {
+			return nextVersion, err
+		}
+		queryBuilder.Set(versionField.column, expression.Param(nextVersion.Interface()))
+	}
+	queryBuilder.Where(expression.Eq(update.meta.idColumn, expression.Param(update.meta.getID(reflect.ValueOf(update.entity)).Interface())))
+	if versioned {
+		queryBuilder.AndWhere(expression.Eq(versionField.column, expression.Param(version.Interface())))
+	}
+	result, err := queryBuilder.ExecContext(ctx)
+	if err != nil || !versioned {
+		return nextVersion, err
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return nextVersion, err
+	} else if rowsAffected == 0 {
+		return nextVersion, ErrOptimisticLock
+	}
+	return nextVersion, nil
+}
+
+func (manager *defaultEntityManager) executeDelete(ctx context.Context, transaction *Transaction, meta metadata, entity interface{}) error {
+	_, err := transaction.CreateQueryBuilder().Delete(meta.table).
+		Where(expression.Eq(meta.idColumn, expression.Param(meta.getID(reflect.ValueOf(entity)).Interface()))).
+		ExecContext(ctx)
+	return err
+}
+
+// indexOfEntity returns the index of entity in entities or -1
+func indexOfEntity(entities []interface{}, entity interface{}) int {
+	for i, e := range entities {
+		if e == entity {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeEntity returns entities without entity
+func removeEntity(entities []interface{}, entity interface{}) []interface{} {
+	if index := indexOfEntity(entities, entity); index != -1 {
+		return append(entities[:index:index], entities[index+1:]...)
+	}
+	return entities
+}
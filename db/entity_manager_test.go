@@ -0,0 +1,170 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/logger"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type EntityUser struct {
+	ID    int64  `sql:"column:id,primaryKey"`
+	Name  string `sql:"column:name"`
+	Email string `sql:"column:email"`
+}
+
+type EntityArticle struct {
+	ID       int64  `sql:"column:id"`
+	Title    string `sql:"column:title"`
+	Content  string `sql:"column:content,persistzerovalue"`
+	AuthorID int64  `sql:"column:author_id,references:users"`
+}
+
+func GetEntityManager(t *testing.T) db.EntityManager {
+	connection := GetConnection(t)
+	// foreign keys are enforced per sqlite connection
+	connection.DB().SetMaxOpenConns(1)
+	_, err := connection.Exec("PRAGMA foreign_keys = ON")
+	test.Fatal(t, err, nil)
+	manager := db.NewEntityManager(connection)
+	manager.SetLogger(logger.NewTestLogger(t))
+	err = manager.RegisterMany(map[string]interface{}{
+		"articles": new(EntityArticle),
+		"users":    new(EntityUser),
+	})
+	test.Fatal(t, err, nil)
+	return manager
+}
+
+func TestEntityManagerPersist(t *testing.T) {
+	manager := GetEntityManager(t)
+	user := &EntityUser{Name: "John Doe", Email: "john.doe@acme.com"}
+	manager.Persist(user)
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, user.ID, int64(1))
+	test.Fatal(t, manager.Contains(user), true)
+
+	candidate := new(EntityUser)
+	test.Fatal(t, manager.Find(1, candidate), nil)
+	test.Fatal(t, candidate.Email, user.Email)
+
+	err := manager.Find(2, new(EntityUser))
+	test.Fatal(t, err, sql.ErrNoRows)
+}
+
+func TestEntityManagerFlushOrder(t *testing.T) {
+	manager := GetEntityManager(t)
+	// the article references the user, persisted after the article
+	// yet the user row must be inserted first.
+	article := &EntityArticle{Title: "Go", AuthorID: 10}
+	user := &EntityUser{ID: 10, Name: "John Doe", Email: "john.doe@acme.com"}
+	manager.Persist(article)
+	manager.Persist(user)
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, article.ID, int64(1))
+
+	// the user row must be removed last
+	manager.Remove(user)
+	manager.Remove(article)
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, manager.Contains(article), false)
+	var count int
+	err := manager.GetConnection().QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 0)
+}
+
+func TestEntityManagerChangeTracking(t *testing.T) {
+	manager := GetEntityManager(t)
+	err := LoadFixtures(manager.GetConnection().(*db.DefaultConnection))
+	test.Fatal(t, err, nil)
+	users := []*EntityUser{}
+	err = manager.FindBy(map[string]interface{}{"name": "John Doe"}, &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(users), 1)
+
+	// entities are loaded once
+	user := users[0]
+	users = []*EntityUser{}
+	err = manager.FindBy(map[string]interface{}{"email": "john.doe@acme.com"}, &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, users[0], user)
+
+	user.Name = "John Robert Doe"
+	test.Fatal(t, manager.Flush(), nil)
+	candidate := map[string]interface{}{}
+	err = manager.GetConnection().QueryRow("SELECT name FROM users WHERE id = ?", user.ID).GetResult(&candidate)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, candidate["name"], "John Robert Doe")
+
+	other := new(EntityUser)
+	err = manager.FindOneBy(map[string]interface{}{"name": "Jane Doe"}, other)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, manager.Contains(other), true)
+}
+
+func TestEntityManagerFlushRollback(t *testing.T) {
+	manager := GetEntityManager(t)
+	user := &EntityUser{Name: "John Doe", Email: "john.doe@acme.com"}
+	// violates the unique constraint on email
+	duplicate := &EntityUser{Name: "Jane Doe", Email: "john.doe@acme.com"}
+	manager.Persist(user)
+	manager.Persist(duplicate)
+	test.Error(t, manager.Flush() != nil, true)
+	test.Fatal(t, user.ID, int64(0))
+	test.Fatal(t, manager.Contains(user), false)
+}
+
+func TestEntityManagerRegister(t *testing.T) {
+	manager := db.NewEntityManager(nil)
+	test.Fatal(t, manager.Register("users", EntityUser{}), db.ErrNotAPointer)
+	type NoID struct {
+		Name string
+	}
+	test.Fatal(t, manager.Register("no_ids", new(NoID)), db.ErrIDFieldNotFound)
+}
+
+type EntityBase struct {
+	ID int64 `sql:"column:id"`
+}
+
+type EntityNote struct {
+	EntityBase
+	Title string `sql:"column:title"`
+}
+
+func TestEntityManagerEmbeddedStruct(t *testing.T) {
+	connection := GetConnection(t)
+	_, err := connection.Exec("CREATE TABLE entity_notes(id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255))")
+	test.Fatal(t, err, nil)
+	manager := db.NewEntityManager(connection)
+	test.Fatal(t, manager.Register("entity_notes", new(EntityNote)), nil)
+	note := &EntityNote{Title: "first"}
+	manager.Persist(note)
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, note.ID, int64(1))
+	note.Title = "updated"
+	test.Fatal(t, manager.Flush(), nil)
+
+	manager = db.NewEntityManager(connection)
+	test.Fatal(t, manager.Register("entity_notes", new(EntityNote)), nil)
+	found := new(EntityNote)
+	test.Fatal(t, manager.Find(1, found), nil)
+	test.Fatal(t, found.Title, "updated")
+}
@@ -3,7 +3,53 @@ package db
 import "fmt"
 
 var (
-	// ErrUnsupportedMethod is yied when a method was called and is not supported by the
+	// ErrUnsupportedMethod is yield when a method was called and is not supported by the
 	// underlying driver
 	ErrUnsupportedMethod = fmt.Errorf("Error this method is not supported by the current driver.")
+	// ErrNotASlice is yield when a pointer to a slice was expected
+	ErrNotASlice = fmt.Errorf("Error a pointer to a slice was expected")
+	// ErrEntityNotRegistered is yield when a type that has not been registered is requested by the EntityManager
+	ErrEntityNotRegistered = fmt.Errorf("Error the type of the entity was not registered in the entity manager")
+	// ErrIDFieldNotFound is yield when no identifier field was found in a struct
+	ErrIDFieldNotFound = fmt.Errorf("Error no identifier field defined for type")
+	// ErrInvalidEntityType is yield when the type of an entity doesn't match the struct type of a repository
+	ErrInvalidEntityType = fmt.Errorf("Error the type of the entity doesn't match the type of the repository")
+	// ErrInvalidAnnotation is yield when an invalid relation was found in a sql struct tag
+	ErrInvalidAnnotation = fmt.Errorf("Error an invalid relation annotation was found, check your sql struct tag")
+	// ErrMappedFieldNotFound is yield when the field of a mappedBy annotation was not found
+	ErrMappedFieldNotFound = fmt.Errorf("Error mapped field not found, check mappedBy annotation for entity")
+	// ErrSavepointsNotSupported is yield when a transaction is nested and the database platform doesn't support savepoints
+	ErrSavepointsNotSupported = fmt.Errorf("Error savepoints are not supported by the database platform, transactions can't be nested")
+	// ErrStopIteration is yield by the callback of Rows.Each to stop the iteration without error
+	ErrStopIteration = fmt.Errorf("Error the iteration was stopped")
+	// ErrOrderByRequired is yield when keyset pagination is requested for a query without ORDER BY clause
+	ErrOrderByRequired = fmt.Errorf("Error keyset pagination requires a query ordered by one or more columns")
+	// ErrInvalidCursor is yield when a pagination cursor can't be decoded or doesn't match the ORDER BY clause of the query
+	ErrInvalidCursor = fmt.Errorf("Error invalid pagination cursor")
+	// ErrCursorColumnNotFound is yield when an ORDER BY column is not found in the results of a query paginated with cursors
+	ErrCursorColumnNotFound = fmt.Errorf("Error a column of the ORDER BY clause was not found in the results, it must be selected")
+	// ErrUnsupportedFieldType is yield when no column type can be derived from the type of a struct field
+	ErrUnsupportedFieldType = fmt.Errorf("Error no column type can be derived from the type of the field, set the type option of its sql struct tag")
+	// ErrUnsupportedScanSource is yield when a JSON or array column can't be read from the value returned by the driver
+	ErrUnsupportedScanSource = fmt.Errorf("Error the value read from the database can't be decoded, []byte, string or NULL expected")
+	// ErrUnsupportedArrayType is yield when a value written as an array is not a slice of strings, numbers or booleans
+	ErrUnsupportedArrayType = fmt.Errorf("Error arrays must be slices of strings, numbers, booleans, pointers to them or nested slices")
+	// ErrInvalidArrayLiteral is yield when an array read from the database is not a valid array literal
+	ErrInvalidArrayLiteral = fmt.Errorf("Error invalid array literal")
+	// ErrOptimisticLock is yield when a versioned row was not updated because its version changed,
+	// the row having been updated or deleted since it was read
+	ErrOptimisticLock = fmt.Errorf("Error the row was not updated, it was modified or deleted since it was read")
+	// ErrInvalidVersionField is yield when a field tagged version is not an integer
+	ErrInvalidVersionField = fmt.Errorf("Error the version field must be an integer")
+	// ErrColumnNotFound is yield when a column to update is not a key of a map or a column of a struct
+	ErrColumnNotFound = fmt.Errorf("Error the column to update was not found in the data")
+	// ErrInvalidFilter is yield when a filter has no column or no table or its name is not a valid parameter name
+	ErrInvalidFilter = fmt.Errorf("Error a filter requires a column, at least one table and a name made of letters, digits and underscores")
+	// ErrMissingFilterValue is yield when a query reads or writes a table of a required filter
+	// that has no value in the context of the query and is not disabled
+	ErrMissingFilterValue = fmt.Errorf("Error the query requires the value of a filter, see WithFilterValue")
+	// ErrFilterViolation is yield when an insert or update query sets the column of a filter to another value than the value of the filter
+	ErrFilterViolation = fmt.Errorf("Error the query sets a filtered column to another value than the value of its filter")
+	// ErrFilteredStatement is yield when a query restricted by filters is prepared, the values of the filters not being arguments of the statement
+	ErrFilteredStatement = fmt.Errorf("Error a query restricted by filters can't be prepared, execute it with the query builder")
 )
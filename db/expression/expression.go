@@ -1,6 +1,13 @@
 package expression
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Mparaiso/go-tiger/db/platform"
+)
 
 // ExpressionType is the type of an expression
 type ExpressionType int
@@ -35,6 +42,30 @@ const (
 	IN
 	// NOTIN is 'NOT IN'
 	NOTIN
+	// EXISTS is EXISTS
+	EXISTS
+	// NOTEXISTS is 'NOT EXISTS'
+	NOTEXISTS
+	// BETWEEN is BETWEEN
+	BETWEEN
+	// NOTBETWEEN is 'NOT BETWEEN'
+	NOTBETWEEN
+	// NOT is NOT
+	NOT
+	// ILIKE is a case insensitive LIKE
+	ILIKE
+	// NOTILIKE is a case insensitive NOT LIKE
+	NOTILIKE
+	// PLUS is +
+	PLUS
+	// MINUS is -
+	MINUS
+	// MULTIPLY is *
+	MULTIPLY
+	// DIVIDE is /
+	DIVIDE
+	// CONCAT is ||
+	CONCAT
 )
 
 func (et ExpressionType) String() string {
@@ -67,6 +98,30 @@ func (et ExpressionType) String() string {
 		return "IN"
 	case NOTIN:
 		return "NOT IN"
+	case EXISTS:
+		return "EXISTS"
+	case NOTEXISTS:
+		return "NOT EXISTS"
+	case BETWEEN:
+		return "BETWEEN"
+	case NOTBETWEEN:
+		return "NOT BETWEEN"
+	case NOT:
+		return "NOT"
+	case ILIKE:
+		return "ILIKE"
+	case NOTILIKE:
+		return "NOT ILIKE"
+	case PLUS:
+		return "+"
+	case MINUS:
+		return "-"
+	case MULTIPLY:
+		return "*"
+	case DIVIDE:
+		return "/"
+	case CONCAT:
+		return "||"
 	default:
 		return ""
 	}
@@ -89,73 +144,106 @@ func (e Expression) Count() int {
 	return len(e.Parts)
 }
 
+// Children returns the parts of the expression
+func (e Expression) Children() []interface{} {
+	return e.Parts
+}
+
 func (e Expression) String() string {
+	return e.ToSQL(nil)
+}
+
+// ToSQL renders the expression for databasePlatform, quoting the fields
+// that are reserved keywords and handling the differences between platforms
+func (e Expression) ToSQL(databasePlatform platform.DatabasePlatform) string {
 	switch e.Type {
-	case EQ, NEQ, LT, GT, GTE, LIKE, NOTLIKE:
-		return fmt.Sprintf("%s %s %v", e.Parts[0], e.Type, e.Parts[1])
+	case EQ, NEQ, LT, LTE, GT, GTE, LIKE, NOTLIKE:
+		return fmt.Sprintf("%s %s %s", toField(e.Parts[0], databasePlatform), e.Type, ToSQL(e.Parts[1], databasePlatform))
 	case AND, OR:
 		if len(e.Parts) <= 1 {
-			return fmt.Sprint(e.Parts...)
+			return toSQLs(e.Parts, databasePlatform, "")
 		}
 		result := ""
-		parts := []interface{}{}
 		for _, part := range e.Parts {
-			if result == "" {
-				result += "(%s)"
-				parts = append(parts, part)
-			} else {
-				result += " %s (%s)"
-				parts = append(parts, e.Type, part)
+			if result != "" {
+				result += " " + e.Type.String() + " "
 			}
+			result += "(" + ToSQL(part, databasePlatform) + ")"
 		}
-		return fmt.Sprintf(result, parts...)
+		return result
 	case ISNULL, ISNOTNULL:
-		return fmt.Sprintf("%s %s", e.Parts[0], e.Type)
+		return fmt.Sprintf("%s %s", toField(e.Parts[0], databasePlatform), e.Type)
 	case IN, NOTIN:
-		result := ""
-		parts := []interface{}{e.Parts[0], e.Type}
-		for i := 1; i < len(e.Parts); i++ {
-			if result == "" {
-				result += "( %v"
-			} else {
-				result += " , %v"
-			}
-			parts = append(parts, e.Parts[i])
+		if _, ok := e.Parts[len(e.Parts)-1].(Query); ok && len(e.Parts) == 2 {
+			// a subquery is already rendered between parentheses
+			return fmt.Sprintf("%s %s %s", toField(e.Parts[0], databasePlatform), e.Type, ToSQL(e.Parts[1], databasePlatform))
+		}
+		return fmt.Sprintf("%s %s ( %s )", toField(e.Parts[0], databasePlatform), e.Type, toSQLs(e.Parts[1:], databasePlatform, " , "))
+	case EXISTS, NOTEXISTS:
+		return fmt.Sprintf("%s %s", e.Type, ToSQL(e.Parts[0], databasePlatform))
+	case BETWEEN, NOTBETWEEN:
+		return fmt.Sprintf("%s %s %s AND %s", toField(e.Parts[0], databasePlatform), e.Type, ToSQL(e.Parts[1], databasePlatform), ToSQL(e.Parts[2], databasePlatform))
+	case NOT:
+		return fmt.Sprintf("NOT (%s)", ToSQL(e.Parts[0], databasePlatform))
+	case ILIKE, NOTILIKE:
+		field, pattern := toField(e.Parts[0], databasePlatform), ToSQL(e.Parts[1], databasePlatform)
+		if databasePlatform == nil {
+			return fmt.Sprintf("%s %s %s", field, e.Type, pattern)
+		}
+		if e.Type == NOTILIKE {
+			return "NOT " + databasePlatform.GetCaseInsensitiveLikeSQL(field, pattern)
 		}
-		result = "%s %s " + result + " )"
-		return fmt.Sprintf(result, parts...)
+		return databasePlatform.GetCaseInsensitiveLikeSQL(field, pattern)
+	case PLUS, MINUS, MULTIPLY, DIVIDE:
+		return fmt.Sprintf("(%s %s %s)", toField(e.Parts[0], databasePlatform), e.Type, toField(e.Parts[1], databasePlatform))
+	case CONCAT:
+		values := []string{}
+		for _, part := range e.Parts {
+			values = append(values, toField(part, databasePlatform))
+		}
+		if databasePlatform == nil {
+			return "(" + strings.Join(values, " || ") + ")"
+		}
+		return databasePlatform.GetConcatSQL(values...)
 	default:
 		return ""
 	}
 }
 
+// Query is a query nested in an expression as a subquery, like a *db.QueryBuilder.
+// A subquery renders itself between parentheses.
+type Query interface {
+	fmt.Stringer
+	GetParameters() map[string]interface{}
+}
+
 // Eq is field = value
-func Eq(field string, value interface{}) *Expression {
+func Eq(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: EQ, Parts: []interface{}{field, value}}
 }
 
 // Neq is field != value
-func Neq(field string, value interface{}) *Expression {
+func Neq(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: NEQ, Parts: []interface{}{field, value}}
 }
 
 // Lt is field < value
-func Lt(field string, value interface{}) *Expression {
+func Lt(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: LT, Parts: []interface{}{field, value}}
 }
 
 // Lt is field <= value
-func Lte(field string, value interface{}) *Expression {
+func Lte(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: LTE, Parts: []interface{}{field, value}}
 }
 
 // Gt is field > value
-func Gt(field string, value interface{}) *Expression {
+func Gt(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: GT, Parts: []interface{}{field, value}}
 }
 
 // Gte is field >= value
-func Gte(field string, value interface{}) *Expression {
+func Gte(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: GTE, Parts: []interface{}{field, value}}
 }
 
@@ -169,26 +257,439 @@ func And(parts ...interface{}) *Expression {
 	return &Expression{Type: AND, Parts: parts}
 }
 
-func IsNull(field string) *Expression {
+func IsNull(field interface{}) *Expression {
 	return &Expression{Type: ISNULL, Parts: []interface{}{field}}
 }
 
-func IsNotNull(field string) *Expression {
+func IsNotNull(field interface{}) *Expression {
 	return &Expression{Type: ISNOTNULL, Parts: []interface{}{field}}
 }
 
-func Like(field string, value interface{}) *Expression {
+func Like(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: LIKE, Parts: []interface{}{field, value}}
 }
 
-func NotLike(field string, value interface{}) *Expression {
+func NotLike(field interface{}, value interface{}) *Expression {
 	return &Expression{Type: NOTLIKE, Parts: []interface{}{field, value}}
 }
 
-func In(field string, values ...interface{}) *Expression {
+func In(field interface{}, values ...interface{}) *Expression {
 	return &Expression{Type: IN, Parts: append([]interface{}{field}, values...)}
 }
 
-func NotIn(field string, values ...interface{}) *Expression {
+func NotIn(field interface{}, values ...interface{}) *Expression {
 	return &Expression{Type: NOTIN, Parts: append([]interface{}{field}, values...)}
 }
+
+// Exists is EXISTS (subquery)
+func Exists(subquery Query) *Expression {
+	return &Expression{Type: EXISTS, Parts: []interface{}{subquery}}
+}
+
+// NotExists is NOT EXISTS (subquery)
+func NotExists(subquery Query) *Expression {
+	return &Expression{Type: NOTEXISTS, Parts: []interface{}{subquery}}
+}
+
+// Between is field BETWEEN low AND high
+func Between(field interface{}, low, high interface{}) *Expression {
+	return &Expression{Type: BETWEEN, Parts: []interface{}{field, low, high}}
+}
+
+// NotBetween is field NOT BETWEEN low AND high
+func NotBetween(field interface{}, low, high interface{}) *Expression {
+	return &Expression{Type: NOTBETWEEN, Parts: []interface{}{field, low, high}}
+}
+
+// Not is NOT (part)
+func Not(part interface{}) *Expression {
+	return &Expression{Type: NOT, Parts: []interface{}{part}}
+}
+
+// ILike is a case insensitive LIKE, rendered as ILIKE with postgresql
+// and as LOWER(field) LIKE LOWER(value) with the other platforms
+func ILike(field interface{}, value interface{}) *Expression {
+	return &Expression{Type: ILIKE, Parts: []interface{}{field, value}}
+}
+
+// NotILike is a case insensitive NOT LIKE
+func NotILike(field interface{}, value interface{}) *Expression {
+	return &Expression{Type: NOTILIKE, Parts: []interface{}{field, value}}
+}
+
+// Plus is (left + right)
+func Plus(left, right interface{}) *Expression {
+	return &Expression{Type: PLUS, Parts: []interface{}{left, right}}
+}
+
+// Minus is (left - right)
+func Minus(left, right interface{}) *Expression {
+	return &Expression{Type: MINUS, Parts: []interface{}{left, right}}
+}
+
+// Multiply is (left * right)
+func Multiply(left, right interface{}) *Expression {
+	return &Expression{Type: MULTIPLY, Parts: []interface{}{left, right}}
+}
+
+// Divide is (left / right)
+func Divide(left, right interface{}) *Expression {
+	return &Expression{Type: DIVIDE, Parts: []interface{}{left, right}}
+}
+
+// Concat concatenates strings, with || or with the CONCAT function of mysql
+func Concat(parts ...interface{}) *Expression {
+	return &Expression{Type: CONCAT, Parts: parts}
+}
+
+// Parameter is a value bound to a query instead of being written in the query.
+// It is rendered as a named placeholder, :Name, that the query builder replaces
+// with the placeholder of the database platform.
+type Parameter struct {
+	Name string
+	// Value is the value of a bound parameter
+	Value interface{}
+	// Bound is false if the value of the parameter is set on the query builder
+	Bound bool
+}
+
+func (p Parameter) String() string {
+	return ":" + p.Name
+}
+
+var parameterCount uint64
+
+// Param binds value to a query, the parameter is given a unique name
+//
+//	Eq("id", Param(42))
+func Param(value interface{}) *Parameter {
+	return &Parameter{Name: fmt.Sprintf("_p%d", atomic.AddUint64(&parameterCount, 1)), Value: value, Bound: true}
+}
+
+// Params binds values to a query
+//
+//	In("id", Params(1, 2, 3)...)
+func Params(values ...interface{}) []interface{} {
+	parameters := []interface{}{}
+	for _, value := range values {
+		parameters = append(parameters, Param(value))
+	}
+	return parameters
+}
+
+// Named references a named parameter, whose value is set
+// with QueryBuilder.SetParameter
+//
+//	Eq("name", Named("name"))
+func Named(name string) *Parameter {
+	return &Parameter{Name: name}
+}
+
+// Window is a window function call, FUNCTION() OVER (PARTITION BY ... ORDER BY ...)
+//
+//	Over("ROW_NUMBER()").PartitionBy("author_id").OrderBy("created DESC").As("rank")
+type Window struct {
+	Function    string
+	Partitions  []string
+	Orders      []string
+	FrameClause string
+	Alias       string
+}
+
+// Over calls function over a window
+func Over(function string) *Window {
+	return &Window{Function: function}
+}
+
+// PartitionBy sets the columns partitioning the rows of the window
+func (w *Window) PartitionBy(columns ...string) *Window {
+	w.Partitions = append(w.Partitions, columns...)
+	return w
+}
+
+// OrderBy sets the order of the rows of a partition
+func (w *Window) OrderBy(orders ...string) *Window {
+	w.Orders = append(w.Orders, orders...)
+	return w
+}
+
+// Frame sets the frame of the window, like ROWS BETWEEN 1 PRECEDING AND CURRENT ROW
+func (w *Window) Frame(frame string) *Window {
+	w.FrameClause = frame
+	return w
+}
+
+// As sets the alias of the result of the window function
+func (w *Window) As(alias string) *Window {
+	w.Alias = alias
+	return w
+}
+
+func (w Window) String() string {
+	definition := []string{}
+	if len(w.Partitions) > 0 {
+		definition = append(definition, "PARTITION BY "+strings.Join(w.Partitions, ", "))
+	}
+	if len(w.Orders) > 0 {
+		definition = append(definition, "ORDER BY "+strings.Join(w.Orders, ", "))
+	}
+	if w.FrameClause != "" {
+		definition = append(definition, w.FrameClause)
+	}
+	result := w.Function + " OVER (" + strings.Join(definition, " ") + ")"
+	if w.Alias != "" {
+		result += " AS " + w.Alias
+	}
+	return result
+}
+
+// Function is an SQL function call
+type Function struct {
+	Name      string
+	Arguments []interface{}
+}
+
+// Func calls the SQL function name
+//
+//	Func("COALESCE", "nickname", "name")
+func Func(name string, arguments ...interface{}) *Function {
+	return &Function{Name: name, Arguments: arguments}
+}
+
+// Count is COUNT(argument)
+func Count(argument interface{}) *Function {
+	return Func("COUNT", argument)
+}
+
+// Coalesce is COALESCE(arguments...)
+func Coalesce(arguments ...interface{}) *Function {
+	return Func("COALESCE", arguments...)
+}
+
+// Lower is LOWER(argument)
+func Lower(argument interface{}) *Function {
+	return Func("LOWER", argument)
+}
+
+// Upper is UPPER(argument)
+func Upper(argument interface{}) *Function {
+	return Func("UPPER", argument)
+}
+
+// Children returns the arguments of the function
+func (f Function) Children() []interface{} {
+	return f.Arguments
+}
+
+func (f Function) String() string {
+	return f.ToSQL(nil)
+}
+
+// ToSQL renders the function call, the arguments that are reserved keywords being quoted
+func (f Function) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	arguments := []string{}
+	for _, argument := range f.Arguments {
+		arguments = append(arguments, toField(argument, databasePlatform))
+	}
+	return f.Name + "(" + strings.Join(arguments, ", ") + ")"
+}
+
+// CaseExpression is CASE WHEN condition THEN result ... ELSE result END
+//
+//	Case().When(Gt("stock", 0), "'available'").Else("'sold out'")
+type CaseExpression struct {
+	Conditions []interface{}
+	Results    []interface{}
+	// Default is the result of the ELSE clause, if not nil
+	Default interface{}
+}
+
+// Case starts a CASE expression
+func Case() *CaseExpression {
+	return &CaseExpression{}
+}
+
+// When adds a WHEN condition THEN result clause
+func (c *CaseExpression) When(condition, result interface{}) *CaseExpression {
+	c.Conditions = append(c.Conditions, condition)
+	c.Results = append(c.Results, result)
+	return c
+}
+
+// Else sets the result of the ELSE clause
+func (c *CaseExpression) Else(result interface{}) *CaseExpression {
+	c.Default = result
+	return c
+}
+
+// Children returns the conditions and results of the expression
+func (c CaseExpression) Children() []interface{} {
+	children := append(append([]interface{}{}, c.Conditions...), c.Results...)
+	if c.Default != nil {
+		children = append(children, c.Default)
+	}
+	return children
+}
+
+func (c CaseExpression) String() string {
+	return c.ToSQL(nil)
+}
+
+func (c CaseExpression) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	result := "CASE"
+	for i, condition := range c.Conditions {
+		result += " WHEN " + ToSQL(condition, databasePlatform) + " THEN " + ToSQL(c.Results[i], databasePlatform)
+	}
+	if c.Default != nil {
+		result += " ELSE " + ToSQL(c.Default, databasePlatform)
+	}
+	return result + " END"
+}
+
+// JSONPathExpression is the value at a path of a JSON document, as text
+type JSONPathExpression struct {
+	Field interface{}
+	// Path is a JSON path like $.author.name or $.tags[0]
+	Path string
+}
+
+// JSONExtract is the value at path in the JSON document field, rendered as
+// #>> with postgresql, JSON_EXTRACT with mysql and sqlite, JSON_VALUE otherwise
+//
+//	Eq(JSONExtract("data", "$.author.name"), Param("John Doe"))
+func JSONExtract(field interface{}, path string) *JSONPathExpression {
+	return &JSONPathExpression{Field: field, Path: path}
+}
+
+// Children returns the field of the expression
+func (j JSONPathExpression) Children() []interface{} {
+	return []interface{}{j.Field}
+}
+
+func (j JSONPathExpression) String() string {
+	return j.ToSQL(nil)
+}
+
+func (j JSONPathExpression) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	field := toField(j.Field, databasePlatform)
+	if databasePlatform == nil {
+		return "JSON_EXTRACT(" + field + ", '" + strings.Replace(j.Path, "'", "''", -1) + "')"
+	}
+	return databasePlatform.GetJSONExtractSQL(field, j.Path)
+}
+
+// RawExpression is an SQL fragment written as is in a query
+type RawExpression struct {
+	SQL string
+	// Parameters are the parameters replacing the ? placeholders of SQL
+	Parameters []interface{}
+}
+
+// Raw is an SQL fragment, its ? placeholders being bound to values
+//
+//	Raw("price * ? > ?", 1.2, 100)
+func Raw(sql string, values ...interface{}) *RawExpression {
+	return &RawExpression{SQL: sql, Parameters: Params(values...)}
+}
+
+// Children returns the parameters of the fragment
+func (r RawExpression) Children() []interface{} {
+	return r.Parameters
+}
+
+func (r RawExpression) String() string {
+	return r.ToSQL(nil)
+}
+
+// ToSQL replaces the ? placeholders of the fragment with its parameters,
+// placeholders in string literals and quoted identifiers being ignored
+func (r RawExpression) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	result := ""
+	parameters := r.Parameters
+	for i := 0; i < len(r.SQL); i++ {
+		switch c := r.SQL[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(r.SQL[i+1:], c)
+			if end == -1 {
+				return result + r.SQL[i:]
+			}
+			result += r.SQL[i : i+end+2]
+			i += end + 1
+		case c == '?' && len(parameters) > 0:
+			result += ToSQL(parameters[0], databasePlatform)
+			parameters = parameters[1:]
+		default:
+			result += string(c)
+		}
+	}
+	return result
+}
+
+// Identifier is the name of a table or a column, always quoted
+type Identifier string
+
+// Ident is an identifier quoted by the database platform
+//
+//	Eq("articles.author_id", Ident("users.id"))
+func Ident(name string) Identifier {
+	return Identifier(name)
+}
+
+func (i Identifier) String() string {
+	return string(i)
+}
+
+func (i Identifier) ToSQL(databasePlatform platform.DatabasePlatform) string {
+	if databasePlatform == nil {
+		return string(i)
+	}
+	return databasePlatform.QuoteIdentifier(string(i))
+}
+
+// Renderer is a part of a query rendered for a database platform
+type Renderer interface {
+	ToSQL(databasePlatform platform.DatabasePlatform) string
+}
+
+// Node is a part of a query made of other parts
+type Node interface {
+	Children() []interface{}
+}
+
+// ToSQL renders part for databasePlatform, parts that are not renderers being formatted
+// with fmt.Sprint. Identifiers are not quoted if databasePlatform is nil.
+func ToSQL(part interface{}, databasePlatform platform.DatabasePlatform) string {
+	if renderer, ok := part.(Renderer); ok {
+		return renderer.ToSQL(databasePlatform)
+	}
+	return fmt.Sprint(part)
+}
+
+func toSQLs(parts []interface{}, databasePlatform platform.DatabasePlatform, separator string) string {
+	results := []string{}
+	for _, part := range parts {
+		results = append(results, ToSQL(part, databasePlatform))
+	}
+	return strings.Join(results, separator)
+}
+
+var identifierChain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// toField renders a field, the identifiers of a field written as a string
+// being quoted if they are reserved keywords
+func toField(field interface{}, databasePlatform platform.DatabasePlatform) string {
+	name, ok := field.(string)
+	if !ok {
+		return ToSQL(field, databasePlatform)
+	}
+	if databasePlatform == nil || !identifierChain.MatchString(name) {
+		return name
+	}
+	identifiers := strings.Split(name, ".")
+	for i, identifier := range identifiers {
+		if databasePlatform.IsReservedKeyword(identifier) {
+			identifiers[i] = databasePlatform.QuoteIdentifier(identifier)
+		}
+	}
+	return strings.Join(identifiers, ".")
+}
@@ -2,8 +2,10 @@ package expression_test
 
 import (
 	"fmt"
+	"testing"
 
 	. "github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/db/platform"
 )
 
 func ExampleExpression() {
@@ -18,3 +20,45 @@ func ExampleExpression() {
 	// ((Name = 'john doe') OR (Name = 'jane doe')) AND (Age > 18) AND (Location NOT IN ( 'Canada' , 'USA' , 'France' ))
 	// (CategoryId IS NULL) OR (Title NOT IN ( 'Book A' , 'Book B' ))
 }
+
+func ExampleOver() {
+	fmt.Println(Over("ROW_NUMBER()").PartitionBy("author_id").OrderBy("created DESC").As("rank"))
+	fmt.Println(Over("SUM(amount)").OrderBy("created").Frame("ROWS BETWEEN 1 PRECEDING AND CURRENT ROW"))
+	// Output:
+	// ROW_NUMBER() OVER (PARTITION BY author_id ORDER BY created DESC) AS rank
+	// SUM(amount) OVER (ORDER BY created ROWS BETWEEN 1 PRECEDING AND CURRENT ROW)
+}
+
+func ExampleCase() {
+	fmt.Println(Case().When(Gt("stock", 0), "'available'").Else("'sold out'"))
+	fmt.Println(And(Between("price", 10, 20), Not(Eq("category", "'books'")), Lte("Age", 18)))
+	fmt.Println(Or(Eq(Coalesce("nickname", Lower("name")), "'john'"), Raw("LENGTH(name) > 3")))
+	// Output:
+	// CASE WHEN stock > 0 THEN 'available' ELSE 'sold out' END
+	// (price BETWEEN 10 AND 20) AND (NOT (category = 'books')) AND (Age <= 18)
+	// (COALESCE(nickname, LOWER(name)) = 'john') OR (LENGTH(name) > 3)
+}
+
+func TestToSQL(t *testing.T) {
+	postgresql := platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())
+	mysql := platform.NewMySqlPlatform(platform.NewDefaultPlatform())
+	for _, fixture := range []struct {
+		Expression       interface{}
+		DatabasePlatform platform.DatabasePlatform
+		Expected         string
+	}{
+		{Eq("users.order", Ident("orders.user")), postgresql, `users."order" = "orders"."user"`},
+		{Eq("order", 1), mysql, "`order` = 1"},
+		{ILike("name", "'john%'"), postgresql, "name ILIKE 'john%'"},
+		{NotILike("name", "'john%'"), mysql, "NOT LOWER(name) LIKE LOWER('john%')"},
+		{Concat("first_name", "' '", "last_name"), postgresql, "(first_name || ' ' || last_name)"},
+		{Concat("first_name", "' '", "last_name"), mysql, "CONCAT(first_name, ' ', last_name)"},
+		{Eq("total", Multiply(Plus("price", 1), "quantity")), postgresql, "total = ((price + 1) * quantity)"},
+		{Count("*"), mysql, "COUNT(*)"},
+		{NotBetween("created", "'2016-01-01'", "'2017-01-01'"), postgresql, "created NOT BETWEEN '2016-01-01' AND '2017-01-01'"},
+	} {
+		if result := ToSQL(fixture.Expression, fixture.DatabasePlatform); result != fixture.Expected {
+			t.Fatalf("%v : got '%s', want '%s'", fixture.Expression, result, fixture.Expected)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/container"
+	"github.com/Mparaiso/go-tiger/db/expression"
+)
+
+// filterParameterPrefix prefixes the names of the parameters bound to the values of filters
+const filterParameterPrefix = "_filter_"
+
+var filterNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Filter restricts the rows of tables to the rows whose column equals the value of the filter,
+// the value being set in the context of the queries with WithFilterValue.
+// Filters are registered on a connection with DefaultConnection.AddFilter.
+type Filter struct {
+	// Column is the column compared to the value of the filter
+	Column string
+	// Tables are the tables whose rows are filtered
+	Tables []string
+	// Required makes the queries on the tables fail with ErrMissingFilterValue
+	// when the filter has no value in their context and is not disabled
+	Required bool
+}
+
+// appliesTo returns true if the rows of table are filtered
+func (filter *Filter) appliesTo(table string) bool {
+	for _, filteredTable := range filter.Tables {
+		if strings.EqualFold(filteredTable, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFilter registers a filter of the queries built with QueryBuilder, replacing the filter
+// registered under the same name. Once the value of the filter is set in the context of a query
+// with WithFilterValue, the SELECT, UPDATE and DELETE queries on the tables of the filter only
+// read and write the rows whose column equals the value and the INSERT queries set the column to the value :
+//
+//	err := connection.AddFilter("tenant", &db.Filter{Column: "tenant_id", Tables: []string{"users", "articles"}, Required: true})
+//	ctx := db.WithFilterValue(r.Context(), "tenant", tenantID)
+//	// SELECT * FROM articles a WHERE (a.published = 1) AND (a.tenant_id = ?)
+//	err = connection.CreateQueryBuilder().Select("*").From("articles", "a").
+//		Where("a.published = 1").QueryContext(ctx).GetResults(&articles)
+//
+// Queries executed with raw SQL are not filtered.
+func (connection *DefaultConnection) AddFilter(name string, filter *Filter) error {
+	if !filterNameRegexp.MatchString(name) || filter == nil || filter.Column == "" || len(filter.Tables) == 0 {
+		return ErrInvalidFilter
+	}
+	connection.filterMutex.Lock()
+	defer connection.filterMutex.Unlock()
+	filters := map[string]*Filter{name: filter}
+	for otherName, otherFilter := range connection.filters {
+		if otherName != name {
+			filters[otherName] = otherFilter
+		}
+	}
+	connection.filters = filters
+	return nil
+}
+
+// RemoveFilter removes the filter registered under name
+func (connection *DefaultConnection) RemoveFilter(name string) {
+	connection.filterMutex.Lock()
+	defer connection.filterMutex.Unlock()
+	filters := map[string]*Filter{}
+	for otherName, otherFilter := range connection.filters {
+		if otherName != name {
+			filters[otherName] = otherFilter
+		}
+	}
+	connection.filters = filters
+}
+
+// getFilterScope returns the filters of the connection enabled in ctx, nil if there are none
+func (connection *DefaultConnection) getFilterScope(ctx context.Context) *filterScope {
+	connection.filterMutex.RLock()
+	filters := connection.filters
+	connection.filterMutex.RUnlock()
+	if len(filters) == 0 {
+		return nil
+	}
+	state, _ := ctx.Value(filterContextKey{}).(*filterContext)
+	if state == nil {
+		state = &filterContext{}
+	}
+	scope := &filterScope{filters: map[string]*Filter{}, values: map[string]interface{}{}}
+	for name, filter := range filters {
+		if state.disableAll || state.disabled[name] {
+			continue
+		}
+		if value, ok := state.values[name]; ok {
+			scope.filters[name], scope.values[name] = filter, value
+			scope.names = append(scope.names, name)
+		} else if filter.Required {
+			scope.missing = append(scope.missing, filter)
+		}
+	}
+	if len(scope.names) == 0 && len(scope.missing) == 0 {
+		return nil
+	}
+	sort.Strings(scope.names)
+	return scope
+}
+
+// filteringConnection is a connection filtering the queries built with QueryBuilder
+type filteringConnection interface {
+	getFilterScope(ctx context.Context) *filterScope
+}
+
+// filterScope are the filters enabled in the context of a query
+type filterScope struct {
+	// names are the names of the filters having a value, sorted
+	names   []string
+	filters map[string]*Filter
+	values  map[string]interface{}
+	// missing are the required filters having no value
+	missing []*Filter
+}
+
+// filterContextKey is the key of the filterContext of a context
+type filterContextKey struct{}
+
+// filterContext are the values of the filters set in a context and the filters disabled in it
+type filterContext struct {
+	values     map[string]interface{}
+	disabled   map[string]bool
+	disableAll bool
+}
+
+// getFilterContext returns a copy of the filterContext of ctx
+func getFilterContext(ctx context.Context) *filterContext {
+	state := &filterContext{values: map[string]interface{}{}, disabled: map[string]bool{}}
+	if parent, ok := ctx.Value(filterContextKey{}).(*filterContext); ok {
+		for name, value := range parent.values {
+			state.values[name] = value
+		}
+		for name := range parent.disabled {
+			state.disabled[name] = true
+		}
+		state.disableAll = parent.disableAll
+	}
+	return state
+}
+
+// WithFilterValue returns a copy of ctx in which the filter registered under name,
+// see DefaultConnection.AddFilter, is enabled with value
+func WithFilterValue(ctx context.Context, name string, value interface{}) context.Context {
+	state := getFilterContext(ctx)
+	state.values[name] = value
+	delete(state.disabled, name)
+	return context.WithValue(ctx, filterContextKey{}, state)
+}
+
+// WithoutFilters returns a copy of ctx in which the filters registered under names,
+// or all filters if no name is given, are disabled, for instance in administration jobs
+// reading the rows of every tenant :
+//
+//	_, err := connection.CreateQueryBuilder().Delete("sessions").
+//		Where("expires < CURRENT_TIMESTAMP").ExecContext(db.WithoutFilters(ctx))
+func WithoutFilters(ctx context.Context, names ...string) context.Context {
+	state := getFilterContext(ctx)
+	if len(names) == 0 {
+		state.disableAll = true
+	}
+	for _, name := range names {
+		state.disabled[name] = true
+	}
+	return context.WithValue(ctx, filterContextKey{}, state)
+}
+
+// applyFilters returns a copy of the query restricted by the filters of its connection enabled in ctx,
+// or the query itself if no filter applies to its tables
+func (b *QueryBuilder) applyFilters(ctx context.Context) (*QueryBuilder, error) {
+	connection, ok := b.connection.(filteringConnection)
+	if !ok || b.filters != nil {
+		return b, nil
+	}
+	scope := connection.getFilterScope(ctx)
+	if scope == nil {
+		return b, nil
+	}
+	applied := false
+	for _, table := range b.getTables() {
+		for _, filter := range scope.missing {
+			if filter.appliesTo(table) {
+				return nil, ErrMissingFilterValue
+			}
+		}
+		for _, filter := range scope.filters {
+			applied = applied || filter.appliesTo(table)
+		}
+	}
+	if !applied {
+		return b, nil
+	}
+	clone := b.Clone()
+	clone.filters = scope
+	for name, value := range scope.values {
+		clone.SetParameter(filterParameterPrefix+name, value)
+	}
+	switch clone.builderType {
+	case Insert:
+		return clone, clone.setFilteredValues()
+	case Update:
+		return clone, clone.checkFilteredAssignments()
+	}
+	return clone, nil
+}
+
+// getTables returns the tables of the query and of its subqueries
+func (b *QueryBuilder) getTables() []string {
+	tables := []string{}
+	for _, part := range b.sqlParts[insert] {
+		tables = append(tables, fmt.Sprint(part))
+	}
+	for _, part := range b.sqlParts {
+		walkParts(func(part interface{}) {
+			switch part := part.(type) {
+			case From:
+				if part.Table != "" {
+					tables = append(tables, part.Table)
+				}
+			case Join:
+				tables = append(tables, part.Table)
+			case *QueryBuilder:
+				tables = append(tables, part.getTables()...)
+			}
+		}, part...)
+	}
+	return tables
+}
+
+// getFilterConditions returns the conditions of the filters of the query restricting the rows of table,
+// alias being the name of table in the query
+func (b *QueryBuilder) getFilterConditions(table, alias string) []interface{} {
+	if b.filters == nil || table == "" {
+		return nil
+	}
+	if alias == "" {
+		alias = table
+	}
+	conditions := []interface{}{}
+	for _, name := range b.filters.names {
+		if filter := b.filters.filters[name]; filter.appliesTo(table) {
+			conditions = append(conditions, expression.Eq(alias+"."+filter.Column, expression.Named(filterParameterPrefix+name)))
+		}
+	}
+	return conditions
+}
+
+// getWherePart returns the WHERE clause of the query restricted by the filters of its FROM tables
+func (b *QueryBuilder) getWherePart() []interface{} {
+	conditions := []interface{}{}
+	for _, part := range b.sqlParts[from] {
+		if from, ok := part.(From); ok {
+			conditions = append(conditions, b.getFilterConditions(from.Table, from.Alias)...)
+		}
+	}
+	if len(conditions) == 0 {
+		return b.sqlParts[where]
+	}
+	return []interface{}{expression.And(append(append([]interface{}{}, b.sqlParts[where]...), conditions...)...)}
+}
+
+// filterJoin returns join with the conditions of the filters of its table added to its ON clause
+func (b *QueryBuilder) filterJoin(join Join) Join {
+	conditions := b.getFilterConditions(join.Table, join.Alias)
+	if len(conditions) == 0 {
+		return join
+	}
+	join.Conditions = []interface{}{expression.And(append(append([]interface{}{}, join.Conditions...), conditions...)...)}
+	return join
+}
+
+// setFilteredValues sets the filtered columns of the rows of an insert query to the values of the filters,
+// returning ErrFilterViolation if a row sets a filtered column to another value
+func (b *QueryBuilder) setFilteredValues() error {
+	table, valuePart := fmt.Sprint(b.sqlParts[insert][0]), b.sqlParts[values]
+	if len(valuePart) == 0 {
+		// the filtered columns are the only values of the row
+		valuePart = []interface{}{Values{container.NewOrderedMap()}}
+	}
+	for _, name := range b.filters.names {
+		filter, value, parameter := b.filters.filters[name], b.filters.values[name], expression.Named(filterParameterPrefix+name)
+		if !filter.appliesTo(table) {
+			continue
+		}
+		switch part := valuePart[0].(type) {
+		case Values:
+			row := container.NewOrderedMap()
+			for i := 0; i < part.Length(); i++ {
+				if fmt.Sprint(part.KeyAt(i)) == filter.Column && !b.isFilterValue(part.ValueAt(i), value) {
+					return ErrFilterViolation
+				}
+				row.Set(part.KeyAt(i), part.ValueAt(i))
+			}
+			if !row.Has(filter.Column) {
+				row.Set(filter.Column, parameter)
+			}
+			valuePart = []interface{}{Values{row}}
+		case BulkValues:
+			if index := indexOfString(part.Columns, filter.Column); index != -1 {
+				for _, row := range part.Rows {
+					if index >= len(row) || !b.isFilterValue(row[index], value) {
+						return ErrFilterViolation
+					}
+				}
+				continue
+			}
+			bulkValues := BulkValues{Columns: append(append([]string{}, part.Columns...), filter.Column)}
+			for _, row := range part.Rows {
+				bulkValues.Rows = append(bulkValues.Rows, append(append([]interface{}{}, row...), parameter))
+			}
+			valuePart = []interface{}{bulkValues}
+		}
+	}
+	b.sqlParts[values] = valuePart
+	return nil
+}
+
+// checkFilteredAssignments returns ErrFilterViolation if an update query sets a filtered column
+// to another value than the value of its filter
+func (b *QueryBuilder) checkFilteredAssignments() error {
+	for _, part := range b.sqlParts[from] {
+		from := part.(From)
+		for _, name := range b.filters.names {
+			filter := b.filters.filters[name]
+			if !filter.appliesTo(from.Table) {
+				continue
+			}
+			for _, part := range b.sqlParts[set] {
+				if assignment, ok := part.(assignment); ok && b.isFilteredColumn(assignment.Column, from, filter) &&
+					!b.isFilterValue(assignment.Value, b.filters.values[name]) {
+					return ErrFilterViolation
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isFilteredColumn returns true if column, optionally qualified by the name of the table, is the column of filter
+func (b *QueryBuilder) isFilteredColumn(column string, from From, filter *Filter) bool {
+	for _, prefix := range []string{"", from.Table + ".", from.Alias + "."} {
+		if strings.EqualFold(column, prefix+filter.Column) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFilterValue returns true if part, a value or a parameter of the query, is the value of a filter
+func (b *QueryBuilder) isFilterValue(part interface{}, value interface{}) bool {
+	if parameter, ok := part.(*expression.Parameter); ok {
+		part = *parameter
+	}
+	if parameter, ok := part.(expression.Parameter); ok {
+		if parameter.Bound {
+			part = parameter.Value
+		} else if part, ok = b.parameters[parameter.Name]; !ok {
+			return false
+		}
+	}
+	converted, err := driver.DefaultParameterConverter.ConvertValue(part)
+	if err != nil {
+		return false
+	}
+	convertedValue, err := driver.DefaultParameterConverter.ConvertValue(value)
+	return err == nil && reflect.DeepEqual(converted, convertedValue)
+}
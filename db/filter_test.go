@@ -0,0 +1,142 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestConnectionAddFilter(t *testing.T) {
+	connection := GetConnection(t)
+	for _, query := range []string{
+		"CREATE TABLE notes(id INTEGER PRIMARY KEY AUTOINCREMENT, tenant_id INTEGER, title VARCHAR(255))",
+		"CREATE TABLE tags(id INTEGER PRIMARY KEY AUTOINCREMENT, tenant_id INTEGER, note_id INTEGER, name VARCHAR(255))",
+	} {
+		_, err := connection.Exec(query)
+		test.Fatal(t, err, nil)
+	}
+	test.Fatal(t, connection.AddFilter("tenant-id", &db.Filter{Column: "tenant_id", Tables: []string{"notes"}}), db.ErrInvalidFilter)
+	test.Fatal(t, connection.AddFilter("tenant", &db.Filter{Column: "tenant_id", Tables: []string{"notes", "tags"}, Required: true}), nil)
+	var lastSQL string
+	connection.Options.Observers = []db.QueryObserver{db.QueryObserverFuncs{Before: func(event *db.QueryEvent) {
+		lastSQL = event.SQL
+	}}}
+	tenant1, tenant2 := db.WithFilterValue(context.Background(), "tenant", 1), db.WithFilterValue(context.Background(), "tenant", 2)
+
+	// inserted rows get the value of the filter
+	_, err := connection.InsertContext(tenant1, "notes", map[string]interface{}{"title": "first"})
+	test.Fatal(t, err, nil)
+	_, err = connection.InsertManyContext(tenant2, "notes", []map[string]interface{}{{"title": "second"}, {"title": "third"}})
+	test.Fatal(t, err, nil)
+	_, err = connection.InsertContext(tenant1, "tags", map[string]interface{}{"note_id": 1, "name": "go", "tenant_id": 1})
+	test.Fatal(t, err, nil)
+	_, err = connection.InsertContext(tenant1, "tags", map[string]interface{}{"note_id": 2, "name": "sql", "tenant_id": 2})
+	test.Fatal(t, err, db.ErrFilterViolation)
+	_, err = connection.InsertContext(context.Background(), "notes", map[string]interface{}{"title": "fourth"})
+	test.Fatal(t, err, db.ErrMissingFilterValue)
+
+	count := func(ctx context.Context) (count int) {
+		test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(*)").From("notes", "n").
+			Where("n.title <> ''").QueryRowContext(ctx).GetSingleResult(&count), nil)
+		return count
+	}
+	test.Fatal(t, count(tenant1), 1)
+	test.Fatal(t, lastSQL, "SELECT COUNT(*) FROM notes n WHERE (n.title <> '') AND (n.tenant_id = ?)")
+	test.Fatal(t, count(tenant2), 2)
+	test.Fatal(t, count(db.WithoutFilters(tenant2)), 3)
+	test.Fatal(t, count(db.WithFilterValue(db.WithoutFilters(tenant2, "tenant"), "tenant", 1)), 1)
+
+	// joined tables and subqueries are filtered
+	var name string
+	notes := connection.CreateQueryBuilder().Select("id").From("notes")
+	test.Fatal(t, connection.CreateQueryBuilder().Select("t.name").From("tags", "t").
+		Where(expression.In("t.note_id", notes)).QueryRowContext(tenant1).GetSingleResult(&name), nil)
+	test.Fatal(t, name, "go")
+	test.Fatal(t, lastSQL, "SELECT t.name FROM tags t WHERE (t.note_id IN (SELECT id FROM notes WHERE notes.tenant_id = ?)) AND (t.tenant_id = ?)")
+	var tags int
+	test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(t.id)").From("notes", "n").
+		LeftJoin("n", "tags", "t", "t.note_id = n.id").QueryRowContext(tenant2).GetSingleResult(&tags), nil)
+	test.Fatal(t, tags, 0)
+	test.Fatal(t, lastSQL, "SELECT COUNT(t.id) FROM notes n LEFT JOIN tags t ON (t.note_id = n.id) AND (t.tenant_id = ?) WHERE n.tenant_id = ?")
+
+	// updates and deletes only write the rows of the tenant
+	result, err := connection.CreateQueryBuilder().Update("notes").Set("title", expression.Param("updated")).ExecContext(tenant2)
+	test.Fatal(t, err, nil)
+	rowsAffected, _ := result.RowsAffected()
+	test.Fatal(t, rowsAffected, int64(2))
+	_, err = connection.UpdateContext(tenant2, "notes", map[string]interface{}{"id": 2}, map[string]interface{}{"tenant_id": 1})
+	test.Fatal(t, err, db.ErrFilterViolation)
+	result, err = connection.DeleteContext(tenant1, "notes", map[string]interface{}{"title": "updated"})
+	test.Fatal(t, err, nil)
+	rowsAffected, _ = result.RowsAffected()
+	test.Fatal(t, rowsAffected, int64(0))
+	test.Fatal(t, count(db.WithoutFilters(context.Background())), 3)
+
+	// the values of the filters are not arguments of prepared statements
+	_, err = connection.CreateQueryBuilder().Delete("notes").PrepareContext(tenant1).Exec()
+	test.Fatal(t, err, db.ErrFilteredStatement)
+	connection.RemoveFilter("tenant")
+	test.Fatal(t, count(context.Background()), 3)
+}
+
+type TenantNote struct {
+	ID    int64  `sql:"column:id"`
+	Title string `sql:"column:title"`
+}
+
+func TestEntityManagerFilters(t *testing.T) {
+	connection := GetConnection(t)
+	_, err := connection.Exec("CREATE TABLE notes(id INTEGER PRIMARY KEY AUTOINCREMENT, tenant_id INTEGER NOT NULL, title VARCHAR(255))")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, connection.AddFilter("tenant", &db.Filter{Column: "tenant_id", Tables: []string{"notes"}, Required: true}), nil)
+	tenant1, tenant2 := db.WithFilterValue(context.Background(), "tenant", 1), db.WithFilterValue(context.Background(), "tenant", 2)
+	manager := db.NewEntityManager(connection)
+	test.Fatal(t, manager.Register("notes", new(TenantNote)), nil)
+
+	// the queries of the entity manager are filtered by the values of ctx
+	first, second := &TenantNote{Title: "first"}, &TenantNote{Title: "second"}
+	manager.Persist(first)
+	test.Fatal(t, manager.Flush(), db.ErrMissingFilterValue)
+	test.Fatal(t, manager.FlushContext(tenant1), nil)
+	manager.Persist(second)
+	test.Fatal(t, manager.FlushContext(tenant2), nil)
+	var count int
+	test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(*)").From("notes").Where("tenant_id = 1").
+		QueryRowContext(db.WithoutFilters(context.Background())).GetSingleResult(&count), nil)
+	test.Fatal(t, count, 1)
+
+	manager = db.NewEntityManager(connection)
+	test.Fatal(t, manager.Register("notes", new(TenantNote)), nil)
+	notes := []*TenantNote{}
+	test.Fatal(t, manager.FindByContext(tenant1, nil, &notes), nil)
+	test.Fatal(t, len(notes), 1)
+	test.Fatal(t, notes[0].Title, "first")
+	test.Fatal(t, manager.FindContext(tenant1, second.ID, new(TenantNote)), sql.ErrNoRows)
+	note := new(TenantNote)
+	test.Fatal(t, manager.FindOneByContext(tenant2, map[string]interface{}{"title": "second"}, note), nil)
+
+	// removals of the rows of another tenant delete nothing
+	manager.Remove(note)
+	test.Fatal(t, manager.FlushContext(tenant1), nil)
+	test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(*)").From("notes").
+		QueryRowContext(db.WithoutFilters(context.Background())).GetSingleResult(&count), nil)
+	test.Fatal(t, count, 2)
+}
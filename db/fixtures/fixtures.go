@@ -0,0 +1,421 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package fixtures loads rows described in YAML or JSON files into database tables.
+//
+// A fixture file maps tables to named fixtures, a fixture being the columns of a row :
+//
+//	users:
+//	  user_1:
+//	    name: John Doe
+//	    email: john.doe@acme.com
+//	articles:
+//	  article_1:
+//	    title: Hello
+//	    author_id: "@user_1"
+//
+// A string starting with @ references the id of another fixture, fixtures
+// being inserted after the fixtures they reference, @@ escapes a leading @.
+// Mappings and sequences are written as JSON.
+package fixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/platform"
+	"github.com/Mparaiso/go-tiger/test"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrInvalidFixtures is yield when a fixture file is not a mapping of tables to fixtures
+	ErrInvalidFixtures = fmt.Errorf("Error the fixtures are not valid")
+	// ErrDuplicateFixture is yield when two fixtures have the same name
+	ErrDuplicateFixture = fmt.Errorf("Error a fixture name is used more than once")
+	// ErrUnknownReference is yield when a fixture references a fixture that does not exist
+	ErrUnknownReference = fmt.Errorf("Error a fixture references an unknown fixture")
+	// ErrCircularReference is yield when fixtures or their tables reference each other
+	ErrCircularReference = fmt.Errorf("Error fixtures reference each other")
+	// ErrMissingID is yield when the id of a referenced fixture is not known,
+	// the fixture having no id column and the driver not supporting LastInsertId
+	ErrMissingID = fmt.Errorf("Error the id of a referenced fixture is not known")
+)
+
+// Executor executes the queries loading fixtures, *db.DefaultConnection and *db.Transaction are executors
+type Executor interface {
+	Exec(query string, parameters ...interface{}) (sql.Result, error)
+	Insert(tableName string, data interface{}) (sql.Result, error)
+	Query(query string, arguments ...interface{}) *db.Rows
+	GetDatabasePlatform() platform.DatabasePlatform
+}
+
+// Fixture is a row of a table
+type Fixture struct {
+	// Name identifies the fixture in references
+	Name   string
+	Table  string
+	Values map[string]interface{}
+}
+
+// references returns the names of the fixtures referenced by the fixture, sorted
+func (fixture *Fixture) references() []string {
+	references := []string{}
+	for _, value := range fixture.Values {
+		if name, ok := getReference(value); ok {
+			references = append(references, name)
+		}
+	}
+	sort.Strings(references)
+	return references
+}
+
+// getReference returns the name of the fixture referenced by value
+func getReference(value interface{}) (name string, ok bool) {
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, "@") || strings.HasPrefix(s, "@@") {
+		return "", false
+	}
+	return s[1:], true
+}
+
+// Loader inserts fixtures, in the order of their references, and truncates their tables.
+// Please use NewLoader or LoadFiles to create a Loader.
+type Loader struct {
+	fixtures []*Fixture
+	// tables are the tables of the fixtures in insertion order
+	tables []string
+	ids    map[string]interface{}
+}
+
+// NewLoader returns a loader of fixtures or an error if fixtures reference
+// unknown fixtures or each other
+func NewLoader(fixtures ...*Fixture) (*Loader, error) {
+	byName := map[string]*Fixture{}
+	names := []string{}
+	dependencies := map[string][]string{}
+	for _, fixture := range fixtures {
+		if _, ok := byName[fixture.Name]; ok {
+			return nil, fmt.Errorf("%s : %s", fixture.Name, ErrDuplicateFixture)
+		}
+		byName[fixture.Name] = fixture
+		names = append(names, fixture.Name)
+		dependencies[fixture.Name] = fixture.references()
+	}
+	for _, fixture := range fixtures {
+		for _, reference := range dependencies[fixture.Name] {
+			if _, ok := byName[reference]; !ok {
+				return nil, fmt.Errorf("%s : @%s : %s", fixture.Name, reference, ErrUnknownReference)
+			}
+		}
+	}
+	sorted, err := sortByDependencies(names, dependencies)
+	if err != nil {
+		return nil, err
+	}
+	loader := &Loader{ids: map[string]interface{}{}}
+	tables := map[string]bool{}
+	for _, name := range sorted {
+		fixture := byName[name]
+		loader.fixtures = append(loader.fixtures, fixture)
+		if !tables[fixture.Table] {
+			tables[fixture.Table] = true
+			loader.tables = append(loader.tables, fixture.Table)
+		}
+	}
+	return loader, nil
+}
+
+// LoadFiles returns a loader of the fixtures of .yml, .yaml and .json files,
+// the fixture files of a directory being loaded in the order of their names.
+func LoadFiles(paths ...string) (*Loader, error) {
+	fixtures := []*Fixture{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			infos, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = []string{}
+			for _, info := range infos {
+				switch filepath.Ext(info.Name()) {
+				case ".yml", ".yaml", ".json":
+					if !info.IsDir() {
+						files = append(files, filepath.Join(path, info.Name()))
+					}
+				}
+			}
+		}
+		for _, file := range files {
+			reader, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			fileFixtures, err := ParseFixtures(reader)
+			reader.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s : %s", filepath.Base(file), err)
+			}
+			fixtures = append(fixtures, fileFixtures...)
+		}
+	}
+	return NewLoader(fixtures...)
+}
+
+// ParseFixtures parses a YAML or JSON fixture file, fixtures are returned
+// in the order they are declared
+func ParseFixtures(reader io.Reader) ([]*Fixture, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	document := yaml.MapSlice{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	fixtures := []*Fixture{}
+	for _, table := range document {
+		rows, ok := table.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, ErrInvalidFixtures
+		}
+		for _, row := range rows {
+			columns, ok := row.Value.(yaml.MapSlice)
+			if !ok {
+				return nil, ErrInvalidFixtures
+			}
+			fixture := &Fixture{Name: fmt.Sprint(row.Key), Table: fmt.Sprint(table.Key), Values: map[string]interface{}{}}
+			for _, column := range columns {
+				fixture.Values[fmt.Sprint(column.Key)] = column.Value
+			}
+			fixtures = append(fixtures, fixture)
+		}
+	}
+	return fixtures, nil
+}
+
+// Fixtures returns the fixtures in insertion order
+func (loader *Loader) Fixtures() []*Fixture {
+	return append([]*Fixture{}, loader.fixtures...)
+}
+
+// GetID returns the id of an inserted fixture, the value of its id column
+// or the id generated by the database, nil if the id is not known
+func (loader *Loader) GetID(name string) interface{} {
+	return loader.ids[name]
+}
+
+// Insert inserts the fixtures, a fixture being inserted after the fixtures it references
+func (loader *Loader) Insert(executor Executor) error {
+	loader.ids = map[string]interface{}{}
+	for _, fixture := range loader.fixtures {
+		values := map[string]interface{}{}
+		for column, value := range fixture.Values {
+			value, err := loader.resolve(value)
+			if err != nil {
+				return fmt.Errorf("%s : %s : %s", fixture.Name, column, err)
+			}
+			values[column] = value
+		}
+		result, err := executor.Insert(fixture.Table, values)
+		if err != nil {
+			return fmt.Errorf("%s : %s", fixture.Name, err)
+		}
+		if id, ok := values["id"]; ok {
+			loader.ids[fixture.Name] = id
+		} else if id, err := result.LastInsertId(); err == nil {
+			loader.ids[fixture.Name] = id
+		}
+	}
+	return nil
+}
+
+// resolve returns the value written to the database for the value of a column
+func (loader *Loader) resolve(value interface{}) (interface{}, error) {
+	if name, ok := getReference(value); ok {
+		id, ok := loader.ids[name]
+		if !ok {
+			return nil, ErrMissingID
+		}
+		return id, nil
+	}
+	switch value := value.(type) {
+	case string:
+		if strings.HasPrefix(value, "@@") {
+			return value[1:], nil
+		}
+	case yaml.MapSlice, []interface{}:
+		return db.JSON(toJSONValue(value)), nil
+	}
+	return value, nil
+}
+
+// toJSONValue converts the mappings of a YAML value to maps that can be marshaled to JSON
+func toJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		result := map[string]interface{}{}
+		for _, item := range value {
+			result[fmt.Sprint(item.Key)] = toJSONValue(item.Value)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = toJSONValue(item)
+		}
+		return result
+	}
+	return value
+}
+
+// Truncate deletes the rows of the tables of the fixtures, tables referencing other tables,
+// either through fixture references or foreign keys, being truncated first.
+func (loader *Loader) Truncate(executor Executor) error {
+	tables, err := loader.getTruncateOrder(executor)
+	if err != nil {
+		return err
+	}
+	databasePlatform := executor.GetDatabasePlatform()
+	for _, table := range tables {
+		if _, err := executor.Exec("DELETE FROM " + databasePlatform.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getTruncateOrder returns the tables of the fixtures, referencing tables first
+func (loader *Loader) getTruncateOrder(executor Executor) ([]string, error) {
+	byName := map[string]*Fixture{}
+	for _, fixture := range loader.fixtures {
+		byName[fixture.Name] = fixture
+	}
+	dependencies := map[string][]string{}
+	for _, fixture := range loader.fixtures {
+		for _, reference := range fixture.references() {
+			if table := byName[reference].Table; table != fixture.Table {
+				dependencies[fixture.Table] = append(dependencies[fixture.Table], table)
+			}
+		}
+	}
+	databasePlatform := executor.GetDatabasePlatform()
+	for _, table := range loader.tables {
+		query := databasePlatform.GetListTableForeignKeysSQL(table)
+		if query == "" {
+			continue
+		}
+		foreignKeys := []*struct {
+			ForeignTable string `sql:"column:foreign_table"`
+		}{}
+		if err := executor.Query(query).GetResults(&foreignKeys); err != nil {
+			return nil, err
+		}
+		for _, foreignKey := range foreignKeys {
+			if foreignKey.ForeignTable != table {
+				dependencies[table] = append(dependencies[table], foreignKey.ForeignTable)
+			}
+		}
+	}
+	sorted, err := sortByDependencies(loader.tables, dependencies)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, len(sorted))
+	for i, table := range sorted {
+		tables[len(sorted)-1-i] = table
+	}
+	return tables, nil
+}
+
+// sortByDependencies sorts names so that a name comes after its dependencies,
+// keeping the order of names otherwise. Dependencies missing from names are ignored.
+func sortByDependencies(names []string, dependencies map[string][]string) ([]string, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	states := map[string]int{}
+	sorted := []string{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch states[name] {
+		case visiting:
+			return fmt.Errorf("%s : %s", name, ErrCircularReference)
+		case visited:
+			return nil
+		}
+		states[name] = visiting
+		for _, dependency := range dependencies[name] {
+			// self references are resolved by the database
+			if dependency == name || !known[dependency] {
+				continue
+			}
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		states[name] = visited
+		sorted = append(sorted, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Run inserts the fixtures in a transaction, runs fn with the transaction then rolls it back,
+// so that each test starts with the same rows. The test fails if the fixtures can't be inserted.
+//
+//	func TestArticles(t *testing.T) {
+//		loader.Run(t, connection, func(transaction *db.Transaction) {
+//			// query the fixtures with transaction
+//		})
+//	}
+func (loader *Loader) Run(t test.Tester, connection db.Connection, fn func(transaction *db.Transaction)) {
+	transaction, err := connection.Begin()
+	if err != nil {
+		t.Fatalf("Error beginning the fixtures transaction : %s", err)
+		return
+	}
+	defer func() {
+		if err := transaction.Rollback(); err != nil {
+			t.Errorf("Error rolling back the fixtures transaction : %s", err)
+		}
+	}()
+	if err := loader.Insert(transaction); err != nil {
+		t.Fatalf("Error inserting fixtures : %s", err)
+		return
+	}
+	fn(transaction)
+}
@@ -0,0 +1,112 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package fixtures_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/fixtures"
+	"github.com/Mparaiso/go-tiger/test"
+	_ "github.com/amattn/go-sqlite3"
+)
+
+func GetConnection(t *testing.T) *db.DefaultConnection {
+	DB, err := sql.Open("sqlite3", ":memory:")
+	test.Fatal(t, err, nil)
+	// a single connection so that transactions see the tables of the in memory database
+	DB.SetMaxOpenConns(1)
+	connection := db.NewConnection("sqlite3", DB)
+	for _, query := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE users(id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255), email VARCHAR(255))",
+		"CREATE TABLE articles(id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), author_id INTEGER REFERENCES users(id), metadata TEXT)",
+		"CREATE TABLE comments(id INTEGER PRIMARY KEY AUTOINCREMENT, article_id INTEGER REFERENCES articles(id), body TEXT)",
+	} {
+		_, err := connection.Exec(query)
+		test.Fatal(t, err, nil)
+	}
+	return connection
+}
+
+func TestLoadFiles(t *testing.T) {
+	connection := GetConnection(t)
+	loader, err := fixtures.LoadFiles("./testdata")
+	test.Fatal(t, err, nil)
+	names := []string{}
+	for _, fixture := range loader.Fixtures() {
+		names = append(names, fixture.Name)
+	}
+	// fixtures are inserted after the fixtures they reference
+	test.Fatal(t, strings.Join(names, ","), "user_1,article_1,user_2,article_2,comment_1")
+
+	test.Fatal(t, loader.Insert(connection), nil)
+	test.Fatal(t, loader.GetID("user_1"), int64(1))
+	test.Fatal(t, loader.GetID("user_2"), 10)
+	var authorID int64
+	var title, metadata string
+	test.Fatal(t, connection.QueryRow("SELECT author_id FROM articles WHERE id = ?", loader.GetID("article_2")).GetSingleResult(&authorID), nil)
+	test.Fatal(t, authorID, int64(10))
+	test.Fatal(t, connection.QueryRow("SELECT title FROM articles WHERE id = ?", loader.GetID("article_2")).GetSingleResult(&title), nil)
+	test.Fatal(t, title, "@handle")
+	test.Fatal(t, connection.QueryRow("SELECT metadata FROM articles WHERE id = ?", loader.GetID("article_1")).GetSingleResult(&metadata), nil)
+	test.Fatal(t, metadata, `{"tags":["go","sql"]}`)
+
+	// users can only be deleted once the rows referencing them are deleted
+	test.Fatal(t, loader.Truncate(connection), nil)
+	var count int
+	for _, table := range []string{"users", "articles", "comments"} {
+		test.Fatal(t, connection.QueryRow("SELECT COUNT(*) FROM "+table).GetSingleResult(&count), nil)
+		test.Fatal(t, count, 0, table)
+	}
+}
+
+func TestRun(t *testing.T) {
+	connection := GetConnection(t)
+	loader, err := fixtures.NewLoader(
+		&fixtures.Fixture{Name: "comment", Table: "comments", Values: map[string]interface{}{"body": "First", "article_id": "@article"}},
+		&fixtures.Fixture{Name: "article", Table: "articles", Values: map[string]interface{}{"title": "Hello"}},
+	)
+	test.Fatal(t, err, nil)
+	var count int
+	loader.Run(t, connection, func(transaction *db.Transaction) {
+		test.Fatal(t, transaction.QueryRow("SELECT COUNT(*) FROM comments WHERE article_id = ?", loader.GetID("article")).GetSingleResult(&count), nil)
+		test.Fatal(t, count, 1)
+	})
+	// the transaction is rolled back
+	test.Fatal(t, connection.QueryRow("SELECT COUNT(*) FROM comments").GetSingleResult(&count), nil)
+	test.Fatal(t, count, 0)
+}
+
+func TestNewLoader(t *testing.T) {
+	for _, fixture := range []struct {
+		Fixtures []*fixtures.Fixture
+		Expected error
+	}{
+		{[]*fixtures.Fixture{{Name: "a", Table: "users"}, {Name: "a", Table: "users"}}, fixtures.ErrDuplicateFixture},
+		{[]*fixtures.Fixture{{Name: "a", Table: "users", Values: map[string]interface{}{"id": "@b"}}}, fixtures.ErrUnknownReference},
+		{[]*fixtures.Fixture{
+			{Name: "a", Table: "users", Values: map[string]interface{}{"id": "@b"}},
+			{Name: "b", Table: "users", Values: map[string]interface{}{"id": "@a"}},
+		}, fixtures.ErrCircularReference},
+	} {
+		_, err := fixtures.NewLoader(fixture.Fixtures...)
+		test.Fatal(t, err != nil && strings.HasSuffix(err.Error(), fixture.Expected.Error()), true, fixture.Expected.Error())
+	}
+	_, err := fixtures.ParseFixtures(strings.NewReader("users: [john]"))
+	test.Fatal(t, err, fixtures.ErrInvalidFixtures)
+}
@@ -0,0 +1,93 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+)
+
+// ValueScanner is a value written to and read from the database
+type ValueScanner interface {
+	driver.Valuer
+	sql.Scanner
+}
+
+// JSON wraps a value marshaled to JSON when written to the database, nil values being written
+// as NULL. When value is a pointer, the JSON read from the database is unmarshaled into it,
+// NULL setting it to its zero value. Struct fields tagged json are wrapped automatically.
+//
+//	connection.Exec("INSERT INTO articles(tags) VALUES(?)", db.JSON([]string{"go", "sql"}))
+//	connection.QueryRow("SELECT tags FROM articles").GetSingleResult(db.JSON(&tags))
+func JSON(value interface{}) ValueScanner {
+	return &jsonValue{value}
+}
+
+type jsonValue struct {
+	value interface{}
+}
+
+func (j jsonValue) Value() (driver.Value, error) {
+	if isNil(reflect.ValueOf(j.value)) {
+		return nil, nil
+	}
+	data, err := json.Marshal(j.value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (j jsonValue) Scan(src interface{}) error {
+	destination := reflect.ValueOf(j.value)
+	if destination.Kind() != reflect.Ptr || destination.IsNil() {
+		return ErrNotAPointer
+	}
+	switch src := src.(type) {
+	case nil:
+		destination.Elem().Set(reflect.Zero(destination.Elem().Type()))
+		return nil
+	case []byte:
+		return json.Unmarshal(src, j.value)
+	case string:
+		return json.Unmarshal([]byte(src), j.value)
+	default:
+		return ErrUnsupportedScanSource
+	}
+}
+
+// wrapColumnValue wraps value with JSON if isJSON is true, with Array if isArray is true
+func wrapColumnValue(value interface{}, isJSON, isArray bool) interface{} {
+	switch {
+	case isJSON:
+		return JSON(value)
+	case isArray:
+		return Array(value)
+	}
+	return value
+}
+
+// isNil returns true if value is invalid or a nil pointer, map, slice or interface
+func isNil(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Chan, reflect.Func:
+		return value.IsNil()
+	}
+	return false
+}
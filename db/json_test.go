@@ -0,0 +1,115 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type DocumentAuthor struct {
+	Name string `json:"name"`
+}
+
+type Document struct {
+	ID       int64                  `sql:"column:id"`
+	Author   *DocumentAuthor        `sql:"column:author,json"`
+	Metadata map[string]interface{} `sql:"column:metadata,json"`
+	Tags     []string               `sql:"column:tags,array"`
+	Scores   []int                  `sql:"column:scores,array"`
+}
+
+func TestJSONAndArrayColumns(t *testing.T) {
+	connection := GetConnection(t)
+	_, err := connection.Exec("CREATE TABLE documents(id INTEGER PRIMARY KEY AUTOINCREMENT, author TEXT, metadata TEXT, tags TEXT, scores TEXT)")
+	test.Fatal(t, err, nil)
+	_, err = connection.Insert("documents", Document{
+		Author:   &DocumentAuthor{Name: "John Doe"},
+		Metadata: map[string]interface{}{"draft": true},
+		Tags:     []string{"go", `say "hello", world`},
+		Scores:   []int{1, 2},
+	})
+	test.Fatal(t, err, nil)
+	// nil maps and slices are not written, their columns are NULL
+	_, err = connection.Insert("documents", Document{})
+	test.Fatal(t, err, nil)
+
+	var author, tags string
+	test.Fatal(t, connection.QueryRow("SELECT author FROM documents WHERE id = 1").GetSingleResult(&author), nil)
+	test.Fatal(t, connection.QueryRow("SELECT tags FROM documents WHERE id = 1").GetSingleResult(&tags), nil)
+	test.Fatal(t, author, `{"name":"John Doe"}`)
+	test.Fatal(t, tags, `{"go","say \"hello\", world"}`)
+
+	documents := []*Document{}
+	test.Fatal(t, connection.Query("SELECT * FROM documents ORDER BY id").GetResults(&documents), nil)
+	test.Fatal(t, len(documents), 2)
+	test.Fatal(t, documents[0].Author.Name, "John Doe")
+	test.Fatal(t, documents[0].Metadata["draft"], true)
+	test.Fatal(t, fmt.Sprintf("%q %v", documents[0].Tags, documents[0].Scores), `["go" "say \"hello\", world"] [1 2]`)
+	test.Fatal(t, documents[1].Author == nil && documents[1].Metadata == nil && documents[1].Tags == nil, true)
+
+	_, err = connection.Update("documents", map[string]interface{}{"id": 2}, Document{Tags: []string{"sql"}})
+	test.Fatal(t, err, nil)
+	var updated []string
+	test.Fatal(t, connection.QueryRow("SELECT tags FROM documents WHERE id = 2").GetSingleResult(db.Array(&updated)), nil)
+	test.Fatal(t, fmt.Sprint(updated), "[sql]")
+
+	// values at a JSON path can be compared
+	var id int64
+	test.Fatal(t, connection.CreateQueryBuilder().Select("id").From("documents").
+		Where(expression.Eq(expression.JSONExtract("author", "$.name"), expression.Param("John Doe"))).
+		QueryRow().GetSingleResult(&id), nil)
+	test.Fatal(t, id, int64(1))
+
+	// the repository writes and reads tagged fields
+	repository, err := db.NewRepository(connection, "documents", new(Document))
+	test.Fatal(t, err, nil)
+	document := &Document{Author: &DocumentAuthor{Name: "Jane Doe"}, Scores: []int{3}}
+	test.Fatal(t, repository.Save(document), nil)
+	found := new(Document)
+	test.Fatal(t, repository.Find(document.ID, found), nil)
+	test.Fatal(t, found.Author.Name+fmt.Sprint(found.Scores), "Jane Doe[3]")
+}
+
+func TestArray(t *testing.T) {
+	for _, fixture := range []struct {
+		Value    interface{}
+		Expected interface{}
+	}{
+		{[]string{"a", "", `b\c`}, `{"a","","b\\c"}`},
+		{[]*float64{nil}, "{NULL}"},
+		{[][]bool{{true}, {false}}, "{{true},{false}}"},
+		{[]string(nil), nil},
+		{"a", db.ErrUnsupportedArrayType},
+	} {
+		value, err := db.Array(fixture.Value).Value()
+		if err != nil {
+			test.Fatal(t, err, fixture.Expected)
+			continue
+		}
+		test.Fatal(t, value, fixture.Expected)
+	}
+	var matrix [][]int64
+	test.Fatal(t, db.Array(&matrix).Scan([]byte("{{1,2},{3,NULL}}")), nil)
+	test.Fatal(t, fmt.Sprint(matrix), "[[1 2] [3 0]]")
+	var flags []*bool
+	test.Fatal(t, db.Array(&flags).Scan("{t,NULL}"), nil)
+	test.Fatal(t, *flags[0] && flags[1] == nil, true)
+	test.Fatal(t, db.Array(&flags).Scan("{t,"), db.ErrInvalidArrayLiteral)
+}
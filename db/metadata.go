@@ -0,0 +1,281 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// metadata describes how the fields of an entity
+// are persisted in a table
+type metadata struct {
+	// table is the table associated with the metadata
+	table string
+	// structType is the go type associated with the metadata,
+	// always a pointer to struct
+	structType reflect.Type
+	// idField is the struct field name of the field holding
+	// the identifier
+	idField string
+	// idColumn is the column holding the identifier
+	idColumn string
+	// fields are metadatas for persisted struct fields
+	fields []field
+}
+
+func (meta metadata) String() string {
+	result := "metadata : {"
+	result += "table: '" + meta.table + "', "
+	result += "idField: '" + meta.idField + "' "
+	result += "fields :["
+	for i, field := range meta.fields {
+		if i > 0 {
+			result += ", "
+		}
+		result += "{" + field.String() + "}"
+	}
+	return result + "]}"
+}
+
+// findField returns the field metadata for a struct field name
+func (meta metadata) findField(name string) (f field, found bool) {
+	for _, field := range meta.fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	return f, false
+}
+
+// getDependencies returns the tables the entity table
+// references through foreign keys
+func (meta metadata) getDependencies() (tables []string) {
+	for _, field := range meta.fields {
+		if field.references != "" && field.references != meta.table {
+			tables = append(tables, field.references)
+		}
+		if field.relation.relation == manyToOne && field.relation.targetEntity != meta.table {
+			tables = append(tables, field.relation.targetEntity)
+		}
+	}
+	return
+}
+
+// findVersionField returns the field holding the version of the entity, if any
+func (meta metadata) findVersionField() (f field, found bool) {
+	for _, field := range meta.fields {
+		if field.version {
+			return field, true
+		}
+	}
+	return f, false
+}
+
+// getID returns the value of the identifier of entity
+func (meta metadata) getID(entity reflect.Value) reflect.Value {
+	return reflect.Indirect(entity).FieldByName(meta.idField)
+}
+
+// hasID returns true if the identifier of entity is not a zero value
+func (meta metadata) hasID(entity reflect.Value) bool {
+	return !isZero(meta.getID(entity))
+}
+
+// normalizeID converts id to the type of the identifier field
+// so it can be used as a key in the identity map.
+func (meta metadata) normalizeID(id interface{}) interface{} {
+	idField, _ := meta.structType.Elem().FieldByName(meta.idField)
+	value := reflect.ValueOf(id)
+	if value.IsValid() && value.Type() != idField.Type && value.Type().ConvertibleTo(idField.Type) {
+		return value.Convert(idField.Type).Interface()
+	}
+	return id
+}
+
+// field describes how a struct field is persisted
+type field struct {
+	// struct field name
+	name string
+	// column name
+	column string
+	// whether to persist zero values on insert
+	persistZeroValue bool
+	// references is the table referenced by a foreign key column
+	references string
+	// relation is the relation to another entity, if any
+	relation relation
+	// json and array are true if the column is written and read with JSON or Array
+	json, array bool
+	// version is true if the field holds the version of the row, see SQLStructTag.Version
+	version bool
+}
+
+func (f field) String() string {
+	return fmt.Sprintf("name:'%s', column:'%s', persistZeroValue:'%v', references:'%s', relation:%s",
+		f.name, f.column, f.persistZeroValue, f.references, f.relation)
+}
+
+// isColumn returns true if the field is persisted in a column of the entity table
+func (f field) isColumn() bool {
+	return f.column != ""
+}
+
+// getTypeMetadata takes a pointer to struct and returns the metadata
+// for the struct or an error if no identifier field was found.
+// The identifier field is either the field with a primaryKey option
+// in its sql struct tag, or the field mapped to the id column or the ID field.
+// The fields of embedded structs are fields of the entity, see getEntityFields.
+func getTypeMetadata(table string, value interface{}) (meta metadata, err error) {
+	Type := reflect.TypeOf(value)
+	if Type.Kind() != reflect.Ptr {
+		return meta, ErrNotAPointer
+	}
+	if Type.Elem().Kind() != reflect.Struct {
+		return meta, ErrNotAStruct
+	}
+	meta.table = table
+	meta.structType = Type
+	guess := ""
+	for _, Field := range getEntityFields(Type.Elem()) {
+		stringTag := Field.Tag.Get("sql")
+		tag := SQLStructTagBuilder{}.BuildFromString(stringTag)
+		MetaField := field{name: Field.Name, column: Field.Name, persistZeroValue: tag.PersistZeroValue || tag.Version,
+			references: tag.References, json: tag.JSON, array: tag.Array, version: tag.Version}
+		if tag.Version {
+			if _, err := getNextVersion(reflect.New(Field.Type).Elem()); err != nil {
+				return meta, err
+			}
+		}
+		if tag.ColumnName != "" {
+			MetaField.column = tag.ColumnName
+		}
+		for _, definition := range splitTag(stringTag) {
+			if !strings.Contains(definition, "(") {
+				continue
+			}
+			if MetaField.relation, err = parseRelation(Field, strings.TrimSpace(definition)); err != nil {
+				return meta, err
+			}
+			MetaField.column = ""
+			if MetaField.relation.relation == manyToOne {
+				MetaField.column = MetaField.relation.joinColumn
+			}
+		}
+		if MetaField.relation.relation != 0 {
+			meta.fields = append(meta.fields, MetaField)
+			continue
+		}
+		if tag.PrimaryKey {
+			meta.idField, meta.idColumn = MetaField.name, MetaField.column
+		} else if guess == "" && (strings.ToLower(MetaField.column) == "id" || MetaField.name == "ID") {
+			guess = MetaField.name
+		}
+		meta.fields = append(meta.fields, MetaField)
+	}
+	if meta.idField == "" && guess != "" {
+		idField, _ := meta.findField(guess)
+		meta.idField, meta.idColumn = idField.name, idField.column
+	}
+	if meta.idField == "" {
+		return meta, ErrIDFieldNotFound
+	}
+	return meta, nil
+}
+
+// getEntityFields returns the exported fields of an entity, the fields of embedded structs
+// being inlined like in CreateTableFromStruct. Fields promoted through embedded pointers are
+// ignored, they can't be set while the pointer is nil.
+func getEntityFields(Type reflect.Type) (fields []reflect.StructField) {
+	for _, Field := range getSchemaFields(Type, map[reflect.Type]bool{}) {
+		promoted, ok := Type.FieldByName(Field.Name)
+		if !ok {
+			continue
+		}
+		embeddedType, throughPointer := Type, false
+		for _, index := range promoted.Index[:len(promoted.Index)-1] {
+			if embeddedType = embeddedType.Field(index).Type; embeddedType.Kind() == reflect.Ptr {
+				throughPointer = true
+				break
+			}
+		}
+		if !throughPointer {
+			fields = append(fields, promoted)
+		}
+	}
+	return fields
+}
+
+// metadatas is a helper type used when working with a list of metadatas
+type metadatas map[reflect.Type]metadata
+
+// getMetadata returns the metadata for a given type
+func (metas metadatas) getMetadata(Type reflect.Type) (metadata, error) {
+	meta, ok := metas[Type]
+	if !ok {
+		return meta, ErrEntityNotRegistered
+	}
+	return meta, nil
+}
+
+// findMetadataByTable returns the metadata registered for a table
+func (metas metadatas) findMetadataByTable(table string) (metadata, bool) {
+	for _, meta := range metas {
+		if meta.table == table {
+			return meta, true
+		}
+	}
+	return metadata{}, false
+}
+
+// getCommitOrder sorts tables so that referenced tables come
+// before the tables referencing them. Tables involved in a cycle
+// keep their original order.
+func (metas metadatas) getCommitOrder(tables []string) []string {
+	visited := map[string]bool{}
+	order := []string{}
+	var visit func(table string, path map[string]bool)
+	visit = func(table string, path map[string]bool) {
+		if visited[table] || path[table] {
+			return
+		}
+		path[table] = true
+		if meta, ok := metas.findMetadataByTable(table); ok {
+			for _, dependency := range meta.getDependencies() {
+				visit(dependency, path)
+			}
+		}
+		delete(path, table)
+		visited[table] = true
+		order = append(order, table)
+	}
+	for _, table := range tables {
+		visit(table, map[string]bool{})
+	}
+	return order
+}
+
+// isZero returns true if value is the zero value of its type
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	if !value.Type().Comparable() {
+		return false
+	}
+	return value.Interface() == reflect.Zero(value.Type()).Interface()
+}
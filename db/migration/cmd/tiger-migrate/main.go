@@ -0,0 +1,98 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// tiger-migrate applies and reverts sql migrations.
+//
+//	tiger-migrate -driver sqlite3 -dsn db.sqlite3 -dir migrations up [max]
+//	tiger-migrate -driver mysql -dsn "user@/database?parseTime=true" -dir migrations down [max]
+//	tiger-migrate -driver sqlite3 -dsn db.sqlite3 -dir migrations status
+//	tiger-migrate -driver sqlite3 -dsn db.sqlite3 unlock
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/migration"
+	_ "github.com/amattn/go-sqlite3"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	driver := flag.String("driver", "sqlite3", "database driver, sqlite3 or mysql")
+	dsn := flag.String("dsn", "", "data source name")
+	dir := flag.String("dir", "migrations", "directory of the migration files")
+	table := flag.String("table", migration.DefaultTableName, "name of the migration table")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tiger-migrate [options] up|down [max] | status | unlock")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*driver, *dsn, *dir, *table, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(driver, dsn, dir, table string, arguments []string) error {
+	connection, err := db.NewConnectionWithString(driver, dsn)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	migrator := migration.NewMigrator(connection)
+	migrator.TableName = table
+	source := migration.FileSource{Dir: dir}
+	switch arguments[0] {
+	case "up", "down":
+		direction, max := migration.Up, 0
+		if arguments[0] == "down" {
+			direction = migration.Down
+		}
+		if len(arguments) > 1 {
+			if max, err = strconv.Atoi(arguments[1]); err != nil {
+				return err
+			}
+		}
+		count, err := migrator.Migrate(source, direction, max)
+		fmt.Printf("%d migration(s) %s\n", count, map[migration.Direction]string{migration.Up: "applied", migration.Down: "reverted"}[direction])
+		return err
+	case "status":
+		statuses, err := migrator.GetStatus(source)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-50s %s\n", status.ID, appliedAt)
+		}
+		return nil
+	case "unlock":
+		return migrator.Unlock()
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %s", arguments[0])
+	}
+}
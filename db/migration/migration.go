@@ -0,0 +1,204 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package migration is a versioned migration runner.
+//
+// Migrations are sql files with the following format :
+//
+//	-- +migrate Up
+//	CREATE TABLE users(id INTEGER PRIMARY KEY, name VARCHAR(255));
+//
+//	-- +migrate Down
+//	DROP TABLE users;
+//
+// Statements are separated by semicolons at the end of a line, statements
+// containing semicolons, like stored procedures, are wrapped between
+// -- +migrate StatementBegin and -- +migrate StatementEnd.
+// Applied migrations are recorded in a migration table.
+package migration
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidMigration is yield when a migration file can't be parsed
+	ErrInvalidMigration = fmt.Errorf("Error the migration is not valid")
+	// ErrUnknownMigration is yield when an applied migration is missing from the migration source
+	ErrUnknownMigration = fmt.Errorf("Error an applied migration was not found in the migration source")
+	// ErrLocked is yield when migrations are already being run
+	ErrLocked = fmt.Errorf("Error migrations are locked, another migration is running")
+)
+
+// Direction is the direction of a migration
+type Direction int
+
+const (
+	// Up applies migrations
+	Up Direction = iota
+	// Down reverts migrations
+	Down
+)
+
+func (direction Direction) String() string {
+	if direction == Down {
+		return "down"
+	}
+	return "up"
+}
+
+// Migration is a versioned change of a database
+type Migration struct {
+	// ID identifies the migration, migrations are sorted by ID
+	ID   string
+	Up   []string
+	Down []string
+}
+
+// Source provides migrations
+type Source interface {
+	FindMigrations() ([]*Migration, error)
+}
+
+// MemorySource is a list of migrations
+type MemorySource struct {
+	Migrations []*Migration
+}
+
+// FindMigrations returns the migrations sorted by ID
+func (source MemorySource) FindMigrations() ([]*Migration, error) {
+	migrations := append([]*Migration{}, source.Migrations...)
+	sortMigrations(migrations)
+	return migrations, nil
+}
+
+// FileSource reads migrations from the .sql files of a directory,
+// the name of a file is the ID of its migration.
+type FileSource struct {
+	Dir string
+}
+
+// FindMigrations returns the migrations sorted by ID
+func (source FileSource) FindMigrations() ([]*Migration, error) {
+	infos, err := ioutil.ReadDir(source.Dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := []*Migration{}
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".sql" {
+			continue
+		}
+		file, err := os.Open(filepath.Join(source.Dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, err := ParseMigration(info.Name(), file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s : %s", info.Name(), err)
+		}
+		migrations = append(migrations, migration)
+	}
+	sortMigrations(migrations)
+	return migrations, nil
+}
+
+// ParseMigration parses a migration file
+func ParseMigration(id string, reader io.Reader) (*Migration, error) {
+	migration := &Migration{ID: id}
+	var statements *[]string
+	statement := ""
+	inStatementBlock := false
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "-- +migrate") {
+			switch strings.TrimSpace(strings.TrimPrefix(trimmed, "-- +migrate")) {
+			case "Up":
+				statements = &migration.Up
+			case "Down":
+				statements = &migration.Down
+			case "StatementBegin":
+				inStatementBlock = true
+			case "StatementEnd":
+				if statements == nil {
+					return nil, ErrInvalidMigration
+				}
+				inStatementBlock = false
+				*statements = append(*statements, strings.TrimSpace(statement))
+				statement = ""
+			default:
+				return nil, ErrInvalidMigration
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if statements == nil {
+			return nil, ErrInvalidMigration
+		}
+		statement += line + "\n"
+		if !inStatementBlock && strings.HasSuffix(trimmed, ";") {
+			*statements = append(*statements, strings.TrimSpace(statement))
+			statement = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inStatementBlock {
+		return nil, ErrInvalidMigration
+	}
+	if strings.TrimSpace(statement) != "" {
+		*statements = append(*statements, strings.TrimSpace(statement))
+	}
+	return migration, nil
+}
+
+// sortMigrations sorts migrations by ID, IDs starting with
+// a number are compared numerically.
+func sortMigrations(migrations []*Migration) {
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return lessID(migrations[i].ID, migrations[j].ID)
+	})
+}
+
+func lessID(a, b string) bool {
+	numberA, errA := strconv.ParseInt(leadingDigits(a), 10, 64)
+	numberB, errB := strconv.ParseInt(leadingDigits(b), 10, 64)
+	if errA == nil && errB == nil && numberA != numberB {
+		return numberA < numberB
+	}
+	return a < b
+}
+
+func leadingDigits(id string) string {
+	for i, r := range id {
+		if r < '0' || r > '9' {
+			return id[:i]
+		}
+	}
+	return id
+}
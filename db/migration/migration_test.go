@@ -0,0 +1,173 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package migration_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/migration"
+	"github.com/Mparaiso/go-tiger/db/platform"
+	"github.com/Mparaiso/go-tiger/test"
+	_ "github.com/amattn/go-sqlite3"
+)
+
+func GetConnection(t *testing.T) *db.DefaultConnection {
+	DB, err := sql.Open("sqlite3", ":memory:")
+	test.Fatal(t, err, nil)
+	// each connection opens a new in memory database
+	DB.SetMaxOpenConns(1)
+	return db.NewConnection("sqlite3", DB)
+}
+
+func TestParseMigration(t *testing.T) {
+	parsed, err := migration.ParseMigration("1_users.sql", strings.NewReader(`
+-- +migrate Up
+-- users
+CREATE TABLE users(
+	id INTEGER PRIMARY KEY,
+	name VARCHAR(255)
+);
+-- +migrate StatementBegin
+CREATE TRIGGER users_trigger AFTER INSERT ON users BEGIN
+	UPDATE users SET name = upper(name) WHERE id = NEW.id;
+END;
+-- +migrate StatementEnd
+
+-- +migrate Down
+DROP TRIGGER users_trigger;
+DROP TABLE users;
+`))
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(parsed.Up), 2)
+	test.Fatal(t, strings.HasPrefix(parsed.Up[0], "CREATE TABLE users("), true)
+	test.Fatal(t, strings.HasSuffix(parsed.Up[1], "END;"), true)
+	test.Fatal(t, len(parsed.Down), 2)
+	test.Fatal(t, parsed.Down[1], "DROP TABLE users;")
+
+	_, err = migration.ParseMigration("2_invalid.sql", strings.NewReader("DROP TABLE users;"))
+	test.Fatal(t, err, migration.ErrInvalidMigration)
+	// a statement block outside of the Up and Down sections
+	_, err = migration.ParseMigration("3_invalid.sql", strings.NewReader("-- +migrate StatementBegin\nDROP TABLE users;\n-- +migrate StatementEnd\n"))
+	test.Fatal(t, err, migration.ErrInvalidMigration)
+}
+
+func TestMigratorFileSource(t *testing.T) {
+	connection := GetConnection(t)
+	migrator := migration.NewMigrator(connection)
+	source := migration.FileSource{Dir: "../testdata/migrations/development.sqlite3"}
+	count, err := migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 6)
+	// migrations are only applied once
+	count, err = migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 0)
+
+	statuses, err := migrator.GetStatus(source)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(statuses), 6)
+	test.Fatal(t, statuses[0].ID, "001_initial_migration.sql")
+	test.Fatal(t, statuses[5].Applied, true)
+	test.Fatal(t, statuses[5].AppliedAt.IsZero(), false)
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	connection := GetConnection(t)
+	migrator := migration.NewMigrator(connection)
+	// migrations are sorted by ID
+	source := migration.MemorySource{Migrations: []*migration.Migration{
+		{ID: "10_articles", Up: []string{"CREATE TABLE articles(id INTEGER PRIMARY KEY)"}, Down: []string{"DROP TABLE articles"}},
+		{ID: "2_users", Up: []string{"CREATE TABLE users(id INTEGER PRIMARY KEY)"}, Down: []string{"DROP TABLE users"}},
+	}}
+	count, err := migrator.Migrate(source, migration.Up, 1)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+	records, err := migrator.GetRecords()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(records), 1)
+	test.Fatal(t, records[0].ID, "2_users")
+
+	count, err = migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+	count, err = migrator.Migrate(source, migration.Down, 1)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+	tables, err := db.NewSchemaManager(connection).ListTableNames()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Join(tables, ","), "tiger_migrations,tiger_migrations_lock,users")
+
+	// a failing migration is rolled back
+	source.Migrations = append(source.Migrations, &migration.Migration{ID: "20_invalid", Up: []string{"CREATE TABLE comments(id INTEGER)", "INVALID"}})
+	count, err = migrator.Migrate(source, migration.Up, 0)
+	test.Error(t, err != nil, true)
+	test.Fatal(t, count, 1)
+	records, err = migrator.GetRecords()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(records), 2)
+}
+
+// postgresPlatform renders the queries for postgresql and lists the tables of the sqlite test database
+type postgresPlatform struct {
+	platform.DatabasePlatform
+}
+
+func (postgresPlatform) GetListTablesSQL(database ...string) string {
+	return platform.GetPlatform("sqlite3").GetListTablesSQL(database...)
+}
+
+func TestMigratorPlaceholders(t *testing.T) {
+	connection := GetConnection(t)
+	migrator := migration.NewMigrator(connection)
+	source := migration.MemorySource{Migrations: []*migration.Migration{
+		{ID: "1_users", Up: []string{"CREATE TABLE users(id INTEGER PRIMARY KEY)"}, Down: []string{"DROP TABLE users"}},
+	}}
+	_, err := migrator.GetRecords()
+	test.Fatal(t, err, nil)
+	connection.Platform = postgresPlatform{platform.GetPlatform("postgres")}
+	queries := []string{}
+	connection.Options.Observers = []db.QueryObserver{db.QueryObserverFuncs{Before: func(event *db.QueryEvent) {
+		if strings.Contains(event.SQL, "$") {
+			queries = append(queries, event.SQL)
+		}
+	}}}
+	_, err = migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+	_, err = migrator.Migrate(source, migration.Down, 0)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Join(queries, "\n"), strings.Join([]string{
+		"INSERT INTO tiger_migrations_lock (id, locked_at) VALUES($1, $2)",
+		"INSERT INTO tiger_migrations (id, applied_at) VALUES($1, $2)",
+		"INSERT INTO tiger_migrations_lock (id, locked_at) VALUES($1, $2)",
+		"DELETE FROM tiger_migrations WHERE id = $1",
+	}, "\n"))
+}
+
+func TestMigratorLock(t *testing.T) {
+	connection := GetConnection(t)
+	migrator := migration.NewMigrator(connection)
+	source := migration.MemorySource{}
+	_, err := migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, migrator.Lock(), nil)
+	_, err = migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, migration.ErrLocked)
+	test.Fatal(t, migrator.Unlock(), nil)
+	_, err = migrator.Migrate(source, migration.Up, 0)
+	test.Fatal(t, err, nil)
+}
@@ -0,0 +1,251 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package migration
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// DefaultTableName is the default name of the migration table
+const DefaultTableName = "tiger_migrations"
+
+// Record is an applied migration
+type Record struct {
+	ID        string    `sql:"column:id"`
+	AppliedAt time.Time `sql:"column:applied_at"`
+}
+
+// Status is the status of a migration
+type Status struct {
+	ID      string
+	Applied bool
+	// AppliedAt is the date the migration was applied at
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts migrations. Applied migrations are recorded
+// in the migration table, a lock table prevents concurrent runs.
+// Tables are created when needed.
+//
+// With MySQL, the parseTime=true option must be set in the data source name.
+type Migrator struct {
+	connection db.Connection
+	// TableName is the name of the migration table, the name of the lock table
+	// is the name of the migration table with a _lock suffix
+	TableName string
+}
+
+// NewMigrator creates a Migrator
+func NewMigrator(connection db.Connection) *Migrator {
+	return &Migrator{connection: connection, TableName: DefaultTableName}
+}
+
+// Migrate applies or reverts at most max migrations, or all migrations if max is 0.
+// Each migration is run in a transaction along with its record.
+// It returns the number of migrations applied or reverted.
+func (migrator *Migrator) Migrate(source Source, direction Direction, max int) (count int, err error) {
+	migrations, err := source.FindMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err = migrator.createTables(); err != nil {
+		return 0, err
+	}
+	if err = migrator.Lock(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if unlockError := migrator.Unlock(); err == nil {
+			err = unlockError
+		}
+	}()
+	plan, err := migrator.plan(migrations, direction, max)
+	if err != nil {
+		return 0, err
+	}
+	for _, migration := range plan {
+		if err = migrator.run(migration, direction); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// GetRecords returns the applied migrations
+func (migrator *Migrator) GetRecords() ([]*Record, error) {
+	if err := migrator.createTables(); err != nil {
+		return nil, err
+	}
+	records := []*Record{}
+	err := migrator.connection.Query("SELECT id, applied_at FROM " + migrator.TableName + " ORDER BY id").GetResults(&records)
+	return records, err
+}
+
+// GetStatus returns the status of each migration of source
+func (migrator *Migrator) GetStatus(source Source) ([]*Status, error) {
+	migrations, err := source.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	records, err := migrator.GetRecords()
+	if err != nil {
+		return nil, err
+	}
+	statuses := []*Status{}
+	for _, migration := range migrations {
+		status := &Status{ID: migration.ID}
+		for _, record := range records {
+			if record.ID == migration.ID {
+				status.Applied, status.AppliedAt = true, record.AppliedAt
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Lock prevents other migrators from running migrations
+func (migrator *Migrator) Lock() error {
+	_, err := migrator.connection.CreateQueryBuilder().Insert(migrator.getLockTableName()).
+		SetValue("id", expression.Param(1)).
+		SetValue("locked_at", expression.Param(time.Now())).
+		Exec()
+	if err != nil {
+		var count int
+		if countError := migrator.connection.QueryRow("SELECT COUNT(*) FROM " + migrator.getLockTableName()).GetSingleResult(&count); countError == nil && count > 0 {
+			return ErrLocked
+		}
+	}
+	return err
+}
+
+// Unlock releases the lock, a lock left by a failed process can be released with Unlock.
+func (migrator *Migrator) Unlock() error {
+	_, err := migrator.connection.Exec("DELETE FROM " + migrator.getLockTableName())
+	return err
+}
+
+// plan returns the migrations to run
+func (migrator *Migrator) plan(migrations []*Migration, direction Direction, max int) (plan []*Migration, err error) {
+	records, err := migrator.GetRecords()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[string]bool{}
+	for _, record := range records {
+		applied[record.ID] = true
+	}
+	if direction == Up {
+		for _, migration := range migrations {
+			if !applied[migration.ID] {
+				plan = append(plan, migration)
+			}
+		}
+	} else {
+		found := map[string]bool{}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if applied[migrations[i].ID] {
+				plan = append(plan, migrations[i])
+				found[migrations[i].ID] = true
+			}
+		}
+		if len(found) != len(applied) {
+			return nil, ErrUnknownMigration
+		}
+	}
+	if max > 0 && len(plan) > max {
+		plan = plan[:max]
+	}
+	return plan, nil
+}
+
+// run runs a migration and records it in a transaction
+func (migrator *Migrator) run(migration *Migration, direction Direction) error {
+	transaction, err := migrator.connection.Begin()
+	if err != nil {
+		return err
+	}
+	statements := migration.Up
+	if direction == Down {
+		statements = migration.Down
+	}
+	for _, statement := range statements {
+		if _, err = transaction.Exec(statement); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	// the record is written by a query builder, which renders the placeholders of the platform
+	if direction == Up {
+		_, err = transaction.CreateQueryBuilder().Insert(migrator.TableName).
+			SetValue("id", expression.Param(migration.ID)).
+			SetValue("applied_at", expression.Param(time.Now())).
+			Exec()
+	} else {
+		_, err = transaction.CreateQueryBuilder().Delete(migrator.TableName).
+			Where(expression.Eq("id", expression.Param(migration.ID))).
+			Exec()
+	}
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// createTables creates the migration and lock tables if they do not exist
+func (migrator *Migrator) createTables() error {
+	schemaManager := db.NewSchemaManager(migrator.connection)
+	tables, err := schemaManager.ListTableNames()
+	if err != nil {
+		return err
+	}
+	for _, table := range []*schema.Table{
+		schema.NewTable(migrator.TableName).
+			AddColumn(&schema.Column{Name: "id", Type: schema.String, PrimaryKey: true}).
+			AddColumn(&schema.Column{Name: "applied_at", Type: schema.DateTime}),
+		schema.NewTable(migrator.getLockTableName()).
+			AddColumn(&schema.Column{Name: "id", Type: schema.Integer, PrimaryKey: true}).
+			AddColumn(&schema.Column{Name: "locked_at", Type: schema.DateTime}),
+	} {
+		if containsName(tables, table.Name) {
+			continue
+		}
+		if err := schemaManager.CreateTable(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (migrator *Migrator) getLockTableName() string {
+	return migrator.TableName + "_lock"
+}
+
+// containsName returns true if names contains name, case insensitively
+func containsName(names []string, name string) bool {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/logger"
+)
+
+// QueryEvent describes a query executed by a connection, a transaction or a statement
+type QueryEvent struct {
+	Context   context.Context
+	SQL       string
+	Arguments []interface{}
+	Start     time.Time
+	// Duration is the time spent executing the query, the rows of a query
+	// are read after the query is observed
+	Duration time.Duration
+	// RowsAffected is the number of rows affected by an Exec, -1 for queries
+	RowsAffected int64
+	Err          error
+	// Caller is the file:line of the code that executed the query, outside of the db package
+	Caller string
+}
+
+// QueryObserver observes the queries executed by a connection and by its transactions
+// and statements. BeforeQuery is called before the execution of each query, AfterQuery
+// after its execution with the duration, rows affected and error of the query set.
+type QueryObserver interface {
+	BeforeQuery(event *QueryEvent)
+	AfterQuery(event *QueryEvent)
+}
+
+// QueryObserverFuncs is a QueryObserver calling its functions when they are not nil
+type QueryObserverFuncs struct {
+	Before func(event *QueryEvent)
+	After  func(event *QueryEvent)
+}
+
+func (observer QueryObserverFuncs) BeforeQuery(event *QueryEvent) {
+	if observer.Before != nil {
+		observer.Before(event)
+	}
+}
+
+func (observer QueryObserverFuncs) AfterQuery(event *QueryEvent) {
+	if observer.After != nil {
+		observer.After(event)
+	}
+}
+
+// observeQuery notifies observers and the query stats of ctx that query is executed, the returned
+// function must be called with the result of the query once executed.
+func observeQuery(ctx context.Context, observers []QueryObserver, query string, arguments []interface{}) func(result sql.Result, err error) {
+	stats := GetQueryStats(ctx)
+	if len(observers) == 0 && stats == nil {
+		return func(sql.Result, error) {}
+	}
+	event := &QueryEvent{Context: ctx, SQL: query, Arguments: arguments, Start: time.Now(), RowsAffected: -1}
+	if len(observers) > 0 {
+		event.Caller = getCaller()
+	}
+	for _, observer := range observers {
+		observer.BeforeQuery(event)
+	}
+	return func(result sql.Result, err error) {
+		event.Duration, event.Err = time.Since(event.Start), err
+		if result != nil && err == nil {
+			if rowsAffected, err := result.RowsAffected(); err == nil {
+				event.RowsAffected = rowsAffected
+			}
+		}
+		if stats != nil {
+			stats.add(event)
+		}
+		for _, observer := range observers {
+			observer.AfterQuery(event)
+		}
+	}
+}
+
+// packagePrefix prefixes the functions of the package, but not the functions
+// of its subpackages or of its external tests
+var packagePrefix = reflectPackagePath() + "."
+
+func reflectPackagePath() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	return name[:strings.LastIndex(name, ".")]
+}
+
+// getCaller returns the file:line of the first caller outside of the package
+func getCaller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// SlowQueryLogger is a QueryObserver logging the queries lasting longer than Threshold
+// at the Warning level, along with their arguments redacted by Redactor.
+type SlowQueryLogger struct {
+	Logger    logger.Logger
+	Threshold time.Duration
+	Redactor  *ArgumentRedactor
+}
+
+// NewSlowQueryLogger returns a SlowQueryLogger
+func NewSlowQueryLogger(Logger logger.Logger, threshold time.Duration, redactor *ArgumentRedactor) *SlowQueryLogger {
+	return &SlowQueryLogger{Logger: Logger, Threshold: threshold, Redactor: redactor}
+}
+
+func (slowQueryLogger *SlowQueryLogger) BeforeQuery(event *QueryEvent) {}
+
+func (slowQueryLogger *SlowQueryLogger) AfterQuery(event *QueryEvent) {
+	if event.Duration < slowQueryLogger.Threshold {
+		return
+	}
+	arguments := event.Arguments
+	if slowQueryLogger.Redactor != nil {
+		arguments = slowQueryLogger.Redactor.Redact(event.SQL, arguments)
+	}
+	slowQueryLogger.Logger.LogF(logger.Warning, "Slow query (%s) at %s : %s %v", event.Duration, event.Caller, event.SQL, arguments)
+}
+
+// ArgumentRedactor replaces the arguments bound to sensitive columns before they are logged.
+// The column of an argument is the column compared to its placeholder, as in password = ?,
+// or the column of its position in the VALUES of an INSERT statement.
+type ArgumentRedactor struct {
+	// Columns are the sensitive columns, compared without their table and case insensitively
+	Columns []string
+	// Replacement replaces the redacted arguments, "[REDACTED]" by default
+	Replacement interface{}
+}
+
+// NewArgumentRedactor returns an ArgumentRedactor redacting the arguments bound to columns
+func NewArgumentRedactor(columns ...string) *ArgumentRedactor {
+	return &ArgumentRedactor{Columns: columns, Replacement: "[REDACTED]"}
+}
+
+var (
+	comparedColumnRegexp = regexp.MustCompile("(?i)([\\w.\"`\\[\\]]+)\\s*(?:=|<>|!=|<=|>=|<|>|\\bNOT\\s+LIKE|\\bLIKE|\\bNOT\\s+ILIKE|\\bILIKE)\\s*$")
+	insertColumnsRegexp  = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\S+\s*\(([^)]*)\)\s*VALUES\b`)
+)
+
+// Redact returns a copy of arguments where the arguments bound to sensitive columns are replaced
+func (redactor *ArgumentRedactor) Redact(query string, arguments []interface{}) []interface{} {
+	redacted := append([]interface{}{}, arguments...)
+	var insertColumns []string
+	valuesIndex := -1
+	if match := insertColumnsRegexp.FindStringSubmatchIndex(query); match != nil {
+		insertColumns = strings.Split(query[match[2]:match[3]], ",")
+		valuesIndex = match[1]
+	}
+	valuesCount := 0
+	for i, placeholder := range findPlaceholders(query) {
+		argument := placeholder.argument
+		if argument == -1 {
+			argument = i
+		}
+		if argument >= len(redacted) {
+			continue
+		}
+		column := ""
+		if match := comparedColumnRegexp.FindStringSubmatch(query[:placeholder.offset]); match != nil {
+			column = match[1]
+		} else if insertColumns != nil && placeholder.offset > valuesIndex {
+			column = insertColumns[valuesCount%len(insertColumns)]
+			valuesCount++
+		}
+		if column != "" && redactor.isSensitive(column) {
+			redacted[argument] = redactor.Replacement
+		}
+	}
+	return redacted
+}
+
+func (redactor *ArgumentRedactor) isSensitive(column string) bool {
+	column = strings.Trim(strings.TrimSpace(column), "\"`[]")
+	if index := strings.LastIndex(column, "."); index != -1 {
+		column = strings.Trim(column[index+1:], "\"`[]")
+	}
+	for _, sensitiveColumn := range redactor.Columns {
+		if strings.EqualFold(column, sensitiveColumn) {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholder is a placeholder found in a query
+type placeholder struct {
+	offset int
+	// argument is the index of the argument of a numbered placeholder, -1 for a ? placeholder
+	argument int
+}
+
+// findPlaceholders returns the ?, $n, :n and @pn placeholders of query,
+// ignoring string literals and quoted identifiers
+func findPlaceholders(query string) (placeholders []placeholder) {
+	isDigit := func(i int) bool { return i < len(query) && query[i] >= '0' && query[i] <= '9' }
+	for i := 0; i < len(query); i++ {
+		prefix := 0
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end == -1 {
+				return placeholders
+			}
+			i += end + 1
+			continue
+		case c == '?':
+			placeholders = append(placeholders, placeholder{offset: i, argument: -1})
+			continue
+		case (c == '$' || c == ':') && isDigit(i+1):
+			prefix = 1
+		case c == '@' && i+1 < len(query) && query[i+1] == 'p' && isDigit(i+2):
+			prefix = 2
+		default:
+			continue
+		}
+		end := i + prefix
+		for isDigit(end) {
+			end++
+		}
+		number, _ := strconv.Atoi(query[i+prefix : end])
+		placeholders = append(placeholders, placeholder{offset: i, argument: number - 1})
+		i = end - 1
+	}
+	return placeholders
+}
+
+// QueryStats counts the queries executed with a context returned by WithQueryStats,
+// like the queries of a http request
+type QueryStats struct {
+	count    int64
+	errors   int64
+	duration int64
+}
+
+// Count returns the number of queries
+func (stats *QueryStats) Count() int64 {
+	return atomic.LoadInt64(&stats.count)
+}
+
+// Errors returns the number of queries that failed
+func (stats *QueryStats) Errors() int64 {
+	return atomic.LoadInt64(&stats.errors)
+}
+
+// Duration returns the time spent executing the queries
+func (stats *QueryStats) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&stats.duration))
+}
+
+func (stats *QueryStats) String() string {
+	return fmt.Sprintf("%d queries in %s", stats.Count(), stats.Duration())
+}
+
+func (stats *QueryStats) add(event *QueryEvent) {
+	atomic.AddInt64(&stats.count, 1)
+	atomic.AddInt64(&stats.duration, int64(event.Duration))
+	if event.Err != nil {
+		atomic.AddInt64(&stats.errors, 1)
+	}
+}
+
+type queryStatsKey struct{}
+
+// WithQueryStats returns a context counting the queries executed with it
+// or with the contexts derived from it, see GetQueryStats.
+//
+//	func(w http.ResponseWriter, r *http.Request) {
+//		r = r.WithContext(db.WithQueryStats(r.Context()))
+//		next.ServeHTTP(w, r)
+//		log.Print(r.URL, db.GetQueryStats(r.Context()))
+//	}
+func WithQueryStats(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryStatsKey{}, &QueryStats{})
+}
+
+// GetQueryStats returns the query stats of ctx, nil if ctx doesn't count queries
+func GetQueryStats(ctx context.Context) *QueryStats {
+	stats, _ := ctx.Value(queryStatsKey{}).(*QueryStats)
+	return stats
+}
@@ -0,0 +1,84 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/logger"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestQueryObserver(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	events := []*db.QueryEvent{}
+	connection.AddQueryObserver(db.QueryObserverFuncs{After: func(event *db.QueryEvent) {
+		events = append(events, event)
+	}})
+	test.Fatal(t, LoadFixtures(connection), nil)
+	test.Fatal(t, len(events), 3)
+	test.Fatal(t, events[0].RowsAffected, int64(1))
+	test.Fatal(t, strings.Contains(events[0].Caller, "connection_test.go"), true)
+
+	ctx := db.WithQueryStats(context.Background())
+	err := connection.Transactional(ctx, func(transaction *db.Transaction) error {
+		_, err := transaction.ExecContext(ctx, "UPDATE users SET email = ? WHERE name = ?", "john@acme.com", "John Doe")
+		return err
+	})
+	test.Fatal(t, err, nil)
+	users := []*AppUser{}
+	test.Fatal(t, connection.QueryContext(ctx, "SELECT * FROM users").GetResults(&users), nil)
+	test.Fatal(t, connection.QueryContext(ctx, "SELECT * FROM unknown_table").Err() != nil, true)
+	test.Fatal(t, len(events), 6)
+	test.Fatal(t, events[4].RowsAffected, int64(-1))
+	test.Fatal(t, events[5].Err != nil, true)
+	stats := db.GetQueryStats(ctx)
+	test.Fatal(t, stats.Count(), int64(3))
+	test.Fatal(t, stats.Errors(), int64(1))
+	test.Fatal(t, db.GetQueryStats(context.Background()) == nil, true)
+}
+
+func TestSlowQueryLogger(t *testing.T) {
+	connection := GetConnection(t)
+	buffer := new(bytes.Buffer)
+	redactor := db.NewArgumentRedactor("email", "password")
+	connection.AddQueryObserver(db.NewSlowQueryLogger(logger.NewDefaultLoggerWith(log.New(buffer, "", 0)), 0, redactor))
+	_, err := connection.Exec("INSERT INTO users(name, email) VALUES(?, ?)", "John Doe", "john.doe@acme.com")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Contains(buffer.String(), "Slow query"), true)
+	test.Fatal(t, strings.Contains(buffer.String(), "[John Doe [REDACTED]]"), true)
+
+	for _, fixture := range []struct {
+		Query     string
+		Arguments []interface{}
+		Expected  string
+	}{
+		{"SELECT * FROM users WHERE name = ? AND u.Email LIKE ?", []interface{}{"John Doe", "john@acme.com"}, "[John Doe [REDACTED]]"},
+		{`UPDATE users SET "password" = $2 WHERE name = $1`, []interface{}{"John Doe", "secret"}, "[John Doe [REDACTED]]"},
+		{"INSERT INTO users (email, name) VALUES (?, ?), (?, ?)", []interface{}{"john@acme.com", "John Doe", "jane@acme.com", "Jane Doe"}, "[[REDACTED] John Doe [REDACTED] Jane Doe]"},
+		{"SELECT * FROM users WHERE name = 'email = ?' AND id = ?", []interface{}{"John Doe", 1}, "[John Doe 1]"},
+		{"UPDATE users SET [password] = @p2 WHERE name = @p1", []interface{}{"John Doe", "secret"}, "[John Doe [REDACTED]]"},
+		{"SELECT * FROM users WHERE email = :1", []interface{}{"john@acme.com"}, "[[REDACTED]]"},
+	} {
+		test.Fatal(t, fmt.Sprint(redactor.Redact(fixture.Query, fixture.Arguments)), fixture.Expected)
+	}
+}
@@ -0,0 +1,360 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/db/platform"
+)
+
+// Paginator paginates the results of a select query, either by page number
+// with GetPage, or with the opaque cursors of keyset pagination with GetPageAfter.
+//
+//	paginator := db.NewPaginator(connection.CreateQueryBuilder().Select("*").From("users").OrderBy("id"), 20)
+//	users := []*User{}
+//	page, err := paginator.GetPage(2, &users) // page.Total, page.Pages
+//	page, err := paginator.GetPageAfter(request.FormValue("cursor"), &users) // page.NextCursor
+type Paginator struct {
+	queryBuilder *QueryBuilder
+	perPage      int
+}
+
+// NewPaginator returns a paginator of the results of queryBuilder, perPage being
+// the number of results per page. queryBuilder is not modified by the paginator.
+func NewPaginator(queryBuilder *QueryBuilder, perPage int) *Paginator {
+	if perPage < 1 {
+		perPage = 1
+	}
+	return &Paginator{queryBuilder: queryBuilder, perPage: perPage}
+}
+
+// Page is a page of results
+type Page struct {
+	// Number is the number of the page, starting at 1
+	Number  int
+	PerPage int
+	// Total is the number of results of the query
+	Total int
+	Pages int
+}
+
+// HasNext returns true if there is a page after the page
+func (page Page) HasNext() bool {
+	return page.Number < page.Pages
+}
+
+// HasPrevious returns true if there is a page before the page
+func (page Page) HasPrevious() bool {
+	return page.Number > 1
+}
+
+// CursorPage is a page of results of keyset pagination
+type CursorPage struct {
+	PerPage int
+	// NextCursor is the cursor of the next page, "" on the last page
+	NextCursor string
+}
+
+// HasNext returns true if there is a page after the page
+func (page CursorPage) HasNext() bool {
+	return page.NextCursor != ""
+}
+
+// GetCountQueryBuilder returns a query counting the results of the query. ORDER BY, LIMIT and locks
+// are removed from the query, queries with GROUP BY, HAVING, DISTINCT or compound queries are
+// counted as a subquery.
+func (paginator *Paginator) GetCountQueryBuilder() *QueryBuilder {
+	query := paginator.queryBuilder.Clone()
+	delete(query.sqlParts, orderBy)
+	query.isLimitquery, query.firstResult, query.maxResults = false, 0, 0
+	query.lockMode, query.skipLocked = platform.NoLock, false
+	_, hasGroupBy := query.sqlParts[groupBy]
+	_, hasHaving := query.sqlParts[having]
+	_, hasUnion := query.sqlParts[union]
+	if hasGroupBy || hasHaving || hasUnion || query.distinct {
+		count := NewQueryBuilder(query.connection).Select("COUNT(*)").FromSubquery(query, "tiger_count")
+		count.queryer = query.queryer
+		return count
+	}
+	return query.Select("COUNT(*)")
+}
+
+// Count returns the number of results of the query, arguments being
+// the values of positional placeholders
+func (paginator *Paginator) Count(arguments ...interface{}) (int, error) {
+	return paginator.CountContext(context.Background(), arguments...)
+}
+
+// CountContext returns the number of results of the query, see Count
+func (paginator *Paginator) CountContext(ctx context.Context, arguments ...interface{}) (count int, err error) {
+	err = paginator.GetCountQueryBuilder().QueryRowContext(ctx, arguments...).GetSingleResult(&count)
+	return count, err
+}
+
+// GetPage maps the results of page number to pointer, a pointer to a slice of structs or maps.
+// Pages are numbered from 1.
+func (paginator *Paginator) GetPage(number int, pointer interface{}, arguments ...interface{}) (*Page, error) {
+	return paginator.GetPageContext(context.Background(), number, pointer, arguments...)
+}
+
+// GetPageContext maps the results of page number to pointer, see GetPage
+func (paginator *Paginator) GetPageContext(ctx context.Context, number int, pointer interface{}, arguments ...interface{}) (*Page, error) {
+	if number < 1 {
+		number = 1
+	}
+	total, err := paginator.CountContext(ctx, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	err = paginator.queryBuilder.Clone().
+		SetFirstResult((number-1)*paginator.perPage).
+		SetMaxResults(paginator.perPage).
+		QueryContext(ctx, arguments...).
+		GetResultsContext(ctx, pointer)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{
+		Number:  number,
+		PerPage: paginator.perPage,
+		Total:   total,
+		Pages:   (total + paginator.perPage - 1) / paginator.perPage,
+	}, nil
+}
+
+// GetPageAfter maps the page of results following cursor to pointer, a pointer to a slice
+// of structs or maps, cursor being "" for the first page or the NextCursor of the previous page.
+//
+// Keyset pagination filters the results with the values of the ORDER BY columns of the last
+// result of the previous page instead of skipping results, so the ORDER BY columns must be
+// selected, not NULL, and must identify results, which is done by ordering by a unique column last.
+func (paginator *Paginator) GetPageAfter(cursor string, pointer interface{}, arguments ...interface{}) (*CursorPage, error) {
+	return paginator.GetPageAfterContext(context.Background(), cursor, pointer, arguments...)
+}
+
+// GetPageAfterContext maps the page of results following cursor to pointer, see GetPageAfter
+func (paginator *Paginator) GetPageAfterContext(ctx context.Context, cursor string, pointer interface{}, arguments ...interface{}) (*CursorPage, error) {
+	orderings := getOrderings(paginator.queryBuilder.sqlParts[orderBy])
+	if len(orderings) == 0 {
+		return nil, ErrOrderByRequired
+	}
+	slice := reflect.ValueOf(pointer)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return nil, ErrNotASlice
+	}
+	slice = slice.Elem()
+	query := paginator.queryBuilder.Clone().SetFirstResult(0).SetMaxResults(paginator.perPage + 1)
+	if cursor != "" {
+		values, err := decodeCursor(cursor)
+		if err != nil || len(values) != len(orderings) {
+			return nil, ErrInvalidCursor
+		}
+		condition := getKeysetCondition(orderings, values)
+		if wherePart, ok := query.sqlParts[where]; ok {
+			condition = expression.And(append(wherePart, condition)...)
+		}
+		query.Where(condition)
+	}
+	if err := query.QueryContext(ctx, arguments...).GetResultsContext(ctx, pointer); err != nil {
+		return nil, err
+	}
+	page := &CursorPage{PerPage: paginator.perPage}
+	if slice.Len() <= paginator.perPage {
+		return page, nil
+	}
+	// the extra result tells that there is a next page
+	slice.Set(slice.Slice(0, paginator.perPage))
+	values := []interface{}{}
+	for _, ordering := range orderings {
+		value, ok := getColumnValue(slice.Index(paginator.perPage-1), ordering.column)
+		if !ok {
+			return nil, ErrCursorColumnNotFound
+		}
+		values = append(values, value)
+	}
+	nextCursor, err := encodeCursor(values)
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = nextCursor
+	return page, nil
+}
+
+// ordering is a column of an ORDER BY clause
+type ordering struct {
+	column     string
+	descending bool
+}
+
+// getOrderings parses the parts of an ORDER BY clause, written as "column direction"
+func getOrderings(orderByPart []interface{}) []ordering {
+	orderings := []ordering{}
+	for _, part := range orderByPart {
+		fields := strings.Fields(expression.ToSQL(part, nil))
+		if len(fields) == 0 {
+			continue
+		}
+		result := ordering{column: strings.Join(fields, " ")}
+		if direction := strings.ToUpper(fields[len(fields)-1]); len(fields) > 1 && (direction == "ASC" || direction == "DESC") {
+			result = ordering{column: strings.Join(fields[:len(fields)-1], " "), descending: direction == "DESC"}
+		}
+		orderings = append(orderings, result)
+	}
+	return orderings
+}
+
+// getKeysetCondition returns the condition selecting the results following values in the order
+// of orderings : (a > 1) OR (a = 1 AND b > 2) ...
+func getKeysetCondition(orderings []ordering, values []interface{}) *expression.Expression {
+	alternatives := []interface{}{}
+	for i, ordering := range orderings {
+		conditions := []interface{}{}
+		for j := 0; j < i; j++ {
+			conditions = append(conditions, expression.Eq(orderings[j].column, expression.Param(values[j])))
+		}
+		if ordering.descending {
+			conditions = append(conditions, expression.Lt(ordering.column, expression.Param(values[i])))
+		} else {
+			conditions = append(conditions, expression.Gt(ordering.column, expression.Param(values[i])))
+		}
+		alternatives = append(alternatives, expression.And(conditions...))
+	}
+	return expression.Or(alternatives...)
+}
+
+// getColumnValue returns the value of column in a result mapped to a struct or a map,
+// columns qualified by a table being also looked up without the table
+func getColumnValue(result reflect.Value, column string) (interface{}, bool) {
+	column = strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(column)
+	names := []string{column}
+	if index := strings.LastIndex(column, "."); index != -1 {
+		names = append(names, column[index+1:])
+	}
+	for result.Kind() == reflect.Ptr || result.Kind() == reflect.Interface {
+		if result.IsNil() {
+			return nil, false
+		}
+		result = result.Elem()
+	}
+	for _, name := range names {
+		switch result.Kind() {
+		case reflect.Map:
+			if value := result.MapIndex(reflect.ValueOf(name)); value.IsValid() {
+				return value.Interface(), true
+			}
+		case reflect.Struct:
+			if field, ok := getStructFields(result.Type()).lookup(name); ok {
+				return getFieldValue(result, field), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// getFieldValue returns the value of field in structValue, nil if one
+// of the nested struct pointers leading to field is nil
+func getFieldValue(structValue reflect.Value, field *structField) interface{} {
+	value := structValue
+	for i, index := range field.Index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return nil
+			}
+			value = value.Elem()
+		}
+		value = value.Field(index)
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	return value.Interface()
+}
+
+// cursorTime is the encoding of a time.Time in a cursor, so that
+// times are decoded as times instead of strings
+type cursorTime struct {
+	Time time.Time `json:"time"`
+}
+
+// encodeCursor encodes the values of a cursor as base64 encoded JSON
+func encodeCursor(values []interface{}) (string, error) {
+	encodedValues := make([]interface{}, len(values))
+	for i, value := range values {
+		if valuer, ok := value.(driver.Valuer); ok {
+			var err error
+			if value, err = valuer.Value(); err != nil {
+				return "", err
+			}
+		}
+		switch value := value.(type) {
+		case time.Time:
+			encodedValues[i] = cursorTime{value}
+		case []byte:
+			encodedValues[i] = string(value)
+		default:
+			encodedValues[i] = value
+		}
+	}
+	data, err := json.Marshal(encodedValues)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes the values of a cursor encoded by encodeCursor
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	values := []interface{}{}
+	if err = decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		switch value := value.(type) {
+		case json.Number:
+			if integer, err := value.Int64(); err == nil {
+				values[i] = integer
+			} else if values[i], err = value.Float64(); err != nil {
+				return nil, err
+			}
+		case map[string]interface{}:
+			date, ok := value["time"].(string)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			if values[i], err = time.Parse(time.RFC3339Nano, date); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,97 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func userNames(users []*AppUser) string {
+	names := []string{}
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestPaginatorGetPage(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	queryBuilder := connection.CreateQueryBuilder().Select("*").From("users").
+		Where(expression.Like("email", expression.Param("%@acme.com"))).OrderBy("name")
+	paginator := db.NewPaginator(queryBuilder, 2)
+	users := []*AppUser{}
+	page, err := paginator.GetPage(1, &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, userNames(users), "Jack Doe, Jane Doe")
+	test.Fatal(t, fmt.Sprint(*page), "{1 2 3 2}")
+	test.Fatal(t, page.HasNext(), true)
+	test.Fatal(t, page.HasPrevious(), false)
+	users = []*AppUser{}
+	page, err = paginator.GetPage(2, &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, userNames(users), "John Doe")
+	test.Fatal(t, page.HasNext(), false)
+
+	// the paginated query builder is left untouched
+	test.Fatal(t, queryBuilder.String(), "SELECT * FROM users WHERE email LIKE ? ORDER BY name ASC")
+	test.Fatal(t, paginator.GetCountQueryBuilder().String(), "SELECT COUNT(*) FROM users WHERE email LIKE ?")
+
+	// grouped queries are counted as subqueries
+	paginator = db.NewPaginator(connection.CreateQueryBuilder().Select("email").From("users").GroupBy("email").OrderBy("email"), 2)
+	test.Fatal(t, paginator.GetCountQueryBuilder().String(), "SELECT COUNT(*) FROM (SELECT email FROM users GROUP BY email) tiger_count")
+	count, err := paginator.Count()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 3)
+}
+
+func TestPaginatorGetPageAfter(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	paginator := db.NewPaginator(connection.CreateQueryBuilder().Select("*").From("users").
+		Where("email LIKE :domain").SetParameter("domain", "%@acme.com").
+		OrderBy("name", "DESC").AddOrderBy("id"), 2)
+	users := []*AppUser{}
+	page, err := paginator.GetPageAfter("", &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, userNames(users), "John Doe, Jane Doe")
+	test.Fatal(t, page.HasNext(), true)
+	users = []*AppUser{}
+	page, err = paginator.GetPageAfter(page.NextCursor, &users)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, userNames(users), "Jack Doe")
+	test.Fatal(t, page.HasNext(), false)
+
+	// results can be mapped to maps
+	rows := []map[string]interface{}{}
+	page, err = paginator.GetPageAfter("", &rows)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(rows), 2)
+	rows = []map[string]interface{}{}
+	_, err = paginator.GetPageAfter(page.NextCursor, &rows)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, rows[0]["name"], "Jack Doe")
+
+	_, err = paginator.GetPageAfter("invalid", &users)
+	test.Fatal(t, err, db.ErrInvalidCursor)
+	_, err = db.NewPaginator(connection.CreateQueryBuilder().Select("*").From("users"), 2).GetPageAfter("", &users)
+	test.Fatal(t, err, db.ErrOrderByRequired)
+}
@@ -0,0 +1,113 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/db/platform"
+)
+
+// parseParameters replaces the placeholders of query, either positional placeholders written as ?
+// or named placeholders written as :name, with the placeholders of the database platform.
+// It returns the query and the names of its parameters in order, positional parameters having no name.
+// Placeholders in string literals and quoted identifiers are ignored, as well as :: casts.
+func parseParameters(query string, databasePlatform platform.DatabasePlatform) (string, []string) {
+	var buffer bytes.Buffer
+	names := []string{}
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end == -1 {
+				buffer.WriteString(query[i:])
+				return buffer.String(), names
+			}
+			buffer.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == '?':
+			names = append(names, "")
+			buffer.WriteString(databasePlatform.GetParameterPlaceholder(len(names)))
+		case c == ':' && i+1 < len(query) && isParameterNameStart(query[i+1]) && (i == 0 || query[i-1] != ':'):
+			end := i + 1
+			for end < len(query) && (isParameterNameStart(query[end]) || (query[end] >= '0' && query[end] <= '9')) {
+				end++
+			}
+			names = append(names, query[i+1:end])
+			buffer.WriteString(databasePlatform.GetParameterPlaceholder(len(names)))
+			i = end - 1
+		default:
+			buffer.WriteByte(c)
+		}
+	}
+	return buffer.String(), names
+}
+
+func isParameterNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// collectParameters collects the values of the bound parameters found in parts,
+// including the parameters of subqueries
+func collectParameters(parameters map[string]interface{}, parts ...interface{}) {
+	walkParts(func(part interface{}) {
+		switch part := part.(type) {
+		case *expression.Parameter:
+			if part.Bound {
+				parameters[part.Name] = part.Value
+			}
+		case expression.Parameter:
+			if part.Bound {
+				parameters[part.Name] = part.Value
+			}
+		case *QueryBuilder:
+			for name, value := range part.GetParameters() {
+				parameters[name] = value
+			}
+		}
+	}, parts...)
+}
+
+// walkParts calls fn with each part and the parts nested in it,
+// subqueries are passed to fn but their parts are not walked.
+func walkParts(fn func(part interface{}), parts ...interface{}) {
+	for _, part := range parts {
+		fn(part)
+		switch part := part.(type) {
+		case expression.Node:
+			walkParts(fn, part.Children()...)
+		case Join:
+			walkParts(fn, part.Conditions...)
+		case From:
+			if part.Subquery != nil {
+				fn(part.Subquery)
+			}
+		case Values:
+			walkParts(fn, part.Values()...)
+		case BulkValues:
+			for _, row := range part.Rows {
+				walkParts(fn, row...)
+			}
+		case assignment:
+			walkParts(fn, part.Value)
+		case compound:
+			fn(part.Query)
+		case commonTableExpression:
+			fn(part.Query)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package platform
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// MSSqlPlatform is the platform of SQL Server 2012 and later
+type MSSqlPlatform struct {
+	DatabasePlatform
+}
+
+func NewMSSqlPlatform(databasePlatform DatabasePlatform) *MSSqlPlatform {
+	return &MSSqlPlatform{DatabasePlatform: databasePlatform}
+}
+
+var (
+	topQueryRegexp = regexp.MustCompile(`(?is)^(\s*(?:SELECT(?:\s+DISTINCT)?|UPDATE|DELETE))\s`)
+	orderByRegexp  = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+	compoundRegexp = regexp.MustCompile(`(?i)\b(?:UNION|INTERSECT|EXCEPT)\b`)
+)
+
+// ModifyLimitQuery limits SELECT, UPDATE and DELETE statements with TOP when there is no offset,
+// other queries with OFFSET FETCH. OFFSET requires an ORDER BY clause, so queries without one
+// are ordered by (SELECT 0), which leaves the order of the rows undefined.
+// TOP would only limit the first query of a UNION, INTERSECT or EXCEPT, so compound queries
+// are limited with OFFSET FETCH, or wrapped in a derived table when they are not ordered.
+func (platform MSSqlPlatform) ModifyLimitQuery(query string, limit, offset int) string {
+	if limit <= 0 && offset <= 0 {
+		return query
+	}
+	stripped := stripNestedSQL(query)
+	if compoundRegexp.MatchString(stripped) {
+		if !orderByRegexp.MatchString(stripped) {
+			return platform.ModifyLimitQuery("SELECT * FROM ("+query+") tiger_query", limit, offset)
+		}
+		return query + fmt.Sprintf(" OFFSET %d ROWS", offset) + platform.getFetchSQL(limit)
+	}
+	if offset <= 0 && topQueryRegexp.MatchString(query) {
+		return topQueryRegexp.ReplaceAllString(query, "${1} TOP ("+strconv.Itoa(limit)+") ")
+	}
+	if !orderByRegexp.MatchString(stripped) {
+		query += " ORDER BY (SELECT 0)"
+	}
+	return query + fmt.Sprintf(" OFFSET %d ROWS", offset) + platform.getFetchSQL(limit)
+}
+
+// getFetchSQL returns the FETCH clause limiting a query to limit rows, "" if limit is 0
+func (platform MSSqlPlatform) getFetchSQL(limit int) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit)
+}
+
+func (platform MSSqlPlatform) GetListTablesSQL(database ...string) string {
+	return "SELECT TABLE_NAME AS name FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = " +
+		platform.getSchemaSQL(database...) + " AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"
+}
+
+func (platform MSSqlPlatform) GetListTableColumnsSQL(table string, database ...string) string {
+	return `SELECT c.name AS name, TYPE_NAME(c.user_type_id) AS type, c.is_nullable AS nullable,
+	OBJECT_DEFINITION(c.default_object_id) AS default_value,
+	CASE WHEN EXISTS (SELECT 1 FROM sys.indexes i JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+	WHERE i.is_primary_key = 1 AND ic.object_id = c.object_id AND ic.column_id = c.column_id) THEN 1 ELSE 0 END AS primary_key,
+	c.is_identity AS auto_increment
+	FROM sys.columns c WHERE c.object_id = ` + platform.getObjectIDSQL(table, database...) + " ORDER BY c.column_id"
+}
+
+func (platform MSSqlPlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return `SELECT i.name AS name, c.name AS column_name, i.is_unique AS is_unique
+	FROM sys.indexes i JOIN sys.index_columns ic ON ic.object_id = i.object_id AND ic.index_id = i.index_id
+	JOIN sys.columns c ON c.object_id = ic.object_id AND c.column_id = ic.column_id
+	WHERE i.object_id = ` + platform.getObjectIDSQL(table, database...) +
+		" AND i.is_primary_key = 0 AND i.type > 0 ORDER BY i.name, ic.key_ordinal"
+}
+
+func (platform MSSqlPlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	return `SELECT fk.name AS name, c.name AS column_name, OBJECT_NAME(fk.referenced_object_id) AS foreign_table, fc.name AS foreign_column,
+	REPLACE(fk.delete_referential_action_desc, '_', ' ') AS on_delete, REPLACE(fk.update_referential_action_desc, '_', ' ') AS on_update
+	FROM sys.foreign_keys fk JOIN sys.foreign_key_columns k ON k.constraint_object_id = fk.object_id
+	JOIN sys.columns c ON c.object_id = k.parent_object_id AND c.column_id = k.parent_column_id
+	JOIN sys.columns fc ON fc.object_id = k.referenced_object_id AND fc.column_id = k.referenced_column_id
+	WHERE fk.parent_object_id = ` + platform.getObjectIDSQL(table, database...) + " ORDER BY fk.name, k.constraint_column_id"
+}
+
+func (platform MSSqlPlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "NVARCHAR(%d)",
+		schema.Text:     "NVARCHAR(MAX)",
+		schema.Integer:  "INT",
+		schema.BigInt:   "BIGINT",
+		schema.Decimal:  "DECIMAL(%d,%d)",
+		schema.Float:    "FLOAT",
+		schema.Boolean:  "BIT",
+		schema.DateTime: "DATETIME2",
+		schema.JSON:     "NVARCHAR(MAX)",
+		schema.Blob:     "VARBINARY(MAX)",
+	})
+}
+
+// GetColumnDeclarationSQL declares auto incremented columns as IDENTITY columns
+func (platform MSSqlPlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	declaration := getColumnDeclarationSQL(platform, column)
+	if column.AutoIncrement {
+		declaration += " IDENTITY(1,1)"
+	}
+	return declaration
+}
+
+func (platform MSSqlPlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, false, flags...)
+}
+
+// GetAlterTableSQL adds columns with ADD, SQL Server not supporting ADD COLUMN
+func (platform MSSqlPlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	return getAlterTableSQLWithAddColumn(platform, diff, "ADD")
+}
+
+// GetChangeColumnSQL changes the type and nullability of a column with ALTER COLUMN and
+// adds its default value. Defaults are named constraints in SQL Server, changing or dropping
+// an existing default requires dropping its constraint first.
+func (platform MSSqlPlatform) GetChangeColumnSQL(table string, diff *schema.ColumnDiff) (statements []string) {
+	Type := platform.GetColumnTypeSQL(diff.To)
+	if !strings.EqualFold(platform.GetColumnTypeSQL(diff.From), Type) || diff.From.Nullable != diff.To.Nullable {
+		nullable := " NOT NULL"
+		if diff.To.Nullable {
+			nullable = " NULL"
+		}
+		statements = append(statements, "ALTER TABLE "+GetIdentifierSQL(platform, table)+" ALTER COLUMN "+GetIdentifierSQL(platform, diff.To.Name)+" "+Type+nullable)
+	}
+	if diff.From.Default != diff.To.Default && diff.To.Default != "" {
+		statements = append(statements, "ALTER TABLE "+GetIdentifierSQL(platform, table)+" ADD DEFAULT "+diff.To.Default+" FOR "+GetIdentifierSQL(platform, diff.To.Name))
+	}
+	return
+}
+
+func (platform MSSqlPlatform) GetDropIndexSQL(table string, index *schema.Index) string {
+	return "DROP INDEX " + GetIdentifierSQL(platform, index.Name) + " ON " + GetIdentifierSQL(platform, table)
+}
+
+// QuoteIdentifier quotes identifiers with brackets
+func (platform MSSqlPlatform) QuoteIdentifier(identifier string) string {
+	return strings.Join(mapStringsToStrings(strings.Split(identifier, "."), platform.QuoteSingleIdentifier), ".")
+}
+
+// QuoteSingleIdentifier quotes a single identifier with brackets, ] being escaped as ]]
+func (platform MSSqlPlatform) QuoteSingleIdentifier(identifier string) string {
+	return "[" + strings.Replace(identifier, "]", "]]", -1) + "]"
+}
+
+func (platform MSSqlPlatform) GetIdentifierQuoteCharacter() string {
+	return "["
+}
+
+// GetConcatSQL concatenates strings with CONCAT, which converts NULL values to empty strings
+func (platform MSSqlPlatform) GetConcatSQL(values ...string) string {
+	return "CONCAT(" + strings.Join(values, ", ") + ")"
+}
+
+func (platform MSSqlPlatform) GetCreateSavepointSQL(savepoint string) string {
+	return "SAVE TRANSACTION " + savepoint
+}
+
+// GetReleaseSavepointSQL returns "", SQL Server releasing savepoints when the transaction ends
+func (platform MSSqlPlatform) GetReleaseSavepointSQL(savepoint string) string {
+	return ""
+}
+
+func (platform MSSqlPlatform) GetRollbackSavepointSQL(savepoint string) string {
+	return "ROLLBACK TRANSACTION " + savepoint
+}
+
+// GetParameterPlaceholder returns @p1, @p2, ... @pn
+func (platform MSSqlPlatform) GetParameterPlaceholder(position int) string {
+	return "@p" + strconv.Itoa(position)
+}
+
+// GetMaxParameters returns 2100, the maximum number of parameters of a SQL Server request
+func (platform MSSqlPlatform) GetMaxParameters() int {
+	return 2100
+}
+
+// GetLockSQL returns "", SQL Server locking rows with table hints, see GetLockHintSQL
+func (platform MSSqlPlatform) GetLockSQL(mode LockMode, skipLocked bool) string {
+	return ""
+}
+
+// GetLockHintSQL returns WITH (UPDLOCK, ROWLOCK) for ForUpdate and WITH (HOLDLOCK, ROWLOCK)
+// for ForShare. READPAST skips locked rows, shared locks being then held with REPEATABLEREAD
+// since READPAST can't be combined with HOLDLOCK.
+func (platform MSSqlPlatform) GetLockHintSQL(mode LockMode, skipLocked bool) string {
+	hints := []string{}
+	switch mode {
+	case ForUpdate:
+		hints = append(hints, "UPDLOCK")
+	case ForShare:
+		if skipLocked {
+			hints = append(hints, "REPEATABLEREAD")
+		} else {
+			hints = append(hints, "HOLDLOCK")
+		}
+	default:
+		return ""
+	}
+	hints = append(hints, "ROWLOCK")
+	if skipLocked {
+		hints = append(hints, "READPAST")
+	}
+	return "WITH (" + strings.Join(hints, ", ") + ")"
+}
+
+// getSchemaSQL returns the schema name as a string literal,
+// or the default schema of the user if no schema is given.
+func (platform MSSqlPlatform) getSchemaSQL(database ...string) string {
+	if len(database) > 0 && database[0] != "" {
+		return platform.QuoteStringLiteral(database[0])
+	}
+	return "SCHEMA_NAME()"
+}
+
+// getObjectIDSQL returns the expression of the object id of a table
+func (platform MSSqlPlatform) getObjectIDSQL(table string, database ...string) string {
+	return "OBJECT_ID(QUOTENAME(" + platform.getSchemaSQL(database...) + ") + '.' + QUOTENAME(" + platform.QuoteStringLiteral(table) + "))"
+}
+
+// stripNestedSQL removes the string literals and the parenthesized
+// expressions of a query, leaving its top level clauses
+func stripNestedSQL(query string) string {
+	stripped := make([]byte, 0, len(query))
+	depth := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'':
+			end := strings.IndexByte(query[i+1:], '\'')
+			if end == -1 {
+				return string(stripped)
+			}
+			i += end + 1
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth == 0:
+			stripped = append(stripped, c)
+		}
+	}
+	return string(stripped)
+}
@@ -1,6 +1,11 @@
 package platform
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
 
 type MySqlPlatform struct {
 	DatabasePlatform
@@ -18,3 +23,125 @@ func (platform MySqlPlatform) ModifyLimitQuery(query string, limit, offset int)
 	query += " OFFSET " + fmt.Sprint(offset)
 	return query
 }
+
+func (platform MySqlPlatform) GetDefaultValuesSQL() string {
+	return "() VALUES ()"
+}
+
+func (platform MySqlPlatform) GetListTablesSQL(database ...string) string {
+	return "SELECT TABLE_NAME AS name FROM information_schema.TABLES WHERE TABLE_SCHEMA = " +
+		platform.getDatabaseSQL(database...) + " AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"
+}
+
+func (platform MySqlPlatform) GetListTableColumnsSQL(table string, database ...string) string {
+	return `SELECT COLUMN_NAME AS name, COLUMN_TYPE AS type, IS_NULLABLE = 'YES' AS nullable, COLUMN_DEFAULT AS default_value,
+	COLUMN_KEY = 'PRI' AS primary_key, EXTRA LIKE '%auto_increment%' AS auto_increment
+	FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ` + platform.getDatabaseSQL(database...) +
+		" AND TABLE_NAME = " + platform.QuoteStringLiteral(table) + " ORDER BY ORDINAL_POSITION"
+}
+
+func (platform MySqlPlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return "SELECT INDEX_NAME AS name, COLUMN_NAME AS column_name, NON_UNIQUE = 0 AS is_unique FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = " +
+		platform.getDatabaseSQL(database...) + " AND TABLE_NAME = " + platform.QuoteStringLiteral(table) +
+		" AND INDEX_NAME <> 'PRIMARY' ORDER BY INDEX_NAME, SEQ_IN_INDEX"
+}
+
+func (platform MySqlPlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	return `SELECT k.CONSTRAINT_NAME AS name, k.COLUMN_NAME AS column_name, k.REFERENCED_TABLE_NAME AS foreign_table,
+	k.REFERENCED_COLUMN_NAME AS foreign_column, r.DELETE_RULE AS on_delete, r.UPDATE_RULE AS on_update
+	FROM information_schema.KEY_COLUMN_USAGE k JOIN information_schema.REFERENTIAL_CONSTRAINTS r
+	ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+	WHERE k.TABLE_SCHEMA = ` + platform.getDatabaseSQL(database...) + " AND k.TABLE_NAME = " + platform.QuoteStringLiteral(table) +
+		" AND k.REFERENCED_TABLE_NAME IS NOT NULL ORDER BY k.CONSTRAINT_NAME, k.ORDINAL_POSITION"
+}
+
+// GetColumnDeclarationSQL wraps default expressions in parentheses
+func (platform MySqlPlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	declaration := getColumnDeclarationSQL(platform, wrapDefaultExpression(column))
+	if column.AutoIncrement {
+		declaration += " AUTO_INCREMENT"
+	}
+	return declaration
+}
+
+func (platform MySqlPlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "VARCHAR(%d)",
+		schema.Text:     "LONGTEXT",
+		schema.Integer:  "INT",
+		schema.BigInt:   "BIGINT",
+		schema.Decimal:  "DECIMAL(%d,%d)",
+		schema.Float:    "DOUBLE",
+		schema.Boolean:  "TINYINT(1)",
+		schema.DateTime: "DATETIME",
+		schema.JSON:     "JSON",
+		schema.Blob:     "LONGBLOB",
+	})
+}
+
+func (platform MySqlPlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, false, flags...)
+}
+
+func (platform MySqlPlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	return getAlterTableSQL(platform, diff)
+}
+
+func (platform MySqlPlatform) GetChangeColumnSQL(table string, diff *schema.ColumnDiff) []string {
+	return []string{"ALTER TABLE " + GetIdentifierSQL(platform, table) + " MODIFY COLUMN " + platform.GetColumnDeclarationSQL(diff.To)}
+}
+
+func (platform MySqlPlatform) GetDropIndexSQL(table string, index *schema.Index) string {
+	return "DROP INDEX " + GetIdentifierSQL(platform, index.Name) + " ON " + GetIdentifierSQL(platform, table)
+}
+
+func (platform MySqlPlatform) GetDropForeignKeySQL(table string, foreignKey *schema.ForeignKey) string {
+	return "ALTER TABLE " + GetIdentifierSQL(platform, table) + " DROP FOREIGN KEY " + GetIdentifierSQL(platform, foreignKey.Name)
+}
+
+// QuoteIdentifier quotes identifiers with backticks
+func (platform MySqlPlatform) QuoteIdentifier(identifier string) string {
+	return quoteIdentifier(identifier, platform.GetIdentifierQuoteCharacter())
+}
+
+func (platform MySqlPlatform) GetIdentifierQuoteCharacter() string {
+	return "`"
+}
+
+// GetConcatSQL concatenates strings with CONCAT, || being the OR operator of mysql
+func (platform MySqlPlatform) GetConcatSQL(values ...string) string {
+	return "CONCAT(" + strings.Join(values, ", ") + ")"
+}
+
+// GetJSONExtractSQL returns an unquoted JSON_EXTRACT call, like the ->> operator
+func (platform MySqlPlatform) GetJSONExtractSQL(expression, path string) string {
+	return "JSON_UNQUOTE(JSON_EXTRACT(" + expression + ", " + platform.QuoteStringLiteral(path) + "))"
+}
+
+// GetMaxParameters returns 65535, the maximum number of parameters of a prepared statement
+func (platform MySqlPlatform) GetMaxParameters() int {
+	return 65535
+}
+
+// GetUpsertSQL returns an ON DUPLICATE KEY UPDATE clause, the conflict is detected on
+// every unique index so conflictColumns are ignored. If updateColumns is empty the first
+// column is assigned to itself, leaving the conflicting row untouched.
+func (platform MySqlPlatform) GetUpsertSQL(columns, conflictColumns, updateColumns []string) string {
+	if len(updateColumns) == 0 {
+		column := GetIdentifierSQL(platform, columns[0])
+		return "ON DUPLICATE KEY UPDATE " + column + " = " + column
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(mapStringsToStrings(updateColumns, func(column string) string {
+		column = GetIdentifierSQL(platform, column)
+		return column + " = VALUES(" + column + ")"
+	}), ", ")
+}
+
+// getDatabaseSQL returns the database name as a string literal,
+// or the current database if no database is given.
+func (platform MySqlPlatform) getDatabaseSQL(database ...string) string {
+	if len(database) > 0 && database[0] != "" {
+		return platform.QuoteStringLiteral(database[0])
+	}
+	return "DATABASE()"
+}
@@ -0,0 +1,186 @@
+package platform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// OraclePlatform is the platform of Oracle Database 12c and later
+type OraclePlatform struct {
+	DatabasePlatform
+	// UseRowNum limits queries with ROWNUM rather than with FETCH FIRST,
+	// for Oracle Database versions older than 12c
+	UseRowNum bool
+}
+
+func NewOraclePlatform(databasePlatform DatabasePlatform) *OraclePlatform {
+	return &OraclePlatform{DatabasePlatform: databasePlatform}
+}
+
+// ModifyLimitQuery limits queries with OFFSET and FETCH FIRST, or with ROWNUM if UseRowNum is true.
+// ROWNUM queries with an offset select an additional tiger_rownum column.
+func (platform OraclePlatform) ModifyLimitQuery(query string, limit, offset int) string {
+	if limit <= 0 && offset <= 0 {
+		return query
+	}
+	if platform.UseRowNum {
+		if offset <= 0 {
+			return fmt.Sprintf("SELECT * FROM (%s) WHERE ROWNUM <= %d", query, limit)
+		}
+		rowNumber := "ROWNUM tiger_rownum FROM (" + query + ") tiger_query"
+		if limit > 0 {
+			rowNumber += fmt.Sprintf(" WHERE ROWNUM <= %d", offset+limit)
+		}
+		return fmt.Sprintf("SELECT * FROM (SELECT tiger_query.*, %s) WHERE tiger_rownum > %d", rowNumber, offset)
+	}
+	if offset <= 0 {
+		return fmt.Sprintf("%s FETCH FIRST %d ROWS ONLY", query, limit)
+	}
+	query += fmt.Sprintf(" OFFSET %d ROWS", offset)
+	if limit > 0 {
+		query += fmt.Sprintf(" FETCH NEXT %d ROWS ONLY", limit)
+	}
+	return query
+}
+
+// GetListTablesSQL lists the tables of a schema, database being the name of the schema
+func (platform OraclePlatform) GetListTablesSQL(database ...string) string {
+	return "SELECT table_name AS name FROM all_tables WHERE owner = " + platform.getSchemaSQL(database...) + " ORDER BY table_name"
+}
+
+func (platform OraclePlatform) GetListTableColumnsSQL(table string, database ...string) string {
+	owner := platform.getSchemaSQL(database...)
+	return `SELECT c.column_name AS name, c.data_type AS type, CASE c.nullable WHEN 'Y' THEN 1 ELSE 0 END AS nullable,
+	c.data_default AS default_value, CASE WHEN p.column_name IS NULL THEN 0 ELSE 1 END AS primary_key,
+	CASE c.identity_column WHEN 'YES' THEN 1 ELSE 0 END AS auto_increment
+	FROM all_tab_columns c LEFT JOIN (SELECT k.owner, k.table_name, kc.column_name FROM all_constraints k
+	JOIN all_cons_columns kc ON kc.owner = k.owner AND kc.constraint_name = k.constraint_name WHERE k.constraint_type = 'P') p
+	ON p.owner = c.owner AND p.table_name = c.table_name AND p.column_name = c.column_name
+	WHERE c.owner = ` + owner + " AND c.table_name = " + platform.QuoteStringLiteral(table) + " ORDER BY c.column_id"
+}
+
+func (platform OraclePlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return `SELECT i.index_name AS name, c.column_name AS column_name, CASE i.uniqueness WHEN 'UNIQUE' THEN 1 ELSE 0 END AS is_unique
+	FROM all_indexes i JOIN all_ind_columns c ON c.index_owner = i.owner AND c.index_name = i.index_name
+	WHERE i.table_owner = ` + platform.getSchemaSQL(database...) + " AND i.table_name = " + platform.QuoteStringLiteral(table) + `
+	AND NOT EXISTS (SELECT 1 FROM all_constraints k WHERE k.owner = i.table_owner AND k.constraint_type = 'P' AND k.index_name = i.index_name)
+	ORDER BY i.index_name, c.column_position`
+}
+
+// GetListTableForeignKeysSQL lists the foreign keys of a table, their on_update
+// action being NO ACTION since Oracle doesn't support ON UPDATE actions
+func (platform OraclePlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	return `SELECT k.constraint_name AS name, c.column_name AS column_name, fc.table_name AS foreign_table, fc.column_name AS foreign_column,
+	k.delete_rule AS on_delete, 'NO ACTION' AS on_update
+	FROM all_constraints k JOIN all_cons_columns c ON c.owner = k.owner AND c.constraint_name = k.constraint_name
+	JOIN all_cons_columns fc ON fc.owner = k.r_owner AND fc.constraint_name = k.r_constraint_name AND fc.position = c.position
+	WHERE k.constraint_type = 'R' AND k.owner = ` + platform.getSchemaSQL(database...) + " AND k.table_name = " + platform.QuoteStringLiteral(table) +
+		" ORDER BY k.constraint_name, c.position"
+}
+
+func (platform OraclePlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "VARCHAR2(%d)",
+		schema.Text:     "CLOB",
+		schema.Integer:  "NUMBER(10)",
+		schema.BigInt:   "NUMBER(19)",
+		schema.Decimal:  "NUMBER(%d,%d)",
+		schema.Float:    "BINARY_DOUBLE",
+		schema.Boolean:  "NUMBER(1)",
+		schema.DateTime: "TIMESTAMP",
+		schema.JSON:     "CLOB",
+		schema.Blob:     "BLOB",
+	})
+}
+
+// GetColumnDeclarationSQL declares auto incremented columns as identity columns
+func (platform OraclePlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	if column.AutoIncrement {
+		identity := *column
+		identity.Type, identity.Default = platform.GetColumnTypeSQL(column)+" GENERATED BY DEFAULT AS IDENTITY", ""
+		return getColumnDeclarationSQL(platform, &identity)
+	}
+	return getColumnDeclarationSQL(platform, column)
+}
+
+func (platform OraclePlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, false, flags...)
+}
+
+// GetAlterTableSQL adds columns with ADD, Oracle not supporting ADD COLUMN
+func (platform OraclePlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	return getAlterTableSQLWithAddColumn(platform, diff, "ADD")
+}
+
+// GetChangeColumnSQL changes a column with MODIFY, its nullability being only
+// declared when it changes since Oracle rejects a column already NOT NULL being set NOT NULL
+func (platform OraclePlatform) GetChangeColumnSQL(table string, diff *schema.ColumnDiff) []string {
+	name := GetIdentifierSQL(platform, diff.To.Name)
+	modify := name
+	if Type := platform.GetColumnTypeSQL(diff.To); !strings.EqualFold(platform.GetColumnTypeSQL(diff.From), Type) {
+		modify += " " + Type
+	}
+	if diff.From.Default != diff.To.Default {
+		if diff.To.Default == "" {
+			modify += " DEFAULT NULL"
+		} else {
+			modify += " DEFAULT " + diff.To.Default
+		}
+	}
+	if diff.From.Nullable != diff.To.Nullable {
+		if diff.To.Nullable {
+			modify += " NULL"
+		} else {
+			modify += " NOT NULL"
+		}
+	}
+	if modify == name {
+		return nil
+	}
+	return []string{"ALTER TABLE " + GetIdentifierSQL(platform, table) + " MODIFY " + modify}
+}
+
+// GetReleaseSavepointSQL returns "", Oracle releasing savepoints when the transaction ends
+func (platform OraclePlatform) GetReleaseSavepointSQL(savepoint string) string {
+	return ""
+}
+
+// GetParameterPlaceholder returns :1, :2, ... :n
+func (platform OraclePlatform) GetParameterPlaceholder(position int) string {
+	return ":" + strconv.Itoa(position)
+}
+
+// GetMaxParameters returns 65535, the maximum number of bind variables of an Oracle statement
+func (platform OraclePlatform) GetMaxParameters() int {
+	return 65535
+}
+
+// GetLockSQL returns a FOR UPDATE clause, Oracle not supporting FOR SHARE
+func (platform OraclePlatform) GetLockSQL(mode LockMode, skipLocked bool) string {
+	if mode != ForUpdate {
+		return ""
+	}
+	return platform.DatabasePlatform.GetLockSQL(mode, skipLocked)
+}
+
+// GetDefaultValuesSQL returns "", Oracle requires the value of at least one column
+func (platform OraclePlatform) GetDefaultValuesSQL() string {
+	return ""
+}
+
+// SupportsLockMode returns false for ForShare, Oracle only locking rows FOR UPDATE
+func (platform OraclePlatform) SupportsLockMode(mode LockMode) bool {
+	return mode != ForShare
+}
+
+// getSchemaSQL returns the schema name as a string literal,
+// or the current schema if no schema is given.
+func (platform OraclePlatform) getSchemaSQL(database ...string) string {
+	if len(database) > 0 && database[0] != "" {
+		return platform.QuoteStringLiteral(database[0])
+	}
+	return "SYS_CONTEXT('USERENV', 'CURRENT_SCHEMA')"
+}
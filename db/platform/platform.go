@@ -3,20 +3,99 @@ package platform
 import (
 	"fmt"
 	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
 )
 
 // DatabasePlatform is a database platform
 type DatabasePlatform interface {
 	GetListDatabaseSQL() string
 	ModifyLimitQuery(query string, maxResults int, firstResult int) string
+	// GetListTablesSQL returns a query listing the tables of a database,
+	// the name of each table is in the name column.
+	GetListTablesSQL(database ...string) string
+	// GetListTableColumnsSQL returns a query listing the columns of a table with the
+	// following columns : name, type, nullable, default_value, primary_key, auto_increment
 	GetListTableColumnsSQL(table string, database ...string) string
+	// GetListTableIndexesSQL returns a query listing the indexes of a table, except the primary key,
+	// with a row per indexed column ordered by index and position : name, column_name, is_unique
+	GetListTableIndexesSQL(table string, database ...string) string
+	// GetListTableForeignKeysSQL returns a query listing the foreign keys of a table,
+	// with a row per column ordered by foreign key and position :
+	// name, column_name, foreign_table, foreign_column, on_delete, on_update
+	GetListTableForeignKeysSQL(table string, database ...string) string
 	GetListSequencesSQL() string
+	GetPlatformOptions() PlatformOptions
+	// GetColumnTypeSQL returns the native type of a column
+	GetColumnTypeSQL(column *schema.Column) string
+	GetColumnDeclarationSQL(column *schema.Column) string
+	// GetCreateTableSQL returns the statements creating a table. flags are a combination of
+	// PlatformOptions.CreateIndexes and PlatformOptions.CreateForeignKeys, both by default.
+	GetCreateTableSQL(table *schema.Table, flags ...int) []string
+	GetDropTableSQL(table string) string
+	GetAlterTableSQL(diff *schema.TableDiff) []string
+	GetChangeColumnSQL(table string, diff *schema.ColumnDiff) []string
+	GetCreateIndexSQL(table string, index *schema.Index) string
+	GetDropIndexSQL(table string, index *schema.Index) string
+	GetCreateForeignKeySQL(table string, foreignKey *schema.ForeignKey) string
+	GetDropForeignKeySQL(table string, foreignKey *schema.ForeignKey) string
+	// SupportsSavepoints returns true if the platform supports savepoints,
+	// used to nest transactions
+	SupportsSavepoints() bool
+	GetCreateSavepointSQL(savepoint string) string
+	GetReleaseSavepointSQL(savepoint string) string
+	GetRollbackSavepointSQL(savepoint string) string
+	// GetParameterPlaceholder returns the placeholder of the parameter
+	// at position in a query, starting at 1.
+	GetParameterPlaceholder(position int) string
+	// GetMaxParameters returns the maximum number of parameters of a query
+	GetMaxParameters() int
+	// GetUpsertSQL returns the clause appended to an INSERT statement of columns that
+	// updates updateColumns of the existing row when the inserted row conflicts with it
+	// on conflictColumns. The conflicting row is left untouched if updateColumns is empty.
+	GetUpsertSQL(columns, conflictColumns, updateColumns []string) string
+	// GetLockSQL returns the clause locking the rows selected by a query, skipLocked skipping
+	// the rows locked by other transactions. It returns "" if the platform locks rows with
+	// table hints, see GetLockHintSQL, or doesn't lock rows.
+	GetLockSQL(mode LockMode, skipLocked bool) string
+	// GetLockHintSQL returns the table hint locking the rows read from a table, like
+	// WITH (UPDLOCK, ROWLOCK) on SQL Server, or "" if the platform locks rows with GetLockSQL.
+	GetLockHintSQL(mode LockMode, skipLocked bool) string
+	// SupportsLockMode returns true if the rows selected by a query can be locked with mode
+	SupportsLockMode(mode LockMode) bool
+	// SupportsReturning returns true if INSERT statements can return the inserted rows
+	SupportsReturning() bool
+	GetReturningSQL(columns []string) string
+	// GetDefaultValuesSQL returns the part of an INSERT statement inserting a row of default
+	// values, or "" if the platform can't insert a row without values.
+	GetDefaultValuesSQL() string
 	GetStringLiteralQuoteCharacter() string
 	QuoteStringLiteral(literal string) string
 	Quote(string, ...string) string
 	QuoteIdentifier(string) string
+	// IsReservedKeyword returns true if word must be quoted to be used as an identifier
+	IsReservedKeyword(word string) bool
+	// GetCaseInsensitiveLikeSQL returns a case insensitive LIKE comparison of expression and pattern
+	GetCaseInsensitiveLikeSQL(expression, pattern string) string
+	// GetConcatSQL returns the concatenation of values
+	GetConcatSQL(values ...string) string
+	// GetJSONExtractSQL returns the value at path in the JSON document expression as text,
+	// path being a JSON path like $.author.name or $.tags[0]
+	GetJSONExtractSQL(expression, path string) string
 }
 
+// LockMode is the mode of the locks of the rows selected by a query
+type LockMode int
+
+const (
+	// NoLock doesn't lock the selected rows
+	NoLock LockMode = iota
+	// ForUpdate locks the selected rows, preventing other transactions from locking or updating them
+	ForUpdate
+	// ForShare locks the selected rows, preventing other transactions from updating them
+	ForShare
+)
+
 type PlatformOptions struct {
 	CreateIndexes           int
 	CreateForeignKeys       int
@@ -64,11 +143,96 @@ func (plateform DefaultPlatform) GetListSequencesSQL() string { return "" }
 // TODO: complete
 func (platform DefaultPlatform) GetListDatabaseSQL() string { return "" }
 
+// TODO: complete
+func (platform DefaultPlatform) GetListTablesSQL(database ...string) string { return "" }
+
 // TODO: complete
 func (platform DefaultPlatform) GetListTableColumnsSQL(table string, database ...string) string {
 	return ""
 }
 
+// TODO: complete
+func (platform DefaultPlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return ""
+}
+
+// TODO: complete
+func (platform DefaultPlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	return ""
+}
+
+// GetPlatformOptions returns the options of the platform
+func (platform DefaultPlatform) GetPlatformOptions() PlatformOptions {
+	return platform.PlatformOptions
+}
+
+// GetColumnTypeSQL returns the native type of a column
+func (platform DefaultPlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "VARCHAR(%d)",
+		schema.Text:     "TEXT",
+		schema.Integer:  "INTEGER",
+		schema.BigInt:   "BIGINT",
+		schema.Decimal:  "NUMERIC(%d,%d)",
+		schema.Float:    "DOUBLE PRECISION",
+		schema.Boolean:  "BOOLEAN",
+		schema.DateTime: "TIMESTAMP",
+		schema.JSON:     "TEXT",
+		schema.Blob:     "BLOB",
+	})
+}
+
+// GetColumnDeclarationSQL returns the declaration of a column
+func (platform DefaultPlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	return getColumnDeclarationSQL(platform, column)
+}
+
+// GetCreateTableSQL returns the statements creating a table, its indexes and foreign keys
+func (platform DefaultPlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, false, flags...)
+}
+
+// GetDropTableSQL returns the statement dropping a table
+func (platform DefaultPlatform) GetDropTableSQL(table string) string {
+	return "DROP TABLE " + GetIdentifierSQL(platform, table)
+}
+
+// GetAlterTableSQL returns the statements altering a table
+func (platform DefaultPlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	return getAlterTableSQL(platform, diff)
+}
+
+// GetChangeColumnSQL returns the statements changing the definition of a column
+func (platform DefaultPlatform) GetChangeColumnSQL(table string, diff *schema.ColumnDiff) []string {
+	return getChangeColumnSQL(platform, table, diff)
+}
+
+// GetCreateIndexSQL returns the statement creating an index
+func (platform DefaultPlatform) GetCreateIndexSQL(table string, index *schema.Index) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+	return "CREATE " + unique + "INDEX " + GetIdentifierSQL(platform, index.Name) + " ON " + GetIdentifierSQL(platform, table) +
+		" (" + GetIdentifierListSQL(platform, index.Columns) + ")"
+}
+
+// GetDropIndexSQL returns the statement dropping an index
+func (platform DefaultPlatform) GetDropIndexSQL(table string, index *schema.Index) string {
+	return "DROP INDEX " + GetIdentifierSQL(platform, index.Name)
+}
+
+// GetCreateForeignKeySQL returns the statement adding a foreign key constraint to a table
+func (platform DefaultPlatform) GetCreateForeignKeySQL(table string, foreignKey *schema.ForeignKey) string {
+	return "ALTER TABLE " + GetIdentifierSQL(platform, table) + " ADD CONSTRAINT " + GetIdentifierSQL(platform, foreignKey.Name) +
+		" " + getForeignKeyDeclarationSQL(platform, foreignKey)
+}
+
+// GetDropForeignKeySQL returns the statement removing a foreign key constraint from a table
+func (platform DefaultPlatform) GetDropForeignKeySQL(table string, foreignKey *schema.ForeignKey) string {
+	return "ALTER TABLE " + GetIdentifierSQL(platform, table) + " DROP CONSTRAINT " + GetIdentifierSQL(platform, foreignKey.Name)
+}
+
 func (platform DefaultPlatform) ModifyLimitQuery(query string, limit, offset int) string {
 	if limit > 0 {
 		query += fmt.Sprintf(" LIMIT %d", limit)
@@ -89,21 +253,116 @@ func (platform DefaultPlatform) Quote(input string, inputType ...string) string
 // even if it is a reserved word of the platform. This also detects identifier
 // chains separated by dot and quotes them independently.
 func (platform DefaultPlatform) QuoteIdentifier(identifier string) string {
-	if strings.Contains(identifier, ".") {
-		parts := strings.Split(identifier, ".")
-		return strings.Join(mapStringsToStrings(parts, func(str string) string {
-			return platform.QuoteSingleIdentifier(str)
-		}), ".")
-	}
-	return platform.QuoteSingleIdentifier(identifier)
+	return quoteIdentifier(identifier, platform.GetIdentifierQuoteCharacter())
 }
+
+// QuoteSingleIdentifier quotes a single identifier, quote characters in the identifier are escaped
 func (platform DefaultPlatform) QuoteSingleIdentifier(identifier string) string {
-	c := platform.GetIdentifierQuoteCharacter()
-	return c + strings.Replace(c, c+c, identifier, -1) + c
+	return quoteSingleIdentifier(identifier, platform.GetIdentifierQuoteCharacter())
 }
 func (platform DefaultPlatform) GetIdentifierQuoteCharacter() string {
 	return `"`
 }
+
+// IsReservedKeyword returns true if word is a keyword reserved by the SQL standard
+// or one of the supported platforms
+func (platform DefaultPlatform) IsReservedKeyword(word string) bool {
+	return reservedKeywords[strings.ToUpper(word)]
+}
+
+// GetCaseInsensitiveLikeSQL compares the lower case values of expression and pattern
+func (platform DefaultPlatform) GetCaseInsensitiveLikeSQL(expression, pattern string) string {
+	return "LOWER(" + expression + ") LIKE LOWER(" + pattern + ")"
+}
+
+// GetConcatSQL concatenates strings with the || operator
+func (platform DefaultPlatform) GetConcatSQL(values ...string) string {
+	return "(" + strings.Join(values, " || ") + ")"
+}
+
+// GetJSONExtractSQL returns a JSON_VALUE call, as defined by the SQL standard
+func (platform DefaultPlatform) GetJSONExtractSQL(expression, path string) string {
+	return "JSON_VALUE(" + expression + ", " + platform.QuoteStringLiteral(path) + ")"
+}
+
+func (platform DefaultPlatform) SupportsSavepoints() bool {
+	return true
+}
+
+func (platform DefaultPlatform) GetCreateSavepointSQL(savepoint string) string {
+	return "SAVEPOINT " + savepoint
+}
+
+func (platform DefaultPlatform) GetReleaseSavepointSQL(savepoint string) string {
+	return "RELEASE SAVEPOINT " + savepoint
+}
+
+func (platform DefaultPlatform) GetRollbackSavepointSQL(savepoint string) string {
+	return "ROLLBACK TO SAVEPOINT " + savepoint
+}
+
+// GetParameterPlaceholder returns ?, the placeholder of most drivers
+func (platform DefaultPlatform) GetParameterPlaceholder(position int) string {
+	return "?"
+}
+
+// GetMaxParameters returns 999, the lowest limit of the supported drivers
+func (platform DefaultPlatform) GetMaxParameters() int {
+	return 999
+}
+
+// GetUpsertSQL returns an ON CONFLICT clause
+func (platform DefaultPlatform) GetUpsertSQL(columns, conflictColumns, updateColumns []string) string {
+	query := "ON CONFLICT"
+	if len(conflictColumns) > 0 {
+		query += " (" + GetIdentifierListSQL(platform, conflictColumns) + ")"
+	}
+	if len(updateColumns) == 0 {
+		return query + " DO NOTHING"
+	}
+	return query + " DO UPDATE SET " + strings.Join(mapStringsToStrings(updateColumns, func(column string) string {
+		column = GetIdentifierSQL(platform, column)
+		return column + " = excluded." + column
+	}), ", ")
+}
+
+// GetLockSQL returns a FOR UPDATE or FOR SHARE clause
+func (platform DefaultPlatform) GetLockSQL(mode LockMode, skipLocked bool) string {
+	query := ""
+	switch mode {
+	case ForUpdate:
+		query = "FOR UPDATE"
+	case ForShare:
+		query = "FOR SHARE"
+	default:
+		return ""
+	}
+	if skipLocked {
+		query += " SKIP LOCKED"
+	}
+	return query
+}
+
+func (platform DefaultPlatform) GetLockHintSQL(mode LockMode, skipLocked bool) string {
+	return ""
+}
+
+func (platform DefaultPlatform) SupportsLockMode(mode LockMode) bool {
+	return true
+}
+
+func (platform DefaultPlatform) SupportsReturning() bool {
+	return false
+}
+
+func (platform DefaultPlatform) GetReturningSQL(columns []string) string {
+	return "RETURNING " + strings.Join(columns, ", ")
+}
+
+func (platform DefaultPlatform) GetDefaultValuesSQL() string {
+	return "DEFAULT VALUES"
+}
+
 func (platform DefaultPlatform) GetStringLiteralQuoteCharacter() string {
 	return "'"
 }
@@ -113,3 +372,29 @@ func (platform DefaultPlatform) QuoteStringLiteral(literal string) string {
 
 	return c + strings.Replace(literal, c, c+c, -1) + c
 }
+
+// quoteIdentifier quotes each identifier of a chain of identifiers separated by dots
+func quoteIdentifier(identifier string, quote string) string {
+	return strings.Join(mapStringsToStrings(strings.Split(identifier, "."), func(str string) string {
+		return quoteSingleIdentifier(str, quote)
+	}), ".")
+}
+
+func quoteSingleIdentifier(identifier string, quote string) string {
+	return quote + strings.Replace(identifier, quote, quote+quote, -1) + quote
+}
+
+// reservedKeywords are the keywords reserved by the SQL standard, mysql, postgresql or sqlite
+// that are likely to be used as identifiers
+var reservedKeywords = map[string]bool{}
+
+func init() {
+	for _, keyword := range strings.Fields(`ALL ALTER ANALYZE AND ANY AS ASC BETWEEN BY CASE CAST CHECK COLLATE COLUMN
+	CONSTRAINT CREATE CROSS CURRENT_DATE CURRENT_TIME CURRENT_TIMESTAMP CURRENT_USER DEFAULT DELETE DESC
+	DISTINCT DROP ELSE END EXCEPT EXISTS FALSE FETCH FOR FOREIGN FROM FULL GRANT GROUP GROUPS HAVING IN INDEX
+	INNER INSERT INTERSECT INTERVAL INTO IS JOIN KEY KEYS LEFT LIKE LIMIT LOCK NATURAL NOT NULL OFFSET ON OR
+	ORDER OUTER OVER PARTITION PRIMARY RANGE RANK READ REFERENCES RIGHT ROW ROWS SELECT SET TABLE THEN TO
+	TRUE UNION UNIQUE UPDATE USER USING VALUES WHEN WHERE WINDOW WITH`) {
+		reservedKeywords[keyword] = true
+	}
+}
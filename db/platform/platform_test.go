@@ -0,0 +1,232 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package platform_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db/platform"
+	"github.com/Mparaiso/go-tiger/db/schema"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestGetColumnTypeSQL(t *testing.T) {
+	columns := []*schema.Column{
+		{Type: schema.String},
+		{Type: schema.String, Length: 50},
+		{Type: schema.Integer},
+		{Type: schema.BigInt},
+		{Type: schema.Decimal, Precision: 8, Scale: 2},
+		{Type: schema.Float},
+		{Type: schema.Boolean},
+		{Type: schema.DateTime},
+		{Type: schema.JSON},
+		{Type: schema.Blob},
+		// native types are used as is
+		{Type: "CHAR(2)"},
+	}
+	for _, fixture := range []struct {
+		Platform platform.DatabasePlatform
+		Expected string
+	}{
+		{platform.NewDefaultPlatform(), "VARCHAR(255), VARCHAR(50), INTEGER, BIGINT, NUMERIC(8,2), DOUBLE PRECISION, BOOLEAN, TIMESTAMP, TEXT, BLOB, CHAR(2)"},
+		{platform.NewSqlitePlatform(platform.NewDefaultPlatform()), "VARCHAR(255), VARCHAR(50), INTEGER, BIGINT, NUMERIC(8,2), REAL, BOOLEAN, DATETIME, TEXT, BLOB, CHAR(2)"},
+		{platform.NewMySqlPlatform(platform.NewDefaultPlatform()), "VARCHAR(255), VARCHAR(50), INT, BIGINT, DECIMAL(8,2), DOUBLE, TINYINT(1), DATETIME, JSON, LONGBLOB, CHAR(2)"},
+		{platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()), "CHARACTER VARYING(255), CHARACTER VARYING(50), INTEGER, BIGINT, NUMERIC(8,2), DOUBLE PRECISION, BOOLEAN, TIMESTAMP WITHOUT TIME ZONE, JSON, BYTEA, CHAR(2)"},
+	} {
+		types := []string{}
+		for _, column := range columns {
+			types = append(types, fixture.Platform.GetColumnTypeSQL(column))
+		}
+		test.Fatal(t, strings.Join(types, ", "), fixture.Expected)
+	}
+}
+
+func TestGetCreateTableSQLFlags(t *testing.T) {
+	table := schema.NewTable("articles").
+		AddColumn(&schema.Column{Name: "id", Type: schema.BigInt, PrimaryKey: true, AutoIncrement: true}).
+		AddColumn(&schema.Column{Name: "author_id", Type: schema.Integer}).
+		AddIndex(&schema.Index{Columns: []string{"author_id"}}).
+		AddForeignKey(&schema.ForeignKey{Columns: []string{"author_id"}, ForeignTable: "users", ForeignColumns: []string{"id"}})
+	postgresql := platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())
+	options := postgresql.GetPlatformOptions()
+	test.Fatal(t, strings.Join(postgresql.GetCreateTableSQL(table), ";\n"), strings.Join([]string{
+		"CREATE TABLE articles (id BIGSERIAL NOT NULL, author_id INTEGER NOT NULL, PRIMARY KEY (id), " +
+			"CONSTRAINT articles_author_id_fk FOREIGN KEY (author_id) REFERENCES users (id))",
+		"CREATE INDEX articles_author_id_idx ON articles (author_id)",
+	}, ";\n"))
+	test.Fatal(t, strings.Join(postgresql.GetCreateTableSQL(table, options.CreateIndexes), ";\n"), strings.Join([]string{
+		"CREATE TABLE articles (id BIGSERIAL NOT NULL, author_id INTEGER NOT NULL, PRIMARY KEY (id))",
+		"CREATE INDEX articles_author_id_idx ON articles (author_id)",
+	}, ";\n"))
+	test.Fatal(t, strings.Join(postgresql.GetCreateTableSQL(table, options.CreateForeignKeys), ";\n"),
+		"CREATE TABLE articles (id BIGSERIAL NOT NULL, author_id INTEGER NOT NULL, PRIMARY KEY (id), "+
+			"CONSTRAINT articles_author_id_fk FOREIGN KEY (author_id) REFERENCES users (id))")
+}
+
+func TestGetCreateTableSQLReservedKeywords(t *testing.T) {
+	table := schema.NewTable("order").
+		AddColumn(&schema.Column{Name: "id", Type: schema.Integer, PrimaryKey: true}).
+		AddColumn(&schema.Column{Name: "user", Type: schema.Integer}).
+		AddColumn(&schema.Column{Name: "group", Type: schema.String, Nullable: true}).
+		AddIndex(&schema.Index{Name: "order_group_idx", Columns: []string{"group"}}).
+		AddForeignKey(&schema.ForeignKey{Name: "order_user_fk", Columns: []string{"user"}, ForeignTable: "user", ForeignColumns: []string{"id"}})
+	postgresql := platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform())
+	test.Fatal(t, strings.Join(postgresql.GetCreateTableSQL(table), ";\n"), strings.Join([]string{
+		`CREATE TABLE "order" (id INTEGER NOT NULL, "user" INTEGER NOT NULL, "group" CHARACTER VARYING(255), PRIMARY KEY (id), ` +
+			`CONSTRAINT order_user_fk FOREIGN KEY ("user") REFERENCES "user" (id))`,
+		`CREATE INDEX order_group_idx ON "order" ("group")`,
+	}, ";\n"))
+	diff := &schema.TableDiff{Name: "order", AddedColumns: []*schema.Column{{Name: "select", Type: schema.Integer, Nullable: true}}}
+	test.Fatal(t, strings.Join(platform.NewMySqlPlatform(platform.NewDefaultPlatform()).GetAlterTableSQL(diff), ";\n"),
+		"ALTER TABLE `order` ADD COLUMN `select` INT")
+}
+
+func TestGetChangeColumnSQL(t *testing.T) {
+	diff := &schema.ColumnDiff{
+		From: &schema.Column{Name: "title", Type: schema.String, Length: 100},
+		To:   &schema.Column{Name: "title", Type: schema.String, Length: 200, Nullable: true, Default: "''"},
+	}
+	test.Fatal(t, strings.Join(platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()).GetChangeColumnSQL("articles", diff), ";\n"), strings.Join([]string{
+		"ALTER TABLE articles ALTER COLUMN title TYPE CHARACTER VARYING(200)",
+		"ALTER TABLE articles ALTER COLUMN title DROP NOT NULL",
+		"ALTER TABLE articles ALTER COLUMN title SET DEFAULT ''",
+	}, ";\n"))
+	test.Fatal(t, strings.Join(platform.NewMySqlPlatform(platform.NewDefaultPlatform()).GetChangeColumnSQL("articles", diff), ";\n"),
+		"ALTER TABLE articles MODIFY COLUMN title VARCHAR(200) DEFAULT ''")
+}
+
+func TestGetUpsertSQL(t *testing.T) {
+	columns := []string{"email", "name"}
+	defaultPlatform := platform.NewDefaultPlatform()
+	test.Fatal(t, platform.NewSqlitePlatform(defaultPlatform).GetUpsertSQL(columns, []string{"email"}, []string{"name"}),
+		"ON CONFLICT (email) DO UPDATE SET name = excluded.name")
+	test.Fatal(t, platform.NewPostgreSqlPlatform(defaultPlatform).GetUpsertSQL(columns, []string{"email"}, nil),
+		"ON CONFLICT (email) DO NOTHING")
+	test.Fatal(t, platform.NewMySqlPlatform(defaultPlatform).GetUpsertSQL(columns, []string{"email"}, []string{"name"}),
+		"ON DUPLICATE KEY UPDATE name = VALUES(name)")
+	test.Fatal(t, platform.NewMySqlPlatform(defaultPlatform).GetUpsertSQL(columns, []string{"email"}, nil),
+		"ON DUPLICATE KEY UPDATE email = email")
+	// reserved keywords are quoted
+	test.Fatal(t, platform.NewPostgreSqlPlatform(defaultPlatform).GetUpsertSQL([]string{"key", "order"}, []string{"key"}, []string{"order"}),
+		`ON CONFLICT ("key") DO UPDATE SET "order" = excluded."order"`)
+	test.Fatal(t, platform.NewMySqlPlatform(defaultPlatform).GetUpsertSQL([]string{"key", "order"}, nil, []string{"order"}),
+		"ON DUPLICATE KEY UPDATE `order` = VALUES(`order`)")
+	test.Fatal(t, platform.NewMySqlPlatform(defaultPlatform).GetUpsertSQL([]string{"key", "order"}, nil, nil),
+		"ON DUPLICATE KEY UPDATE `key` = `key`")
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	test.Fatal(t, platform.NewDefaultPlatform().QuoteIdentifier(`users.first"name`), `"users"."first""name"`)
+	test.Fatal(t, platform.NewMySqlPlatform(platform.NewDefaultPlatform()).QuoteIdentifier("users.order"), "`users`.`order`")
+}
+
+func TestModifyLimitQuery(t *testing.T) {
+	mssql := platform.NewMSSqlPlatform(platform.NewDefaultPlatform())
+	oracle := platform.NewOraclePlatform(platform.NewDefaultPlatform())
+	oracle11 := platform.NewOraclePlatform(platform.NewDefaultPlatform())
+	oracle11.UseRowNum = true
+	for _, fixture := range []struct {
+		Platform      platform.DatabasePlatform
+		Query         string
+		Limit, Offset int
+		Expected      string
+	}{
+		{mssql, "SELECT DISTINCT name FROM users ORDER BY name", 10, 0, "SELECT DISTINCT TOP (10) name FROM users ORDER BY name"},
+		{mssql, "DELETE FROM users WHERE id > @p1", 5, 0, "DELETE TOP (5) FROM users WHERE id > @p1"},
+		{mssql, "SELECT * FROM users ORDER BY name", 10, 20, "SELECT * FROM users ORDER BY name OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{mssql, "SELECT * FROM users WHERE id IN (SELECT user_id FROM posts ORDER BY id)", 0, 20,
+			"SELECT * FROM users WHERE id IN (SELECT user_id FROM posts ORDER BY id) ORDER BY (SELECT 0) OFFSET 20 ROWS"},
+		{mssql, "SELECT name FROM users UNION SELECT name FROM admins ORDER BY name", 10, 0,
+			"SELECT name FROM users UNION SELECT name FROM admins ORDER BY name OFFSET 0 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{mssql, "SELECT name FROM users EXCEPT SELECT name FROM admins", 10, 0,
+			"SELECT TOP (10) * FROM (SELECT name FROM users EXCEPT SELECT name FROM admins) tiger_query"},
+		{mssql, "SELECT name FROM users UNION ALL SELECT name FROM admins", 10, 20,
+			"SELECT * FROM (SELECT name FROM users UNION ALL SELECT name FROM admins) tiger_query ORDER BY (SELECT 0) OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{oracle, "SELECT * FROM users ORDER BY name", 10, 0, "SELECT * FROM users ORDER BY name FETCH FIRST 10 ROWS ONLY"},
+		{oracle, "SELECT * FROM users ORDER BY name", 10, 20, "SELECT * FROM users ORDER BY name OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{oracle11, "SELECT * FROM users", 10, 0, "SELECT * FROM (SELECT * FROM users) WHERE ROWNUM <= 10"},
+		{oracle11, "SELECT * FROM users", 10, 20,
+			"SELECT * FROM (SELECT tiger_query.*, ROWNUM tiger_rownum FROM (SELECT * FROM users) tiger_query WHERE ROWNUM <= 30) WHERE tiger_rownum > 20"},
+	} {
+		test.Fatal(t, fixture.Platform.ModifyLimitQuery(fixture.Query, fixture.Limit, fixture.Offset), fixture.Expected)
+	}
+}
+
+func TestMSSqlAndOraclePlatforms(t *testing.T) {
+	mssql := platform.NewMSSqlPlatform(platform.NewDefaultPlatform())
+	oracle := platform.NewOraclePlatform(platform.NewDefaultPlatform())
+	test.Fatal(t, mssql.QuoteIdentifier("dbo.order]s"), "[dbo].[order]]s]")
+	test.Fatal(t, mssql.GetParameterPlaceholder(2), "@p2")
+	test.Fatal(t, oracle.GetParameterPlaceholder(2), ":2")
+	test.Fatal(t, mssql.GetCreateSavepointSQL("tiger_1")+"; "+mssql.GetRollbackSavepointSQL("tiger_1"), "SAVE TRANSACTION tiger_1; ROLLBACK TRANSACTION tiger_1")
+	test.Fatal(t, oracle.GetReleaseSavepointSQL("tiger_1"), "")
+	test.Fatal(t, oracle.GetLockSQL(platform.ForShare, false), "")
+
+	table := schema.NewTable("articles").
+		AddColumn(&schema.Column{Name: "id", Type: schema.BigInt, PrimaryKey: true, AutoIncrement: true}).
+		AddColumn(&schema.Column{Name: "title", Type: schema.String, Length: 100})
+	test.Fatal(t, strings.Join(mssql.GetCreateTableSQL(table), ";\n"),
+		"CREATE TABLE articles (id BIGINT NOT NULL IDENTITY(1,1), title NVARCHAR(100) NOT NULL, PRIMARY KEY (id))")
+	test.Fatal(t, strings.Join(oracle.GetCreateTableSQL(table), ";\n"),
+		"CREATE TABLE articles (id NUMBER(19) GENERATED BY DEFAULT AS IDENTITY NOT NULL, title VARCHAR2(100) NOT NULL, PRIMARY KEY (id))")
+
+	diff := &schema.ColumnDiff{
+		From: &schema.Column{Name: "title", Type: schema.String, Length: 100},
+		To:   &schema.Column{Name: "title", Type: schema.String, Length: 200, Nullable: true, Default: "''"},
+	}
+	test.Fatal(t, strings.Join(mssql.GetChangeColumnSQL("articles", diff), ";\n"), strings.Join([]string{
+		"ALTER TABLE articles ALTER COLUMN title NVARCHAR(200) NULL",
+		"ALTER TABLE articles ADD DEFAULT '' FOR title",
+	}, ";\n"))
+	test.Fatal(t, strings.Join(oracle.GetChangeColumnSQL("articles", diff), ";\n"),
+		"ALTER TABLE articles MODIFY title VARCHAR2(200) DEFAULT '' NULL")
+	test.Fatal(t, strings.Join(mssql.GetAlterTableSQL(&schema.TableDiff{Name: "articles", AddedColumns: []*schema.Column{{Name: "body", Type: schema.Text, Nullable: true}}}), ";\n"),
+		"ALTER TABLE articles ADD body NVARCHAR(MAX)")
+}
+
+type customPlatform struct {
+	platform.DatabasePlatform
+}
+
+func TestRegister(t *testing.T) {
+	test.Fatal(t, fmt.Sprintf("%T", platform.GetPlatform("sqlserver")), "*platform.MSSqlPlatform")
+	test.Fatal(t, fmt.Sprintf("%T", platform.GetPlatform("godror")), "*platform.OraclePlatform")
+	test.Fatal(t, fmt.Sprintf("%T", platform.GetPlatform("unknown")), "*platform.DefaultPlatform")
+	platform.Register("custom", func(databasePlatform platform.DatabasePlatform) platform.DatabasePlatform {
+		return customPlatform{databasePlatform}
+	})
+	test.Fatal(t, fmt.Sprintf("%T", platform.GetPlatform("custom")), "platform_test.customPlatform")
+}
+
+func TestGetJSONExtractSQL(t *testing.T) {
+	for _, fixture := range []struct {
+		Platform platform.DatabasePlatform
+		Expected string
+	}{
+		{platform.NewDefaultPlatform(), "JSON_VALUE(data, '$.author.name')"},
+		{platform.NewSqlitePlatform(platform.NewDefaultPlatform()), "JSON_EXTRACT(data, '$.author.name')"},
+		{platform.NewMySqlPlatform(platform.NewDefaultPlatform()), "JSON_UNQUOTE(JSON_EXTRACT(data, '$.author.name'))"},
+		{platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()), "(data #>> '{author,name}')"},
+	} {
+		test.Fatal(t, fixture.Platform.GetJSONExtractSQL("data", "$.author.name"), fixture.Expected)
+	}
+	test.Fatal(t, platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()).GetJSONExtractSQL("data", `$.tags[0]."first name"`),
+		`(data #>> '{tags,0,"first name"}')`)
+	test.Fatal(t, platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()).GetColumnTypeSQL(&schema.Column{Type: schema.String + "[]"}),
+		"CHARACTER VARYING(255)[]")
+}
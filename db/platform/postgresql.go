@@ -1,5 +1,12 @@
 package platform
 
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
 type PostgreSqlPlatform struct {
 	DatabasePlatform
 }
@@ -7,3 +14,163 @@ type PostgreSqlPlatform struct {
 func NewPostgreSqlPlatform(databasePlatform DatabasePlatform) *PostgreSqlPlatform {
 	return &PostgreSqlPlatform{DatabasePlatform: databasePlatform}
 }
+
+// GetListTablesSQL lists the tables of a schema, database being the name of the schema
+func (platform PostgreSqlPlatform) GetListTablesSQL(database ...string) string {
+	return "SELECT table_name AS name FROM information_schema.tables WHERE table_schema = " +
+		platform.getSchemaSQL(database...) + " AND table_type = 'BASE TABLE' ORDER BY table_name"
+}
+
+func (platform PostgreSqlPlatform) GetListTableColumnsSQL(table string, database ...string) string {
+	return `SELECT a.attname AS name, format_type(a.atttypid, a.atttypmod) AS type, NOT a.attnotnull AS nullable,
+	CASE WHEN pg_get_expr(d.adbin, d.adrelid) LIKE 'nextval(%' THEN NULL ELSE pg_get_expr(d.adbin, d.adrelid) END AS default_value, COALESCE(i.indisprimary, false) AS primary_key,
+	COALESCE(pg_get_expr(d.adbin, d.adrelid) LIKE 'nextval(%', false) AS auto_increment
+	FROM pg_attribute a JOIN pg_class t ON t.oid = a.attrelid JOIN pg_namespace n ON n.oid = t.relnamespace
+	LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	LEFT JOIN pg_index i ON i.indrelid = a.attrelid AND i.indisprimary AND a.attnum = ANY(i.indkey)
+	WHERE t.relname = ` + platform.QuoteStringLiteral(table) + " AND n.nspname = " + platform.getSchemaSQL(database...) +
+		" AND a.attnum > 0 AND NOT a.attisdropped ORDER BY a.attnum"
+}
+
+func (platform PostgreSqlPlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return `SELECT i.relname AS name, a.attname AS column_name, ix.indisunique AS is_unique
+	FROM pg_index ix JOIN pg_class t ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, position) ON true
+	JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+	WHERE t.relname = ` + platform.QuoteStringLiteral(table) + " AND n.nspname = " + platform.getSchemaSQL(database...) +
+		" AND NOT ix.indisprimary ORDER BY i.relname, k.position"
+}
+
+func (platform PostgreSqlPlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	action := func(column string) string {
+		return "CASE " + column + " WHEN 'r' THEN 'RESTRICT' WHEN 'c' THEN 'CASCADE' WHEN 'n' THEN 'SET NULL' WHEN 'd' THEN 'SET DEFAULT' ELSE 'NO ACTION' END"
+	}
+	return `SELECT c.conname AS name, a.attname AS column_name, ft.relname AS foreign_table, fa.attname AS foreign_column,
+	` + action("c.confdeltype") + ` AS on_delete, ` + action("c.confupdtype") + ` AS on_update
+	FROM pg_constraint c JOIN pg_class t ON t.oid = c.conrelid JOIN pg_namespace n ON n.oid = t.relnamespace
+	JOIN pg_class ft ON ft.oid = c.confrelid
+	JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, foreign_attnum, position) ON true
+	JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+	JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = k.foreign_attnum
+	WHERE c.contype = 'f' AND t.relname = ` + platform.QuoteStringLiteral(table) + " AND n.nspname = " + platform.getSchemaSQL(database...) +
+		" ORDER BY c.conname, k.position"
+}
+
+// GetColumnTypeSQL returns native types as spelled by format_type, so that
+// introspected types match the types of the columns they were created from.
+func (platform PostgreSqlPlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "CHARACTER VARYING(%d)",
+		schema.Text:     "TEXT",
+		schema.Integer:  "INTEGER",
+		schema.BigInt:   "BIGINT",
+		schema.Decimal:  "NUMERIC(%d,%d)",
+		schema.Float:    "DOUBLE PRECISION",
+		schema.Boolean:  "BOOLEAN",
+		schema.DateTime: "TIMESTAMP WITHOUT TIME ZONE",
+		schema.JSON:     "JSON",
+		schema.Blob:     "BYTEA",
+	})
+}
+
+// GetColumnDeclarationSQL declares auto incremented columns as SERIAL or BIGSERIAL
+func (platform PostgreSqlPlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	if column.AutoIncrement {
+		serial := *column
+		serial.Type, serial.Default = "SERIAL", ""
+		if strings.Contains(strings.ToUpper(platform.GetColumnTypeSQL(column)), "BIGINT") {
+			serial.Type = "BIGSERIAL"
+		}
+		return getColumnDeclarationSQL(platform, &serial)
+	}
+	return getColumnDeclarationSQL(platform, column)
+}
+
+func (platform PostgreSqlPlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, false, flags...)
+}
+
+func (platform PostgreSqlPlatform) GetChangeColumnSQL(table string, diff *schema.ColumnDiff) []string {
+	return getChangeColumnSQL(platform, table, diff)
+}
+
+func (platform PostgreSqlPlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	return getAlterTableSQL(platform, diff)
+}
+
+// GetParameterPlaceholder returns $1, $2, ... $n
+func (platform PostgreSqlPlatform) GetParameterPlaceholder(position int) string {
+	return "$" + strconv.Itoa(position)
+}
+
+// GetCaseInsensitiveLikeSQL returns an ILIKE comparison
+func (platform PostgreSqlPlatform) GetCaseInsensitiveLikeSQL(expression, pattern string) string {
+	return expression + " ILIKE " + pattern
+}
+
+// GetJSONExtractSQL returns a #>> operation, the JSON path being converted to an array of keys and indexes
+func (platform PostgreSqlPlatform) GetJSONExtractSQL(expression, path string) string {
+	elements := mapStringsToStrings(parseJSONPath(path), func(element string) string {
+		if element == "" || strings.EqualFold(element, "NULL") || strings.ContainsAny(element, "{},\"\\ \t\n") {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(element) + `"`
+		}
+		return element
+	})
+	return "(" + expression + " #>> " + platform.QuoteStringLiteral("{"+strings.Join(elements, ",")+"}") + ")"
+}
+
+// GetMaxParameters returns 65535, the maximum number of parameters of the postgresql protocol
+func (platform PostgreSqlPlatform) GetMaxParameters() int {
+	return 65535
+}
+
+func (platform PostgreSqlPlatform) SupportsReturning() bool {
+	return true
+}
+
+// getSchemaSQL returns the schema name as a string literal,
+// or the current schema if no schema is given.
+func (platform PostgreSqlPlatform) getSchemaSQL(database ...string) string {
+	if len(database) > 0 && database[0] != "" {
+		return platform.QuoteStringLiteral(database[0])
+	}
+	return "current_schema()"
+}
+
+// parseJSONPath returns the keys and array indexes of a JSON path like $.author."first name" or $.tags[0]
+func parseJSONPath(path string) (elements []string) {
+	path = strings.TrimPrefix(strings.TrimSpace(path), "$")
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			if strings.HasPrefix(path, `"`) {
+				end := strings.Index(path[1:], `"`)
+				if end == -1 {
+					return append(elements, path[1:])
+				}
+				elements, path = append(elements, path[1:end+1]), path[end+2:]
+				continue
+			}
+			end := strings.IndexAny(path, ".[")
+			if end == -1 {
+				end = len(path)
+			}
+			elements, path = append(elements, path[:end]), path[end:]
+		case '[':
+			end := strings.Index(path, "]")
+			if end == -1 {
+				return append(elements, path[1:])
+			}
+			elements, path = append(elements, strings.TrimSpace(path[1:end])), path[end+1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end == -1 {
+				end = len(path)
+			}
+			elements, path = append(elements, path[:end]), path[end:]
+		}
+	}
+	return elements
+}
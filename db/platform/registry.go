@@ -0,0 +1,63 @@
+package platform
+
+import "sync"
+
+// Factory returns the platform of a driver, databasePlatform being
+// the default platform the returned platform may extend
+type Factory func(databasePlatform DatabasePlatform) DatabasePlatform
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Factory{}
+)
+
+// Register registers the factory of the platform of the databases opened with
+// the driver registered as driverName in database/sql, replacing any factory
+// previously registered for driverName.
+//
+//	platform.Register("mydriver", func(databasePlatform platform.DatabasePlatform) platform.DatabasePlatform {
+//		return NewMyPlatform(databasePlatform)
+//	})
+func Register(driverName string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[driverName] = factory
+}
+
+// GetPlatform returns the platform registered for driverName,
+// the default platform if no platform was registered
+func GetPlatform(driverName string) DatabasePlatform {
+	registryMutex.RLock()
+	factory, ok := registry[driverName]
+	registryMutex.RUnlock()
+	if !ok {
+		return NewDefaultPlatform()
+	}
+	return factory(NewDefaultPlatform())
+}
+
+func init() {
+	for _, driverName := range []string{"sqlite3", "sqlite"} {
+		Register(driverName, func(databasePlatform DatabasePlatform) DatabasePlatform {
+			return NewSqlitePlatform(databasePlatform)
+		})
+	}
+	Register("mysql", func(databasePlatform DatabasePlatform) DatabasePlatform {
+		return NewMySqlPlatform(databasePlatform)
+	})
+	for _, driverName := range []string{"postgresql", "postgres", "pgx"} {
+		Register(driverName, func(databasePlatform DatabasePlatform) DatabasePlatform {
+			return NewPostgreSqlPlatform(databasePlatform)
+		})
+	}
+	for _, driverName := range []string{"sqlserver", "mssql", "azuresql"} {
+		Register(driverName, func(databasePlatform DatabasePlatform) DatabasePlatform {
+			return NewMSSqlPlatform(databasePlatform)
+		})
+	}
+	for _, driverName := range []string{"oracle", "godror", "oci8"} {
+		Register(driverName, func(databasePlatform DatabasePlatform) DatabasePlatform {
+			return NewOraclePlatform(databasePlatform)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// The following helpers generate DDL statements on behalf of a platform.
+// Platforms decorate each other, so a helper takes the outermost platform
+// as an argument in order to call the methods the decorator overrides.
+
+var identifierChain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// GetIdentifierSQL returns a table, column, index or constraint name of a statement,
+// quoting the identifiers of the name that are reserved keywords as queries do
+func GetIdentifierSQL(platform DatabasePlatform, name string) string {
+	if !identifierChain.MatchString(name) {
+		return name
+	}
+	identifiers := strings.Split(name, ".")
+	for i, identifier := range identifiers {
+		if platform.IsReservedKeyword(identifier) {
+			identifiers[i] = platform.QuoteIdentifier(identifier)
+		}
+	}
+	return strings.Join(identifiers, ".")
+}
+
+// GetIdentifierListSQL returns names separated by commas, see GetIdentifierSQL
+func GetIdentifierListSQL(platform DatabasePlatform, names []string) string {
+	identifiers := make([]string, len(names))
+	for i, name := range names {
+		identifiers[i] = GetIdentifierSQL(platform, name)
+	}
+	return strings.Join(identifiers, ", ")
+}
+
+// getColumnTypeSQL returns the native type of a column given the native types of portable types.
+// Native types of strings are formatted with their length, those of decimals with their precision and scale.
+// Portable types are case sensitive, so that a native type like INTEGER is used as is.
+// Portable types suffixed with [] are arrays of the portable type, like string[] for VARCHAR(255)[].
+func getColumnTypeSQL(column *schema.Column, types map[string]string) string {
+	if strings.HasSuffix(column.Type, "[]") {
+		element := *column
+		element.Type = strings.TrimSuffix(column.Type, "[]")
+		return getColumnTypeSQL(&element, types) + "[]"
+	}
+	nativeType, ok := types[column.Type]
+	if !ok {
+		return column.Type
+	}
+	switch column.Type {
+	case schema.String:
+		length := column.Length
+		if length == 0 {
+			length = 255
+		}
+		return fmt.Sprintf(nativeType, length)
+	case schema.Decimal:
+		precision := column.Precision
+		if precision == 0 {
+			precision = 10
+		}
+		return fmt.Sprintf(nativeType, precision, column.Scale)
+	}
+	return nativeType
+}
+
+// getColumnDeclarationSQL returns the declaration of a column
+// in a CREATE TABLE or an ALTER TABLE statement
+func getColumnDeclarationSQL(platform DatabasePlatform, column *schema.Column) string {
+	declaration := GetIdentifierSQL(platform, column.Name) + " " + platform.GetColumnTypeSQL(column)
+	if !column.Nullable {
+		declaration += " NOT NULL"
+	}
+	if column.Default != "" {
+		declaration += " DEFAULT " + column.Default
+	}
+	return declaration
+}
+
+// getCreateTableSQL returns the statements creating a table, with its indexes and foreign keys
+// depending on flags. if inlinePrimaryKey is true, the primary key is expected to be declared
+// along with its column.
+func getCreateTableSQL(platform DatabasePlatform, table *schema.Table, inlinePrimaryKey bool, flags ...int) []string {
+	options := platform.GetPlatformOptions()
+	flag := options.CreateIndexes | options.CreateForeignKeys
+	if len(flags) > 0 {
+		flag = 0
+		for _, f := range flags {
+			flag |= f
+		}
+	}
+	definitions := []string{}
+	for _, column := range table.Columns {
+		definitions = append(definitions, platform.GetColumnDeclarationSQL(column))
+	}
+	if primaryKey := table.GetPrimaryKey(); len(primaryKey) > 0 && !inlinePrimaryKey {
+		definitions = append(definitions, "PRIMARY KEY ("+GetIdentifierListSQL(platform, primaryKey)+")")
+	}
+	if flag&options.CreateForeignKeys != 0 {
+		for _, foreignKey := range table.ForeignKeys {
+			definitions = append(definitions, "CONSTRAINT "+GetIdentifierSQL(platform, foreignKey.Name)+" "+getForeignKeyDeclarationSQL(platform, foreignKey))
+		}
+	}
+	statements := []string{"CREATE TABLE " + GetIdentifierSQL(platform, table.Name) + " (" + strings.Join(definitions, ", ") + ")"}
+	if flag&options.CreateIndexes != 0 {
+		for _, index := range table.Indexes {
+			statements = append(statements, platform.GetCreateIndexSQL(table.Name, index))
+		}
+	}
+	return statements
+}
+
+// getAlterTableSQL returns the statements altering a table.
+// Foreign keys and indexes are dropped first and created last,
+// since they may depend on altered columns.
+func getAlterTableSQL(platform DatabasePlatform, diff *schema.TableDiff) []string {
+	return getAlterTableSQLWithAddColumn(platform, diff, "ADD COLUMN")
+}
+
+// getAlterTableSQLWithAddColumn returns the statements altering a table, columns
+// being added with the addColumn clause of the platform
+func getAlterTableSQLWithAddColumn(platform DatabasePlatform, diff *schema.TableDiff, addColumn string) (statements []string) {
+	for _, foreignKey := range diff.RemovedForeignKeys {
+		statements = append(statements, platform.GetDropForeignKeySQL(diff.Name, foreignKey))
+	}
+	for _, index := range diff.RemovedIndexes {
+		statements = append(statements, platform.GetDropIndexSQL(diff.Name, index))
+	}
+	for _, column := range diff.RemovedColumns {
+		statements = append(statements, "ALTER TABLE "+GetIdentifierSQL(platform, diff.Name)+" DROP COLUMN "+GetIdentifierSQL(platform, column.Name))
+	}
+	for _, column := range diff.AddedColumns {
+		statements = append(statements, "ALTER TABLE "+GetIdentifierSQL(platform, diff.Name)+" "+addColumn+" "+platform.GetColumnDeclarationSQL(column))
+	}
+	for _, columnDiff := range diff.ChangedColumns {
+		statements = append(statements, platform.GetChangeColumnSQL(diff.Name, columnDiff)...)
+	}
+	for _, index := range diff.AddedIndexes {
+		statements = append(statements, platform.GetCreateIndexSQL(diff.Name, index))
+	}
+	for _, foreignKey := range diff.AddedForeignKeys {
+		statements = append(statements, platform.GetCreateForeignKeySQL(diff.Name, foreignKey))
+	}
+	return
+}
+
+// getChangeColumnSQL returns the ALTER COLUMN statements changing the definition of a column
+func getChangeColumnSQL(platform DatabasePlatform, table string, diff *schema.ColumnDiff) (statements []string) {
+	alter := "ALTER TABLE " + GetIdentifierSQL(platform, table) + " ALTER COLUMN " + GetIdentifierSQL(platform, diff.To.Name)
+	if Type := platform.GetColumnTypeSQL(diff.To); !strings.EqualFold(platform.GetColumnTypeSQL(diff.From), Type) {
+		statements = append(statements, alter+" TYPE "+Type)
+	}
+	if diff.From.Nullable != diff.To.Nullable {
+		if diff.To.Nullable {
+			statements = append(statements, alter+" DROP NOT NULL")
+		} else {
+			statements = append(statements, alter+" SET NOT NULL")
+		}
+	}
+	if diff.From.Default != diff.To.Default {
+		if diff.To.Default == "" {
+			statements = append(statements, alter+" DROP DEFAULT")
+		} else {
+			statements = append(statements, alter+" SET DEFAULT "+diff.To.Default)
+		}
+	}
+	return
+}
+
+// getForeignKeyDeclarationSQL returns the definition of a foreign key constraint
+func getForeignKeyDeclarationSQL(platform DatabasePlatform, foreignKey *schema.ForeignKey) string {
+	declaration := "FOREIGN KEY (" + GetIdentifierListSQL(platform, foreignKey.Columns) + ") REFERENCES " +
+		GetIdentifierSQL(platform, foreignKey.ForeignTable) + " (" + GetIdentifierListSQL(platform, foreignKey.ForeignColumns) + ")"
+	if foreignKey.OnDelete != "" {
+		declaration += " ON DELETE " + foreignKey.OnDelete
+	}
+	if foreignKey.OnUpdate != "" {
+		declaration += " ON UPDATE " + foreignKey.OnUpdate
+	}
+	return declaration
+}
+
+// wrapDefaultExpression wraps default values that are not literals in parentheses,
+// as required by some platforms for expressions like DATETIME('now')
+func wrapDefaultExpression(column *schema.Column) *schema.Column {
+	value := strings.TrimSpace(column.Default)
+	if value == "" || strings.HasPrefix(value, "(") || strings.HasPrefix(value, "'") || !strings.Contains(value, "(") {
+		return column
+	}
+	result := *column
+	result.Default = "(" + value + ")"
+	return &result
+}
+
+// hasAutoIncrement returns true if a column of the table is auto incremented
+func hasAutoIncrement(table *schema.Table) bool {
+	for _, column := range table.Columns {
+		if column.AutoIncrement {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,11 @@
 package platform
 
-import "fmt"
-import "strings"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
 
 type SqlitePlatform struct {
 	DatabasePlatform
@@ -11,17 +15,145 @@ func NewSqlitePlatform(databasePlatform DatabasePlatform) *SqlitePlatform {
 	return &SqlitePlatform{DatabasePlatform: databasePlatform}
 }
 
+func (platform SqlitePlatform) GetListTablesSQL(database ...string) string {
+	return `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`
+}
+
 func (platform SqlitePlatform) GetListTableColumnsSQL(table string, database ...string) string {
-	table = strings.Replace(".", "__", table, -1)
 	table = platform.QuoteStringLiteral(table)
-	return "PRAGMA table_info($table)"
+	return `SELECT name, type, "notnull" = 0 AND pk = 0 AS nullable, dflt_value AS default_value, pk > 0 AS primary_key,
+	pk > 0 AND upper(type) = 'INTEGER' AND EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ` + table + ` AND upper(sql) LIKE '%AUTOINCREMENT%') AS auto_increment
+	FROM pragma_table_info(` + table + `) ORDER BY cid`
+}
+
+func (platform SqlitePlatform) GetListTableIndexesSQL(table string, database ...string) string {
+	return `SELECT il.name AS name, ii.name AS column_name, il."unique" AS is_unique
+	FROM pragma_index_list(` + platform.QuoteStringLiteral(table) + `) AS il, pragma_index_info(il.name) AS ii
+	WHERE il.origin <> 'pk' ORDER BY il.name, ii.seqno`
+}
+
+// GetListTableForeignKeysSQL lists foreign keys, sqlite does not keep
+// the names of foreign key constraints so names are generated.
+func (platform SqlitePlatform) GetListTableForeignKeysSQL(table string, database ...string) string {
+	return `SELECT 'fk_' || id AS name, "from" AS column_name, "table" AS foreign_table, "to" AS foreign_column, on_delete, on_update
+	FROM pragma_foreign_key_list(` + platform.QuoteStringLiteral(table) + `) ORDER BY id, seq`
+}
+
+// GetColumnDeclarationSQL declares auto incremented columns as INTEGER PRIMARY KEY AUTOINCREMENT,
+// the only way to get an auto incremented column with sqlite, default expressions
+// are wrapped in parentheses.
+func (platform SqlitePlatform) GetColumnDeclarationSQL(column *schema.Column) string {
+	if column.AutoIncrement {
+		return column.Name + " INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	return getColumnDeclarationSQL(platform, wrapDefaultExpression(column))
+}
+
+func (platform SqlitePlatform) GetColumnTypeSQL(column *schema.Column) string {
+	return getColumnTypeSQL(column, map[string]string{
+		schema.String:   "VARCHAR(%d)",
+		schema.Text:     "TEXT",
+		schema.Integer:  "INTEGER",
+		schema.BigInt:   "BIGINT",
+		schema.Decimal:  "NUMERIC(%d,%d)",
+		schema.Float:    "REAL",
+		schema.Boolean:  "BOOLEAN",
+		schema.DateTime: "DATETIME",
+		schema.JSON:     "TEXT",
+		schema.Blob:     "BLOB",
+	})
+}
+
+func (platform SqlitePlatform) GetCreateTableSQL(table *schema.Table, flags ...int) []string {
+	return getCreateTableSQL(platform, table, hasAutoIncrement(table), flags...)
+}
+
+// GetAlterTableSQL alters a table. Since sqlite can only add columns or rename tables,
+// other changes are made by copying the table into a new table, which is then renamed.
+// Foreign key enforcement should be disabled while a table is rebuilt.
+// @see https://www.sqlite.org/lang_altertable.html#otheralter
+func (platform SqlitePlatform) GetAlterTableSQL(diff *schema.TableDiff) []string {
+	if !platform.requiresRebuild(diff) {
+		return getAlterTableSQL(platform, diff)
+	}
+	temporary := *diff.To
+	temporary.Name = "__temp__" + diff.To.Name
+	temporary.Indexes = nil
+	statements := platform.GetCreateTableSQL(&temporary)
+	columns := []string{}
+	for _, column := range diff.To.Columns {
+		if _, ok := diff.From.GetColumn(column.Name); ok {
+			columns = append(columns, column.Name)
+		}
+	}
+	columnList := GetIdentifierListSQL(platform, columns)
+	statements = append(statements,
+		"INSERT INTO "+GetIdentifierSQL(platform, temporary.Name)+" ("+columnList+") SELECT "+columnList+" FROM "+GetIdentifierSQL(platform, diff.From.Name),
+		platform.GetDropTableSQL(diff.From.Name),
+		"ALTER TABLE "+GetIdentifierSQL(platform, temporary.Name)+" RENAME TO "+GetIdentifierSQL(platform, diff.To.Name),
+	)
+	for _, index := range diff.To.Indexes {
+		if isSqliteAutoIndex(index) {
+			// indexes created by sqlite for UNIQUE constraints can't be created by name
+			index = &schema.Index{Columns: index.Columns, Unique: index.Unique}
+			schema.NewTable(diff.To.Name).AddIndex(index)
+		}
+		statements = append(statements, platform.GetCreateIndexSQL(diff.To.Name, index))
+	}
+	return statements
+}
+
+// requiresRebuild returns true if the changes can't be made with ALTER TABLE statements
+func (platform SqlitePlatform) requiresRebuild(diff *schema.TableDiff) bool {
+	if len(diff.ChangedColumns) > 0 || len(diff.RemovedColumns) > 0 ||
+		len(diff.AddedForeignKeys) > 0 || len(diff.RemovedForeignKeys) > 0 {
+		return true
+	}
+	for _, column := range diff.AddedColumns {
+		if column.PrimaryKey || column.AutoIncrement || (!column.Nullable && column.Default == "") {
+			return true
+		}
+	}
+	for _, index := range diff.RemovedIndexes {
+		if isSqliteAutoIndex(index) {
+			return true
+		}
+	}
+	return false
 }
 
 func (platform SqlitePlatform) ModifyLimitQuery(query string, limit, offset int) string {
 	if limit == 0 {
-		return query + " LIMIT -1 " + fmt.Sprint(offset)
+		return query + " LIMIT -1 OFFSET " + fmt.Sprint(offset)
 	}
 	query += " LIMIT " + fmt.Sprint(limit)
 	query += " OFFSET " + fmt.Sprint(offset)
 	return query
 }
+
+// GetLockSQL returns "", sqlite locks the whole database instead of rows
+func (platform SqlitePlatform) GetLockSQL(mode LockMode, skipLocked bool) string {
+	return ""
+}
+
+// SupportsLockMode returns true, locks being ignored since a sqlite transaction
+// writing to the database prevents other transactions from writing to it
+func (platform SqlitePlatform) SupportsLockMode(mode LockMode) bool {
+	return true
+}
+
+// SupportsReturning returns true, RETURNING is supported since sqlite 3.35
+func (platform SqlitePlatform) SupportsReturning() bool {
+	return true
+}
+
+// isSqliteAutoIndex returns true if index was created by sqlite
+// for a UNIQUE constraint
+func isSqliteAutoIndex(index *schema.Index) bool {
+	return strings.HasPrefix(strings.ToLower(index.Name), "sqlite_autoindex_")
+}
+
+// GetJSONExtractSQL returns a JSON_EXTRACT call, which returns JSON strings as text
+func (platform SqlitePlatform) GetJSONExtractSQL(expression, path string) string {
+	return "JSON_EXTRACT(" + expression + ", " + platform.QuoteStringLiteral(path) + ")"
+}
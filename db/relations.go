@@ -0,0 +1,629 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/tag"
+)
+
+// relation defines a relationship between the source entity
+// and a related entity
+type relation struct {
+	// relation is the type of relation.
+	// it can either be manyToOne, oneToMany or manyToMany
+	relation relationType
+
+	// targetEntity is the table of the related entity
+	targetEntity string
+
+	// joinColumn is the foreign key column. For manyToOne it
+	// belongs to the source table, for manyToMany to the join table
+	// and references the source table.
+	joinColumn string
+
+	// inverseJoinColumn is the column of the join table
+	// referencing the target table in a manyToMany relation
+	inverseJoinColumn string
+
+	// joinTable is the table linking both sides of a manyToMany relation
+	joinTable string
+
+	// mappedBy is the field on the related entity which owns
+	// the relation. The inverse side of a relation never writes it.
+	mappedBy string
+
+	// cascade sets how related entities are automatically persisted or removed
+	// it can be either all, persist or remove
+	cascade cascade
+
+	// load is how the relation is loaded. Eager relations are loaded
+	// in batch as soon as entities are fetched, lazy relations
+	// are only loaded with EntityManager.ResolveRelations
+	// load defaults to lazy
+	load load
+}
+
+func (r relation) String() string {
+	if r == (relation{}) {
+		return "{}"
+	}
+	return fmt.Sprintf("{ relation: '%s', targetEntity: '%s', joinColumn: '%s', joinTable: '%s', inverseJoinColumn: '%s', mappedBy: '%s', cascade: '%v', load: '%v' }",
+		r.relation, r.targetEntity, r.joinColumn, r.joinTable, r.inverseJoinColumn, r.mappedBy, r.cascade, r.load)
+}
+
+// isOwningSide returns true if the relation is written
+// when the source entity is flushed
+func (r relation) isOwningSide() bool {
+	return r.mappedBy == "" && (r.relation == manyToOne || r.relation == manyToMany)
+}
+
+func (r relation) cascadePersist() bool {
+	return r.cascade == all || r.cascade == persist
+}
+
+func (r relation) cascadeRemove() bool {
+	return r.cascade == all || r.cascade == remove
+}
+
+type relationType int
+
+const (
+	_ relationType = iota
+	manyToOne
+	oneToMany
+	manyToMany
+)
+
+func (Type relationType) String() string {
+	switch Type {
+	case manyToOne:
+		return "manyToOne"
+	case oneToMany:
+		return "oneToMany"
+	case manyToMany:
+		return "manyToMany"
+	}
+	return ""
+}
+
+// load defines whether a relationship
+// is lazyly or eagerly fetched
+type load int
+
+const (
+	lazy load = iota
+	eager
+)
+
+// cascade sets the behavior of related entities when
+// an entity is removed or persisted.
+type cascade int
+
+const (
+	_ cascade = iota
+	all
+	persist
+	remove
+)
+
+// parseRelation parses a relation definition found in a sql struct tag, like
+//
+//	manyToOne(targetEntity:users,joinColumn:author_id,cascade:persist,load:eager)
+//	oneToMany(targetEntity:articles,mappedBy:Author)
+//	manyToMany(targetEntity:tags,joinTable:article_tags,joinColumn:article_id,inverseJoinColumn:tag_id)
+func parseRelation(Field reflect.StructField, definition string) (r relation, err error) {
+	definitions, err := tag.NewParser(strings.NewReader(definition)).Parse()
+	if err != nil {
+		return r, err
+	}
+	if len(definitions) != 1 {
+		return r, ErrInvalidAnnotation
+	}
+	switch strings.ToLower(definitions[0].Name) {
+	case "manytoone":
+		r.relation = manyToOne
+		r.joinColumn = strings.ToLower(Field.Name) + "_id"
+		if Field.Type.Kind() != reflect.Ptr || Field.Type.Elem().Kind() != reflect.Struct {
+			return r, ErrInvalidAnnotation
+		}
+	case "onetomany", "manytomany":
+		r.relation = oneToMany
+		if strings.ToLower(definitions[0].Name) == "manytomany" {
+			r.relation = manyToMany
+		}
+		if Field.Type.Kind() != reflect.Slice || Field.Type.Elem().Kind() != reflect.Ptr {
+			return r, ErrInvalidAnnotation
+		}
+	default:
+		return r, ErrInvalidAnnotation
+	}
+	for _, parameter := range definitions[0].Parameters {
+		switch strings.ToLower(parameter.Key) {
+		case "targetentity":
+			r.targetEntity = parameter.Value
+		case "joincolumn":
+			r.joinColumn = parameter.Value
+		case "inversejoincolumn":
+			r.inverseJoinColumn = parameter.Value
+		case "jointable":
+			r.joinTable = parameter.Value
+		case "mappedby":
+			r.mappedBy = parameter.Value
+		case "cascade":
+			switch strings.ToLower(parameter.Value) {
+			case "persist":
+				r.cascade = persist
+			case "remove":
+				r.cascade = remove
+			case "all":
+				r.cascade = all
+			default:
+				return r, ErrInvalidAnnotation
+			}
+		case "load":
+			switch strings.ToLower(parameter.Value) {
+			case "eager":
+				r.load = eager
+			case "lazy":
+				r.load = lazy
+			default:
+				return r, ErrInvalidAnnotation
+			}
+		default:
+			return r, ErrInvalidAnnotation
+		}
+	}
+	if r.targetEntity == "" {
+		return r, ErrInvalidAnnotation
+	}
+	switch {
+	case r.relation == oneToMany && r.mappedBy == "":
+		return r, ErrInvalidAnnotation
+	case r.relation == manyToMany && r.mappedBy == "" &&
+		(r.joinTable == "" || r.joinColumn == "" || r.inverseJoinColumn == ""):
+		return r, ErrInvalidAnnotation
+	}
+	return r, nil
+}
+
+// getOwningRelation returns the relation owning the relationship described by f,
+// which is f's relation itself or the relation on the target entity f is mapped by.
+func (manager *defaultEntityManager) getOwningRelation(f field) (relation, error) {
+	if f.relation.mappedBy == "" {
+		return f.relation, nil
+	}
+	targetMeta, ok := manager.metadatas.findMetadataByTable(f.relation.targetEntity)
+	if !ok {
+		return relation{}, ErrEntityNotRegistered
+	}
+	mappedField, ok := targetMeta.findField(f.relation.mappedBy)
+	if !ok || !mappedField.relation.isOwningSide() {
+		return relation{}, ErrMappedFieldNotFound
+	}
+	return mappedField.relation, nil
+}
+
+// getRelated returns the entities referenced by a relation field of entity
+func getRelated(entity reflect.Value, f field) (related []reflect.Value) {
+	value := reflect.Indirect(entity).FieldByName(f.name)
+	switch f.relation.relation {
+	case manyToOne:
+		if !value.IsNil() {
+			related = append(related, value)
+		}
+	default:
+		for i := 0; i < value.Len(); i++ {
+			if !value.Index(i).IsNil() {
+				related = append(related, value.Index(i))
+			}
+		}
+	}
+	return
+}
+
+func (manager *defaultEntityManager) ResolveRelations(entities interface{}, fields ...string) error {
+	return manager.ResolveRelationsContext(context.Background(), entities, fields...)
+}
+
+func (manager *defaultEntityManager) ResolveRelationsContext(ctx context.Context, entities interface{}, fields ...string) error {
+	Value := reflect.ValueOf(entities)
+	if Value.Kind() != reflect.Ptr {
+		return ErrNotAPointer
+	}
+	values := []reflect.Value{}
+	switch Value.Elem().Kind() {
+	case reflect.Struct:
+		values = append(values, Value)
+	case reflect.Slice:
+		values = convertValueToArrayOfValues(Value.Elem())
+	default:
+		return ErrNotASlice
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	meta, err := manager.metadatas.getMetadata(values[0].Type())
+	if err != nil {
+		return err
+	}
+	return manager.resolveRelations(ctx, meta, values, fields, false)
+}
+
+// resolveRelations loads the relations of entities, which are pointers to struct of the same type.
+// if fields is empty, every relation is loaded, or only eager relations if eagerOnly is true.
+// Each relation is loaded with a single query for all entities, then related entities
+// not already in the identity map are fetched with a single query.
+func (manager *defaultEntityManager) resolveRelations(ctx context.Context, meta metadata, entities []reflect.Value, fields []string, eagerOnly bool) error {
+	sourceIDs := []interface{}{}
+	bySourceID := map[interface{}]reflect.Value{}
+	for _, entity := range entities {
+		if entity.IsNil() || !meta.hasID(entity) {
+			continue
+		}
+		id := meta.getID(entity).Interface()
+		if _, ok := bySourceID[id]; !ok {
+			sourceIDs = append(sourceIDs, id)
+		}
+		bySourceID[id] = entity
+	}
+	if len(sourceIDs) == 0 {
+		return nil
+	}
+	for _, f := range meta.fields {
+		if f.relation.relation == 0 {
+			continue
+		}
+		if len(fields) > 0 && indexOfString(fields, f.name) == -1 {
+			continue
+		}
+		if len(fields) == 0 && eagerOnly && f.relation.load != eager {
+			continue
+		}
+		targetMeta, ok := manager.metadatas.findMetadataByTable(f.relation.targetEntity)
+		if !ok {
+			return ErrEntityNotRegistered
+		}
+		pairs, err := manager.fetchRelationPairs(ctx, meta, f, sourceIDs)
+		if err != nil {
+			return err
+		}
+		targetIDs := []interface{}{}
+		for _, pair := range pairs {
+			targetIDs = append(targetIDs, targetMeta.normalizeID(pair[1]))
+		}
+		targets, loaded, err := manager.findByIDs(ctx, targetMeta, targetIDs)
+		if err != nil {
+			return err
+		}
+		// reset relation fields before assigning related entities
+		for _, id := range sourceIDs {
+			value := reflect.Indirect(bySourceID[id]).FieldByName(f.name)
+			value.Set(reflect.Zero(value.Type()))
+		}
+		for _, pair := range pairs {
+			source, ok := bySourceID[meta.normalizeID(pair[0])]
+			target, found := targets[targetMeta.normalizeID(pair[1])]
+			if !ok || !found {
+				continue
+			}
+			value := reflect.Indirect(source).FieldByName(f.name)
+			if f.relation.relation == manyToOne {
+				value.Set(target)
+			} else {
+				value.Set(reflect.Append(value, target))
+			}
+		}
+		for _, id := range sourceIDs {
+			manager.refreshRelationSnapshot(meta, f, bySourceID[id])
+		}
+		if len(loaded) > 0 {
+			if err := manager.resolveRelations(ctx, targetMeta, loaded, nil, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchRelationPairs returns (source id, target id) pairs for a relation field
+func (manager *defaultEntityManager) fetchRelationPairs(ctx context.Context, meta metadata, f field, sourceIDs []interface{}) (pairs [][]interface{}, err error) {
+	targetMeta, _ := manager.metadatas.findMetadataByTable(f.relation.targetEntity)
+	owning, err := manager.getOwningRelation(f)
+	if err != nil {
+		return nil, err
+	}
+	rows := [][]interface{}{}
+	for _, chunk := range manager.chunkIDs(sourceIDs) {
+		queryBuilder := manager.connection.CreateQueryBuilder()
+		switch {
+		case f.relation.relation == manyToOne:
+			queryBuilder.Select(meta.idColumn, owning.joinColumn).From(meta.table).
+				Where(inExpression(meta.idColumn, chunk))
+		case f.relation.relation == oneToMany:
+			queryBuilder.Select(owning.joinColumn, targetMeta.idColumn).From(targetMeta.table).
+				Where(inExpression(owning.joinColumn, chunk)).
+				OrderBy(targetMeta.idColumn)
+		case f.relation.relation == manyToMany && f.relation.mappedBy == "":
+			queryBuilder.Select(owning.joinColumn, owning.inverseJoinColumn).From(owning.joinTable).
+				Where(inExpression(owning.joinColumn, chunk)).
+				OrderBy(owning.inverseJoinColumn)
+		default:
+			queryBuilder.Select(owning.inverseJoinColumn, owning.joinColumn).From(owning.joinTable).
+				Where(inExpression(owning.inverseJoinColumn, chunk)).
+				OrderBy(owning.joinColumn)
+		}
+		// the rows of each chunk are appended to rows
+		if err = queryBuilder.QueryContext(ctx).GetResults(&rows); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range rows {
+		if row[0] != nil && row[1] != nil {
+			pairs = append(pairs, row)
+		}
+	}
+	return pairs, nil
+}
+
+// findByIDs returns the entities matching ids keyed by id, fetching
+// the entities that are not managed yet in as few queries as possible.
+// loaded are the entities that were fetched from the database.
+func (manager *defaultEntityManager) findByIDs(ctx context.Context, meta metadata, ids []interface{}) (entities map[interface{}]reflect.Value, loaded []reflect.Value, err error) {
+	entities = map[interface{}]reflect.Value{}
+	missing := []interface{}{}
+	for _, id := range ids {
+		if managed, ok := manager.identityMap[meta.table][id]; ok {
+			entities[id] = reflect.ValueOf(managed)
+		} else if indexOf(missing, id) == -1 {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return entities, nil, nil
+	}
+	results := reflect.New(reflect.SliceOf(meta.structType))
+	for _, chunk := range manager.chunkIDs(missing) {
+		err = manager.connection.CreateQueryBuilder().Select("*").From(meta.table).
+			Where(inExpression(meta.idColumn, chunk)).
+			QueryContext(ctx).
+			GetResults(results.Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, entity := range convertValueToArrayOfValues(results.Elem()) {
+		id := meta.getID(entity).Interface()
+		if managed, ok := manager.identityMap[meta.table][id]; ok {
+			entities[id] = reflect.ValueOf(managed)
+			continue
+		}
+		manager.manage(meta, entity.Interface())
+		entities[id] = entity
+		loaded = append(loaded, entity)
+	}
+	return entities, loaded, nil
+}
+
+// cascadePersist persists entities related to the scheduled and managed entities
+// through relations with a persist cascade.
+func (manager *defaultEntityManager) cascadePersist() {
+	queue := append([]interface{}{}, manager.insertions...)
+	for entity := range manager.snapshots {
+		queue = append(queue, entity)
+	}
+	for len(queue) > 0 {
+		entity := queue[0]
+		queue = queue[1:]
+		meta, err := manager.metadatas.getMetadata(reflect.TypeOf(entity))
+		if err != nil {
+			continue
+		}
+		for _, f := range meta.fields {
+			if f.relation.relation == 0 || !f.relation.cascadePersist() {
+				continue
+			}
+			for _, related := range getRelated(reflect.ValueOf(entity), f) {
+				if f.relation.relation == oneToMany {
+					// attach children to their parent
+					if mapped := related.Elem().FieldByName(f.relation.mappedBy); mapped.IsValid() && mapped.IsNil() {
+						mapped.Set(reflect.ValueOf(entity))
+					}
+				}
+				if !manager.Contains(related.Interface()) && indexOfEntity(manager.insertions, related.Interface()) == -1 {
+					manager.insertions = append(manager.insertions, related.Interface())
+					queue = append(queue, related.Interface())
+				}
+			}
+		}
+	}
+}
+
+// cascadeRemove schedules for removal the entities related to the entities
+// scheduled for removal through relations with a remove cascade.
+// collections that were never loaded are loaded first.
+func (manager *defaultEntityManager) cascadeRemove(ctx context.Context) error {
+	for i := 0; i < len(manager.removals); i++ {
+		entity := manager.removals[i]
+		meta, err := manager.metadatas.getMetadata(reflect.TypeOf(entity))
+		if err != nil {
+			return err
+		}
+		for _, f := range meta.fields {
+			if f.relation.relation == 0 || !f.relation.cascadeRemove() {
+				continue
+			}
+			if value := reflect.Indirect(reflect.ValueOf(entity)).FieldByName(f.name); value.IsNil() {
+				if err := manager.resolveRelations(ctx, meta, []reflect.Value{reflect.ValueOf(entity)}, []string{f.name}, false); err != nil {
+					return err
+				}
+			}
+			for _, related := range getRelated(reflect.ValueOf(entity), f) {
+				manager.Remove(related.Interface())
+			}
+		}
+	}
+	return nil
+}
+
+// getCollectionIDs returns the identifiers of the entities
+// of a manyToMany relation owned by entity
+func (manager *defaultEntityManager) getCollectionIDs(entity reflect.Value, f field) (ids []interface{}) {
+	targetMeta, _ := manager.metadatas.findMetadataByTable(f.relation.targetEntity)
+	for _, related := range getRelated(entity, f) {
+		if targetMeta.hasID(related) {
+			ids = append(ids, targetMeta.getID(related).Interface())
+		}
+	}
+	return
+}
+
+// refreshRelationSnapshot updates the snapshot of a loaded relation so
+// that loading a relation is not mistaken for a change.
+func (manager *defaultEntityManager) refreshRelationSnapshot(meta metadata, f field, entity reflect.Value) {
+	snapshot, ok := manager.snapshots[entity.Interface()]
+	if !ok {
+		return
+	}
+	switch {
+	case f.relation.relation == manyToOne:
+		snapshot.columns[f.column] = manager.getFieldValue(entity, f)
+	case f.relation.relation == manyToMany && f.relation.isOwningSide():
+		snapshot.collections[f.name] = manager.getCollectionIDs(entity, f)
+	}
+}
+
+// executeCollectionUpdates inserts and deletes the rows of the join table of the
+// manyToMany relations owned by entity, comparing the related entities with the snapshot.
+func (manager *defaultEntityManager) executeCollectionUpdates(ctx context.Context, transaction *Transaction, meta metadata, entity interface{}) error {
+	Value := reflect.ValueOf(entity)
+	id := meta.getID(Value).Interface()
+	for _, f := range meta.fields {
+		if f.relation.relation != manyToMany || !f.relation.isOwningSide() {
+			continue
+		}
+		var previous []interface{}
+		if snapshot, ok := manager.snapshots[entity]; ok {
+			previous = snapshot.collections[f.name]
+		}
+		current := manager.getCollectionIDs(Value, f)
+		for _, targetID := range previous {
+			if indexOf(current, targetID) == -1 {
+				_, err := transaction.CreateQueryBuilder().Delete(f.relation.joinTable).
+					Where(expression.Eq(f.relation.joinColumn, expression.Param(id)), expression.Eq(f.relation.inverseJoinColumn, expression.Param(targetID))).
+					ExecContext(ctx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, targetID := range current {
+			if indexOf(previous, targetID) == -1 {
+				_, err := transaction.CreateQueryBuilder().Insert(f.relation.joinTable).
+					SetValue(f.relation.joinColumn, expression.Param(id)).
+					SetValue(f.relation.inverseJoinColumn, expression.Param(targetID)).
+					ExecContext(ctx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// executeCollectionDeletes removes the join table rows referencing an entity about to be removed
+func (manager *defaultEntityManager) executeCollectionDeletes(ctx context.Context, transaction *Transaction, meta metadata, entity interface{}) error {
+	id := meta.getID(reflect.ValueOf(entity)).Interface()
+	for _, other := range manager.metadatas {
+		for _, f := range other.fields {
+			if f.relation.relation != manyToMany || !f.relation.isOwningSide() {
+				continue
+			}
+			columns := []string{}
+			if other.table == meta.table {
+				columns = append(columns, f.relation.joinColumn)
+			}
+			if f.relation.targetEntity == meta.table {
+				columns = append(columns, f.relation.inverseJoinColumn)
+			}
+			for _, column := range columns {
+				_, err := transaction.CreateQueryBuilder().Delete(f.relation.joinTable).
+					Where(expression.Eq(column, expression.Param(id))).
+					ExecContext(ctx)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maxInListSize is the maximum number of items of an IN list, the limit of Oracle
+const maxInListSize = 1000
+
+// chunkIDs splits ids in chunks small enough to be bound to the IN list of a single query,
+// given the maximum number of parameters of the database platform
+func (manager *defaultEntityManager) chunkIDs(ids []interface{}) (chunks [][]interface{}) {
+	size := manager.connection.GetDatabasePlatform().GetMaxParameters()
+	if size < 1 || size > maxInListSize {
+		size = maxInListSize
+	}
+	for len(ids) > size {
+		chunks, ids = append(chunks, ids[:size:size]), ids[size:]
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
+
+// inExpression returns column IN ( ... ) with a parameter bound to each of values
+func inExpression(column string, values []interface{}) *expression.Expression {
+	return expression.In(column, expression.Params(values...)...)
+}
+
+func convertValueToArrayOfValues(value reflect.Value) (arrayOfValues []reflect.Value) {
+	for i := 0; i < value.Len(); i++ {
+		arrayOfValues = append(arrayOfValues, value.Index(i))
+	}
+	return
+}
+
+// indexOf returns the index of value in values or -1
+func indexOf(values []interface{}, value interface{}) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOfString returns the index of value in values or -1
+func indexOfString(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
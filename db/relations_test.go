@@ -0,0 +1,225 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/logger"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type RelationUser struct {
+	ID       int64              `sql:"column:id,primaryKey"`
+	Name     string             `sql:"column:name"`
+	Email    string             `sql:"column:email"`
+	Articles []*RelationArticle `sql:"oneToMany(targetEntity:articles,mappedBy:Author,cascade:remove)"`
+}
+
+type RelationArticle struct {
+	ID      int64          `sql:"column:id"`
+	Title   string         `sql:"column:title"`
+	Content string         `sql:"column:content,persistzerovalue"`
+	Author  *RelationUser  `sql:"manyToOne(targetEntity:users,joinColumn:author_id,cascade:persist,load:eager)"`
+	Tags    []*RelationTag `sql:"manyToMany(targetEntity:tags,joinTable:article_tags,joinColumn:article_id,inverseJoinColumn:tag_id,cascade:persist)"`
+}
+
+type RelationTag struct {
+	ID   int64  `sql:"column:id"`
+	Name string `sql:"column:name"`
+}
+
+// RelationArticleTag is the join table between articles and tags
+type RelationArticleTag struct {
+	ArticleID int64 `sql:"column:article_id,primaryKey,references:articles"`
+	TagID     int64 `sql:"column:tag_id,primaryKey,references:tags"`
+}
+
+func GetRelationEntityManager(t *testing.T) db.EntityManager {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	_, err := connection.Exec("PRAGMA foreign_keys = ON")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, db.CreateTableFromStruct(connection, "tags", RelationTag{}), nil)
+	test.Fatal(t, db.CreateTableFromStruct(connection, "article_tags", RelationArticleTag{}), nil)
+	manager := db.NewEntityManager(connection)
+	manager.SetLogger(logger.NewTestLogger(t))
+	err = manager.RegisterMany(map[string]interface{}{
+		"articles": new(RelationArticle),
+		"users":    new(RelationUser),
+		"tags":     new(RelationTag),
+	})
+	test.Fatal(t, err, nil)
+	return manager
+}
+
+func TestRelationsCascadePersist(t *testing.T) {
+	manager := GetRelationEntityManager(t)
+	author := &RelationUser{Name: "John Doe", Email: "john.doe@acme.com"}
+	article := &RelationArticle{Title: "Go", Author: author, Tags: []*RelationTag{{Name: "go"}, {Name: "sql"}}}
+	// the author and the tags are persisted along with the article
+	manager.Persist(article)
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, author.ID, int64(1))
+	test.Fatal(t, manager.Contains(article.Tags[1]), true)
+	var count int
+	err := manager.GetConnection().QueryRow("SELECT COUNT(*) FROM article_tags WHERE article_id = ?", article.ID).GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 2)
+
+	// removing a tag from the collection removes the join table row
+	article.Tags = article.Tags[:1]
+	test.Fatal(t, manager.Flush(), nil)
+	err = manager.GetConnection().QueryRow("SELECT COUNT(*) FROM article_tags WHERE article_id = ?", article.ID).GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+}
+
+func TestRelationsFlushRollback(t *testing.T) {
+	manager := GetRelationEntityManager(t)
+	// the join table rows can't be inserted
+	_, err := manager.GetConnection().Exec("DROP TABLE article_tags")
+	test.Fatal(t, err, nil)
+	article := &RelationArticle{Title: "Go", Author: &RelationUser{Name: "John Doe"}, Tags: []*RelationTag{{Name: "go"}}}
+	manager.Persist(article)
+	test.Error(t, manager.Flush() != nil, true)
+	// the author and the tag persisted by cascade are not scheduled anymore
+	manager.Remove(article)
+	test.Fatal(t, manager.Flush(), nil)
+	var count int
+	test.Fatal(t, manager.GetConnection().QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count), nil)
+	test.Fatal(t, count, 0)
+}
+
+func TestRelationsLoadingChunks(t *testing.T) {
+	manager := GetRelationEntityManager(t)
+	connection := manager.GetConnection().(*db.DefaultConnection)
+	users, articles := []map[string]interface{}{}, []map[string]interface{}{}
+	for i := 1; i <= 1200; i++ {
+		users = append(users, map[string]interface{}{"id": i, "name": fmt.Sprint("user ", i), "email": fmt.Sprint("user", i, "@acme.com")})
+		articles = append(articles, map[string]interface{}{"id": i, "title": fmt.Sprint("article ", i), "content": "", "author_id": i})
+	}
+	_, err := connection.InsertMany("users", users)
+	test.Fatal(t, err, nil)
+	_, err = connection.InsertMany("articles", articles)
+	test.Fatal(t, err, nil)
+	queries := 0
+	connection.Options.Observers = []db.QueryObserver{db.QueryObserverFuncs{Before: func(event *db.QueryEvent) {
+		if strings.HasPrefix(event.SQL, "SELECT * FROM users") {
+			queries++
+		}
+	}}}
+	// the authors are loaded by queries binding at most 999 parameters
+	loaded := []*RelationArticle{}
+	test.Fatal(t, manager.FindBy(map[string]interface{}{}, &loaded), nil)
+	test.Fatal(t, len(loaded), 1200)
+	test.Fatal(t, loaded[1199].Author.Name, "user 1200")
+	test.Fatal(t, queries, 2)
+}
+
+func TestRelationsLoading(t *testing.T) {
+	manager := GetRelationEntityManager(t)
+	john := &RelationUser{Name: "John Doe", Email: "john.doe@acme.com"}
+	jane := &RelationUser{Name: "Jane Doe", Email: "jane.doe@acme.com"}
+	for _, article := range []*RelationArticle{
+		{Title: "Go", Author: john, Tags: []*RelationTag{{Name: "go"}}},
+		{Title: "SQL", Author: john},
+		{Title: "Rust", Author: jane},
+	} {
+		manager.Persist(article)
+	}
+	test.Fatal(t, manager.Flush(), nil)
+	manager.Clear()
+
+	// manyToOne relations marked eager are loaded with the entities
+	articles := []*RelationArticle{}
+	test.Fatal(t, manager.FindBy(map[string]interface{}{}, &articles), nil)
+	test.Fatal(t, len(articles), 3)
+	test.Fatal(t, articles[0].Author.Name, "John Doe")
+	// related entities are loaded once
+	test.Fatal(t, articles[0].Author, articles[1].Author)
+	// lazy relations are not loaded
+	test.Fatal(t, articles[0].Tags == nil, true)
+
+	test.Fatal(t, manager.ResolveRelations(&articles, "Tags"), nil)
+	test.Fatal(t, len(articles[0].Tags), 1)
+	test.Fatal(t, articles[0].Tags[0].Name, "go")
+	test.Fatal(t, len(articles[1].Tags), 0)
+
+	// the inverse side of a relation
+	author := articles[0].Author
+	test.Fatal(t, manager.ResolveRelations(author), nil)
+	test.Fatal(t, len(author.Articles), 2)
+	test.Fatal(t, author.Articles[0], articles[0])
+
+	// resolving relations is not a change
+	test.Fatal(t, manager.Flush(), nil)
+
+	// changing the owning side of a relation updates the join column
+	articles[2].Author = author
+	test.Fatal(t, manager.Flush(), nil)
+	var count int
+	err := manager.GetConnection().QueryRow("SELECT COUNT(*) FROM articles WHERE author_id = ?", author.ID).GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 3)
+}
+
+func TestRelationsCascadeRemove(t *testing.T) {
+	manager := GetRelationEntityManager(t)
+	author := &RelationUser{Name: "John Doe", Email: "john.doe@acme.com"}
+	manager.Persist(&RelationArticle{Title: "Go", Author: author, Tags: []*RelationTag{{Name: "go"}}})
+	manager.Persist(&RelationArticle{Title: "SQL", Author: author})
+	test.Fatal(t, manager.Flush(), nil)
+	manager.Clear()
+
+	user := new(RelationUser)
+	test.Fatal(t, manager.Find(author.ID, user), nil)
+	// articles are not loaded yet, they are loaded before being removed
+	manager.Remove(user)
+	test.Fatal(t, manager.Flush(), nil)
+	for _, table := range []string{"users", "articles", "article_tags"} {
+		var count int
+		err := manager.GetConnection().QueryRow("SELECT COUNT(*) FROM " + table).GetSingleResult(&count)
+		test.Fatal(t, err, nil)
+		test.Fatal(t, count, 0)
+	}
+	// tags are not removed
+	var count int
+	err := manager.GetConnection().QueryRow("SELECT COUNT(*) FROM tags").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+}
+
+func TestRelationsInvalidAnnotation(t *testing.T) {
+	manager := db.NewEntityManager(nil)
+	type MissingMappedBy struct {
+		ID       int64
+		Articles []*RelationArticle `sql:"oneToMany(targetEntity:articles)"`
+	}
+	test.Fatal(t, manager.Register("users", new(MissingMappedBy)), db.ErrInvalidAnnotation)
+	type NotAPointer struct {
+		ID     int64
+		Author RelationUser `sql:"manyToOne(targetEntity:users)"`
+	}
+	test.Fatal(t, manager.Register("articles", new(NotAPointer)), db.ErrInvalidAnnotation)
+	type UnknownParameter struct {
+		ID     int64
+		Author *RelationUser `sql:"manyToOne(targetEntity:users,fetch:eager)"`
+	}
+	test.Fatal(t, manager.Register("articles", new(UnknownParameter)), db.ErrInvalidAnnotation)
+}
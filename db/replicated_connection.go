@@ -0,0 +1,251 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaStrategy selects the replica a read query is routed to
+type ReplicaStrategy int
+
+const (
+	// RoundRobin routes read queries to each healthy replica in turn
+	RoundRobin ReplicaStrategy = iota
+	// LeastLatency routes read queries to the healthy replica with the lowest ping latency
+	LeastLatency
+)
+
+// ReplicatedConnection is a connection to a primary database and its replicas.
+// SELECT queries are routed to the healthy replicas, other queries and transactions
+// to the primary. Replicas failing a health check are evicted until a later health check succeeds.
+//
+// With a context returned by WithStickyPrimary, queries are routed to the primary once a write
+// was executed with the context, so that a request reads its own writes despite replication lag.
+type ReplicatedConnection struct {
+	// DefaultConnection is the connection to the primary
+	*DefaultConnection
+	strategy ReplicaStrategy
+	replicas []*replica
+	// mutex guards the health and latency of the replicas
+	mutex sync.RWMutex
+	next  uint64
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// replica is a replica database
+type replica struct {
+	connection *DefaultConnection
+	healthy    bool
+	latency    time.Duration
+}
+
+// NewReplicatedConnection returns a connection routing writes to primary and reads to replicas,
+// the replicas sharing the driver, options and platform of primary.
+func NewReplicatedConnection(primary *DefaultConnection, replicas []*sql.DB, strategy ReplicaStrategy) *ReplicatedConnection {
+	connection := &ReplicatedConnection{DefaultConnection: primary, strategy: strategy, stop: make(chan struct{})}
+	for _, DB := range replicas {
+		connection.replicas = append(connection.replicas, &replica{
+			connection: &DefaultConnection{Db: DB, DriverName: primary.DriverName, Options: primary.GetOptions(), Platform: primary.GetDatabasePlatform()},
+			healthy:    true,
+		})
+	}
+	return connection
+}
+
+// CreateQueryBuilder creates a *QueryBuilder whose SELECT queries are routed to the replicas
+func (connection *ReplicatedConnection) CreateQueryBuilder() *QueryBuilder {
+	return NewQueryBuilder(connection)
+}
+
+// Query executes a query on a replica if it is a read query, on the primary otherwise
+func (connection *ReplicatedConnection) Query(query string, arguments ...interface{}) *Rows {
+	return connection.QueryContext(context.Background(), query, arguments...)
+}
+
+// QueryContext executes a query on a replica if it is a read query, on the primary otherwise
+func (connection *ReplicatedConnection) QueryContext(ctx context.Context, query string, arguments ...interface{}) *Rows {
+	return connection.route(ctx, query).QueryContext(ctx, query, arguments...)
+}
+
+// QueryRow executes a query on a replica if it is a read query, on the primary otherwise
+func (connection *ReplicatedConnection) QueryRow(query string, arguments ...interface{}) *Row {
+	return connection.QueryRowContext(context.Background(), query, arguments...)
+}
+
+// QueryRowContext executes a query on a replica if it is a read query, on the primary otherwise
+func (connection *ReplicatedConnection) QueryRowContext(ctx context.Context, query string, arguments ...interface{}) *Row {
+	return connection.route(ctx, query).QueryRowContext(ctx, query, arguments...)
+}
+
+// Transactional runs fn in a transaction on the primary, see DefaultConnection.Transactional
+func (connection *ReplicatedConnection) Transactional(ctx context.Context, fn TransactionalFunc) error {
+	transaction, err := connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return runTransactional(transaction, fn)
+}
+
+// GetPrimary returns the connection to the primary
+func (connection *ReplicatedConnection) GetPrimary() *DefaultConnection {
+	return connection.DefaultConnection
+}
+
+// CheckReplicas pings the replicas, evicting the replicas that can't be reached
+// and restoring the evicted replicas that can be reached again
+func (connection *ReplicatedConnection) CheckReplicas(ctx context.Context) {
+	var wait sync.WaitGroup
+	for _, r := range connection.replicas {
+		wait.Add(1)
+		go func(r *replica) {
+			defer wait.Done()
+			start := time.Now()
+			err := r.connection.DB().PingContext(ctx)
+			connection.mutex.Lock()
+			defer connection.mutex.Unlock()
+			r.healthy, r.latency = err == nil, time.Since(start)
+		}(r)
+	}
+	wait.Wait()
+}
+
+// StartHealthChecks checks the replicas every interval until the connection is closed
+func (connection *ReplicatedConnection) StartHealthChecks(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-connection.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				connection.CheckReplicas(ctx)
+				cancel()
+			}
+		}
+	}()
+}
+
+// HealthyReplicas returns the number of replicas queries can be routed to
+func (connection *ReplicatedConnection) HealthyReplicas() (count int) {
+	connection.mutex.RLock()
+	defer connection.mutex.RUnlock()
+	for _, r := range connection.replicas {
+		if r.healthy {
+			count++
+		}
+	}
+	return count
+}
+
+// Close stops the health checks and closes the replicas and the primary
+func (connection *ReplicatedConnection) Close() error {
+	connection.once.Do(func() { close(connection.stop) })
+	for _, r := range connection.replicas {
+		r.connection.Close()
+	}
+	return connection.DefaultConnection.Close()
+}
+
+// route returns the connection query is executed with
+func (connection *ReplicatedConnection) route(ctx context.Context, query string) *DefaultConnection {
+	if !isReadQuery(query) {
+		markPrimaryWrite(ctx)
+		return connection.DefaultConnection
+	}
+	if routing := getPrimaryRouting(ctx); routing != nil && (routing.always || atomic.LoadInt32(&routing.written) == 1) {
+		return connection.DefaultConnection
+	}
+	if replica := connection.selectReplica(); replica != nil {
+		return replica.connection
+	}
+	return connection.DefaultConnection
+}
+
+// selectReplica returns a healthy replica according to the strategy, nil if there is none
+func (connection *ReplicatedConnection) selectReplica() *replica {
+	connection.mutex.RLock()
+	defer connection.mutex.RUnlock()
+	healthy := make([]*replica, 0, len(connection.replicas))
+	for _, r := range connection.replicas {
+		if r.healthy {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if connection.strategy == LeastLatency {
+		selected := healthy[0]
+		for _, r := range healthy[1:] {
+			if r.latency < selected.latency {
+				selected = r
+			}
+		}
+		return selected
+	}
+	return healthy[(atomic.AddUint64(&connection.next, 1)-1)%uint64(len(healthy))]
+}
+
+var (
+	readQueryRegexp    = regexp.MustCompile(`(?is)^\s*\(?\s*SELECT\b`)
+	lockingQueryRegexp = regexp.MustCompile(`(?is)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+)
+
+// isReadQuery returns true for SELECT queries that don't lock rows
+func isReadQuery(query string) bool {
+	return readQueryRegexp.MatchString(query) && !lockingQueryRegexp.MatchString(query)
+}
+
+// primaryRouting routes the queries of a context to the primary
+type primaryRouting struct {
+	// always is true if queries are always routed to the primary
+	always bool
+	// written is 1 once a write was executed with the context
+	written int32
+}
+
+type primaryRoutingKey struct{}
+
+// WithStickyPrimary returns a context whose read queries are routed to the primary once a write
+// query or a transaction was executed with the context or a context derived from it
+func WithStickyPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryRoutingKey{}, &primaryRouting{})
+}
+
+// WithPrimary returns a context whose queries are always routed to the primary
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryRoutingKey{}, &primaryRouting{always: true})
+}
+
+func getPrimaryRouting(ctx context.Context) *primaryRouting {
+	routing, _ := ctx.Value(primaryRoutingKey{}).(*primaryRouting)
+	return routing
+}
+
+// markPrimaryWrite records that a write was executed with ctx
+func markPrimaryWrite(ctx context.Context) {
+	if routing := getPrimaryRouting(ctx); routing != nil {
+		atomic.StoreInt32(&routing.written, 1)
+	}
+}
@@ -0,0 +1,78 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+// getReplica returns a replica database containing a single user named name
+func getReplica(t *testing.T, name string) *sql.DB {
+	_, replica := GetDB(t)
+	replica.SetMaxOpenConns(1)
+	_, err := replica.Exec("INSERT INTO users(name, email) VALUES(?, ?)", name, name+"@acme.com")
+	test.Fatal(t, err, nil)
+	return replica
+}
+
+func TestReplicatedConnection(t *testing.T) {
+	primary := GetConnection(t)
+	primary.DB().SetMaxOpenConns(1)
+	connection := db.NewReplicatedConnection(primary, []*sql.DB{getReplica(t, "replica1"), getReplica(t, "replica2")}, db.RoundRobin)
+	defer connection.Close()
+	readName := func(ctx context.Context) (name string) {
+		err := connection.CreateQueryBuilder().Select("name").From("users").SetMaxResults(1).QueryRowContext(ctx).GetSingleResult(&name)
+		test.Fatal(t, err, nil)
+		return name
+	}
+	// reads are routed to the replicas in turn
+	test.Fatal(t, readName(context.Background()), "replica1")
+	test.Fatal(t, readName(context.Background()), "replica2")
+	test.Fatal(t, readName(context.Background()), "replica1")
+
+	// writes are routed to the primary, and reads after a write with a sticky context
+	ctx := db.WithStickyPrimary(context.Background())
+	test.Fatal(t, readName(ctx), "replica2")
+	_, err := connection.InsertContext(ctx, "users", &AppUser{Name: "primary", Email: "primary@acme.com"})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, readName(ctx), "primary")
+	test.Fatal(t, readName(db.WithPrimary(context.Background())), "primary")
+
+	test.Fatal(t, connection.HealthyReplicas(), 2)
+	connection.CheckReplicas(context.Background())
+	test.Fatal(t, connection.HealthyReplicas(), 2)
+}
+
+func TestReplicatedConnectionEviction(t *testing.T) {
+	primary := GetConnection(t)
+	primary.DB().SetMaxOpenConns(1)
+	test.Fatal(t, LoadFixtures(primary), nil)
+	replica := getReplica(t, "replica")
+	connection := db.NewReplicatedConnection(primary, []*sql.DB{replica}, db.LeastLatency)
+	defer connection.Close()
+	var name string
+	test.Fatal(t, connection.QueryRow("SELECT name FROM users").GetSingleResult(&name), nil)
+	test.Fatal(t, name, "replica")
+	replica.Close()
+	connection.CheckReplicas(context.Background())
+	test.Fatal(t, connection.HealthyReplicas(), 0)
+	test.Fatal(t, connection.QueryRow("SELECT name FROM users ORDER BY id").GetSingleResult(&name), nil)
+	test.Fatal(t, name, "John Doe")
+}
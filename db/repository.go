@@ -0,0 +1,353 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db/expression"
+)
+
+// Repository finds and persists the rows of a table mapped to a struct type.
+// Unlike the EntityManager, a repository doesn't track the structs it loads,
+// changes are written when Save is called.
+type Repository interface {
+	// GetTable returns the table of the repository
+	GetTable() string
+
+	// CreateQueryBuilder returns a query builder selecting the rows of the table,
+	// soft deleted rows excluded
+	CreateQueryBuilder() *QueryBuilder
+
+	// Find finds a struct by its identifier, entity being a pointer to struct.
+	// sql.ErrNoRows is returned if no row was found.
+	Find(id interface{}, entity interface{}) error
+	FindContext(ctx context.Context, id interface{}, entity interface{}) error
+
+	// FindAll finds all structs, entities being a pointer to a slice of pointers to struct
+	FindAll(entities interface{}) error
+	FindAllContext(ctx context.Context, entities interface{}) error
+
+	// FindBy finds the structs matching criteria, ordered by the columns of orderBy written
+	// as "column [ASC|DESC]". limit and offset are ignored when 0.
+	FindBy(criteria map[string]interface{}, orderBy []string, limit, offset int, entities interface{}) error
+	FindByContext(ctx context.Context, criteria map[string]interface{}, orderBy []string, limit, offset int, entities interface{}) error
+
+	// FindOneBy finds a single struct matching criteria, sql.ErrNoRows is returned if no row was found
+	FindOneBy(criteria map[string]interface{}, entity interface{}) error
+	FindOneByContext(ctx context.Context, criteria map[string]interface{}, entity interface{}) error
+
+	// Count returns the number of rows matching criteria
+	Count(criteria map[string]interface{}) (int, error)
+	CountContext(ctx context.Context, criteria map[string]interface{}) (int, error)
+
+	// Save inserts entity if its identifier is a zero value, updates it otherwise.
+	// The identifier generated by the database is set on insert, an entity whose identifier is
+	// assigned by the application is inserted if no row has its identifier. Updates of an entity
+	// with a version field return ErrOptimisticLock if the version of its row changed.
+	// manyToOne relations write the identifier of the related entity in their join column.
+	Save(entity interface{}) error
+	SaveContext(ctx context.Context, entity interface{}) error
+
+	// Delete deletes entity, or sets its soft delete column if the repository soft deletes rows
+	Delete(entity interface{}) error
+	DeleteContext(ctx context.Context, entity interface{}) error
+}
+
+// RepositoryOptions are the opt-in behaviors of a repository
+type RepositoryOptions struct {
+	// SoftDeleteColumn is the column set to the time of deletion when a row is
+	// deleted, rows where the column is not NULL are excluded from queries
+	SoftDeleteColumn string
+	// CreatedAtColumn is the column set to the time of insertion
+	CreatedAtColumn string
+	// UpdatedAtColumn is the column set to the time of insertion and of each update
+	UpdatedAtColumn string
+}
+
+type defaultRepository struct {
+	connection Connection
+	meta       metadata
+	options    RepositoryOptions
+}
+
+// NewRepository returns a repository of the rows of table, entity being a pointer to the struct
+// the rows are mapped to. The identifier field is found like with EntityManager.Register.
+func NewRepository(connection Connection, table string, entity interface{}, options ...RepositoryOptions) (Repository, error) {
+	meta, err := getTypeMetadata(table, entity)
+	if err != nil {
+		return nil, err
+	}
+	repository := &defaultRepository{connection: connection, meta: meta}
+	if len(options) > 0 {
+		repository.options = options[0]
+	}
+	return repository, nil
+}
+
+func (repository *defaultRepository) GetTable() string {
+	return repository.meta.table
+}
+
+func (repository *defaultRepository) CreateQueryBuilder() *QueryBuilder {
+	queryBuilder := repository.connection.CreateQueryBuilder().Select("*").From(repository.meta.table)
+	if repository.options.SoftDeleteColumn != "" {
+		queryBuilder.Where(expression.IsNull(repository.options.SoftDeleteColumn))
+	}
+	return queryBuilder
+}
+
+func (repository *defaultRepository) Find(id interface{}, entity interface{}) error {
+	return repository.FindContext(context.Background(), id, entity)
+}
+
+func (repository *defaultRepository) FindContext(ctx context.Context, id interface{}, entity interface{}) error {
+	return repository.FindOneByContext(ctx, map[string]interface{}{repository.meta.idColumn: id}, entity)
+}
+
+func (repository *defaultRepository) FindAll(entities interface{}) error {
+	return repository.FindAllContext(context.Background(), entities)
+}
+
+func (repository *defaultRepository) FindAllContext(ctx context.Context, entities interface{}) error {
+	return repository.FindByContext(ctx, nil, nil, 0, 0, entities)
+}
+
+func (repository *defaultRepository) FindBy(criteria map[string]interface{}, orderBy []string, limit, offset int, entities interface{}) error {
+	return repository.FindByContext(context.Background(), criteria, orderBy, limit, offset, entities)
+}
+
+func (repository *defaultRepository) FindByContext(ctx context.Context, criteria map[string]interface{}, orderBy []string, limit, offset int, entities interface{}) error {
+	queryBuilder := repository.createQueryBuilderWithCriteria(criteria)
+	orderByPart := []interface{}{}
+	for _, order := range orderBy {
+		orderByPart = append(orderByPart, order)
+	}
+	for _, ordering := range getOrderings(orderByPart) {
+		direction := "ASC"
+		if ordering.descending {
+			direction = "DESC"
+		}
+		queryBuilder.AddOrderBy(ordering.column, direction)
+	}
+	if limit > 0 {
+		queryBuilder.SetMaxResults(limit)
+	}
+	if offset > 0 {
+		queryBuilder.SetFirstResult(offset)
+	}
+	return queryBuilder.QueryContext(ctx).GetResults(entities)
+}
+
+func (repository *defaultRepository) FindOneBy(criteria map[string]interface{}, entity interface{}) error {
+	return repository.FindOneByContext(context.Background(), criteria, entity)
+}
+
+func (repository *defaultRepository) FindOneByContext(ctx context.Context, criteria map[string]interface{}, entity interface{}) error {
+	if reflect.TypeOf(entity) != repository.meta.structType {
+		return ErrInvalidEntityType
+	}
+	entities := reflect.New(reflect.SliceOf(repository.meta.structType))
+	if err := repository.FindByContext(ctx, criteria, nil, 1, 0, entities.Interface()); err != nil {
+		return err
+	}
+	if entities.Elem().Len() == 0 {
+		return sql.ErrNoRows
+	}
+	reflect.ValueOf(entity).Elem().Set(entities.Elem().Index(0).Elem())
+	return nil
+}
+
+func (repository *defaultRepository) Count(criteria map[string]interface{}) (int, error) {
+	return repository.CountContext(context.Background(), criteria)
+}
+
+func (repository *defaultRepository) CountContext(ctx context.Context, criteria map[string]interface{}) (count int, err error) {
+	err = repository.createQueryBuilderWithCriteria(criteria).Select("COUNT(*)").QueryRowContext(ctx).GetSingleResult(&count)
+	return count, err
+}
+
+func (repository *defaultRepository) Save(entity interface{}) error {
+	return repository.SaveContext(context.Background(), entity)
+}
+
+func (repository *defaultRepository) SaveContext(ctx context.Context, entity interface{}) error {
+	if reflect.TypeOf(entity) != repository.meta.structType {
+		return ErrInvalidEntityType
+	}
+	Value := reflect.ValueOf(entity)
+	now := time.Now()
+	if !repository.meta.hasID(Value) {
+		return repository.insert(ctx, Value, now)
+	}
+	queryBuilder := repository.connection.CreateQueryBuilder().Update(repository.meta.table)
+	for _, f := range repository.meta.fields {
+		if f.name == repository.meta.idField || !f.isColumn() || f.version ||
+			f.column == repository.options.CreatedAtColumn || f.column == repository.options.UpdatedAtColumn {
+			continue
+		}
+		value, err := repository.getColumnValue(Value, f)
+		if err != nil {
+			return err
+		}
+		queryBuilder.Set(f.column, expression.Param(value))
+	}
+	if repository.options.UpdatedAtColumn != "" {
+		queryBuilder.Set(repository.options.UpdatedAtColumn, expression.Param(repository.setTimestamp(Value, repository.options.UpdatedAtColumn, now)))
+	}
+	queryBuilder.Where(expression.Eq(repository.meta.idColumn, expression.Param(repository.meta.getID(Value).Interface())))
+	versionField, versioned := repository.meta.findVersionField()
+	var version, nextVersion reflect.Value
+	if versioned {
+		version = reflect.Indirect(Value).FieldByName(versionField.name)
+		var err error
+		if nextVersion, err = getNextVersion(version); err != nil {
+			return err
+		}
+		queryBuilder.Set(versionField.column, expression.Param(nextVersion.Interface())).
+			AndWhere(expression.Eq(versionField.column, expression.Param(version.Interface())))
+	}
+	result, err := queryBuilder.ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		// the identifier may be assigned by the application, the entity is new if no row has it
+		var count int
+		err := repository.connection.CreateQueryBuilder().Select("COUNT(*)").From(repository.meta.table).
+			Where(expression.Eq(repository.meta.idColumn, expression.Param(repository.meta.getID(Value).Interface()))).
+			QueryRowContext(ctx).GetSingleResult(&count)
+		switch {
+		case err != nil:
+			return err
+		case count == 0:
+			return repository.insert(ctx, Value, now)
+		case versioned:
+			return ErrOptimisticLock
+		}
+	}
+	if versioned {
+		version.Set(nextVersion)
+	}
+	return nil
+}
+
+// getColumnValue returns the value written in the column of f, the identifier of the related
+// entity for a manyToOne relation
+func (repository *defaultRepository) getColumnValue(entity reflect.Value, f field) (interface{}, error) {
+	value := reflect.Indirect(entity).FieldByName(f.name)
+	if f.relation.relation != manyToOne {
+		return wrapColumnValue(value.Interface(), f.json, f.array), nil
+	}
+	if value.IsNil() {
+		return nil, nil
+	}
+	targetMeta, err := getTypeMetadata(f.relation.targetEntity, value.Interface())
+	if err != nil || !targetMeta.hasID(value) {
+		return nil, err
+	}
+	return targetMeta.getID(value).Interface(), nil
+}
+
+// insert inserts entity, zero values being omitted unless persisted with
+// the persistZeroValue option of the sql struct tag
+func (repository *defaultRepository) insert(ctx context.Context, entity reflect.Value, now time.Time) error {
+	timestamps := map[string]interface{}{}
+	for _, column := range []string{repository.options.CreatedAtColumn, repository.options.UpdatedAtColumn} {
+		if column != "" {
+			timestamps[column] = repository.setTimestamp(entity, column, now)
+		}
+	}
+	queryBuilder := repository.connection.CreateQueryBuilder().Insert(repository.meta.table)
+	for _, f := range repository.meta.fields {
+		if !f.isColumn() {
+			continue
+		}
+		if _, ok := timestamps[f.column]; ok {
+			continue
+		}
+		if !f.persistZeroValue && isZero(reflect.Indirect(entity).FieldByName(f.name)) {
+			continue
+		}
+		value, err := repository.getColumnValue(entity, f)
+		if err != nil {
+			return err
+		}
+		if value == nil && !f.persistZeroValue {
+			continue
+		}
+		queryBuilder.SetValue(f.column, expression.Param(value))
+	}
+	for column, timestamp := range timestamps {
+		queryBuilder.SetValue(column, expression.Param(timestamp))
+	}
+	result, err := queryBuilder.ExecContext(ctx)
+	if err != nil || repository.meta.hasID(entity) {
+		return err
+	}
+	return setGeneratedID(repository.meta, entity, result)
+}
+
+func (repository *defaultRepository) Delete(entity interface{}) error {
+	return repository.DeleteContext(context.Background(), entity)
+}
+
+func (repository *defaultRepository) DeleteContext(ctx context.Context, entity interface{}) error {
+	if reflect.TypeOf(entity) != repository.meta.structType {
+		return ErrInvalidEntityType
+	}
+	Value := reflect.ValueOf(entity)
+	id := expression.Eq(repository.meta.idColumn, expression.Param(repository.meta.getID(Value).Interface()))
+	if column := repository.options.SoftDeleteColumn; column != "" {
+		_, err := repository.connection.CreateQueryBuilder().Update(repository.meta.table).
+			Set(column, expression.Param(repository.setTimestamp(Value, column, time.Now()))).
+			Where(id).
+			ExecContext(ctx)
+		return err
+	}
+	_, err := repository.connection.CreateQueryBuilder().Delete(repository.meta.table).Where(id).ExecContext(ctx)
+	return err
+}
+
+// createQueryBuilderWithCriteria returns a query builder selecting the rows matching criteria
+func (repository *defaultRepository) createQueryBuilderWithCriteria(criteria map[string]interface{}) *QueryBuilder {
+	queryBuilder := repository.CreateQueryBuilder()
+	if len(criteria) > 0 {
+		queryBuilder.AndWhere(mapToExpression(queryBuilder, criteria))
+	}
+	return queryBuilder
+}
+
+// setTimestamp sets the field of entity mapped to column, if any, to now and returns now
+func (repository *defaultRepository) setTimestamp(entity reflect.Value, column string, now time.Time) interface{} {
+	for _, f := range repository.meta.fields {
+		if f.column != column {
+			continue
+		}
+		field := reflect.Indirect(entity).FieldByName(f.name)
+		switch field.Type() {
+		case reflect.TypeOf(now):
+			field.Set(reflect.ValueOf(now))
+		case reflect.TypeOf(&now):
+			field.Set(reflect.ValueOf(&now))
+		}
+	}
+	return now
+}
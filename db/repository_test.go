@@ -0,0 +1,167 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type Note struct {
+	ID        int64      `sql:"column:id"`
+	Title     string     `sql:"column:title"`
+	CreatedAt time.Time  `sql:"column:created_at"`
+	UpdatedAt time.Time  `sql:"column:updated_at"`
+	DeletedAt *time.Time `sql:"column:deleted_at"`
+}
+
+func TestRepository(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	repository, err := db.NewRepository(connection, "users", new(AppUser))
+	test.Fatal(t, err, nil)
+	user := new(AppUser)
+	test.Fatal(t, repository.FindOneBy(map[string]interface{}{"email": "jane.doe@acme.com"}, user), nil)
+	test.Fatal(t, user.Name, "Jane Doe")
+	test.Fatal(t, repository.Find(user.ID, user), nil)
+	test.Fatal(t, repository.Find(100, user), sql.ErrNoRows)
+	users := []*AppUser{}
+	test.Fatal(t, repository.FindBy(map[string]interface{}{"email LIKE": "%@acme.com"}, []string{"name DESC"}, 2, 1, &users), nil)
+	test.Fatal(t, userNames(users), "Jane Doe, Jack Doe")
+	count, err := repository.Count(map[string]interface{}{"name": "John Doe"})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+
+	user = &AppUser{Name: "Jim Doe", Email: "jim.doe@acme.com"}
+	test.Fatal(t, repository.Save(user), nil)
+	test.Fatal(t, user.ID != "", true)
+	user.Email = "jim@acme.com"
+	test.Fatal(t, repository.Save(user), nil)
+	test.Fatal(t, repository.FindOneBy(map[string]interface{}{"email": "jim@acme.com"}, new(AppUser)), nil)
+	test.Fatal(t, repository.Delete(user), nil)
+	count, err = repository.Count(nil)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 3)
+	test.Fatal(t, repository.Save(new(Note)), db.ErrInvalidEntityType)
+}
+
+func TestRepositoryContext(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	repository, err := db.NewRepository(connection, "users", new(AppUser))
+	test.Fatal(t, err, nil)
+	user := new(AppUser)
+	test.Fatal(t, repository.FindOneByContext(context.Background(), map[string]interface{}{"email": "jane.doe@acme.com"}, user), nil)
+	test.Fatal(t, user.Name, "Jane Doe")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	test.Fatal(t, repository.FindContext(ctx, user.ID, new(AppUser)), context.Canceled)
+	_, err = repository.CountContext(ctx, nil)
+	test.Fatal(t, err, context.Canceled)
+	test.Fatal(t, repository.SaveContext(ctx, &AppUser{Name: "Jim Doe", Email: "jim.doe@acme.com"}), context.Canceled)
+	test.Fatal(t, repository.DeleteContext(ctx, user), context.Canceled)
+	count, err := repository.Count(nil)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 3)
+}
+
+func TestRepositorySoftDeleteAndTimestamps(t *testing.T) {
+	connection := GetConnection(t)
+	_, err := connection.Exec(`CREATE TABLE notes(id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL,
+		created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL, deleted_at DATETIME)`)
+	test.Fatal(t, err, nil)
+	repository, err := db.NewRepository(connection, "notes", new(Note), db.RepositoryOptions{
+		SoftDeleteColumn: "deleted_at",
+		CreatedAtColumn:  "created_at",
+		UpdatedAtColumn:  "updated_at",
+	})
+	test.Fatal(t, err, nil)
+	note := &Note{Title: "first note"}
+	test.Fatal(t, repository.Save(note), nil)
+	test.Fatal(t, note.ID, int64(1))
+	test.Fatal(t, note.CreatedAt.IsZero(), false)
+	test.Fatal(t, note.UpdatedAt.Equal(note.CreatedAt), true)
+	created := note.CreatedAt
+	note.Title = "edited note"
+	test.Fatal(t, repository.Save(note), nil)
+	found := new(Note)
+	test.Fatal(t, repository.Find(note.ID, found), nil)
+	test.Fatal(t, found.Title, "edited note")
+	test.Fatal(t, found.CreatedAt.Equal(created), true)
+	test.Fatal(t, found.UpdatedAt.Before(created), false)
+
+	// soft deleted rows are excluded from queries
+	test.Fatal(t, repository.Delete(note), nil)
+	test.Fatal(t, note.DeletedAt != nil, true)
+	test.Fatal(t, repository.Find(note.ID, found), sql.ErrNoRows)
+	var count int
+	test.Fatal(t, connection.QueryRow("SELECT COUNT(*) FROM notes").GetSingleResult(&count), nil)
+	test.Fatal(t, count, 1)
+}
+
+type RepositoryCountry struct {
+	Code string `sql:"column:code,primaryKey"`
+	Name string `sql:"column:name"`
+}
+
+type RepositoryPost struct {
+	ID     int64    `sql:"column:id"`
+	Title  string   `sql:"column:title"`
+	Author *AppUser `sql:"manyToOne(targetEntity:users,joinColumn:author_id)"`
+}
+
+func TestRepositoryAssignedIDAndRelations(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	for _, query := range []string{
+		"CREATE TABLE countries(code VARCHAR(2) PRIMARY KEY, name VARCHAR(255))",
+		"CREATE TABLE posts(id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), author_id INTEGER)",
+	} {
+		_, err := connection.Exec(query)
+		test.Fatal(t, err, nil)
+	}
+	// entities with an identifier assigned by the application are inserted if no row has it
+	countries, err := db.NewRepository(connection, "countries", new(RepositoryCountry))
+	test.Fatal(t, err, nil)
+	country := &RepositoryCountry{Code: "FR", Name: "France"}
+	test.Fatal(t, countries.Save(country), nil)
+	test.Fatal(t, country.Code, "FR")
+	country.Name = "République française"
+	test.Fatal(t, countries.Save(country), nil)
+	found := new(RepositoryCountry)
+	test.Fatal(t, countries.Find("FR", found), nil)
+	test.Fatal(t, found.Name, "République française")
+	count, err := countries.Count(nil)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 1)
+
+	// manyToOne relations write the identifier of the related entity
+	posts, err := db.NewRepository(connection, "posts", new(RepositoryPost))
+	test.Fatal(t, err, nil)
+	post := &RepositoryPost{Title: "first", Author: &AppUser{ID: "2"}}
+	test.Fatal(t, posts.Save(post), nil)
+	var authorID int
+	test.Fatal(t, connection.QueryRow("SELECT author_id FROM posts WHERE id = ?", post.ID).GetSingleResult(&authorID), nil)
+	test.Fatal(t, authorID, 2)
+	post.Author = &AppUser{ID: "3"}
+	test.Fatal(t, posts.Save(post), nil)
+	test.Fatal(t, connection.QueryRow("SELECT author_id FROM posts WHERE id = ?", post.ID).GetSingleResult(&authorID), nil)
+	test.Fatal(t, authorID, 3)
+}
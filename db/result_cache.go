@@ -0,0 +1,377 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResultCache caches the results of queries, see QueryBuilder.UseCache.
+// Implementations must be safe for concurrent use.
+type ResultCache interface {
+	// Get returns the result cached for key, ok being false if no result is cached or it expired
+	Get(key string) (result *CachedResult, ok bool)
+	// Set caches the result of key for ttl, a ttl of 0 never expiring.
+	// The result is removed when one of its tags is invalidated.
+	Set(key string, result *CachedResult, ttl time.Duration, tags []string)
+	Delete(key string)
+	// InvalidateTags removes the results tagged with one of tags
+	InvalidateTags(tags ...string)
+	Clear()
+}
+
+// CachedResult is the result of a query, its rows being the values returned by the driver
+type CachedResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// memoryResultCache is a least recently used ResultCache
+type memoryResultCache struct {
+	capacity int
+	mutex    sync.Mutex
+	// entries are the cached results, the most recently used first
+	entries *list.List
+	keys    map[string]*list.Element
+	// tags are the keys of the results of each tag
+	tags map[string]map[string]bool
+}
+
+// memoryResultCacheEntry is a result of a memoryResultCache
+type memoryResultCacheEntry struct {
+	key    string
+	result *CachedResult
+	// expires is the expiration time of the result, zero if it never expires
+	expires time.Time
+	tags    []string
+}
+
+// NewMemoryResultCache returns an in memory ResultCache holding at most capacity results,
+// the least recently used results being evicted first
+//
+//	connection.SetResultCache(db.NewMemoryResultCache(1000))
+func NewMemoryResultCache(capacity int) ResultCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &memoryResultCache{capacity: capacity, entries: list.New(), keys: map[string]*list.Element{}, tags: map[string]map[string]bool{}}
+}
+
+func (cache *memoryResultCache) Get(key string) (*CachedResult, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.keys[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*memoryResultCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		cache.remove(element)
+		return nil, false
+	}
+	cache.entries.MoveToFront(element)
+	return entry.result, true
+}
+
+func (cache *memoryResultCache) Set(key string, result *CachedResult, ttl time.Duration, tags []string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.keys[key]; ok {
+		cache.remove(element)
+	}
+	entry := &memoryResultCacheEntry{key: key, result: result, tags: tags}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	cache.keys[key] = cache.entries.PushFront(entry)
+	for _, tag := range tags {
+		if cache.tags[tag] == nil {
+			cache.tags[tag] = map[string]bool{}
+		}
+		cache.tags[tag][key] = true
+	}
+	for cache.entries.Len() > cache.capacity {
+		cache.remove(cache.entries.Back())
+	}
+}
+
+func (cache *memoryResultCache) Delete(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.keys[key]; ok {
+		cache.remove(element)
+	}
+}
+
+func (cache *memoryResultCache) InvalidateTags(tags ...string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for _, tag := range tags {
+		for key := range cache.tags[tag] {
+			cache.remove(cache.keys[key])
+		}
+	}
+}
+
+func (cache *memoryResultCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries.Init()
+	cache.keys = map[string]*list.Element{}
+	cache.tags = map[string]map[string]bool{}
+}
+
+// remove removes element from the cache, the mutex being locked
+func (cache *memoryResultCache) remove(element *list.Element) {
+	entry := cache.entries.Remove(element).(*memoryResultCacheEntry)
+	delete(cache.keys, entry.key)
+	for _, tag := range entry.tags {
+		delete(cache.tags[tag], entry.key)
+		if len(cache.tags[tag]) == 0 {
+			delete(cache.tags, tag)
+		}
+	}
+}
+
+// cacheOptions are the options of a query cached with QueryBuilder.UseCache
+type cacheOptions struct {
+	key  string
+	ttl  time.Duration
+	tags []string
+}
+
+// cachingQueryer runs queries through a result cache
+type cachingQueryer interface {
+	// queryCached returns the cached result of a query, load running the query on a cache miss
+	queryCached(ctx context.Context, options *cacheOptions, query string, arguments []interface{}, load func() *Rows) *Rows
+}
+
+// SetResultCache sets the cache of the queries of the connection cached with QueryBuilder.UseCache,
+// nil disabling the cache. Writes through the connection invalidate the results of the tables written.
+func (connection *DefaultConnection) SetResultCache(cache ResultCache) {
+	connection.resultCache = cache
+	connection.resultCacheFlights = &singleFlight{calls: map[string]*flight{}}
+}
+
+// InvalidateCache removes the cached results tagged with one of tags, tables being tags
+// of the queries that read them
+func (connection *DefaultConnection) InvalidateCache(tags ...string) {
+	if connection.resultCache == nil || len(tags) == 0 {
+		return
+	}
+	atomic.AddUint64(&connection.resultCacheGeneration, 1)
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		normalized[i] = normalizeCacheTag(tag)
+	}
+	connection.resultCache.InvalidateTags(normalized...)
+}
+
+// invalidateWrittenTable invalidates the cached results of the table written by query, if any
+func (connection *DefaultConnection) invalidateWrittenTable(query string) {
+	if table := getWrittenTable(query); table != "" {
+		connection.InvalidateCache(table)
+	}
+}
+
+func (connection *DefaultConnection) queryCached(ctx context.Context, options *cacheOptions, query string, arguments []interface{}, load func() *Rows) *Rows {
+	cache := connection.resultCache
+	if cache == nil {
+		return load()
+	}
+	key := options.key
+	if key == "" {
+		key = getCacheKey(query, arguments)
+	}
+	if result, ok := cache.Get(key); ok {
+		return replayResult(ctx, result)
+	}
+	// concurrent misses of a key wait for a single query
+	result, err := connection.resultCacheFlights.do(key, func() (*CachedResult, error) {
+		// the result may have been cached by a query that ended since the cache was read
+		if result, ok := cache.Get(key); ok {
+			return result, nil
+		}
+		generation := atomic.LoadUint64(&connection.resultCacheGeneration)
+		result, err := readResult(load())
+		// a result read while its tables were written may be stale
+		if err == nil && atomic.LoadUint64(&connection.resultCacheGeneration) == generation {
+			cache.Set(key, result, options.ttl, options.tags)
+		}
+		return result, err
+	})
+	if err != nil {
+		return &Rows{err: err}
+	}
+	return replayResult(ctx, result)
+}
+
+// getCacheKey derives the cache key of a query from its SQL and its arguments
+func getCacheKey(query string, arguments []interface{}) string {
+	hash := sha256.Sum256([]byte(query + "\x00" + fmt.Sprintf("%#v", arguments)))
+	return "tiger:" + hex.EncodeToString(hash[:])
+}
+
+// writtenTableRegexp matches the table written by an INSERT, UPDATE, DELETE, REPLACE or TRUNCATE statement
+var writtenTableRegexp = regexp.MustCompile(`(?is)^\s*(?:INSERT\s+(?:OR\s+\w+\s+)?INTO|INSERT|UPDATE|DELETE\s+FROM|DELETE|REPLACE\s+INTO|TRUNCATE(?:\s+TABLE)?|MERGE\s+INTO)\s+([^\s(;,]+)`)
+
+// getWrittenTable returns the table written by query, "" if query is not a write statement
+func getWrittenTable(query string) string {
+	match := writtenTableRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// normalizeCacheTag returns the tag of a table name, unquoted, unqualified and lower cased
+func normalizeCacheTag(tag string) string {
+	if index := strings.LastIndex(tag, "."); index != -1 {
+		tag = tag[index+1:]
+	}
+	return strings.ToLower(strings.Trim(tag, "\"`[]"))
+}
+
+// readResult reads the rows of a query
+func readResult(rows *Rows) (*CachedResult, error) {
+	if rows.err != nil {
+		return nil, rows.err
+	}
+	defer rows.rows.Close()
+	columns, err := rows.rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &CachedResult{Columns: columns, Rows: [][]interface{}{}}
+	for rows.rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			// values scanned into *interface{} are the values of the driver, []byte being copied
+			pointers[i] = &values[i]
+		}
+		if err := rows.rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.rows.Err()
+}
+
+// singleFlight runs a single call of the function of a key at a time,
+// concurrent callers waiting for its result
+type singleFlight struct {
+	mutex sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is a call in progress of a singleFlight
+type flight struct {
+	wait   sync.WaitGroup
+	result *CachedResult
+	err    error
+}
+
+func (group *singleFlight) do(key string, fn func() (*CachedResult, error)) (*CachedResult, error) {
+	group.mutex.Lock()
+	if call, ok := group.calls[key]; ok {
+		group.mutex.Unlock()
+		call.wait.Wait()
+		return call.result, call.err
+	}
+	call := &flight{}
+	call.wait.Add(1)
+	group.calls[key] = call
+	group.mutex.Unlock()
+	defer func() {
+		group.mutex.Lock()
+		delete(group.calls, key)
+		group.mutex.Unlock()
+		call.wait.Done()
+	}()
+	call.result, call.err = fn()
+	return call.result, call.err
+}
+
+var (
+	// replayDB reads cached results as *sql.Rows, so that they are scanned like the rows of the database
+	replayDB     *sql.DB
+	replayDBOnce sync.Once
+)
+
+// replayResult returns the rows of a cached result
+func replayResult(ctx context.Context, result *CachedResult) *Rows {
+	replayDBOnce.Do(func() {
+		replayDB = sql.OpenDB(replayConnector{})
+	})
+	rows, err := replayDB.QueryContext(ctx, "", result)
+	return &Rows{rows: rows, err: err}
+}
+
+// replayConnector connects to replayDB
+type replayConnector struct{}
+
+func (replayConnector) Connect(context.Context) (driver.Conn, error) { return replayConn{}, nil }
+func (replayConnector) Driver() driver.Driver                        { return replayDriver{} }
+
+type replayDriver struct{}
+
+func (replayDriver) Open(string) (driver.Conn, error) { return replayConn{}, nil }
+
+// replayConn returns the rows of the cached result given as argument of a query
+type replayConn struct{}
+
+func (replayConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (replayConn) Close() error                        { return nil }
+func (replayConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+// CheckNamedValue accepts cached results as arguments
+func (replayConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (replayConn) QueryContext(ctx context.Context, query string, arguments []driver.NamedValue) (driver.Rows, error) {
+	return &replayRows{result: arguments[0].Value.(*CachedResult)}, nil
+}
+
+// replayRows are the rows of a cached result
+type replayRows struct {
+	result *CachedResult
+	index  int
+}
+
+func (rows *replayRows) Columns() []string { return rows.result.Columns }
+func (rows *replayRows) Close() error      { return nil }
+
+func (rows *replayRows) Next(destination []driver.Value) error {
+	if rows.index >= len(rows.result.Rows) {
+		return io.EOF
+	}
+	for i, value := range rows.result.Rows[rows.index] {
+		destination[i] = value
+	}
+	rows.index++
+	return nil
+}
@@ -0,0 +1,143 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestQueryBuilderUseCache(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	var queries int64
+	connection.Options.Observers = []db.QueryObserver{db.QueryObserverFuncs{Before: func(event *db.QueryEvent) {
+		if strings.HasPrefix(event.SQL, "SELECT") {
+			atomic.AddInt64(&queries, 1)
+		}
+	}}}
+	connection.SetResultCache(db.NewMemoryResultCache(10))
+	count := func() (count int) {
+		test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(*)").From("users").
+			UseCache("", 0).QueryRow().GetSingleResult(&count), nil)
+		return count
+	}
+	test.Fatal(t, count(), 3)
+	// writes that bypass the connection don't invalidate the cache
+	_, err := connection.DB().Exec("INSERT INTO users(name, email) VALUES('Jim Doe', 'jim.doe@acme.com')")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count(), 3)
+	test.Fatal(t, atomic.LoadInt64(&queries), int64(1))
+
+	// cached rows are mapped like the rows of the database
+	users := []*AppUser{}
+	query := connection.CreateQueryBuilder().Select("id", "name", "email").From("users").OrderBy("id").UseCache("users", time.Minute)
+	test.Fatal(t, query.Query().GetResults(&users), nil)
+	users = []*AppUser{}
+	test.Fatal(t, query.Query().GetResults(&users), nil)
+	test.Fatal(t, len(users), 4)
+	test.Fatal(t, users[3].Name, "Jim Doe")
+	test.Fatal(t, atomic.LoadInt64(&queries), int64(2))
+
+	// writes through the connection invalidate the results of the table
+	_, err = connection.Delete("users", map[string]interface{}{"name": "Jim Doe"})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count(), 3)
+	transaction, err := connection.Begin()
+	test.Fatal(t, err, nil)
+	_, err = transaction.Exec("INSERT INTO users(name, email) VALUES('Jim Doe', 'jim.doe@acme.com')")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, transaction.Commit(), nil)
+	test.Fatal(t, count(), 4)
+	test.Fatal(t, atomic.LoadInt64(&queries), int64(4))
+
+	// failed writes don't invalidate the cache
+	_, err = connection.Exec("INSERT INTO users(id, name, email) VALUES(1, 'Jim Doe', 'jim.doe@acme.com')")
+	test.Fatal(t, err != nil, true)
+	_, err = connection.PrepareContext(context.Background(), "INSERT INTO users(id, name, email) VALUES(1, 'Jim Doe', 'jim.doe@acme.com')").Exec()
+	test.Fatal(t, err != nil, true)
+	test.Fatal(t, count(), 4)
+	test.Fatal(t, atomic.LoadInt64(&queries), int64(4))
+
+	// concurrent queries of an uncached result run a single query
+	connection.InvalidateCache("USERS")
+	wait := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			var names []map[string]interface{}
+			if err := query.Query().GetResults(&names); err != nil || len(names) != 4 {
+				t.Errorf("Error got %v %v", names, err)
+			}
+		}()
+	}
+	wait.Wait()
+	test.Fatal(t, atomic.LoadInt64(&queries), int64(5))
+}
+
+func TestQueryBuilderUseCacheSubqueries(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	connection.SetResultCache(db.NewMemoryResultCache(10))
+	users := connection.CreateQueryBuilder().Select("id").From("users")
+	fromSubquery := func() (count int) {
+		test.Fatal(t, connection.CreateQueryBuilder().Select("COUNT(*)").FromSubquery(users, "u").
+			UseCache("", 0).QueryRow().GetSingleResult(&count), nil)
+		return count
+	}
+	fromCTE := func() (count int) {
+		test.Fatal(t, connection.CreateQueryBuilder().With("u", users).Select("COUNT(*)").From("u").
+			UseCache("", 0).QueryRow().GetSingleResult(&count), nil)
+		return count
+	}
+	test.Fatal(t, fromSubquery(), 3)
+	test.Fatal(t, fromCTE(), 3)
+	// the tables of subqueries and common table expressions invalidate the results of the query
+	_, err := connection.Insert("users", map[string]interface{}{"name": "Jim Doe", "email": "jim.doe@acme.com"})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, fromSubquery(), 4)
+	test.Fatal(t, fromCTE(), 4)
+}
+
+func TestMemoryResultCache(t *testing.T) {
+	cache := db.NewMemoryResultCache(2)
+	result := &db.CachedResult{Columns: []string{"id"}, Rows: [][]interface{}{{int64(1)}}}
+	cache.Set("a", result, 0, []string{"users"})
+	cache.Set("b", result, 0, []string{"articles"})
+	_, ok := cache.Get("a")
+	test.Fatal(t, ok, true)
+	// the least recently used result is evicted
+	cache.Set("c", result, 0, nil)
+	_, ok = cache.Get("b")
+	test.Fatal(t, ok, false)
+	cache.InvalidateTags("users")
+	_, ok = cache.Get("a")
+	test.Fatal(t, ok, false)
+	cache.Set("d", result, time.Nanosecond, nil)
+	time.Sleep(time.Millisecond)
+	_, ok = cache.Get("d")
+	test.Fatal(t, ok, false)
+	cached, ok := cache.Get("c")
+	test.Fatal(t, ok && cached == result, true)
+}
@@ -15,9 +15,14 @@
 package db
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Mparaiso/go-tiger/logger"
 )
@@ -45,28 +50,10 @@ func MapRowsToSliceOfSlices(scanner RowsScanner, Slices *[][]interface{}) error
 		if err != nil {
 			return err
 		}
-		sliceOfResults := make([]interface{}, len(columns))
-		for i := range columns {
-			// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
-			// create a new interface{} than is not nil
-			sliceOfResults[i] = new(interface{})
-		}
-		err = scanner.Scan(sliceOfResults...)
+		row, err := MapRowToSlice(columns, scanner)
 		if err != nil {
 			return err
 		}
-		row := []interface{}{}
-		for index := range columns {
-			// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
-			// convert the sliceOfResults[index] value back to interface{}
-			var v interface{} = *(sliceOfResults[index].(*interface{}))
-			if u, ok := v.([]uint8); ok {
-				// v is likely a string, so convert to string
-				row = append(row, (interface{}(string(u))))
-			} else {
-				row = append(row, v)
-			}
-		}
 		*Slices = append(*Slices, row)
 	}
 	return scanner.Err()
@@ -81,34 +68,55 @@ func MapRowsToSliceOfMaps(scanner RowsScanner, Map *[]map[string]interface{}) er
 		if err != nil {
 			return err
 		}
-		sliceOfResults := make([]interface{}, len(columns))
-		for i := range columns {
-			// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
-			// create a new interface{} than is not nil
-			sliceOfResults[i] = new(interface{})
-		}
-		err = scanner.Scan(sliceOfResults...)
+		row, err := MapRowToMap(columns, scanner)
 		if err != nil {
 			return err
 		}
-		row := map[string]interface{}{}
-		for index, column := range columns {
-			// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
-			// convert the sliceOfResults[index] value back to interface{}
-			var v interface{} = *(sliceOfResults[index].(*interface{}))
-			if u, ok := v.([]uint8); ok {
-				// v is likely a string, so convert to string
-				row[column] = (interface{}(string(u)))
-			} else {
-				row[column] = v
-			}
-		}
 		*Map = append(*Map, row)
 	}
 	return scanner.Err()
 }
 
-// MapRowsToSliceOfStruct  maps db rows to structs
+// MapRowToSlice maps a db row to a slice of values in the order of columns
+func MapRowToSlice(columns []string, scanner Scanner) ([]interface{}, error) {
+	sliceOfResults := make([]interface{}, len(columns))
+	for i := range columns {
+		// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
+		// create a new interface{} than is not nil
+		sliceOfResults[i] = new(interface{})
+	}
+	if err := scanner.Scan(sliceOfResults...); err != nil {
+		return nil, err
+	}
+	row := []interface{}{}
+	for index := range columns {
+		// @see https://github.com/jmoiron/sqlx/blob/398dd5876282499cdfd4cb8ea0f31a672abe9495/sqlx.go#L751
+		// convert the sliceOfResults[index] value back to interface{}
+		var v interface{} = *(sliceOfResults[index].(*interface{}))
+		if u, ok := v.([]uint8); ok {
+			// v is likely a string, so convert to string
+			row = append(row, (interface{}(string(u))))
+		} else {
+			row = append(row, v)
+		}
+	}
+	return row, nil
+}
+
+// MapRowToMap maps a db row to a map, the map keys being the column names
+func MapRowToMap(columns []string, scanner Scanner) (map[string]interface{}, error) {
+	values, err := MapRowToSlice(columns, scanner)
+	if err != nil {
+		return nil, err
+	}
+	row := map[string]interface{}{}
+	for index, column := range columns {
+		row[column] = values[index]
+	}
+	return row, nil
+}
+
+// MapRowsToSliceOfStruct  maps db rows to structs, see MapRowToStruct
 func MapRowsToSliceOfStruct(scanner RowsScanner, pointerToASliceOfStructs interface{}, ignoreMissingField bool, transforms ...func(string) string) error {
 	defer scanner.Close()
 	recordsPointerValue := reflect.ValueOf(pointerToASliceOfStructs)
@@ -124,33 +132,28 @@ func MapRowsToSliceOfStruct(scanner RowsScanner, pointerToASliceOfStructs interf
 	if err != nil {
 		return err
 	}
-
 	// get the underlying type of a slice
 	// @see http://stackoverflow.com/questions/24366895/golang-reflect-slice-underlying-type
+	var t reflect.Type
+	isSliceOfPointers := recordsValue.Type().Elem().Kind() == reflect.Ptr
+	if isSliceOfPointers {
+		// the sliceOfStructs type is like []*T
+		t = recordsValue.Type().Elem().Elem()
+	} else {
+		// the sliceOfStructs type is like []T
+		t = recordsValue.Type().Elem()
+	}
 	for scanner.Next() {
-		//
-		var t reflect.Type
-		if recordsValue.Type().Elem().Kind() == reflect.Ptr {
-			// the sliceOfStructs type is like []*T
-			t = recordsValue.Type().Elem().Elem()
-		} else {
-			// the sliceOfStructs type is like []T
-			t = recordsValue.Type().Elem()
-		}
 		pointerOfElement := reflect.New(t)
-		if len(transforms) == 0 {
-			// a default transform will be added , and will correlate the name of a field with a sql struct tag
-			tagMapper, err := CreateTagMapperFunc(pointerOfElement.Interface())
-			if err != nil {
-				return err
-			}
-			transforms = []func(string) string{tagMapper}
-		}
 		err = MapRowToStruct(columns, scanner, pointerOfElement.Interface(), ignoreMissingField, transforms...)
 		if err != nil {
 			return err
 		}
-		recordsValue = reflect.Append(recordsValue, pointerOfElement)
+		if isSliceOfPointers {
+			recordsValue = reflect.Append(recordsValue, pointerOfElement)
+		} else {
+			recordsValue = reflect.Append(recordsValue, pointerOfElement.Elem())
+		}
 	}
 	recordsPointerValue.Elem().Set(recordsValue)
 	return scanner.Err()
@@ -158,8 +161,14 @@ func MapRowsToSliceOfStruct(scanner RowsScanner, pointerToASliceOfStructs interf
 
 // MapRowToStruct  automatically maps a db row to a struct.
 //
-// columns are the names of the columns in the row, they should match the fieldnames of Struct unless an optional transform function
-// is passed.
+// columns are the names of the columns in the row. A column is mapped to the field whose sql struct tag
+// column or name matches the column, then to the field matching the column regardless of case.
+// The fields of embedded structs are mapped as the fields of Struct, the fields of nested
+// structs are mapped by the columns prefixed with the name of the nested struct field
+// and a dot or an underscore, like author.id or author_id for Author.ID.
+// Nested struct pointers are only allocated when one of their columns is not NULL,
+// struct types implementing sql.Scanner are scanned like any other value.
+// Fields tagged json or array are read with JSON or Array.
 //
 // scanner is the Scanner (a sql.Row type for instance).
 //
@@ -169,42 +178,187 @@ func MapRowsToSliceOfStruct(scanner RowsScanner, pointerToASliceOfStructs interf
 //
 // transforms is an optinal function that changes the name of the columns to match the name of the fields.
 func MapRowToStruct(columns []string, scanner Scanner, Struct interface{}, ignoreMissingFields bool, transforms ...func(string) string) error {
-	if len(transforms) == 0 {
-		transforms = []func(string) string{noop}
-	}
 	structPointer := reflect.ValueOf(Struct)
 	if structPointer.Kind() != reflect.Ptr {
 		return fmt.Errorf("Pointer expected, got %#v", Struct)
 	}
 	structValue := reflect.Indirect(structPointer)
-	zeroValue := reflect.Value{}
+	if structValue.Kind() != reflect.Struct {
+		return fmt.Errorf("Pointer to a struct expected, got %#v", Struct)
+	}
+	fields := getStructFields(structValue.Type())
 	arrayOfResults := []interface{}{}
+	// nested holds the values of the fields of nested struct pointers, set once scanned
+	nested := map[*structField]reflect.Value{}
 	for _, column := range columns {
-		column = transforms[0](column)
-		field := structValue.FieldByName(column)
-		if field == zeroValue {
-			if ignoreMissingFields {
-				pointer := reflect.New(reflect.TypeOf([]byte{}))
-				arrayOfResults = append(arrayOfResults, pointer.Interface())
-			} else {
-				return fmt.Errorf("No field found for column %s in struct %#v", column, Struct)
-
-			}
-		} else {
-			if !field.CanSet() {
-				return fmt.Errorf("Unexported field %s cannot be set in struct %#v", column, Struct)
-			}
-			arrayOfResults = append(arrayOfResults, field.Addr().Interface())
+		if len(transforms) > 0 {
+			column = transforms[0](column)
+		}
+		field, ok := fields.lookup(column)
+		switch {
+		case !ok && ignoreMissingFields:
+			pointer := reflect.New(reflect.TypeOf([]byte{}))
+			arrayOfResults = append(arrayOfResults, pointer.Interface())
+		case !ok:
+			return fmt.Errorf("No field found for column %s in struct %#v", column, Struct)
+		case field.indirect:
+			holder := reflect.New(reflect.PtrTo(field.Type))
+			nested[field] = holder
+			arrayOfResults = append(arrayOfResults, wrapColumnValue(holder.Interface(), field.json, field.array))
+		default:
+			arrayOfResults = append(arrayOfResults, wrapColumnValue(structValue.FieldByIndex(field.Index).Addr().Interface(), field.json, field.array))
 		}
 	}
 	err := scanner.Scan(arrayOfResults...)
 	if err != nil {
 		return err
 	}
+	for field, holder := range nested {
+		if !holder.Elem().IsNil() {
+			field.allocate(structValue).Set(holder.Elem().Elem())
+		}
+	}
 	return nil
 }
 
-func noop(s string) string { return s }
+// structField is a field of a struct, or of a struct nested in a struct
+type structField struct {
+	Index []int
+	Type  reflect.Type
+	// indirect is true if the field belongs to a nested struct pointer
+	indirect bool
+	// json and array are true if the column is read with JSON or Array
+	json, array bool
+}
+
+// allocate returns the field in structValue, allocating the nested struct pointers
+func (field *structField) allocate(structValue reflect.Value) reflect.Value {
+	value := structValue
+	for i, index := range field.Index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(index)
+	}
+	return value
+}
+
+// structFields are the fields of a struct type by column
+type structFields struct {
+	columns map[string]*structField
+	// lowerCaseColumns are the fields by lower case column
+	lowerCaseColumns map[string]*structField
+}
+
+func (fields structFields) lookup(column string) (*structField, bool) {
+	if field, ok := fields.columns[column]; ok {
+		return field, true
+	}
+	field, ok := fields.lowerCaseColumns[strings.ToLower(column)]
+	return field, ok
+}
+
+// structFieldsCache caches the fields of struct types so that
+// struct types are only inspected once
+var structFieldsCache sync.Map
+
+// getStructFields returns the fields of a struct type by column
+func getStructFields(Type reflect.Type) structFields {
+	if fields, ok := structFieldsCache.Load(Type); ok {
+		return fields.(structFields)
+	}
+	fields := structFields{columns: map[string]*structField{}, lowerCaseColumns: map[string]*structField{}}
+	collectStructFields(fields, Type, []string{""}, nil, false, map[reflect.Type]bool{Type: true})
+	structFieldsCache.Store(Type, fields)
+	return fields
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// collectStructFields collects the fields of Type, the columns of the fields being prefixed with prefixes.
+// The fields of Type shadow the fields of its embedded and nested structs.
+func collectStructFields(fields structFields, Type reflect.Type, prefixes []string, index []int, indirect bool, visited map[reflect.Type]bool) {
+	type nestedStruct struct {
+		Field    reflect.StructField
+		Type     reflect.Type
+		prefixes []string
+	}
+	nestedStructs := []nestedStruct{}
+	for i := 0; i < Type.NumField(); i++ {
+		Field := Type.Field(i)
+		stringTag := Field.Tag.Get("sql")
+		if (Field.PkgPath != "" && !Field.Anonymous) || stringTag == "-" || strings.Contains(stringTag, "(") {
+			// unexported fields and relations are not mapped
+			continue
+		}
+		tag := SQLStructTagBuilder{}.BuildFromString(stringTag)
+		name := Field.Name
+		if tag.ColumnName != "" {
+			name = tag.ColumnName
+		}
+		fieldType := Field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && !tag.JSON && !isScannedAsAValue(Field.Type) && !visited[fieldType] {
+			if Field.Anonymous {
+				if Field.PkgPath != "" && Field.Type.Kind() == reflect.Ptr {
+					// a pointer to an unexported struct can't be allocated
+					continue
+				}
+				nestedStructs = append(nestedStructs, nestedStruct{Field, fieldType, prefixes})
+			} else {
+				nestedPrefixes := []string{}
+				for _, prefix := range prefixes {
+					nestedPrefixes = append(nestedPrefixes, prefix+name+".", prefix+name+"_")
+				}
+				nestedStructs = append(nestedStructs, nestedStruct{Field, fieldType, nestedPrefixes})
+			}
+			continue
+		}
+		if Field.PkgPath != "" {
+			continue
+		}
+		field := &structField{Index: append(append([]int{}, index...), i), Type: Field.Type, indirect: indirect, json: tag.JSON, array: tag.Array}
+		for _, prefix := range prefixes {
+			fields.add(prefix+name, field)
+			if name != Field.Name {
+				fields.add(prefix+Field.Name, field)
+			}
+		}
+	}
+	for _, nested := range nestedStructs {
+		visited[nested.Type] = true
+		collectStructFields(fields, nested.Type, nested.prefixes, append(append([]int{}, index...), nested.Field.Index...),
+			indirect || nested.Field.Type.Kind() == reflect.Ptr, visited)
+		delete(visited, nested.Type)
+	}
+}
+
+func (fields structFields) add(column string, field *structField) {
+	if _, ok := fields.columns[column]; !ok {
+		fields.columns[column] = field
+	}
+	if _, ok := fields.lowerCaseColumns[strings.ToLower(column)]; !ok {
+		fields.lowerCaseColumns[strings.ToLower(column)] = field
+	}
+}
+
+// isScannedAsAValue returns true if values of Type are scanned as a single column
+func isScannedAsAValue(Type reflect.Type) bool {
+	if Type.Kind() == reflect.Ptr {
+		Type = Type.Elem()
+	}
+	return Type == timeType || Type.Implements(scannerType) || reflect.PtrTo(Type).Implements(scannerType) ||
+		Type.Implements(valuerType)
+}
 
 // CreateTagMapperFunc creates a function that
 // can be used to map db fields to struct fields
@@ -220,7 +374,8 @@ func noop(s string) string { return s }
 //      tagMapper := CreateTagMapperFunc(Foo{})
 //      err := MapRowToStruct([]string{"bar"},someRow,foo,true,tagMapper)
 //
-// Will map Bar field in struct to bar DB field in the row
+// Will map Bar field in struct to bar DB field in the row.
+// The columns of the fields of embedded structs are mapped to the names of the promoted fields.
 func CreateTagMapperFunc(Struct interface{}, tagname ...string) (func(string) string, error) {
 	structValue := reflect.Indirect(reflect.ValueOf(Struct))
 	if structValue.Kind() != reflect.Struct {
@@ -230,10 +385,33 @@ func CreateTagMapperFunc(Struct interface{}, tagname ...string) (func(string) st
 		tagname = []string{"sql"}
 	}
 	m := map[string]string{}
-	for i := 0; i < structValue.NumField(); i++ {
-		name := structValue.Type().Field(i).Name
+	collectTagMappings(m, structValue.Type(), tagname[0])
+	return func(s string) string {
+		if r, ok := m[s]; ok {
+			return r
+		}
+		return s
+	}, nil
+}
+
+// collectTagMappings maps the columns of the fields of Type to their names,
+// the fields of Type shadowing the fields of its embedded structs
+func collectTagMappings(m map[string]string, Type reflect.Type, tagname string) {
+	embedded := []reflect.Type{}
+	for i := 0; i < Type.NumField(); i++ {
+		Field := Type.Field(i)
+		if Field.Anonymous {
+			if fieldType := Field.Type; fieldType.Kind() == reflect.Struct || (fieldType.Kind() == reflect.Ptr && fieldType.Elem().Kind() == reflect.Struct) {
+				if fieldType.Kind() == reflect.Ptr {
+					fieldType = fieldType.Elem()
+				}
+				embedded = append(embedded, fieldType)
+				continue
+			}
+		}
+		name := Field.Name
 		// There can be multiple tags in the struct tag, always only check the first tag
-		stringTags := structValue.Type().Field(i).Tag.Get(tagname[0])
+		stringTags := Field.Tag.Get(tagname)
 		tags := SQLStructTagBuilder{}.BuildFromString(stringTags)
 		if tags.ColumnName != "" {
 			m[tags.ColumnName] = name
@@ -241,18 +419,63 @@ func CreateTagMapperFunc(Struct interface{}, tagname ...string) (func(string) st
 			m[name] = name
 		}
 	}
-	return func(s string) string {
-		if r, ok := m[s]; ok {
-			return r
+	for _, Type := range embedded {
+		mappings := map[string]string{}
+		collectTagMappings(mappings, Type, tagname)
+		for column, name := range mappings {
+			if _, ok := m[column]; !ok {
+				m[column] = name
+			}
 		}
-		return s
-	}, nil
+	}
 }
 
 // SQLStructTag is the type representation of sql struct tag
+//
+//	`sql:"column:name,type:string,size:100,nullable,default:'anonymous',unique"`
+//	`sql:"column:tags,json"`
 type SQLStructTag struct {
 	ColumnName       string
 	PersistZeroValue bool
+	// PrimaryKey marks the field holding the identifier of an entity
+	PrimaryKey bool
+	// References is the table a foreign key column points to
+	References string
+	// Type is the column type, either a portable type like string or a native type.
+	// By default it is derived from the type of the field.
+	Type string
+	// Size is the length of a string column
+	Size int
+	// Precision and Scale are the number of digits of a decimal column
+	Precision int
+	Scale     int
+	// Nullable allows NULL values, pointers and sql.Null types are always nullable
+	Nullable bool
+	// Default is the SQL expression of the default value
+	Default       string
+	AutoIncrement bool
+	// NoAutoIncrement is set by autoincrement:false, so that a single integer
+	// primary key is not auto incremented
+	NoAutoIncrement bool
+	// Unique adds a unique index on the column
+	Unique bool
+	// Index adds an index on the column
+	Index bool
+	// IndexName is the name of the index or of the unique index,
+	// columns sharing an index name are indexed together.
+	IndexName string
+	// JSON marshals the field to JSON when written and unmarshals it when read, see JSON
+	JSON bool
+	// Array writes and reads a slice field as a PostgreSQL array, see Array
+	Array bool
+	// Version marks the integer field holding the version of a row, updates of a struct
+	// with a version field fail with ErrOptimisticLock if the version of the row changed.
+	Version bool
+}
+
+// wrapValue wraps the value of a field tagged json or array with JSON or Array
+func (tag SQLStructTag) wrapValue(value interface{}) interface{} {
+	return wrapColumnValue(value, tag.JSON, tag.Array)
 }
 
 // SQLStructTagBuilder is a SQLStructTag build
@@ -268,7 +491,7 @@ func (builder SQLStructTagBuilder) log(args ...interface{}) {
 
 // BuildFromString builds a SQLStructTag from a string
 func (builder SQLStructTagBuilder) BuildFromString(data string) SQLStructTag {
-	datas := strings.Split(data, ",")
+	datas := splitTag(data)
 	builder.log("datas:", fmt.Sprint(data))
 	tag := SQLStructTag{}
 	for _, data := range datas {
@@ -280,9 +503,65 @@ func (builder SQLStructTagBuilder) BuildFromString(data string) SQLStructTag {
 			builder.log("\t\tcolumn:", fmt.Sprint(tag.ColumnName))
 
 		}
-		if strings.TrimSpace(strings.ToLower(data)) == "persistzerovalue" {
+		if strings.HasPrefix(data, "references:") {
+			tag.References = strings.TrimSpace(strings.TrimPrefix(data, "references:"))
+		}
+		key, value := data, ""
+		if index := strings.Index(data, ":"); index != -1 && !strings.Contains(data[:index], "(") {
+			key, value = data[:index], strings.TrimSpace(data[index+1:])
+		}
+		switch strings.TrimSpace(strings.ToLower(key)) {
+		case "persistzerovalue":
 			tag.PersistZeroValue = true
+		case "primarykey":
+			tag.PrimaryKey = true
+		case "type":
+			tag.Type = value
+		case "size":
+			tag.Size, _ = strconv.Atoi(value)
+		case "precision":
+			tag.Precision, _ = strconv.Atoi(value)
+		case "scale":
+			tag.Scale, _ = strconv.Atoi(value)
+		case "nullable":
+			tag.Nullable = true
+		case "default":
+			tag.Default = value
+		case "autoincrement":
+			tag.AutoIncrement = strings.ToLower(value) != "false"
+			tag.NoAutoIncrement = !tag.AutoIncrement
+		case "unique":
+			tag.Unique, tag.IndexName = true, value
+		case "index":
+			tag.Index, tag.IndexName = true, value
+		case "json":
+			tag.JSON = true
+		case "array":
+			tag.Array = true
+		case "version":
+			tag.Version = true
 		}
 	}
 	return tag
 }
+
+// splitTag splits a sql struct tag on commas that are not
+// enclosed in parentheses, so relation definitions like
+// manyToOne(targetEntity:users,joinColumn:author_id) are kept whole.
+func splitTag(data string) (parts []string) {
+	depth, start := 0, 0
+	for i, character := range data {
+		switch character {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, data[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, data[start:])
+}
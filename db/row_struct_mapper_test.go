@@ -17,6 +17,7 @@ package db_test
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -59,6 +60,66 @@ func TestMapRowsToSliceOfStruct(t *testing.T) {
 	})
 }
 
+// upperCase is a custom sql.Scanner
+type upperCase string
+
+func (value *upperCase) Scan(source interface{}) error {
+	switch source := source.(type) {
+	case []byte:
+		*value = upperCase(strings.ToUpper(string(source)))
+	case string:
+		*value = upperCase(strings.ToUpper(source))
+	default:
+		return fmt.Errorf("unexpected value %#v", source)
+	}
+	return nil
+}
+
+func TestMapRowsToSliceOfNestedStructs(t *testing.T) {
+	type Timestamps struct {
+		Created *time.Time `sql:"column:created"`
+	}
+	type Author struct {
+		ID   int64  `sql:"column:id"`
+		Name string `sql:"column:name"`
+	}
+	type Article struct {
+		ID       int64     `sql:"column:id"`
+		Title    upperCase `sql:"column:title"`
+		Subtitle *string   `sql:"column:subtitle"`
+		Timestamps
+		Author *Author
+		Editor Author `sql:"column:editor"`
+	}
+	connection, err := sql.Open("sqlite3", ":memory:")
+	test.Fatal(t, err, nil)
+	defer connection.Close()
+	for _, statement := range []string{
+		"CREATE TABLE authors(id INTEGER PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE articles(id INTEGER PRIMARY KEY, title TEXT NOT NULL, subtitle TEXT, author_id INTEGER, created TIMESTAMP NOT NULL DEFAULT(DATETIME('now')))",
+		"INSERT INTO authors(id, name) VALUES(1, 'john doe')",
+		"INSERT INTO articles(id, title, subtitle, author_id) VALUES(1, 'first article', 'first', 1), (2, 'second article', NULL, NULL)",
+	} {
+		_, err := connection.Exec(statement)
+		test.Fatal(t, err, nil)
+	}
+	rows, err := connection.Query(`SELECT articles.id, title, subtitle, created, authors.id AS "author.id", authors.name AS author_name,
+		'jane doe' AS editor_name FROM articles LEFT JOIN authors ON authors.id = articles.author_id ORDER BY articles.id`)
+	test.Fatal(t, err, nil)
+	articles := []Article{}
+	test.Fatal(t, mapper.MapRowsToSliceOfStruct(rows, &articles, false), nil)
+	test.Fatal(t, len(articles), 2)
+	test.Fatal(t, articles[0].Title, upperCase("FIRST ARTICLE"))
+	test.Fatal(t, *articles[0].Subtitle, "first")
+	test.Fatal(t, articles[0].Created != nil, true)
+	test.Fatal(t, articles[0].Author.ID, int64(1))
+	test.Fatal(t, articles[0].Author.Name, "john doe")
+	test.Fatal(t, articles[0].Editor.Name, "jane doe")
+	// NULL values leave pointers nil
+	test.Fatal(t, articles[1].Subtitle == nil, true)
+	test.Fatal(t, articles[1].Author == nil, true)
+}
+
 func TestSQLTagBuilder(t *testing.T) {
 	tag := "column:nick_name"
 	sqlTag := db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
@@ -68,6 +129,31 @@ func TestSQLTagBuilder(t *testing.T) {
 	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
 	test.Fatal(t, sqlTag.ColumnName, "email_address")
 	test.Fatal(t, sqlTag.PersistZeroValue, true)
+	tag = "column:author_id,references:users"
+	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
+	test.Fatal(t, sqlTag.References, "users")
+	test.Fatal(t, sqlTag.PrimaryKey, false)
+	tag = "column:id,primaryKey"
+	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
+	test.Fatal(t, sqlTag.PrimaryKey, true)
+	tag = "column:price,type:decimal,precision:8,scale:2,default:0,index:products_price_idx"
+	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
+	test.Fatal(t, sqlTag.Type, "decimal")
+	test.Fatal(t, sqlTag.Precision, 8)
+	test.Fatal(t, sqlTag.Scale, 2)
+	test.Fatal(t, sqlTag.Default, "0")
+	test.Fatal(t, sqlTag.Index, true)
+	test.Fatal(t, sqlTag.IndexName, "products_price_idx")
+	tag = "column:created_at,nullable,default:DATETIME('now')"
+	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
+	test.Fatal(t, sqlTag.Nullable, true)
+	test.Fatal(t, sqlTag.Default, "DATETIME('now')")
+	tag = "column:code,size:20,unique,autoIncrement"
+	sqlTag = db.SQLStructTagBuilder{logger.NewTestLogger(t)}.BuildFromString(tag)
+	test.Fatal(t, sqlTag.Size, 20)
+	test.Fatal(t, sqlTag.Unique, true)
+	test.Fatal(t, sqlTag.IndexName, "")
+	test.Fatal(t, sqlTag.AutoIncrement, true)
 }
 
 func Example() {
@@ -0,0 +1,236 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package schema
+
+import "strings"
+
+// Platform generates the DDL statements of a database platform
+type Platform interface {
+	GetCreateTableSQL(table *Table, flags ...int) []string
+	GetDropTableSQL(table string) string
+	GetAlterTableSQL(diff *TableDiff) []string
+}
+
+// SchemaDiff lists the differences between two schemas
+type SchemaDiff struct {
+	NewTables     []*Table
+	RemovedTables []*Table
+	ChangedTables []*TableDiff
+}
+
+// IsEmpty returns true if both schemas are the same
+func (diff *SchemaDiff) IsEmpty() bool {
+	return len(diff.NewTables) == 0 && len(diff.RemovedTables) == 0 && len(diff.ChangedTables) == 0
+}
+
+// ToSQL returns the statements migrating a database from one schema to the other.
+// New tables are created so that referenced tables are created first,
+// removed tables are dropped last.
+func (diff *SchemaDiff) ToSQL(platform Platform) (statements []string) {
+	for _, table := range sortTables(diff.NewTables) {
+		statements = append(statements, platform.GetCreateTableSQL(table)...)
+	}
+	for _, tableDiff := range diff.ChangedTables {
+		statements = append(statements, platform.GetAlterTableSQL(tableDiff)...)
+	}
+	removed := sortTables(diff.RemovedTables)
+	for i := len(removed) - 1; i >= 0; i-- {
+		statements = append(statements, platform.GetDropTableSQL(removed[i].Name))
+	}
+	return
+}
+
+// TableDiff lists the differences between two versions of a table
+type TableDiff struct {
+	Name               string
+	From               *Table
+	To                 *Table
+	AddedColumns       []*Column
+	RemovedColumns     []*Column
+	ChangedColumns     []*ColumnDiff
+	AddedIndexes       []*Index
+	RemovedIndexes     []*Index
+	AddedForeignKeys   []*ForeignKey
+	RemovedForeignKeys []*ForeignKey
+}
+
+// IsEmpty returns true if both tables are the same
+func (diff *TableDiff) IsEmpty() bool {
+	return len(diff.AddedColumns) == 0 && len(diff.RemovedColumns) == 0 && len(diff.ChangedColumns) == 0 &&
+		len(diff.AddedIndexes) == 0 && len(diff.RemovedIndexes) == 0 &&
+		len(diff.AddedForeignKeys) == 0 && len(diff.RemovedForeignKeys) == 0
+}
+
+// ColumnDiff is a column whose definition changed
+type ColumnDiff struct {
+	From *Column
+	To   *Column
+}
+
+// Compare returns the differences between from and to.
+func Compare(from, to *Schema) *SchemaDiff {
+	diff := &SchemaDiff{}
+	for _, table := range to.Tables {
+		fromTable, ok := from.GetTable(table.Name)
+		if !ok {
+			diff.NewTables = append(diff.NewTables, table)
+			continue
+		}
+		if tableDiff := CompareTables(fromTable, table); !tableDiff.IsEmpty() {
+			diff.ChangedTables = append(diff.ChangedTables, tableDiff)
+		}
+	}
+	for _, table := range from.Tables {
+		if !to.HasTable(table.Name) {
+			diff.RemovedTables = append(diff.RemovedTables, table)
+		}
+	}
+	return diff
+}
+
+// CompareTables returns the differences between two versions of a table.
+// Indexes and foreign keys are compared by definition, not by name,
+// since names generated by databases differ from one platform to another.
+func CompareTables(from, to *Table) *TableDiff {
+	diff := &TableDiff{Name: to.Name, From: from, To: to}
+	for _, column := range to.Columns {
+		fromColumn, ok := from.GetColumn(column.Name)
+		if !ok {
+			diff.AddedColumns = append(diff.AddedColumns, column)
+		} else if !ColumnsEqual(fromColumn, column) {
+			diff.ChangedColumns = append(diff.ChangedColumns, &ColumnDiff{From: fromColumn, To: column})
+		}
+	}
+	for _, column := range from.Columns {
+		if _, ok := to.GetColumn(column.Name); !ok {
+			diff.RemovedColumns = append(diff.RemovedColumns, column)
+		}
+	}
+	for _, index := range to.Indexes {
+		if findIndex(from.Indexes, index) == nil {
+			diff.AddedIndexes = append(diff.AddedIndexes, index)
+		}
+	}
+	for _, index := range from.Indexes {
+		if findIndex(to.Indexes, index) == nil {
+			diff.RemovedIndexes = append(diff.RemovedIndexes, index)
+		}
+	}
+	for _, foreignKey := range to.ForeignKeys {
+		if findForeignKey(from.ForeignKeys, foreignKey) == nil {
+			diff.AddedForeignKeys = append(diff.AddedForeignKeys, foreignKey)
+		}
+	}
+	for _, foreignKey := range from.ForeignKeys {
+		if findForeignKey(to.ForeignKeys, foreignKey) == nil {
+			diff.RemovedForeignKeys = append(diff.RemovedForeignKeys, foreignKey)
+		}
+	}
+	return diff
+}
+
+// ColumnsEqual returns true if both columns have the same definition.
+// Types are compared case insensitively, the default values of
+// auto incremented columns are ignored.
+func ColumnsEqual(a, b *Column) bool {
+	return strings.EqualFold(strings.TrimSpace(a.Type), strings.TrimSpace(b.Type)) &&
+		a.Length == b.Length && a.Precision == b.Precision && a.Scale == b.Scale &&
+		a.Nullable == b.Nullable &&
+		(strings.TrimSpace(a.Default) == strings.TrimSpace(b.Default) || (a.AutoIncrement && b.AutoIncrement)) &&
+		a.PrimaryKey == b.PrimaryKey &&
+		a.AutoIncrement == b.AutoIncrement
+}
+
+// IndexesEqual returns true if both indexes index the same columns
+// with the same uniqueness
+func IndexesEqual(a, b *Index) bool {
+	return a.Unique == b.Unique && namesEqual(a.Columns, b.Columns)
+}
+
+// ForeignKeysEqual returns true if both foreign keys have the same definition
+func ForeignKeysEqual(a, b *ForeignKey) bool {
+	return namesEqual(a.Columns, b.Columns) &&
+		strings.EqualFold(a.ForeignTable, b.ForeignTable) &&
+		namesEqual(a.ForeignColumns, b.ForeignColumns) &&
+		normalizeAction(a.OnDelete) == normalizeAction(b.OnDelete) &&
+		normalizeAction(a.OnUpdate) == normalizeAction(b.OnUpdate)
+}
+
+func findIndex(indexes []*Index, index *Index) *Index {
+	for _, candidate := range indexes {
+		if IndexesEqual(candidate, index) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func findForeignKey(foreignKeys []*ForeignKey, foreignKey *ForeignKey) *ForeignKey {
+	for _, candidate := range foreignKeys {
+		if ForeignKeysEqual(candidate, foreignKey) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func namesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAction returns the referential action in upper case,
+// NO ACTION being the default action.
+func normalizeAction(action string) string {
+	action = strings.ToUpper(strings.TrimSpace(action))
+	if action == "" {
+		return "NO ACTION"
+	}
+	return action
+}
+
+// sortTables sorts tables so that referenced tables come
+// before the tables referencing them.
+func sortTables(tables []*Table) []*Table {
+	sorted := []*Table{}
+	visited := map[*Table]bool{}
+	var visit func(table *Table, path map[*Table]bool)
+	visit = func(table *Table, path map[*Table]bool) {
+		if visited[table] || path[table] {
+			return
+		}
+		path[table] = true
+		for _, dependency := range table.GetDependencies() {
+			for _, candidate := range tables {
+				if strings.EqualFold(candidate.Name, dependency) {
+					visit(candidate, path)
+				}
+			}
+		}
+		visited[table] = true
+		sorted = append(sorted, table)
+	}
+	for _, table := range tables {
+		visit(table, map[*Table]bool{})
+	}
+	return sorted
+}
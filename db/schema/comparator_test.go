@@ -0,0 +1,128 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package schema_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db/platform"
+	"github.com/Mparaiso/go-tiger/db/schema"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func getUsersTable() *schema.Table {
+	return schema.NewTable("users").
+		AddColumn(&schema.Column{Name: "id", Type: "INTEGER", PrimaryKey: true, AutoIncrement: true}).
+		AddColumn(&schema.Column{Name: "name", Type: "VARCHAR(255)"}).
+		AddIndex(&schema.Index{Columns: []string{"name"}, Unique: true})
+}
+
+func getArticlesTable() *schema.Table {
+	return schema.NewTable("articles").
+		AddColumn(&schema.Column{Name: "id", Type: "INTEGER", PrimaryKey: true, AutoIncrement: true}).
+		AddColumn(&schema.Column{Name: "title", Type: "VARCHAR(255)"}).
+		AddColumn(&schema.Column{Name: "author_id", Type: "INTEGER", Nullable: true}).
+		AddForeignKey(&schema.ForeignKey{Columns: []string{"author_id"}, ForeignTable: "users", ForeignColumns: []string{"id"}, OnDelete: "SET NULL"})
+}
+
+func TestCompare(t *testing.T) {
+	from := schema.NewSchema(getUsersTable(), schema.NewTable("comments"))
+	to := schema.NewSchema(getArticlesTable(), getUsersTable())
+	users, _ := to.GetTable("USERS")
+	users.AddColumn(&schema.Column{Name: "email", Type: "VARCHAR(255)", Nullable: true})
+	users.Columns[1].Type = "VARCHAR(100)"
+	users.Indexes[0].Name = "other_name"
+
+	diff := schema.Compare(from, to)
+	test.Fatal(t, len(diff.NewTables), 1)
+	test.Fatal(t, diff.NewTables[0].Name, "articles")
+	test.Fatal(t, len(diff.RemovedTables), 1)
+	test.Fatal(t, diff.RemovedTables[0].Name, "comments")
+	test.Fatal(t, len(diff.ChangedTables), 1)
+	tableDiff := diff.ChangedTables[0]
+	test.Fatal(t, len(tableDiff.AddedColumns), 1)
+	test.Fatal(t, len(tableDiff.ChangedColumns), 1)
+	test.Fatal(t, tableDiff.ChangedColumns[0].From.Type, "VARCHAR(255)")
+	// indexes are compared by definition
+	test.Fatal(t, len(tableDiff.AddedIndexes), 0)
+
+	test.Fatal(t, schema.Compare(to, to).IsEmpty(), true)
+}
+
+func TestSchemaDiffToSQL(t *testing.T) {
+	from := schema.NewSchema(schema.NewTable("comments"))
+	// articles references users, users must be created first
+	to := schema.NewSchema(getArticlesTable(), getUsersTable())
+	diff := schema.Compare(from, to)
+	for _, fixture := range []struct {
+		Platform schema.Platform
+		Expected []string
+	}{
+		{platform.NewSqlitePlatform(platform.NewDefaultPlatform()), []string{
+			"CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255) NOT NULL)",
+			"CREATE UNIQUE INDEX users_name_uniq ON users (name)",
+			"CREATE TABLE articles (id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255) NOT NULL, author_id INTEGER, " +
+				"CONSTRAINT articles_author_id_fk FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE SET NULL)",
+			"DROP TABLE comments",
+		}},
+		{platform.NewMySqlPlatform(platform.NewDefaultPlatform()), []string{
+			"CREATE TABLE users (id INTEGER NOT NULL AUTO_INCREMENT, name VARCHAR(255) NOT NULL, PRIMARY KEY (id))",
+			"CREATE UNIQUE INDEX users_name_uniq ON users (name)",
+			"CREATE TABLE articles (id INTEGER NOT NULL AUTO_INCREMENT, title VARCHAR(255) NOT NULL, author_id INTEGER, PRIMARY KEY (id), " +
+				"CONSTRAINT articles_author_id_fk FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE SET NULL)",
+			"DROP TABLE comments",
+		}},
+		{platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()), []string{
+			"CREATE TABLE users (id SERIAL NOT NULL, name VARCHAR(255) NOT NULL, PRIMARY KEY (id))",
+			"CREATE UNIQUE INDEX users_name_uniq ON users (name)",
+			"CREATE TABLE articles (id SERIAL NOT NULL, title VARCHAR(255) NOT NULL, author_id INTEGER, PRIMARY KEY (id), " +
+				"CONSTRAINT articles_author_id_fk FOREIGN KEY (author_id) REFERENCES users (id) ON DELETE SET NULL)",
+			"DROP TABLE comments",
+		}},
+	} {
+		test.Fatal(t, strings.Join(diff.ToSQL(fixture.Platform), ";\n"), strings.Join(fixture.Expected, ";\n"))
+	}
+}
+
+func TestTableDiffToSQL(t *testing.T) {
+	from := getArticlesTable()
+	to := getArticlesTable()
+	to.Columns[1].Nullable = true
+	to.ForeignKeys = nil
+	to.AddColumn(&schema.Column{Name: "content", Type: "TEXT", Default: "''"})
+	to.AddIndex(&schema.Index{Columns: []string{"title"}})
+	diff := schema.CompareTables(from, to)
+	test.Fatal(t, strings.Join(platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()).GetAlterTableSQL(diff), ";\n"), strings.Join([]string{
+		"ALTER TABLE articles DROP CONSTRAINT articles_author_id_fk",
+		"ALTER TABLE articles ADD COLUMN content TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE articles ALTER COLUMN title DROP NOT NULL",
+		"CREATE INDEX articles_title_idx ON articles (title)",
+	}, ";\n"))
+	test.Fatal(t, strings.Join(platform.NewMySqlPlatform(platform.NewDefaultPlatform()).GetAlterTableSQL(diff), ";\n"), strings.Join([]string{
+		"ALTER TABLE articles DROP FOREIGN KEY articles_author_id_fk",
+		"ALTER TABLE articles ADD COLUMN content TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE articles MODIFY COLUMN title VARCHAR(255)",
+		"CREATE INDEX articles_title_idx ON articles (title)",
+	}, ";\n"))
+	// sqlite can't drop foreign keys, the table is rebuilt
+	test.Fatal(t, strings.Join(platform.NewSqlitePlatform(platform.NewDefaultPlatform()).GetAlterTableSQL(diff), ";\n"), strings.Join([]string{
+		"CREATE TABLE __temp__articles (id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), author_id INTEGER, content TEXT NOT NULL DEFAULT '')",
+		"INSERT INTO __temp__articles (id, title, author_id) SELECT id, title, author_id FROM articles",
+		"DROP TABLE articles",
+		"ALTER TABLE __temp__articles RENAME TO articles",
+		"CREATE INDEX articles_title_idx ON articles (title)",
+	}, ";\n"))
+}
@@ -0,0 +1,188 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package schema describes database schemas : tables, columns, indexes
+// and foreign keys. Schemas can either be built by hand or introspected
+// from a database, then compared in order to generate the DDL
+// migrating a database from one schema to another.
+package schema
+
+import "strings"
+
+// Schema is a set of tables
+type Schema struct {
+	Tables []*Table
+}
+
+// NewSchema creates a Schema
+func NewSchema(tables ...*Table) *Schema {
+	return &Schema{Tables: tables}
+}
+
+// AddTable adds a table to the schema
+func (schema *Schema) AddTable(table *Table) *Schema {
+	schema.Tables = append(schema.Tables, table)
+	return schema
+}
+
+// GetTable returns a table by name, table names are case insensitive
+func (schema *Schema) GetTable(name string) (*Table, bool) {
+	for _, table := range schema.Tables {
+		if strings.EqualFold(table.Name, name) {
+			return table, true
+		}
+	}
+	return nil, false
+}
+
+// HasTable returns true if the schema has a table named name
+func (schema *Schema) HasTable(name string) bool {
+	_, ok := schema.GetTable(name)
+	return ok
+}
+
+// Table is a database table
+type Table struct {
+	Name        string
+	Columns     []*Column
+	Indexes     []*Index
+	ForeignKeys []*ForeignKey
+}
+
+// NewTable creates a Table
+func NewTable(name string) *Table {
+	return &Table{Name: name}
+}
+
+// AddColumn adds a column to the table
+func (table *Table) AddColumn(column *Column) *Table {
+	table.Columns = append(table.Columns, column)
+	return table
+}
+
+// AddIndex adds an index to the table. If the index has no name,
+// a name is generated from the table and column names.
+func (table *Table) AddIndex(index *Index) *Table {
+	if index.Name == "" {
+		suffix := "idx"
+		if index.Unique {
+			suffix = "uniq"
+		}
+		index.Name = generateName(table.Name, index.Columns, suffix)
+	}
+	table.Indexes = append(table.Indexes, index)
+	return table
+}
+
+// AddForeignKey adds a foreign key to the table. If the foreign key has no name,
+// a name is generated from the table and column names.
+func (table *Table) AddForeignKey(foreignKey *ForeignKey) *Table {
+	if foreignKey.Name == "" {
+		foreignKey.Name = generateName(table.Name, foreignKey.Columns, "fk")
+	}
+	table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+	return table
+}
+
+// GetColumn returns a column by name, column names are case insensitive
+func (table *Table) GetColumn(name string) (*Column, bool) {
+	for _, column := range table.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return column, true
+		}
+	}
+	return nil, false
+}
+
+// GetPrimaryKey returns the names of the columns of the primary key
+func (table *Table) GetPrimaryKey() (columns []string) {
+	for _, column := range table.Columns {
+		if column.PrimaryKey {
+			columns = append(columns, column.Name)
+		}
+	}
+	return
+}
+
+// GetDependencies returns the tables referenced by the foreign keys of the table
+func (table *Table) GetDependencies() (tables []string) {
+	for _, foreignKey := range table.ForeignKeys {
+		if !strings.EqualFold(foreignKey.ForeignTable, table.Name) {
+			tables = append(tables, foreignKey.ForeignTable)
+		}
+	}
+	return
+}
+
+// Portable column types, mapped to native types by each platform.
+// Portable types are lower case, any other type is considered native
+// and used as is, like VARCHAR(255) or INTEGER.
+const (
+	// String is a variable length string of Column.Length characters, 255 by default
+	String = "string"
+	// Text is a string without length limit
+	Text    = "text"
+	Integer = "integer"
+	BigInt  = "bigint"
+	// Decimal is a fixed point number of Column.Precision digits, 10 by default,
+	// with Column.Scale digits after the decimal point
+	Decimal = "decimal"
+	// Float is a double precision floating point number
+	Float    = "float"
+	Boolean  = "boolean"
+	DateTime = "datetime"
+	JSON     = "json"
+	Blob     = "blob"
+)
+
+// Column is a table column
+type Column struct {
+	Name string
+	// Type is either a portable type or a native type
+	Type string
+	// Length is the length of string columns
+	Length int
+	// Precision and Scale are the number of digits of decimal columns
+	Precision int
+	Scale     int
+	Nullable  bool
+	// Default is the SQL expression of the default value,
+	// an empty string means no default value.
+	Default       string
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+// Index is a table index
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKey is a foreign key constraint
+type ForeignKey struct {
+	Name           string
+	Columns        []string
+	ForeignTable   string
+	ForeignColumns []string
+	// OnDelete is the referential action on delete, like CASCADE or SET NULL
+	OnDelete string
+	// OnUpdate is the referential action on update
+	OnUpdate string
+}
+
+func generateName(table string, columns []string, suffix string) string {
+	return strings.ToLower(strings.Join(append(append([]string{table}, columns...), suffix), "_"))
+}
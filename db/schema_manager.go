@@ -0,0 +1,247 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"database/sql"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// SchemaManager introspects the schema of a database
+// and migrates a database to a given schema.
+type SchemaManager interface {
+	// ListTableNames returns the names of the tables of the database
+	ListTableNames() ([]string, error)
+	ListTableColumns(table string) ([]*schema.Column, error)
+	ListTableIndexes(table string) ([]*schema.Index, error)
+	ListTableForeignKeys(table string) ([]*schema.ForeignKey, error)
+	// ListTableDetails returns a table with its columns, indexes and foreign keys
+	ListTableDetails(table string) (*schema.Table, error)
+	// CreateSchema returns the schema of the database
+	CreateSchema() (*schema.Schema, error)
+	// GetMigrateToSQL returns the statements migrating the database to schema,
+	// portable column types are compared to introspected types once converted to native types.
+	GetMigrateToSQL(to *schema.Schema) ([]string, error)
+	// MigrateTo migrates the database to schema in a transaction
+	MigrateTo(to *schema.Schema) error
+	CreateTable(table *schema.Table) error
+	DropTable(table string) error
+}
+
+type defaultSchemaManager struct {
+	connection Connection
+}
+
+// NewSchemaManager creates a SchemaManager
+func NewSchemaManager(connection Connection) SchemaManager {
+	return &defaultSchemaManager{connection: connection}
+}
+
+func (manager *defaultSchemaManager) ListTableNames() ([]string, error) {
+	query := manager.connection.GetDatabasePlatform().GetListTablesSQL()
+	if query == "" {
+		return nil, ErrUnsupportedMethod
+	}
+	rows := []*struct {
+		Name string `sql:"column:name"`
+	}{}
+	if err := manager.connection.Query(query).GetResults(&rows); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, row := range rows {
+		names = append(names, row.Name)
+	}
+	return names, nil
+}
+
+func (manager *defaultSchemaManager) ListTableColumns(table string) ([]*schema.Column, error) {
+	query := manager.connection.GetDatabasePlatform().GetListTableColumnsSQL(table)
+	if query == "" {
+		return nil, ErrUnsupportedMethod
+	}
+	rows := []*struct {
+		Name          string         `sql:"column:name"`
+		Type          string         `sql:"column:type"`
+		Nullable      bool           `sql:"column:nullable"`
+		Default       sql.NullString `sql:"column:default_value"`
+		PrimaryKey    bool           `sql:"column:primary_key"`
+		AutoIncrement bool           `sql:"column:auto_increment"`
+	}{}
+	if err := manager.connection.Query(query).GetResults(&rows); err != nil {
+		return nil, err
+	}
+	columns := []*schema.Column{}
+	for _, row := range rows {
+		columns = append(columns, &schema.Column{
+			Name:          row.Name,
+			Type:          row.Type,
+			Nullable:      row.Nullable,
+			Default:       row.Default.String,
+			PrimaryKey:    row.PrimaryKey,
+			AutoIncrement: row.AutoIncrement,
+		})
+	}
+	return columns, nil
+}
+
+func (manager *defaultSchemaManager) ListTableIndexes(table string) ([]*schema.Index, error) {
+	query := manager.connection.GetDatabasePlatform().GetListTableIndexesSQL(table)
+	if query == "" {
+		return nil, ErrUnsupportedMethod
+	}
+	rows := []*struct {
+		Name   string `sql:"column:name"`
+		Column string `sql:"column:column_name"`
+		Unique bool   `sql:"column:is_unique"`
+	}{}
+	if err := manager.connection.Query(query).GetResults(&rows); err != nil {
+		return nil, err
+	}
+	indexes := []*schema.Index{}
+	for _, row := range rows {
+		// rows are ordered by index, a row per column
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != row.Name {
+			indexes = append(indexes, &schema.Index{Name: row.Name, Unique: row.Unique})
+		}
+		index := indexes[len(indexes)-1]
+		index.Columns = append(index.Columns, row.Column)
+	}
+	return indexes, nil
+}
+
+func (manager *defaultSchemaManager) ListTableForeignKeys(table string) ([]*schema.ForeignKey, error) {
+	query := manager.connection.GetDatabasePlatform().GetListTableForeignKeysSQL(table)
+	if query == "" {
+		return nil, ErrUnsupportedMethod
+	}
+	rows := []*struct {
+		Name          string         `sql:"column:name"`
+		Column        string         `sql:"column:column_name"`
+		ForeignTable  string         `sql:"column:foreign_table"`
+		ForeignColumn sql.NullString `sql:"column:foreign_column"`
+		OnDelete      sql.NullString `sql:"column:on_delete"`
+		OnUpdate      sql.NullString `sql:"column:on_update"`
+	}{}
+	if err := manager.connection.Query(query).GetResults(&rows); err != nil {
+		return nil, err
+	}
+	foreignKeys := []*schema.ForeignKey{}
+	for _, row := range rows {
+		// rows are ordered by foreign key, a row per column
+		if len(foreignKeys) == 0 || foreignKeys[len(foreignKeys)-1].Name != row.Name {
+			foreignKeys = append(foreignKeys, &schema.ForeignKey{
+				Name:         row.Name,
+				ForeignTable: row.ForeignTable,
+				OnDelete:     row.OnDelete.String,
+				OnUpdate:     row.OnUpdate.String,
+			})
+		}
+		foreignKey := foreignKeys[len(foreignKeys)-1]
+		foreignKey.Columns = append(foreignKey.Columns, row.Column)
+		foreignKey.ForeignColumns = append(foreignKey.ForeignColumns, row.ForeignColumn.String)
+	}
+	return foreignKeys, nil
+}
+
+func (manager *defaultSchemaManager) ListTableDetails(table string) (result *schema.Table, err error) {
+	result = schema.NewTable(table)
+	if result.Columns, err = manager.ListTableColumns(table); err != nil {
+		return nil, err
+	}
+	if result.Indexes, err = manager.ListTableIndexes(table); err != nil {
+		return nil, err
+	}
+	if result.ForeignKeys, err = manager.ListTableForeignKeys(table); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (manager *defaultSchemaManager) CreateSchema() (*schema.Schema, error) {
+	names, err := manager.ListTableNames()
+	if err != nil {
+		return nil, err
+	}
+	result := schema.NewSchema()
+	for _, name := range names {
+		table, err := manager.ListTableDetails(name)
+		if err != nil {
+			return nil, err
+		}
+		result.AddTable(table)
+	}
+	return result, nil
+}
+
+func (manager *defaultSchemaManager) GetMigrateToSQL(to *schema.Schema) ([]string, error) {
+	from, err := manager.CreateSchema()
+	if err != nil {
+		return nil, err
+	}
+	return schema.Compare(from, manager.getNativeSchema(to)).ToSQL(manager.connection.GetDatabasePlatform()), nil
+}
+
+func (manager *defaultSchemaManager) MigrateTo(to *schema.Schema) error {
+	statements, err := manager.GetMigrateToSQL(to)
+	if err != nil {
+		return err
+	}
+	return manager.execute(statements)
+}
+
+func (manager *defaultSchemaManager) CreateTable(table *schema.Table) error {
+	return manager.execute(manager.connection.GetDatabasePlatform().GetCreateTableSQL(table))
+}
+
+func (manager *defaultSchemaManager) DropTable(table string) error {
+	return manager.execute([]string{manager.connection.GetDatabasePlatform().GetDropTableSQL(table)})
+}
+
+// getNativeSchema returns a copy of a schema where portable column types are replaced
+// with native types, so that it can be compared with an introspected schema.
+func (manager *defaultSchemaManager) getNativeSchema(portable *schema.Schema) *schema.Schema {
+	native := schema.NewSchema()
+	for _, table := range portable.Tables {
+		nativeTable := &schema.Table{Name: table.Name, Indexes: table.Indexes, ForeignKeys: table.ForeignKeys}
+		for _, column := range table.Columns {
+			nativeColumn := *column
+			nativeColumn.Type = manager.connection.GetDatabasePlatform().GetColumnTypeSQL(column)
+			nativeColumn.Length, nativeColumn.Precision, nativeColumn.Scale = 0, 0, 0
+			nativeTable.AddColumn(&nativeColumn)
+		}
+		native.AddTable(nativeTable)
+	}
+	return native
+}
+
+// execute executes statements in a transaction
+func (manager *defaultSchemaManager) execute(statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	transaction, err := manager.connection.Begin()
+	if err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := transaction.Exec(statement); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	return transaction.Commit()
+}
@@ -0,0 +1,107 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/schema"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestSchemaManagerListTableDetails(t *testing.T) {
+	connection := GetConnection(t)
+	manager := db.NewSchemaManager(connection)
+	tables, err := manager.ListTableNames()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(tables) >= 3, true)
+
+	users, err := manager.ListTableDetails("users")
+	test.Fatal(t, err, nil)
+	id, ok := users.GetColumn("id")
+	test.Fatal(t, ok, true)
+	test.Fatal(t, id.PrimaryKey, true)
+	test.Fatal(t, id.AutoIncrement, true)
+	name, _ := users.GetColumn("name")
+	test.Fatal(t, name.Type, "VARCHAR(255)")
+	test.Fatal(t, name.Nullable, false)
+	userinfoID, _ := users.GetColumn("userinfo_id")
+	test.Fatal(t, userinfoID.Nullable, true)
+	passwordDigest, _ := users.GetColumn("password_digest")
+	test.Fatal(t, passwordDigest.Default, "'password'")
+	var emailIndex *schema.Index
+	for _, index := range users.Indexes {
+		if index.Name == "USERS_EMAIL" {
+			emailIndex = index
+		}
+	}
+	test.Fatal(t, emailIndex != nil, true)
+	test.Fatal(t, emailIndex.Unique, true)
+	test.Fatal(t, emailIndex.Columns[0], "email")
+
+	articles, err := manager.ListTableDetails("articles")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(articles.ForeignKeys), 1)
+	test.Fatal(t, articles.ForeignKeys[0].ForeignTable, "users")
+	test.Fatal(t, articles.ForeignKeys[0].Columns[0], "author_id")
+	test.Fatal(t, articles.ForeignKeys[0].OnDelete, "SET NULL")
+}
+
+func TestSchemaManagerMigrateTo(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	manager := db.NewSchemaManager(connection)
+	to, err := manager.CreateSchema()
+	test.Fatal(t, err, nil)
+
+	// portable types are compared once converted to native types
+	tags := schema.NewTable("tags").
+		AddColumn(&schema.Column{Name: "id", Type: schema.Integer, PrimaryKey: true, AutoIncrement: true}).
+		AddColumn(&schema.Column{Name: "name", Type: schema.String, Length: 100}).
+		AddColumn(&schema.Column{Name: "weight", Type: schema.Decimal, Precision: 5, Scale: 2, Default: "0"}).
+		AddColumn(&schema.Column{Name: "visible", Type: schema.Boolean, Nullable: true}).
+		AddColumn(&schema.Column{Name: "created", Type: schema.DateTime, Default: "CURRENT_TIMESTAMP"}).
+		AddIndex(&schema.Index{Columns: []string{"name"}, Unique: true})
+	to.AddTable(tags)
+	articles, _ := to.GetTable("articles")
+	articles.AddColumn(&schema.Column{Name: "tag_id", Type: schema.Integer, Nullable: true}).
+		AddForeignKey(&schema.ForeignKey{Columns: []string{"tag_id"}, ForeignTable: "tags", ForeignColumns: []string{"id"}})
+	userinfos, _ := to.GetTable("userinfos")
+	nicename, _ := userinfos.GetColumn("nicename")
+	nicename.Type, nicename.Nullable, nicename.Default = "VARCHAR(100)", false, "''"
+
+	err = LoadFixtures(connection)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, manager.MigrateTo(to), nil)
+
+	// the database matches the schema
+	statements, err := manager.GetMigrateToSQL(to)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Join(statements, ";\n"), "")
+	// rows of rebuilt tables are kept
+	var count int
+	err = connection.QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, count, 3)
+
+	test.Fatal(t, manager.DropTable("tags"), nil)
+	tables, err := manager.ListTableNames()
+	test.Fatal(t, err, nil)
+	for _, table := range tables {
+		test.Error(t, table != "tags", true)
+	}
+}
@@ -0,0 +1,153 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StatementCache is a least recently used cache of prepared statements keyed by query.
+// Evicted statements are closed once they are no longer in use.
+//
+//	connection.SetStatementCache(db.NewStatementCache(100))
+//	statement := connection.Prepare("SELECT * FROM users WHERE id = ?") // prepared once
+type StatementCache struct {
+	capacity int
+	mutex    sync.Mutex
+	// statements are the cached statements, the most recently used first
+	statements *list.List
+	queries    map[string]*list.Element
+	stats      StatementCacheStats
+}
+
+// StatementCacheStats are the statistics of a statement cache
+type StatementCacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	// Size is the number of cached statements
+	Size int
+}
+
+// cachedStatement is a statement of a StatementCache
+type cachedStatement struct {
+	query     string
+	statement *sql.Stmt
+	// references is the number of uses of the statement in progress
+	references int
+	// evicted is true once the statement was removed from the cache
+	evicted bool
+}
+
+// NewStatementCache returns a statement cache holding at most capacity statements
+func NewStatementCache(capacity int) *StatementCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &StatementCache{capacity: capacity, statements: list.New(), queries: map[string]*list.Element{}}
+}
+
+// GetStats returns the statistics of the cache
+func (cache *StatementCache) GetStats() StatementCacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	stats := cache.stats
+	stats.Size = cache.statements.Len()
+	return stats
+}
+
+// Clear removes all the statements from the cache, closing them once they are no longer in use
+func (cache *StatementCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for cache.statements.Len() > 0 {
+		cache.evict(cache.statements.Back())
+	}
+}
+
+// acquire returns the statement of query prepared with DB, preparing it if it is not cached,
+// and a function that must be called once the statement is no longer used
+func (cache *StatementCache) acquire(ctx context.Context, DB *sql.DB, query string) (*sql.Stmt, func(), error) {
+	if statement, release, ok := cache.acquireCached(query); ok {
+		return statement, release, nil
+	}
+	statement, err := DB.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.queries[query]; ok {
+		// the query was prepared concurrently
+		statement.Close()
+		cache.statements.MoveToFront(element)
+		cached := element.Value.(*cachedStatement)
+		cached.references++
+		return cached.statement, cache.releaser(cached), nil
+	}
+	cached := &cachedStatement{query: query, statement: statement, references: 1}
+	cache.queries[query] = cache.statements.PushFront(cached)
+	for cache.statements.Len() > cache.capacity {
+		cache.evict(cache.statements.Back())
+	}
+	return statement, cache.releaser(cached), nil
+}
+
+// acquireCached returns the cached statement of query and a function that must be called
+// once the statement is no longer used, ok being false if the statement is not cached
+func (cache *StatementCache) acquireCached(query string) (statement *sql.Stmt, release func(), ok bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.queries[query]
+	if !ok {
+		cache.stats.Misses++
+		return nil, nil, false
+	}
+	cache.stats.Hits++
+	cache.statements.MoveToFront(element)
+	cached := element.Value.(*cachedStatement)
+	cached.references++
+	return cached.statement, cache.releaser(cached), true
+}
+
+// releaser returns a function releasing cached once, the statement being closed
+// if it was evicted and is no longer in use
+func (cache *StatementCache) releaser(cached *cachedStatement) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cache.mutex.Lock()
+			defer cache.mutex.Unlock()
+			cached.references--
+			if cached.evicted && cached.references == 0 {
+				cached.statement.Close()
+			}
+		})
+	}
+}
+
+// evict removes element from the cache, the mutex being locked
+func (cache *StatementCache) evict(element *list.Element) {
+	cached := cache.statements.Remove(element).(*cachedStatement)
+	delete(cache.queries, cached.query)
+	cache.stats.Evictions++
+	cached.evicted = true
+	if cached.references == 0 {
+		cached.statement.Close()
+	}
+}
@@ -0,0 +1,64 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func TestStatementCache(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	test.Fatal(t, LoadFixtures(connection), nil)
+	cache := db.NewStatementCache(2)
+	connection.SetStatementCache(cache)
+
+	var name string
+	byID := connection.Prepare("SELECT name FROM users WHERE id = ?")
+	test.Fatal(t, byID.QueryRow(1).GetSingleResult(&name), nil)
+	test.Fatal(t, name, "John Doe")
+	test.Fatal(t, connection.Prepare("SELECT name FROM users WHERE id = ?").QueryRow(2).GetSingleResult(&name), nil)
+	test.Fatal(t, name, "Jane Doe")
+	test.Fatal(t, cache.GetStats(), db.StatementCacheStats{Hits: 3, Misses: 1, Size: 1})
+
+	// evicted statements are closed, statements prepared from the cache are prepared again
+	connection.Prepare("SELECT COUNT(*) FROM users")
+	connection.CreateQueryBuilder().Select("email").From("users").Prepare()
+	test.Fatal(t, cache.GetStats().Evictions, int64(1))
+	test.Fatal(t, byID.QueryRow(3).GetSingleResult(&name), nil)
+	test.Fatal(t, name, "Jack Doe")
+	test.Fatal(t, byID.Close(), nil)
+
+	// cached statements are bound to transactions
+	connection.Prepare("UPDATE users SET name = ? WHERE id = ?")
+	err := connection.Transactional(context.Background(), func(transaction *db.Transaction) error {
+		statement, err := transaction.Prepare("UPDATE users SET name = ? WHERE id = ?")
+		if err != nil {
+			return err
+		}
+		_, err = statement.Exec("John Robert Doe", 1)
+		return err
+	})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, byID.QueryRow(1).GetSingleResult(&name), nil)
+	test.Fatal(t, name, "John Robert Doe")
+	test.Fatal(t, cache.GetStats().Size, 2)
+	test.Fatal(t, connection.Close(), nil)
+	test.Fatal(t, cache.GetStats().Size, 0)
+}
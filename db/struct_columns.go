@@ -0,0 +1,182 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+// structColumn is a column written from a field of a struct
+type structColumn struct {
+	name string
+	// value is the value written, wrapped with JSON or Array for fields tagged json or array
+	value interface{}
+	// fieldName is the name of the field the column is written from
+	fieldName string
+	field     reflect.Value
+	tag       SQLStructTag
+}
+
+// getStructColumns returns the columns written from the exported fields of a struct,
+// in the order of the fields. Fields tagged "-" are omitted, a field is written
+// in the column of its column option or in the column named after the field.
+// The fields of untagged embedded structs are written like the fields of the struct,
+// the fields of the struct shadowing the embedded fields with the same name.
+// Nil embedded pointers have no columns.
+func getStructColumns(Value reflect.Value) []structColumn {
+	return getStructColumnsOf(Value, map[reflect.Type]bool{})
+}
+
+func getStructColumnsOf(Value reflect.Value, visited map[reflect.Type]bool) []structColumn {
+	columns := []structColumn{}
+	Type := Value.Type()
+	names := map[string]bool{}
+	for i := 0; i < Type.NumField(); i++ {
+		if Field := Type.Field(i); !Field.Anonymous {
+			names[Field.Name] = true
+		}
+	}
+	for i := 0; i < Type.NumField(); i++ {
+		Field := Type.Field(i)
+		stringTag, _ := Field.Tag.Lookup("sql")
+		if stringTag == "-" {
+			continue
+		}
+		if fieldType := Field.Type; Field.Anonymous && stringTag == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && !isScannedAsAValue(fieldType) {
+				embedded := reflect.Indirect(Value.Field(i))
+				if visited[fieldType] || !embedded.IsValid() || (Field.PkgPath != "" && Field.Type.Kind() == reflect.Ptr) {
+					continue
+				}
+				visited[fieldType] = true
+				for _, column := range getStructColumnsOf(embedded, visited) {
+					if !names[column.fieldName] {
+						names[column.fieldName] = true
+						columns = append(columns, column)
+					}
+				}
+				continue
+			}
+		}
+		if Field.PkgPath != "" {
+			continue
+		}
+		tag := SQLStructTagBuilder{}.BuildFromString(stringTag)
+		column := structColumn{name: Field.Name, fieldName: Field.Name, field: Value.Field(i), tag: tag}
+		if tag.ColumnName != "" {
+			column.name = tag.ColumnName
+		}
+		column.value = tag.wrapValue(column.field.Interface())
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// isOmitted returns true if the column is not written when its struct is inserted or updated.
+// Zero values, including nil pointers, invalid sql.Null values and empty slices and maps,
+// are omitted unless the field is tagged persistZeroValue or version. Fields tagged nullable
+// are written as NULL when nil or invalid, so that updates can clear a column.
+func (column structColumn) isOmitted() bool {
+	switch {
+	case column.tag.PersistZeroValue || column.tag.Version:
+		return false
+	case column.tag.Nullable && isNull(column.field):
+		return false
+	}
+	return isZero(column.field)
+}
+
+// isNull returns true if value is written as NULL
+func isNull(value reflect.Value) bool {
+	if isNil(value) {
+		return true
+	}
+	if valuer, ok := value.Interface().(driver.Valuer); ok {
+		driverValue, err := valuer.Value()
+		return err == nil && driverValue == nil
+	}
+	return false
+}
+
+// Snapshot is the state of the columns of a struct, see TakeSnapshot
+type Snapshot struct {
+	values map[string]interface{}
+}
+
+// TakeSnapshot returns the state of the columns of a struct, usually taken when the struct
+// is read from the database, so that only the columns changed since are updated :
+//
+//	snapshot, err := db.TakeSnapshot(article)
+//	article.Title = "Hello World"
+//	_, err = connection.UpdateColumns("articles", criteria, article, snapshot.GetChangedColumns(article)...)
+func TakeSnapshot(data interface{}) (*Snapshot, error) {
+	Value := reflect.Indirect(reflect.ValueOf(data))
+	if Value.Kind() != reflect.Struct {
+		return nil, ErrNotAStruct
+	}
+	snapshot := &Snapshot{values: map[string]interface{}{}}
+	for _, column := range getStructColumns(Value) {
+		snapshot.values[column.name] = getSnapshotValue(column)
+	}
+	return snapshot, nil
+}
+
+// GetChangedColumns returns the columns of a struct whose values changed since the snapshot
+// was taken, in the order of the fields. Version columns are never returned, they are updated
+// with the other columns.
+func (snapshot *Snapshot) GetChangedColumns(data interface{}) []string {
+	Value := reflect.Indirect(reflect.ValueOf(data))
+	if Value.Kind() != reflect.Struct {
+		return nil
+	}
+	changed := []string{}
+	for _, column := range getStructColumns(Value) {
+		if column.tag.Version {
+			continue
+		}
+		if value, ok := snapshot.values[column.name]; !ok || !reflect.DeepEqual(value, getSnapshotValue(column)) {
+			changed = append(changed, column.name)
+		}
+	}
+	return changed
+}
+
+// getSnapshotValue returns the value of a column converted to a driver value, so that pointers
+// are dereferenced and slices or maps changed in place are not altered in the snapshot
+func getSnapshotValue(column structColumn) interface{} {
+	value, err := driver.DefaultParameterConverter.ConvertValue(column.value)
+	if err != nil {
+		// the value can't be converted, it is written by a driver that supports it
+		return column.value
+	}
+	if bytes, ok := value.([]byte); ok {
+		return append([]byte{}, bytes...)
+	}
+	return value
+}
+
+// indexOfStructColumn returns the index of the column named name in columns or -1
+func indexOfStructColumn(columns []structColumn, name string) int {
+	for i, column := range columns {
+		if column.name == name {
+			return i
+		}
+	}
+	return -1
+}
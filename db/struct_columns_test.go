@@ -0,0 +1,140 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type Profile struct {
+	ID       int64          `sql:"column:id"`
+	Name     string         `sql:"column:name"`
+	Age      int            `sql:"column:age"`
+	Active   bool           `sql:"column:active"`
+	Nickname *string        `sql:"column:nickname,nullable"`
+	Bio      sql.NullString `sql:"column:bio,nullable"`
+	Tags     []string       `sql:"column:tags,array"`
+}
+
+func GetProfileConnection(t *testing.T) *db.DefaultConnection {
+	connection := GetConnection(t)
+	_, err := connection.Exec("CREATE TABLE profiles(id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255), age INTEGER, active BOOLEAN, nickname VARCHAR(255), bio TEXT, tags TEXT)")
+	test.Fatal(t, err, nil)
+	nickname := "johnny"
+	_, err = connection.Insert("profiles", Profile{Name: "John Doe", Age: 30, Active: true, Nickname: &nickname,
+		Bio: sql.NullString{String: "", Valid: true}, Tags: []string{"go"}})
+	test.Fatal(t, err, nil)
+	return connection
+}
+
+func TestUpdateColumns(t *testing.T) {
+	connection := GetProfileConnection(t)
+	criteria := map[string]interface{}{"id": 1}
+	// zero values are omitted by Update
+	_, err := connection.Update("profiles", criteria, Profile{Name: "Johnny Doe"})
+	test.Fatal(t, err, nil)
+	profile := new(Profile)
+	test.Fatal(t, connection.QueryRow("SELECT * FROM profiles WHERE id = 1").GetResult(profile), nil)
+	test.Fatal(t, profile.Name, "Johnny Doe")
+	test.Fatal(t, profile.Age, 30)
+	// nullable fields are written as NULL
+	test.Fatal(t, profile.Nickname == nil && !profile.Bio.Valid, true)
+
+	// the given columns are written, zero values included
+	result, err := connection.UpdateColumns("profiles", criteria, Profile{Name: "ignored"}, "age", "active")
+	test.Fatal(t, err, nil)
+	rowsAffected, err := result.RowsAffected()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, rowsAffected, int64(1))
+	test.Fatal(t, connection.QueryRow("SELECT * FROM profiles WHERE id = 1").GetResult(profile), nil)
+	test.Fatal(t, profile.Name, "Johnny Doe")
+	test.Fatal(t, profile.Age, 0)
+	test.Fatal(t, profile.Active, false)
+
+	_, err = connection.UpdateColumns("profiles", criteria, map[string]interface{}{"name": "Jim Doe", "age": 40}, "name")
+	test.Fatal(t, err, nil)
+	test.Fatal(t, connection.QueryRow("SELECT * FROM profiles WHERE id = 1").GetResult(profile), nil)
+	test.Fatal(t, profile.Name, "Jim Doe")
+	test.Fatal(t, profile.Age, 0)
+
+	_, err = connection.UpdateColumns("profiles", criteria, Profile{}, "missing")
+	test.Fatal(t, err, db.ErrColumnNotFound)
+	// nothing is updated without columns
+	result, err = connection.UpdateColumns("profiles", criteria, Profile{})
+	test.Fatal(t, err, nil)
+	rowsAffected, _ = result.RowsAffected()
+	test.Fatal(t, rowsAffected, int64(0))
+}
+
+func TestSnapshot(t *testing.T) {
+	connection := GetProfileConnection(t)
+	profile := new(Profile)
+	test.Fatal(t, connection.QueryRow("SELECT * FROM profiles WHERE id = 1").GetResult(profile), nil)
+	snapshot, err := db.TakeSnapshot(profile)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(snapshot.GetChangedColumns(profile)), 0)
+
+	// changes made in place through pointers and slices are detected
+	*profile.Nickname = "jd"
+	profile.Tags[0] = "sql"
+	profile.Active = false
+	columns := snapshot.GetChangedColumns(profile)
+	test.Fatal(t, strings.Join(columns, ","), "active,nickname,tags")
+	_, err = connection.UpdateColumns("profiles", map[string]interface{}{"id": profile.ID}, profile, columns...)
+	test.Fatal(t, err, nil)
+
+	updated := new(Profile)
+	test.Fatal(t, connection.QueryRow("SELECT * FROM profiles WHERE id = 1").GetResult(updated), nil)
+	test.Fatal(t, *updated.Nickname+" "+updated.Tags[0], "jd sql")
+	test.Fatal(t, updated.Active, false)
+	test.Fatal(t, updated.Name, "John Doe")
+	_, err = db.TakeSnapshot("profile")
+	test.Fatal(t, err, db.ErrNotAStruct)
+}
+
+type ThingBase struct {
+	ID    int64  `sql:"column:id"`
+	Label string `sql:"column:label"`
+}
+
+type Thing struct {
+	ThingBase
+	Name string `sql:"column:name"`
+}
+
+func TestInsertEmbeddedStruct(t *testing.T) {
+	connection := GetConnection(t)
+	_, err := connection.Exec("CREATE TABLE things(id INTEGER PRIMARY KEY AUTOINCREMENT, label VARCHAR(255), name VARCHAR(255))")
+	test.Fatal(t, err, nil)
+	_, err = connection.Insert("things", Thing{ThingBase: ThingBase{ID: 5, Label: "first"}, Name: "a"})
+	test.Fatal(t, err, nil)
+	_, err = connection.Update("things", map[string]interface{}{"id": 5}, Thing{ThingBase: ThingBase{Label: "updated"}})
+	test.Fatal(t, err, nil)
+	thing := new(Thing)
+	test.Fatal(t, connection.QueryRow("SELECT * FROM things WHERE id = 5").GetResult(thing), nil)
+	test.Fatal(t, thing.Label, "updated")
+	test.Fatal(t, thing.Name, "a")
+	// a row of default values is inserted when every column is omitted
+	result, err := connection.Insert("things", Thing{})
+	test.Fatal(t, err, nil)
+	id, err := result.LastInsertId()
+	test.Fatal(t, err, nil)
+	test.Fatal(t, id, int64(6))
+}
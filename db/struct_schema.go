@@ -0,0 +1,283 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db/schema"
+)
+
+// TableFromStruct derives the definition of a table from the fields of a struct
+// or a pointer to struct, using the sql struct tags of the fields.
+//
+// Column types are derived from field types unless a type option is given :
+// strings are mapped to string, int and int64 to bigint, smaller integers to integer,
+// floats to float, bool to boolean, time.Time to datetime and []byte to blob.
+// Pointers and sql.Null types are nullable.
+// The primary key is made of the fields with a primaryKey option, or of the ID field,
+// a single integer primary key is auto incremented unless tagged autoincrement:false.
+// The fields of embedded structs are mapped as the fields of the struct.
+// manyToOne relations are mapped to a nullable foreign key column,
+// other relations and fields tagged with sql:"-" are ignored.
+func TableFromStruct(table string, Struct interface{}) (*schema.Table, error) {
+	result, _, err := tableFromStruct(table, Struct)
+	return result, err
+}
+
+// SchemaFromStruct derives a schema from structs indexed by table name.
+// Foreign keys reference the primary keys of the tables of the schema,
+// manyToOne columns get the type of the primary key they reference.
+func SchemaFromStruct(structs map[string]interface{}) (*schema.Schema, error) {
+	names := []string{}
+	for name := range structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := schema.NewSchema()
+	relationColumns := map[*schema.Table][]string{}
+	for _, name := range names {
+		table, columns, err := tableFromStruct(name, structs[name])
+		if err != nil {
+			return nil, err
+		}
+		relationColumns[table] = columns
+		result.AddTable(table)
+	}
+	for _, table := range result.Tables {
+		for _, foreignKey := range table.ForeignKeys {
+			foreignTable, ok := result.GetTable(foreignKey.ForeignTable)
+			if !ok || len(foreignTable.GetPrimaryKey()) != 1 {
+				continue
+			}
+			foreignKey.ForeignColumns = foreignTable.GetPrimaryKey()
+			if indexOfString(relationColumns[table], foreignKey.Columns[0]) == -1 {
+				continue
+			}
+			primaryKey, _ := foreignTable.GetColumn(foreignKey.ForeignColumns[0])
+			column, _ := table.GetColumn(foreignKey.Columns[0])
+			column.Type, column.Length, column.Precision, column.Scale = primaryKey.Type, primaryKey.Length, primaryKey.Precision, primaryKey.Scale
+		}
+	}
+	return result, nil
+}
+
+// CreateTableFromStruct creates the table derived from a struct, see TableFromStruct
+func CreateTableFromStruct(connection Connection, table string, Struct interface{}) error {
+	definition, err := TableFromStruct(table, Struct)
+	if err != nil {
+		return err
+	}
+	return NewSchemaManager(connection).CreateTable(definition)
+}
+
+// tableFromStruct returns the table derived from a struct
+// and the names of the columns mapped by manyToOne relations.
+func tableFromStruct(table string, Struct interface{}) (result *schema.Table, relationColumns []string, err error) {
+	Type := reflect.TypeOf(Struct)
+	if Type != nil && Type.Kind() == reflect.Ptr {
+		Type = Type.Elem()
+	}
+	if Type == nil || Type.Kind() != reflect.Struct {
+		return nil, nil, ErrNotAStruct
+	}
+	result = schema.NewTable(table)
+	indexes := []*schema.Index{}
+	var guess *schema.Column
+	// noAutoIncrement are the columns tagged autoincrement:false
+	noAutoIncrement := map[string]bool{}
+	for _, Field := range getSchemaFields(Type, map[reflect.Type]bool{Type: true}) {
+		stringTag := Field.Tag.Get("sql")
+		tag := SQLStructTagBuilder{}.BuildFromString(stringTag)
+		column := &schema.Column{Name: Field.Name}
+		if tag.ColumnName != "" {
+			column.Name = tag.ColumnName
+		}
+		if _, ok := result.GetColumn(column.Name); ok {
+			// the column is mapped by a field of the struct or of a previous embedded struct
+			continue
+		}
+		noAutoIncrement[column.Name] = tag.NoAutoIncrement
+		var r relation
+		for _, definition := range splitTag(stringTag) {
+			if !strings.Contains(definition, "(") {
+				continue
+			}
+			if r, err = parseRelation(Field, strings.TrimSpace(definition)); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch r.relation {
+		case oneToMany, manyToMany:
+			continue
+		case manyToOne:
+			column.Name, column.Type, column.Nullable = r.joinColumn, schema.BigInt, true
+			tag.References = r.targetEntity
+			relationColumns = append(relationColumns, column.Name)
+		default:
+			if tag.JSON {
+				column.Type, column.Nullable = schema.JSON, tag.Nullable || isNullableKind(Field.Type.Kind())
+				break
+			}
+			if tag.Array && (Field.Type.Kind() == reflect.Slice || Field.Type.Kind() == reflect.Array) {
+				portableType, _, ok := getPortableType(Field.Type.Elem())
+				if !ok && tag.Type == "" {
+					return nil, nil, ErrUnsupportedFieldType
+				}
+				column.Type, column.Nullable = portableType+"[]", tag.Nullable || Field.Type.Kind() == reflect.Slice
+				break
+			}
+			portableType, nullable, ok := getPortableType(Field.Type)
+			if !ok && tag.Type == "" {
+				return nil, nil, ErrUnsupportedFieldType
+			}
+			column.Type, column.Nullable = portableType, nullable || tag.Nullable
+		}
+		if tag.Type != "" {
+			column.Type = tag.Type
+		}
+		column.Length, column.Precision, column.Scale = tag.Size, tag.Precision, tag.Scale
+		column.Default, column.AutoIncrement = tag.Default, tag.AutoIncrement
+		if tag.Version && column.Default == "" {
+			// rows inserted without a version start at version 0
+			column.Default = "0"
+		}
+		if tag.PrimaryKey {
+			column.PrimaryKey, column.Nullable = true, false
+		} else if guess == nil && (strings.ToLower(column.Name) == "id" || Field.Name == "ID") {
+			guess = column
+		}
+		result.AddColumn(column)
+		if tag.References != "" {
+			result.AddForeignKey(&schema.ForeignKey{Columns: []string{column.Name}, ForeignTable: tag.References, ForeignColumns: []string{"id"}})
+		}
+		if tag.Unique || tag.Index {
+			indexes = addColumnToIndex(indexes, column.Name, tag)
+		}
+	}
+	if primaryKey := result.GetPrimaryKey(); len(primaryKey) == 0 && guess != nil {
+		guess.PrimaryKey, guess.Nullable = true, false
+	}
+	if primaryKey := result.GetPrimaryKey(); len(primaryKey) == 1 {
+		column, _ := result.GetColumn(primaryKey[0])
+		if (column.Type == schema.Integer || column.Type == schema.BigInt) && !noAutoIncrement[column.Name] {
+			column.AutoIncrement = true
+		}
+	}
+	for _, index := range indexes {
+		result.AddIndex(index)
+	}
+	return result, relationColumns, nil
+}
+
+// getSchemaFields returns the exported fields of Type mapped to columns, the fields of embedded
+// structs being inlined at the position of the embedded struct. Fields of Type shadow the fields
+// of embedded structs with the same name. visited are the structs already inlined.
+func getSchemaFields(Type reflect.Type, visited map[reflect.Type]bool) (fields []reflect.StructField) {
+	names := map[string]bool{}
+	for i := 0; i < Type.NumField(); i++ {
+		if Field := Type.Field(i); !Field.Anonymous {
+			names[Field.Name] = true
+		}
+	}
+	for i := 0; i < Type.NumField(); i++ {
+		Field := Type.Field(i)
+		stringTag := Field.Tag.Get("sql")
+		if stringTag == "-" {
+			continue
+		}
+		if fieldType := Field.Type; Field.Anonymous && stringTag == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && !isScannedAsAValue(fieldType) {
+				if visited[fieldType] || (Field.PkgPath != "" && Field.Type.Kind() == reflect.Ptr) {
+					continue
+				}
+				visited[fieldType] = true
+				for _, embedded := range getSchemaFields(fieldType, visited) {
+					if !names[embedded.Name] {
+						names[embedded.Name] = true
+						fields = append(fields, embedded)
+					}
+				}
+				continue
+			}
+		}
+		if Field.PkgPath == "" {
+			fields = append(fields, Field)
+		}
+	}
+	return fields
+}
+
+// addColumnToIndex adds a column to the index named after the index option of tag,
+// or to a new index if the index has no name.
+func addColumnToIndex(indexes []*schema.Index, column string, tag SQLStructTag) []*schema.Index {
+	if tag.IndexName != "" {
+		for _, index := range indexes {
+			if index.Name == tag.IndexName {
+				index.Columns = append(index.Columns, column)
+				return indexes
+			}
+		}
+	}
+	return append(indexes, &schema.Index{Name: tag.IndexName, Columns: []string{column}, Unique: tag.Unique})
+}
+
+// getPortableType returns the portable column type of a field type
+// and whether the column is nullable.
+func getPortableType(Type reflect.Type) (portableType string, nullable bool, ok bool) {
+	if Type.Kind() == reflect.Ptr {
+		portableType, _, ok = getPortableType(Type.Elem())
+		return portableType, true, ok
+	}
+	switch Type {
+	case reflect.TypeOf(time.Time{}):
+		return schema.DateTime, false, true
+	case reflect.TypeOf([]byte{}):
+		return schema.Blob, false, true
+	case reflect.TypeOf(sql.NullString{}):
+		return schema.String, true, true
+	case reflect.TypeOf(sql.NullInt64{}):
+		return schema.BigInt, true, true
+	case reflect.TypeOf(sql.NullFloat64{}):
+		return schema.Float, true, true
+	case reflect.TypeOf(sql.NullBool{}):
+		return schema.Boolean, true, true
+	}
+	switch Type.Kind() {
+	case reflect.String:
+		return schema.String, false, true
+	case reflect.Bool:
+		return schema.Boolean, false, true
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return schema.BigInt, false, true
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema.Integer, false, true
+	case reflect.Float32, reflect.Float64:
+		return schema.Float, false, true
+	}
+	return "", false, false
+}
+
+// isNullableKind returns true if the zero value of a kind is nil, which is written as NULL
+func isNullableKind(kind reflect.Kind) bool {
+	return kind == reflect.Ptr || kind == reflect.Map || kind == reflect.Slice || kind == reflect.Interface
+}
@@ -0,0 +1,141 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/platform"
+	"github.com/Mparaiso/go-tiger/db/schema"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type SchemaCategory struct {
+	ID   int32  `sql:"column:id"`
+	Name string `sql:"column:name,size:100,unique"`
+}
+
+type SchemaProduct struct {
+	ID          int64           `sql:"column:id,primaryKey"`
+	Name        string          `sql:"column:name,index:products_name_price_idx"`
+	Price       float64         `sql:"column:price,index:products_name_price_idx"`
+	Description sql.NullString  `sql:"column:description,type:text"`
+	Published   bool            `sql:"column:published,default:0"`
+	CreatedAt   *time.Time      `sql:"column:created_at,default:CURRENT_TIMESTAMP"`
+	Category    *SchemaCategory `sql:"manyToOne(targetEntity:categories,joinColumn:category_id)"`
+	Cache       string          `sql:"-"`
+}
+
+func TestTableFromStruct(t *testing.T) {
+	table, err := db.TableFromStruct("products", SchemaProduct{})
+	test.Fatal(t, err, nil)
+	sqlite := platform.NewSqlitePlatform(platform.NewDefaultPlatform())
+	test.Fatal(t, strings.Join(sqlite.GetCreateTableSQL(table), ";\n"), strings.Join([]string{
+		"CREATE TABLE products (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255) NOT NULL, price REAL NOT NULL, " +
+			"description TEXT, published BOOLEAN NOT NULL DEFAULT 0, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, category_id BIGINT, " +
+			"CONSTRAINT products_category_id_fk FOREIGN KEY (category_id) REFERENCES categories (id))",
+		"CREATE INDEX products_name_price_idx ON products (name, price)",
+	}, ";\n"))
+
+	_, err = db.TableFromStruct("products", struct{ Tags []string }{})
+	test.Fatal(t, err, db.ErrUnsupportedFieldType)
+	table, err = db.TableFromStruct("documents", Document{})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, strings.Join(platform.NewPostgreSqlPlatform(platform.NewDefaultPlatform()).GetCreateTableSQL(table), ";\n"),
+		"CREATE TABLE documents (id BIGSERIAL NOT NULL, author JSON, metadata JSON, tags CHARACTER VARYING(255)[], scores BIGINT[], PRIMARY KEY (id))")
+	_, err = db.TableFromStruct("products", 1)
+	test.Fatal(t, err, db.ErrNotAStruct)
+}
+
+type SchemaTimestamps struct {
+	CreatedAt time.Time `sql:"column:created_at"`
+	UpdatedAt time.Time `sql:"column:updated_at"`
+}
+
+type SchemaCountry struct {
+	Code int32 `sql:"column:code,primaryKey,autoincrement:false"`
+	*SchemaTimestamps
+	// Name shadows the name of the embedded struct
+	Name string `sql:"column:name"`
+	SchemaNamed
+}
+
+type SchemaNamed struct {
+	Name string `sql:"column:label"`
+}
+
+func TestTableFromStructEmbedded(t *testing.T) {
+	table, err := db.TableFromStruct("countries", SchemaCountry{})
+	test.Fatal(t, err, nil)
+	sqlite := platform.NewSqlitePlatform(platform.NewDefaultPlatform())
+	test.Fatal(t, strings.Join(sqlite.GetCreateTableSQL(table), ";\n"),
+		"CREATE TABLE countries (code INTEGER NOT NULL, created_at DATETIME NOT NULL, updated_at DATETIME NOT NULL, name VARCHAR(255) NOT NULL, PRIMARY KEY (code))")
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	result, err := db.SchemaFromStruct(map[string]interface{}{
+		"products":   new(SchemaProduct),
+		"categories": new(SchemaCategory),
+	})
+	test.Fatal(t, err, nil)
+	categories, ok := result.GetTable("categories")
+	test.Fatal(t, ok, true)
+	id, _ := categories.GetColumn("id")
+	test.Fatal(t, id.PrimaryKey, true)
+	test.Fatal(t, id.AutoIncrement, true)
+	test.Fatal(t, categories.Indexes[0].Unique, true)
+	products, _ := result.GetTable("products")
+	// the foreign key column gets the type of the referenced primary key
+	categoryID, _ := products.GetColumn("category_id")
+	test.Fatal(t, categoryID.Type, "integer")
+	// referenced tables are created first
+	statements := schema.Compare(schema.NewSchema(), result).ToSQL(platform.NewMySqlPlatform(platform.NewDefaultPlatform()))
+	test.Fatal(t, strings.Join(statements, ";\n"), strings.Join([]string{
+		"CREATE TABLE categories (id INT NOT NULL AUTO_INCREMENT, name VARCHAR(100) NOT NULL, PRIMARY KEY (id))",
+		"CREATE UNIQUE INDEX categories_name_uniq ON categories (name)",
+		"CREATE TABLE products (id BIGINT NOT NULL AUTO_INCREMENT, name VARCHAR(255) NOT NULL, price DOUBLE NOT NULL, " +
+			"description LONGTEXT, published TINYINT(1) NOT NULL DEFAULT 0, created_at DATETIME DEFAULT CURRENT_TIMESTAMP, category_id INT, " +
+			"PRIMARY KEY (id), CONSTRAINT products_category_id_fk FOREIGN KEY (category_id) REFERENCES categories (id))",
+		"CREATE INDEX products_name_price_idx ON products (name, price)",
+	}, ";\n"))
+}
+
+func TestCreateTableFromStruct(t *testing.T) {
+	connection := GetConnection(t)
+	test.Fatal(t, db.CreateTableFromStruct(connection, "categories", SchemaCategory{}), nil)
+	test.Fatal(t, db.CreateTableFromStruct(connection, "products", SchemaProduct{}), nil)
+	_, err := connection.Exec("INSERT INTO categories(name) VALUES('books')")
+	test.Fatal(t, err, nil)
+	_, err = connection.Exec("INSERT INTO products(name, price, category_id) VALUES('Go', 19.5, 1)")
+	test.Fatal(t, err, nil)
+	products := []*struct {
+		ID        int64   `sql:"column:id"`
+		Price     float64 `sql:"column:price"`
+		Published bool    `sql:"column:published"`
+	}{}
+	err = connection.Query("SELECT id, price, published FROM products").GetResults(&products)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, len(products), 1)
+	test.Fatal(t, products[0].ID, int64(1))
+	test.Fatal(t, products[0].Price, 19.5)
+	test.Fatal(t, products[0].Published, false)
+	// the unique index is created
+	_, err = connection.Exec("INSERT INTO categories(name) VALUES('books')")
+	test.Fatal(t, err != nil, true)
+}
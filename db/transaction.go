@@ -15,49 +15,306 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
 
+	"github.com/Mparaiso/go-tiger/db/platform"
 	"github.com/Mparaiso/go-tiger/logger"
 )
 
+// Transaction is a wrapper around *sql.Tx.
+// A nested transaction shares the *sql.Tx of its parent, it is
+// committed and rolled back with savepoints.
 type Transaction struct {
 	*sql.Tx
-	Logger logger.Logger
+	Logger     logger.Logger
+	connection Connection
+	// savepoint is the savepoint of a nested transaction
+	savepoint string
+	// level is the nesting level of the transaction, 0 for the outermost transaction
+	level int
+	// observers observe the queries of the transaction
+	observers []QueryObserver
+	// releases release the cached statements the statements of the transaction were prepared from,
+	// they are shared with nested transactions and called when the transaction ends
+	releases *[]func()
+	// writtenTables are the tables written by the transaction and its nested transactions,
+	// whose cached results are invalidated again once the transaction is committed
+	writtenTables *[]string
 }
 
+// TransactionalFunc is a unit of work run in a transaction
+type TransactionalFunc func(transaction *Transaction) error
+
 func (transaction *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return transaction.ExecContext(context.Background(), query, args...)
+}
+
+func (transaction *Transaction) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	defer transaction.log(append([]interface{}{query}, args...)...)
-	return transaction.Tx.Exec(query, args...)
+	markPrimaryWrite(ctx)
+	done := observeQuery(ctx, transaction.observers, query, args)
+	result, err := transaction.Tx.ExecContext(ctx, query, args...)
+	done(result, err)
+	if err == nil {
+		transaction.invalidateWrittenTable(query)
+	}
+	return result, err
+}
+
+// invalidateWrittenTable invalidates the cached results of the table written by query, if any,
+// results read by other connections before the transaction is committed being invalidated on commit
+func (transaction *Transaction) invalidateWrittenTable(query string) {
+	invalidator, ok := transaction.connection.(interface{ InvalidateCache(tags ...string) })
+	table := getWrittenTable(query)
+	if !ok || table == "" {
+		return
+	}
+	invalidator.InvalidateCache(table)
+	if transaction.writtenTables == nil {
+		transaction.writtenTables = &[]string{}
+	}
+	*transaction.writtenTables = append(*transaction.writtenTables, table)
 }
 
 func (transaction *Transaction) Prepare(query string) (*Statement, error) {
+	return transaction.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement in the transaction. If the statement is cached by the statement
+// cache of the connection, the cached statement is bound to the transaction instead of preparing a new statement.
+func (transaction *Transaction) PrepareContext(ctx context.Context, query string) (*Statement, error) {
 	defer transaction.log(query)
-	stmt, err := transaction.Tx.Prepare(query)
+	if cached, ok := transaction.connection.(interface{ GetStatementCache() *StatementCache }); ok && cached.GetStatementCache() != nil {
+		// a statement is not prepared on the connection pool, which may wait for the connection of the transaction
+		if parent, release, ok := cached.GetStatementCache().acquireCached(query); ok {
+			if transaction.releases == nil {
+				transaction.releases = &[]func(){}
+			}
+			*transaction.releases = append(*transaction.releases, release)
+			return &Statement{statement: transaction.Tx.StmtContext(ctx, parent), query: query, logger: transaction.Logger, observers: transaction.observers,
+				written: transaction.invalidateWrittenTable}, nil
+		}
+	}
+	stmt, err := transaction.Tx.PrepareContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
-	return &Statement{statement: stmt, query: query, logger: transaction.Logger}, nil
+	return &Statement{statement: stmt, query: query, logger: transaction.Logger, observers: transaction.observers,
+		written: transaction.invalidateWrittenTable}, nil
 }
 
 func (transaction *Transaction) Query(query string, arguments ...interface{}) *Rows {
+	return transaction.QueryContext(context.Background(), query, arguments...)
+}
+
+func (transaction *Transaction) QueryContext(ctx context.Context, query string, arguments ...interface{}) *Rows {
 	defer transaction.log(query, arguments)
-	return NewRows(transaction.Tx.Query(query, arguments...))
+	done := observeQuery(ctx, transaction.observers, query, arguments)
+	rows, err := transaction.Tx.QueryContext(ctx, query, arguments...)
+	done(nil, err)
+	return NewRows(rows, err)
 }
 
 func (transaction *Transaction) QueryRow(query string, arguments ...interface{}) *Row {
+	return transaction.QueryRowContext(context.Background(), query, arguments...)
+}
+
+func (transaction *Transaction) QueryRowContext(ctx context.Context, query string, arguments ...interface{}) *Row {
 	defer transaction.log(query, arguments)
-	return NewRow(transaction.Tx.Query(query, arguments...))
+	done := observeQuery(ctx, transaction.observers, query, arguments)
+	rows, err := transaction.Tx.QueryContext(ctx, query, arguments...)
+	done(nil, err)
+	return NewRow(rows, err)
+}
+
+// GetConnection returns the connection the transaction was started from
+func (transaction *Transaction) GetConnection() Connection {
+	return transaction.connection
+}
+
+// GetDatabasePlatform returns the database platform
+func (transaction *Transaction) GetDatabasePlatform() platform.DatabasePlatform {
+	return transaction.connection.GetDatabasePlatform()
+}
+
+// CreateQueryBuilder creates a *QueryBuilder executing its queries in the transaction
+func (transaction *Transaction) CreateQueryBuilder() *QueryBuilder {
+	queryBuilder := NewQueryBuilder(transaction.connection)
+	queryBuilder.queryer = transaction
+	return queryBuilder
+}
+
+// Insert persists a new record in the transaction, see DefaultConnection.Insert
+func (transaction *Transaction) Insert(tableName string, data interface{}) (sql.Result, error) {
+	return transaction.InsertContext(context.Background(), tableName, data)
+}
+
+func (transaction *Transaction) InsertContext(ctx context.Context, tableName string, data interface{}) (sql.Result, error) {
+	return insertRow(ctx, transaction.CreateQueryBuilder(), tableName, data)
+}
+
+// InsertMany inserts several rows in the transaction, see DefaultConnection.InsertMany
+func (transaction *Transaction) InsertMany(tableName string, data interface{}) (sql.Result, error) {
+	return transaction.InsertManyContext(context.Background(), tableName, data)
+}
+
+func (transaction *Transaction) InsertManyContext(ctx context.Context, tableName string, data interface{}) (sql.Result, error) {
+	return insertRows(ctx, transaction.CreateQueryBuilder, tableName, data)
+}
+
+// Upsert inserts or updates a row in the transaction, see DefaultConnection.Upsert
+func (transaction *Transaction) Upsert(tableName string, data interface{}, conflictColumns []string, updateColumns ...string) (sql.Result, error) {
+	return transaction.UpsertContext(context.Background(), tableName, data, conflictColumns, updateColumns...)
+}
+
+func (transaction *Transaction) UpsertContext(ctx context.Context, tableName string, data interface{}, conflictColumns []string, updateColumns ...string) (sql.Result, error) {
+	return upsertRow(ctx, transaction.CreateQueryBuilder(), tableName, data, conflictColumns, updateColumns...)
+}
+
+// Update executes an SQL UPDATE statement in the transaction, see DefaultConnection.Update
+func (transaction *Transaction) Update(table string, criteria map[string]interface{}, data interface{}) (sql.Result, error) {
+	return transaction.UpdateContext(context.Background(), table, criteria, data)
 }
 
+func (transaction *Transaction) UpdateContext(ctx context.Context, table string, criteria map[string]interface{}, data interface{}) (sql.Result, error) {
+	return updateRows(ctx, transaction.CreateQueryBuilder(), table, criteria, data, nil)
+}
+
+// UpdateColumns updates the given columns of the rows of a table in the transaction, see DefaultConnection.UpdateColumns
+func (transaction *Transaction) UpdateColumns(table string, criteria map[string]interface{}, data interface{}, columns ...string) (sql.Result, error) {
+	return transaction.UpdateColumnsContext(context.Background(), table, criteria, data, columns...)
+}
+
+func (transaction *Transaction) UpdateColumnsContext(ctx context.Context, table string, criteria map[string]interface{}, data interface{}, columns ...string) (sql.Result, error) {
+	if len(columns) == 0 {
+		return driver.RowsAffected(0), nil
+	}
+	return updateRows(ctx, transaction.CreateQueryBuilder(), table, criteria, data, columns)
+}
+
+// Delete deletes all rows of a table matching criteria in the transaction
+func (transaction *Transaction) Delete(table string, criteria map[string]interface{}) (sql.Result, error) {
+	return transaction.DeleteContext(context.Background(), table, criteria)
+}
+
+func (transaction *Transaction) DeleteContext(ctx context.Context, table string, criteria map[string]interface{}) (sql.Result, error) {
+	return deleteRows(ctx, transaction.CreateQueryBuilder(), table, criteria)
+}
+
+// Transactional runs fn in a nested transaction, see DefaultConnection.Transactional.
+// The nested transaction is rolled back to a savepoint, leaving the outer transaction untouched.
+func (transaction *Transaction) Transactional(ctx context.Context, fn TransactionalFunc) error {
+	nested, err := transaction.BeginNested(ctx)
+	if err != nil {
+		return err
+	}
+	return runTransactional(nested, fn)
+}
+
+// BeginNested begins a nested transaction by creating a savepoint.
+// Committing the nested transaction releases the savepoint, rolling it back
+// rolls back the outer transaction to the savepoint.
+func (transaction *Transaction) BeginNested(ctx context.Context) (*Transaction, error) {
+	nested := &Transaction{
+		Tx:         transaction.Tx,
+		Logger:     transaction.Logger,
+		connection: transaction.connection,
+		savepoint:  fmt.Sprintf("tiger_savepoint_%d", transaction.level+1),
+		level:      transaction.level + 1,
+		observers:  transaction.observers,
+		releases:   transaction.releases,
+	}
+	if transaction.writtenTables == nil {
+		transaction.writtenTables = &[]string{}
+	}
+	nested.writtenTables = transaction.writtenTables
+	if err := nested.CreateSavepoint(ctx, nested.savepoint); err != nil {
+		return nil, err
+	}
+	return nested, nil
+}
+
+// IsNested returns true if the transaction is nested in another transaction
+func (transaction *Transaction) IsNested() bool {
+	return transaction.savepoint != ""
+}
+
+// CreateSavepoint creates a savepoint in the transaction
+func (transaction *Transaction) CreateSavepoint(ctx context.Context, savepoint string) error {
+	databasePlatform := transaction.GetDatabasePlatform()
+	if !databasePlatform.SupportsSavepoints() {
+		return ErrSavepointsNotSupported
+	}
+	_, err := transaction.ExecContext(ctx, databasePlatform.GetCreateSavepointSQL(savepoint))
+	return err
+}
+
+// ReleaseSavepoint releases a savepoint, keeping the changes made since its creation
+func (transaction *Transaction) ReleaseSavepoint(ctx context.Context, savepoint string) error {
+	databasePlatform := transaction.GetDatabasePlatform()
+	if !databasePlatform.SupportsSavepoints() {
+		return ErrSavepointsNotSupported
+	}
+	// platforms without RELEASE SAVEPOINT release savepoints on commit
+	if query := databasePlatform.GetReleaseSavepointSQL(savepoint); query != "" {
+		_, err := transaction.ExecContext(ctx, query)
+		return err
+	}
+	return nil
+}
+
+// RollbackSavepoint rolls back the changes made since the creation of a savepoint
+func (transaction *Transaction) RollbackSavepoint(ctx context.Context, savepoint string) error {
+	databasePlatform := transaction.GetDatabasePlatform()
+	if !databasePlatform.SupportsSavepoints() {
+		return ErrSavepointsNotSupported
+	}
+	_, err := transaction.ExecContext(ctx, databasePlatform.GetRollbackSavepointSQL(savepoint))
+	return err
+}
+
+// Rollback rolls back the transaction. A nested transaction is rolled back to its savepoint,
+// which is then released on platforms that support releasing savepoints.
 func (transaction *Transaction) Rollback() (err error) {
-	defer transaction.error("Rollback Transaction.", err)
+	if transaction.IsNested() {
+		if err = transaction.RollbackSavepoint(context.Background(), transaction.savepoint); err != nil {
+			return err
+		}
+		return transaction.ReleaseSavepoint(context.Background(), transaction.savepoint)
+	}
+	defer func() { transaction.error("Rollback Transaction.", err) }()
+	defer transaction.releaseStatements()
 	err = transaction.Tx.Rollback()
 	return
 }
 
 func (transaction *Transaction) Commit() error {
+	if transaction.IsNested() {
+		return transaction.ReleaseSavepoint(context.Background(), transaction.savepoint)
+	}
 	defer transaction.log("Commit Transaction.")
-	return transaction.Tx.Commit()
+	defer transaction.releaseStatements()
+	if err := transaction.Tx.Commit(); err != nil {
+		return err
+	}
+	if invalidator, ok := transaction.connection.(interface{ InvalidateCache(tags ...string) }); ok && transaction.writtenTables != nil {
+		invalidator.InvalidateCache(*transaction.writtenTables...)
+	}
+	return nil
+}
+
+// releaseStatements releases the cached statements used by the transaction
+func (transaction *Transaction) releaseStatements() {
+	if transaction.releases == nil {
+		return
+	}
+	for _, release := range *transaction.releases {
+		release()
+	}
+	*transaction.releases = nil
 }
 func (transaction *Transaction) error(messages ...interface{}) {
 	if transaction.Logger != nil {
@@ -69,3 +326,19 @@ func (transaction *Transaction) log(messages ...interface{}) {
 		transaction.Logger.Log(logger.Debug, messages...)
 	}
 }
+
+// runTransactional runs fn in transaction, commits the transaction if fn returns nil
+// and rolls it back if fn returns an error or panics, in which case the panic is propagated.
+func runTransactional(transaction *Transaction, fn TransactionalFunc) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			transaction.Rollback()
+			panic(recovered)
+		}
+	}()
+	if err = fn(transaction); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
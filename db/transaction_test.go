@@ -0,0 +1,117 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/db/expression"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+func countUsers(t *testing.T, connection db.Connection) (count int) {
+	err := connection.QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count)
+	test.Fatal(t, err, nil)
+	return count
+}
+
+func TestTransactional(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	err := connection.Transactional(context.Background(), func(transaction *db.Transaction) error {
+		_, err := transaction.Insert("users", &AppUser{Name: "John Doe", Email: "john.doe@acme.com"})
+		return err
+	})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, countUsers(t, connection), 1)
+
+	// the transaction is rolled back on error
+	rollback := fmt.Errorf("rollback")
+	err = connection.Transactional(context.Background(), func(transaction *db.Transaction) error {
+		if _, err := transaction.Insert("users", map[string]interface{}{"name": "Jane Doe", "email": "jane.doe@acme.com"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	test.Fatal(t, err, rollback)
+	test.Fatal(t, countUsers(t, connection), 1)
+
+	// the transaction is rolled back on panic, and the panic propagated
+	func() {
+		defer func() {
+			test.Fatal(t, recover(), "panic")
+		}()
+		connection.Transactional(context.Background(), func(transaction *db.Transaction) error {
+			transaction.Delete("users", map[string]interface{}{"name": "John Doe"})
+			panic("panic")
+		})
+	}()
+	test.Fatal(t, countUsers(t, connection), 1)
+}
+
+func TestTransactionalNested(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	rollback := fmt.Errorf("rollback")
+	err := connection.Transactional(context.Background(), func(transaction *db.Transaction) error {
+		if _, err := transaction.Insert("users", &AppUser{Name: "John Doe", Email: "john.doe@acme.com"}); err != nil {
+			return err
+		}
+		// the nested transaction is rolled back to its savepoint
+		err := transaction.Transactional(context.Background(), func(nested *db.Transaction) error {
+			test.Fatal(t, nested.IsNested(), true)
+			if _, err := nested.Update("users", map[string]interface{}{"name": "John Doe"}, map[string]interface{}{"email": "john@acme.com"}); err != nil {
+				return err
+			}
+			return rollback
+		})
+		test.Fatal(t, err, rollback)
+		// the nested transaction is committed along with the outer transaction
+		return transaction.Transactional(context.Background(), func(nested *db.Transaction) error {
+			_, err := nested.CreateQueryBuilder().Insert("users").
+				SetValue("name", expression.Param("Jane Doe")).
+				SetValue("email", expression.Param("jane.doe@acme.com")).
+				Exec()
+			return err
+		})
+	})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, countUsers(t, connection), 2)
+	var email string
+	err = connection.QueryRow("SELECT email FROM users WHERE name = ?", "John Doe").GetSingleResult(&email)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, email, "john.doe@acme.com")
+}
+
+func TestTransactionRollbackNested(t *testing.T) {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	transaction, err := connection.Begin()
+	test.Fatal(t, err, nil)
+	defer transaction.Rollback()
+	nested, err := transaction.BeginNested(context.Background())
+	test.Fatal(t, err, nil)
+	_, err = nested.Insert("users", &AppUser{Name: "John Doe", Email: "john.doe@acme.com"})
+	test.Fatal(t, err, nil)
+	test.Fatal(t, nested.Rollback(), nil)
+	// the savepoint is released once rolled back to
+	test.Fatal(t, transaction.ReleaseSavepoint(context.Background(), "tiger_savepoint_1") != nil, true)
+	var count int
+	test.Fatal(t, transaction.QueryRow("SELECT COUNT(*) FROM users").GetSingleResult(&count), nil)
+	test.Fatal(t, count, 0)
+}
@@ -0,0 +1,107 @@
+//    Copyright (C) 2016  mparaiso <mparaiso@online.fr>
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package db_test
+
+import (
+	"testing"
+
+	"github.com/Mparaiso/go-tiger/db"
+	"github.com/Mparaiso/go-tiger/test"
+)
+
+type VersionedArticle struct {
+	ID      int64  `sql:"column:id"`
+	Title   string `sql:"column:title"`
+	Version int    `sql:"column:version,version"`
+}
+
+func GetVersionedConnection(t *testing.T) *db.DefaultConnection {
+	connection := GetConnection(t)
+	connection.DB().SetMaxOpenConns(1)
+	_, err := connection.Exec("CREATE TABLE versioned_articles(id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(255), version INTEGER NOT NULL)")
+	test.Fatal(t, err, nil)
+	return connection
+}
+
+func TestUpdateVersioned(t *testing.T) {
+	connection := GetVersionedConnection(t)
+	// zero versions are inserted
+	_, err := connection.Insert("versioned_articles", VersionedArticle{Title: "Hello"})
+	test.Fatal(t, err, nil)
+
+	article := &VersionedArticle{ID: 1, Title: "Hello World"}
+	_, err = connection.Update("versioned_articles", map[string]interface{}{"id": 1}, article)
+	test.Fatal(t, err, nil)
+	test.Fatal(t, article.Version, 1)
+	var version int
+	test.Fatal(t, connection.QueryRow("SELECT version FROM versioned_articles WHERE id = 1").GetSingleResult(&version), nil)
+	test.Fatal(t, version, 1)
+
+	// a stale version is not updated
+	stale := VersionedArticle{ID: 1, Title: "Stale"}
+	_, err = connection.Update("versioned_articles", map[string]interface{}{"id": 1}, stale)
+	test.Fatal(t, err, db.ErrOptimisticLock)
+	var title string
+	test.Fatal(t, connection.QueryRow("SELECT title FROM versioned_articles WHERE id = 1").GetSingleResult(&title), nil)
+	test.Fatal(t, title, "Hello World")
+
+	type InvalidVersion struct {
+		Version string `sql:"column:version,version"`
+	}
+	_, err = connection.Update("versioned_articles", map[string]interface{}{"id": 1}, InvalidVersion{})
+	test.Fatal(t, err, db.ErrInvalidVersionField)
+
+	table, err := db.TableFromStruct("versioned_articles", VersionedArticle{})
+	test.Fatal(t, err, nil)
+	column, _ := table.GetColumn("version")
+	test.Fatal(t, column.Default, "0")
+}
+
+func TestRepositorySaveVersioned(t *testing.T) {
+	connection := GetVersionedConnection(t)
+	repository, err := db.NewRepository(connection, "versioned_articles", new(VersionedArticle))
+	test.Fatal(t, err, nil)
+	article := &VersionedArticle{Title: "Hello"}
+	test.Fatal(t, repository.Save(article), nil)
+	stale := new(VersionedArticle)
+	test.Fatal(t, repository.Find(article.ID, stale), nil)
+
+	article.Title = "Hello World"
+	test.Fatal(t, repository.Save(article), nil)
+	test.Fatal(t, article.Version, 1)
+	stale.Title = "Stale"
+	test.Fatal(t, repository.Save(stale), db.ErrOptimisticLock)
+	test.Fatal(t, stale.Version, 0)
+}
+
+func TestEntityManagerFlushVersioned(t *testing.T) {
+	connection := GetVersionedConnection(t)
+	manager := db.NewEntityManager(connection)
+	test.Fatal(t, manager.Register("versioned_articles", new(VersionedArticle)), nil)
+	article := &VersionedArticle{Title: "Hello"}
+	manager.Persist(article)
+	test.Fatal(t, manager.Flush(), nil)
+
+	article.Title = "Hello World"
+	test.Fatal(t, manager.Flush(), nil)
+	test.Fatal(t, article.Version, 1)
+
+	// the row is updated by another connection
+	_, err := connection.Exec("UPDATE versioned_articles SET version = 2 WHERE id = ?", article.ID)
+	test.Fatal(t, err, nil)
+	article.Title = "Stale"
+	test.Fatal(t, manager.Flush(), db.ErrOptimisticLock)
+	test.Fatal(t, article.Version, 1)
+}